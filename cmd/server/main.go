@@ -9,7 +9,11 @@ import (
 	"numera/config"
 	"numera/db"
 	"numera/handler"
+	appmiddleware "numera/middleware"
+	"numera/pkg/encrypt"
+	"numera/pkg/mailer"
 	"numera/pkg/session"
+	"numera/pkg/storage"
 	"numera/services"
 	"os"
 	"os/signal"
@@ -31,6 +35,8 @@ type App struct {
 	db      *sql.DB
 	logger  *logrus.Logger
 	session *session.Session
+	mailer  mailer.Mailer
+	storage storage.Storage
 	wg      sync.WaitGroup
 }
 
@@ -40,6 +46,8 @@ func NewApp(
 	db *sql.DB,
 	logger *logrus.Logger,
 	session *session.Session,
+	mailer mailer.Mailer,
+	storage storage.Storage,
 ) *App {
 	return &App{
 		addr:    addr,
@@ -47,14 +55,17 @@ func NewApp(
 		db:      db,
 		logger:  logger,
 		session: session,
+		mailer:  mailer,
+		storage: storage,
 	}
 }
 
 func (app *App) Serve() error {
 	r := chi.NewRouter()
 
-	r.Use(middleware.RealIP)
+	r.Use(appmiddleware.RealIP(app.cfg.TrustedProxies))
 	r.Use(middleware.Recoverer)
+	r.Use(appmiddleware.SecureHeaders(app.cfg))
 	r.Use(middleware.RequestLogger(&middleware.DefaultLogFormatter{
 		Logger:  app.logger,
 		NoColor: false,
@@ -68,27 +79,106 @@ func (app *App) Serve() error {
 		MaxAge:           app.cfg.MaxAge,
 	}))
 	r.Use(app.session.LoadAndSave)
+	r.Use(appmiddleware.TrackSession(app.session, app.db, app.logger))
+	r.Use(appmiddleware.Preferences(app.session, app.db, app.logger))
+	r.Use(appmiddleware.CSRF(app.session, app.logger))
 
 	// serve static files
 	fs := http.FileServer(http.Dir("./static"))
 	r.Handle("/static/*", http.StripPrefix("/static/", fs))
 
-	exchangeService := services.NewExchangeService(app.logger)
+	exchangeService := services.NewExchangeService(app.db, app.logger)
+	archiveService := services.NewArchiveService(app.db, app.logger)
+	exportService := services.NewExportService(app.db, app.logger)
+	verificationService := services.NewVerificationService(app.db, app.logger, app.mailer, app.cfg.AppURL, app.cfg.AppKey)
+	attachmentService := services.NewAttachmentService(
+		app.db,
+		app.logger,
+		app.storage,
+		int64(app.cfg.AttachmentMaxSize)<<20,
+		int64(app.cfg.AttachmentQuota)<<20,
+	)
+
+	encrypter, err := encrypt.New(app.cfg.AppKey)
+	if err != nil {
+		return err
+	}
+	twoFactorService := services.NewTwoFactorService(app.db, app.logger, encrypter)
 
-	userHandler := handler.NewUserHandler(app.db, app.logger, app.session, exchangeService)
+	passkeyService, err := services.NewPasskeyService(app.db, app.logger, app.cfg.AppURL)
+	if err != nil {
+		return err
+	}
+
+	userHandler := handler.NewUserHandler(app.db, app.logger, app.session, exchangeService, verificationService)
 	userHandler.RegisterRoutes(r)
 
-	authHandler := handler.NewAuthHandler(app.db, app.logger, app.session)
+	authHandler := handler.NewAuthHandler(app.db, app.logger, app.session, twoFactorService)
 	authHandler.RegisterRoutes(r)
 
+	passwordResetHandler := handler.NewPasswordResetHandler(app.db, app.logger, app.session, app.mailer, app.cfg.AppURL)
+	passwordResetHandler.RegisterRoutes(r)
+
+	verificationHandler := handler.NewVerificationHandler(app.db, app.logger, app.session, verificationService)
+	verificationHandler.RegisterRoutes(r)
+
+	twoFactorHandler := handler.NewTwoFactorHandler(app.db, app.logger, app.session, twoFactorService)
+	twoFactorHandler.RegisterRoutes(r)
+
+	passkeyHandler := handler.NewPasskeyHandler(app.db, app.logger, app.session, passkeyService)
+	passkeyHandler.RegisterRoutes(r)
+
+	sessionHandler := handler.NewSessionHandler(app.db, app.logger, app.session)
+	sessionHandler.RegisterRoutes(r)
+
+	settingsHandler := handler.NewSettingsHandler(app.db, app.logger, app.session, verificationService, attachmentService)
+	settingsHandler.RegisterRoutes(r)
+
+	householdHandler := handler.NewHouseholdHandler(app.db, app.logger, app.session, app.mailer, app.cfg.AppURL)
+	householdHandler.RegisterRoutes(r)
+
 	dashboardHandler := handler.NewDashboardHandler(app.db, app.logger, app.session, exchangeService)
 	dashboardHandler.RegisterRoutes(r)
 
-	accountHandler := handler.NewAccountHandler(app.db, app.logger, app.session)
+	accountHandler := handler.NewAccountHandler(app.db, app.logger, app.session, exchangeService, attachmentService)
 	accountHandler.RegisterRoutes(r)
 
+	reconciliationHandler := handler.NewReconciliationHandler(app.db, app.logger, app.session)
+	reconciliationHandler.RegisterRoutes(r)
+
+	transactionHandler := handler.NewTransactionHandler(app.db, app.logger, app.session)
+	transactionHandler.RegisterRoutes(r)
+
+	tagHandler := handler.NewTagHandler(app.db, app.logger, app.session, exchangeService)
+	tagHandler.RegisterRoutes(r)
+
+	attachmentHandler := handler.NewAttachmentHandler(app.db, app.logger, app.session, attachmentService)
+	attachmentHandler.RegisterRoutes(r)
+
+	payeeHandler := handler.NewPayeeHandler(app.db, app.logger, app.session)
+	payeeHandler.RegisterRoutes(r)
+
+	contactHandler := handler.NewContactHandler(app.db, app.logger, app.session, exchangeService)
+	contactHandler.RegisterRoutes(r)
+
+	savingsGoalHandler := handler.NewSavingsGoalHandler(app.db, app.logger, app.session, exchangeService)
+	savingsGoalHandler.RegisterRoutes(r)
+
+	tokenHandler := handler.NewTokenHandler(app.db, app.logger, app.session)
+	tokenHandler.RegisterRoutes(r)
+
+	archiveHandler := handler.NewArchiveHandler(app.db, app.logger, app.session, archiveService)
+	archiveHandler.RegisterRoutes(r)
+
+	exportHandler := handler.NewExportHandler(app.db, app.logger, app.session, exportService)
+	exportHandler.RegisterRoutes(r)
+
+	apiHandler := handler.NewAPIHandler(app.db, app.logger, app.session, exchangeService)
+	apiHandler.RegisterRoutes(r)
+
 	if !app.cfg.IsProd() {
 		printRoutes(r, app.logger)
+		checkOpenAPIRoutes(r, app.logger)
 	}
 
 	server := &http.Server{
@@ -116,7 +206,7 @@ func (app *App) Serve() error {
 		"mode": app.cfg.Mode,
 	}).Info("starting server")
 
-	err := server.ListenAndServe()
+	err = server.ListenAndServe()
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -187,11 +277,21 @@ func main() {
 		logger.WithError(err).Fatal("failed to run migrations")
 	}
 
+	mailer, err := mailer.New(cfg)
+	if err != nil {
+		logger.WithError(err).Fatal("failed to initialize mailer")
+	}
+
+	storage, err := storage.New(cfg)
+	if err != nil {
+		logger.WithError(err).Fatal("failed to initialize storage")
+	}
+
 	if cfg.Port == "" {
 		logger.Fatal("port is not provided")
 	}
 
-	server := NewApp(fmt.Sprintf(":%s", cfg.Port), cfg, dbConn, logger, session)
+	server := NewApp(fmt.Sprintf(":%s", cfg.Port), cfg, dbConn, logger, session, mailer, storage)
 	if err := server.Serve(); err != nil {
 		logger.WithError(err).Fatal("server failed")
 	}
@@ -211,3 +311,14 @@ func printRoutes(r *chi.Mux, logger *logrus.Logger) {
 	}
 	logger.Info("=== END ROUTES ===")
 }
+
+func checkOpenAPIRoutes(r *chi.Mux, logger *logrus.Logger) {
+	undocumented, err := handler.CheckOpenAPIRoutes(r)
+	if err != nil {
+		logger.WithError(err).Error("Failed to check OpenAPI document")
+		return
+	}
+	for _, route := range undocumented {
+		logger.WithField("route", route).Warn("route missing from OpenAPI document")
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
@@ -13,6 +14,39 @@ type Config struct {
 	Mode   string
 	Port   string
 	DBPath string
+	// AppURL is the public base url used when building links sent by email
+	AppURL string
+	// AppKey is the secret used to sign links and encrypt sensitive values
+	AppKey string
+
+	// Mail related
+	MailDriver   string
+	MailFrom     string
+	MailDir      string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+
+	// Storage related
+	StorageDriver string
+	StorageDir    string
+	// AttachmentMaxSize is the largest file that can be attached, in MB
+	AttachmentMaxSize int
+	// AttachmentQuota is how much every user may attach in total, in MB
+	AttachmentQuota int
+
+	// Security headers related
+	CSPReportOnly     bool
+	CSPReportURI      string
+	FrameAncestors    []string
+	HSTSMaxAge        int
+	ReferrerPolicy    string
+	PermissionsPolicy string
+
+	// TrustedProxies are the reverse proxies whose forwarded headers are
+	// believed, requests from anywhere else keep their peer address
+	TrustedProxies []netip.Prefix
 
 	// Cors related
 	AllowedOrigins   []string
@@ -34,6 +68,28 @@ func Load() (*Config, error) {
 		Mode:   getEnv("MODE", "production"),
 		Port:   getEnv("PORT", "8000"),
 		DBPath: getEnv("DB_PATH", "./db/database.sqlite3"),
+		AppURL: strings.TrimRight(getEnv("APP_URL", "http://localhost:8000"), "/"),
+		AppKey: getEnv("APP_KEY", ""),
+
+		MailDriver:   getEnv("MAIL_DRIVER", "stdout"),
+		MailFrom:     getEnv("MAIL_FROM", "Numera <no-reply@localhost>"),
+		MailDir:      getEnv("MAIL_DIR", "./tmp/mail"),
+		SMTPHost:     getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+
+		StorageDriver:     getEnv("STORAGE_DRIVER", "local"),
+		StorageDir:        getEnv("STORAGE_DIR", "./storage"),
+		AttachmentMaxSize: getEnvInt("ATTACHMENT_MAX_SIZE", 10),
+		AttachmentQuota:   getEnvInt("ATTACHMENT_QUOTA", 250),
+
+		CSPReportOnly:     getEnvBool("CSP_REPORT_ONLY", false),
+		CSPReportURI:      getEnv("CSP_REPORT_URI", ""),
+		FrameAncestors:    getEnvSlice("FRAME_ANCESTORS", []string{"'none'"}),
+		HSTSMaxAge:        getEnvInt("HSTS_MAX_AGE", 63072000),
+		ReferrerPolicy:    getEnv("REFERRER_POLICY", "strict-origin-when-cross-origin"),
+		PermissionsPolicy: getEnv("PERMISSIONS_POLICY", "camera=(), microphone=(), geolocation=(), payment=(), usb=()"),
 
 		AllowedOrigins: getEnvSlice("ALLOWED_ORIGINS", []string{"*"}),
 		AllowedMethods: getEnvSlice("ALLOWED_METHODS", []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
@@ -44,6 +100,21 @@ func Load() (*Config, error) {
 		MaxAge:           getEnvInt("MAX_AGE", 300),
 	}
 
+	for _, proxy := range getEnvSlice("TRUSTED_PROXIES", nil) {
+		prefix, err := parsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: %w", proxy, err)
+		}
+		cfg.TrustedProxies = append(cfg.TrustedProxies, prefix)
+	}
+
+	if cfg.AppKey == "" {
+		if cfg.IsProd() {
+			return nil, fmt.Errorf("APP_KEY must be set in production")
+		}
+		cfg.AppKey = "insecure-development-key"
+	}
+
 	return cfg, nil
 }
 
@@ -51,6 +122,20 @@ func (c *Config) ServerAddress() string {
 	return fmt.Sprintf(":%s", c.Port)
 }
 
+// parsePrefix reads a CIDR range, or a single address as the range holding
+// only it
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		return netip.ParsePrefix(value)
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
 func getEnv(key, defaultVal string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -22,6 +22,17 @@ func Open(dbPath string) (*sql.DB, error) {
 		return nil, err
 	}
 
+	// transaction search relies on FTS5, which go-sqlite3 only compiles in
+	// with the sqlite_fts5 build tag
+	var fts5 bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+		return nil, err
+	}
+	if !fts5 {
+		db.Close()
+		return nil, fmt.Errorf("sqlite was built without FTS5, build with -tags sqlite_fts5")
+	}
+
 	return db, nil
 }
 
@@ -2,12 +2,18 @@ package handler
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"net/http"
 	"numera/middleware"
 	"numera/model"
 	"numera/pkg/session"
 	"numera/pkg/validator"
+	"numera/services"
 	"numera/views/pages"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/shopspring/decimal"
@@ -15,16 +21,26 @@ import (
 )
 
 type AccountHandler struct {
-	db      *sql.DB
-	logger  *logrus.Logger
-	session *session.Session
+	db                *sql.DB
+	logger            *logrus.Logger
+	session           *session.Session
+	exchangeService   *services.ExchangeService
+	attachmentService *services.AttachmentService
 }
 
-func NewAccountHandler(db *sql.DB, logger *logrus.Logger, session *session.Session) *AccountHandler {
+func NewAccountHandler(
+	db *sql.DB,
+	logger *logrus.Logger,
+	session *session.Session,
+	exchangeService *services.ExchangeService,
+	attachmentService *services.AttachmentService,
+) *AccountHandler {
 	return &AccountHandler{
-		db:      db,
-		logger:  logger,
-		session: session,
+		db:                db,
+		logger:            logger,
+		session:           session,
+		exchangeService:   exchangeService,
+		attachmentService: attachmentService,
 	}
 }
 
@@ -32,16 +48,38 @@ func (h *AccountHandler) RegisterRoutes(r *chi.Mux) {
 	r.Group(func(r chi.Router) {
 		r.Use(middleware.RequireAuth(h.session))
 		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
 
 		r.Get("/accounts", h.handleShowIndex)
 		r.Get("/accounts/create", h.handleShowCreate)
 		r.Post("/accounts/create", h.handleCreate)
+		r.Get("/accounts/archived", h.handleShowArchived)
+		r.Get("/accounts/archived/list", h.handleShowArchivedList)
+		r.Post("/accounts/order", h.handleReorder)
+		r.Get("/accounts/groups", h.handleShowGroups)
+		r.Get("/accounts/groups/list", h.handleShowGroupList)
+		r.Post("/accounts/groups", h.handleCreateGroup)
+		r.Post("/accounts/groups/order", h.handleReorderGroups)
+		r.Put("/accounts/groups/{groupID}", h.handleRenameGroup)
+		r.Delete("/accounts/groups/{groupID}", h.handleDeleteGroup)
 
 		r.Route("/accounts/{id}", func(r chi.Router) {
-			r.Get("/edit", h.handleShowUpdate)
-			r.Put("/update", h.handleUpdate)
+			r.With(middleware.AuthorizeAccount(h.db, model.PermissionView)).Get("/", h.handleShow)
+			r.With(middleware.AuthorizeAccount(h.db, model.PermissionView)).Get("/transactions", h.handleShowTransactions)
+			r.With(middleware.AuthorizeAccount(h.db, model.PermissionView)).Get("/history", h.handleShowHistory)
+			r.With(middleware.AuthorizeAccount(h.db, model.PermissionView)).Post("/pin", h.handleTogglePin)
+			r.With(middleware.AuthorizeAccount(h.db, model.PermissionEdit)).Get("/edit", h.handleShowUpdate)
+			r.With(middleware.AuthorizeAccount(h.db, model.PermissionEdit)).Put("/update", h.handleUpdate)
 			// TODO: add confirmation modal before destroy
-			r.Delete("/destroy", h.handleDestroy)
+			r.With(middleware.AuthorizeAccount(h.db, model.PermissionManage)).Delete("/destroy", h.handleDestroy)
+
+			r.Group(func(r chi.Router) {
+				r.Use(middleware.AuthorizeArchivedAccount(h.db))
+
+				r.Post("/restore", h.handleRestore)
+				r.Get("/purge", h.handleShowPurge)
+				r.Delete("/purge", h.handlePurge)
+			})
 		})
 	})
 }
@@ -52,6 +90,13 @@ func (h *AccountHandler) handleShowIndex(w http.ResponseWriter, r *http.Request)
 
 	logger.WithField("user_id", userID).Debug("fetching_accounts_for_user")
 
+	user, err := model.GetUserByID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("user_fetch_failed")
+		http.Error(w, "Failed to fetch accounts", http.StatusInternalServerError)
+		return
+	}
+
 	accounts, err := model.GetAccounstByID(h.db, userID)
 	if err != nil {
 		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_accounts_by_user_id")
@@ -59,17 +104,53 @@ func (h *AccountHandler) handleShowIndex(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	accountViews := make([]model.AccountView, len(accounts))
-	for i, account := range accounts {
-		accountViews[i] = account.ToView()
+	groups, err := model.GetAccountGroupsByUserID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_account_groups")
+		http.Error(w, "Failed to fetch accounts", http.StatusInternalServerError)
+		return
+	}
+
+	groupViews := model.GroupAccounts(groups, accounts)
+	for i := range groupViews {
+		h.calculateSubtotal(r, &groupViews[i], user.Currency)
 	}
 
 	logger.WithFields(logrus.Fields{
 		"user_id":        userID,
 		"accounts_count": len(accounts),
+		"groups_count":   len(groups),
 	}).Debug("accounts_fetched_successfully")
 
-	view(w, r, pages.AccountSlider(accountViews))
+	view(w, r, pages.AccountSlider(groupViews))
+}
+
+// calculateSubtotal sums the group's balances in the user's currency, when a
+// rate is unavailable the subtotal is left out rather than failing the page
+func (h *AccountHandler) calculateSubtotal(r *http.Request, group *model.AccountGroupView, currency model.Currency) {
+	logger := middleware.GetLogger(r.Context())
+
+	total := decimal.Zero
+	for from, balance := range group.BalancesByCurrency() {
+		if from == currency {
+			total = total.Add(balance)
+			continue
+		}
+		converted, err := h.exchangeService.ConvertAmount(r.Context(), balance, from, currency)
+		if err != nil {
+			logger.WithError(err).
+				WithField("group_id", group.ID).
+				WithField("from_currency", from).
+				WithField("to_currency", currency).
+				Warn("failed_to_convert_currency")
+			return
+		}
+		total = total.Add(converted)
+	}
+
+	group.Subtotal = total
+	group.Currency = currency
+	group.HasSubtotal = true
 }
 
 func (h *AccountHandler) handleShowCreate(w http.ResponseWriter, r *http.Request) {
@@ -77,7 +158,15 @@ func (h *AccountHandler) handleShowCreate(w http.ResponseWriter, r *http.Request
 	userID := GetUserID(r.Context())
 
 	logger.WithField("user_id", userID).Debug("showing_create_account_modal")
-	view(w, r, pages.CreateAccountModal())
+
+	households, err := model.GetShareableHouseholds(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_households")
+		http.Error(w, "Failed to fetch households", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.CreateAccountModal(households))
 }
 
 func (h *AccountHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
@@ -102,6 +191,7 @@ func (h *AccountHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
 		Color:                 r.FormValue("color"),
 		Currency:              model.Currency(r.FormValue("currency")),
 		AllowsNegativeBalance: r.FormValue("allows_negative_balance") == "true",
+		HouseholdID:           formValueAsInt64(r, "household_id"),
 	}
 
 	logger.WithFields(logrus.Fields{
@@ -125,7 +215,11 @@ func (h *AccountHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accountID, err := model.CreateAccount(h.db, userID, input)
+	if !h.canShare(w, r, input.HouseholdID) {
+		return
+	}
+
+	accountID, err := model.CreateAccount(h.db, auditActor(r), input)
 	if err != nil {
 		logger.WithError(err).WithFields(logrus.Fields{
 			"user_id":      userID,
@@ -152,103 +246,40 @@ func (h *AccountHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
 func (h *AccountHandler) handleShowUpdate(w http.ResponseWriter, r *http.Request) {
 	logger := middleware.GetLogger(r.Context())
 	userID := GetUserID(r.Context())
-
-	id, err := routeParamAsInt64(r, "id")
-	if err != nil {
-		logger.WithError(err).WithFields(logrus.Fields{
-			"user_id": userID,
-			"id":      chi.URLParam(r, "id"),
-		}).Error("invalid_account_id_parameter")
-		http.Error(w, "Invalid account ID", http.StatusBadRequest)
-		return
-	}
+	account := middleware.GetAccount(r.Context())
 
 	logger.WithFields(logrus.Fields{
 		"user_id":    userID,
-		"account_id": id,
-	}).Debug("fetching_account_for_edit")
+		"account_id": account.ID,
+	}).Debug("showing_edit_account_modal")
 
-	account, err := model.GetAccountByID(h.db, id)
+	households, err := model.GetHouseholdsByUserID(h.db, userID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			logger.WithFields(logrus.Fields{
-				"user_id":    userID,
-				"account_id": id,
-			}).Warn("account_not_found")
-			http.Error(w, "Account not found", http.StatusNotFound)
-			return
-		}
-		logger.WithError(err).WithFields(logrus.Fields{
-			"user_id":    userID,
-			"account_id": id,
-		}).Error("failed_to_fetch_account_for_edit")
-		http.Error(w, "Failed to fetch account", http.StatusInternalServerError)
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_households")
+		http.Error(w, "Failed to fetch households", http.StatusInternalServerError)
 		return
 	}
 
-	if !account.IsOwnedByUserID(userID) {
-		logger.WithFields(logrus.Fields{
-			"account_id": id,
-			"user_id":    userID,
-		}).Warn("unauthorized_account_access_attempt")
-		http.Error(w, "Unauthorized", http.StatusForbidden)
-		return
-	}
-
-	logger.WithFields(logrus.Fields{
-		"user_id":    userID,
-		"account_id": id,
-	}).Debug("showing_edit_account_modal")
+	// the current household stays selectable so saving doesn't unshare the
+	// account when the owner lost edit rights in it
+	households = slices.DeleteFunc(households, func(household model.Household) bool {
+		return !household.Role.Can(model.PermissionEdit) && household.ID != account.HouseholdID.Int64
+	})
 
-	view(w, r, pages.EditAccountModal(account.ToView()))
+	view(w, r, pages.EditAccountModal(account.ToView(), households))
 }
 
 func (h *AccountHandler) handleUpdate(w http.ResponseWriter, r *http.Request) {
 	logger := middleware.GetLogger(r.Context())
 	userID := GetUserID(r.Context())
-
-	id, err := routeParamAsInt64(r, "id")
-	if err != nil {
-		logger.WithError(err).WithFields(logrus.Fields{
-			"user_id": userID,
-			"id":      chi.URLParam(r, "id"),
-		}).Error("invalid_account_id_parameter")
-		http.Error(w, "Invalid account ID", http.StatusBadRequest)
-		return
-	}
+	account := middleware.GetAccount(r.Context())
+	id := account.ID
 
 	logger.WithFields(logrus.Fields{
 		"user_id":    userID,
 		"account_id": id,
 	}).Debug("processing_account_update")
 
-	account, err := model.GetAccountByID(h.db, id)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			logger.WithFields(logrus.Fields{
-				"user_id":    userID,
-				"account_id": id,
-			}).Warn("account_not_found")
-			http.Error(w, "Account not found", http.StatusNotFound)
-			return
-		}
-		logger.WithError(err).WithFields(logrus.Fields{
-			"user_id":    userID,
-			"account_id": id,
-		}).Error("failed_to_fetch_account_for_update")
-		http.Error(w, "Failed to fetch account", http.StatusInternalServerError)
-		return
-	}
-
-	if !account.IsOwnedByUserID(userID) {
-		logger.WithFields(logrus.Fields{
-			"account_id": id,
-			"user_id":    userID,
-		}).Warn("unauthorized_account_update_attempt")
-		http.Error(w, "Unauthorized", http.StatusForbidden)
-		return
-	}
-
 	input := model.UpdateAccountInput{
 		Name:                  r.FormValue("name"),
 		AccountType:           model.AccountType(r.FormValue("account_type")),
@@ -256,6 +287,12 @@ func (h *AccountHandler) handleUpdate(w http.ResponseWriter, r *http.Request) {
 		Currency:              model.Currency(r.FormValue("currency")),
 		AllowsNegativeBalance: r.FormValue("allows_negative_balance") == "on",
 		IsActive:              1,
+		HouseholdID:           account.HouseholdID.Int64,
+	}
+
+	// only the owner decides who else gets to see the account
+	if account.Role == model.RoleOwner {
+		input.HouseholdID = formValueAsInt64(r, "household_id")
 	}
 
 	v := validator.New()
@@ -270,7 +307,11 @@ func (h *AccountHandler) handleUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = model.UpdateAccount(h.db, id, input)
+	if input.HouseholdID != account.HouseholdID.Int64 && !h.canShare(w, r, input.HouseholdID) {
+		return
+	}
+
+	err := model.UpdateAccount(h.db, auditActor(r), id, input)
 	if err != nil {
 		logger.WithError(err).WithFields(logrus.Fields{
 			"user_id":      userID,
@@ -295,63 +336,521 @@ func (h *AccountHandler) handleUpdate(w http.ResponseWriter, r *http.Request) {
 func (h *AccountHandler) handleDestroy(w http.ResponseWriter, r *http.Request) {
 	userID := GetUserID(r.Context())
 	logger := middleware.GetLogger(r.Context())
+	accountID := middleware.GetAccount(r.Context()).ID
+
+	logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"account_id": accountID,
+	}).Debug("processing_account_deletion")
 
-	accountID, err := routeParamAsInt64(r, "id")
+	err := model.DeleteAccount(h.db, auditActor(r), accountID)
 	if err != nil {
 		logger.WithError(err).WithFields(logrus.Fields{
-			"user_id": userID,
-			"id":      chi.URLParam(r, "id"),
-		}).Error("invalid_account_id_parameter")
-		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+			"user_id":    userID,
+			"account_id": accountID,
+		}).Error("failed_to_delete_account")
+		TriggerErrorToast(w, "Failed to delete account")
 		return
 	}
 
 	logger.WithFields(logrus.Fields{
-		"user_id":    userID,
 		"account_id": accountID,
-	}).Debug("processing_account_deletion")
+		"user_id":    userID,
+	}).Info("account_deleted_successfully")
+
+	TriggerHtmx(w, "reloadAccounts")
+}
+
+// canShare checks the current user may share an account with the household,
+// rendering the form error when they may not
+func (h *AccountHandler) canShare(w http.ResponseWriter, r *http.Request, householdID int64) bool {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	allowed, err := model.CanShareWithHousehold(h.db, householdID, userID)
+	if err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":      userID,
+			"household_id": householdID,
+		}).Error("failed_to_check_household_access")
+		TriggerErrorToast(w, "Something went wrong")
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
 
-	account, err := model.GetAccountByID(h.db, accountID)
+	if !allowed {
+		logger.WithFields(logrus.Fields{
+			"user_id":      userID,
+			"household_id": householdID,
+		}).Warn("account_share_not_allowed")
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.CreateAccountFormErrors(map[string]string{
+			"householdid": "You can't share accounts with this household",
+		}))
+		return false
+	}
+
+	return true
+}
+
+// handleShow renders the account page, the transactions are loaded by the
+// page itself so filtering can swap them alone
+func (h *AccountHandler) handleShow(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	categories, err := model.GetTransactionCategories(h.db, account.ID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			logger.WithFields(logrus.Fields{
-				"user_id":    userID,
-				"account_id": accountID,
-			}).Warn("account_not_found")
-			http.Error(w, "Account not found", http.StatusNotFound)
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_fetch_transaction_categories")
+		http.Error(w, "Failed to fetch account", http.StatusInternalServerError)
+		return
+	}
+
+	tags, err := model.GetTagsByUserID(h.db, GetUserID(r.Context()))
+	if err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_fetch_tags")
+		http.Error(w, "Failed to fetch account", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.AccountDetail(account.ToView(), categories, tags))
+}
+
+// handleShowTransactions renders a page of the account's transactions, the
+// first page as a whole table and later ones as rows appended while the user
+// scrolls
+func (h *AccountHandler) handleShowTransactions(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	account := middleware.GetAccount(r.Context())
+	query := r.URL.Query()
+	page, perPage := paginationParams(r)
+
+	tagID, _ := strconv.ParseInt(query.Get("tag_id"), 10, 64)
+	input := model.TransactionSearchInput{
+		Search:    strings.TrimSpace(query.Get("q")),
+		From:      query.Get("from"),
+		To:        query.Get("to"),
+		Category:  query.Get("category"),
+		MinAmount: strings.TrimSpace(query.Get("min_amount")),
+		MaxAmount: strings.TrimSpace(query.Get("max_amount")),
+		TagID:     tagID,
+	}
+
+	v := validator.New()
+	if errs := v.Validate(input); len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the filters")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	// tags are personal, only the user's own can narrow the list down
+	if input.TagID != 0 {
+		if _, err := model.GetTagForUser(h.db, input.TagID, userID); err != nil {
+			if !errors.Is(err, model.ErrTagNotFound) {
+				logger.WithError(err).WithField("tag_id", input.TagID).Error("failed_to_fetch_tag")
+			}
+			TriggerErrorToast(w, "Please check the filters")
+			w.WriteHeader(http.StatusUnprocessableEntity)
 			return
 		}
+	}
+
+	// one extra row tells whether there is another page to load
+	transactions, err := model.GetAccountTransactionsPage(
+		h.db,
+		account,
+		input.Filter(account.ID),
+		perPage+1,
+		(page-1)*perPage,
+	)
+	if err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_fetch_transactions")
+		http.Error(w, "Failed to fetch transactions", http.StatusInternalServerError)
+		return
+	}
+
+	nextURL := ""
+	if len(transactions) > perPage {
+		transactions = transactions[:perPage]
+		query.Set("page", strconv.Itoa(page+1))
+		nextURL = fmt.Sprintf("/accounts/%d/transactions?%s", account.ID, query.Encode())
+	}
+
+	transactionIDs := make([]int64, len(transactions))
+	for i, transaction := range transactions {
+		transactionIDs[i] = transaction.ID
+	}
+
+	splits, err := model.GetTransactionSplits(h.db, transactionIDs)
+	if err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_fetch_transaction_splits")
+		http.Error(w, "Failed to fetch transactions", http.StatusInternalServerError)
+		return
+	}
+
+	tags, err := model.GetTransactionTags(h.db, userID, transactionIDs)
+	if err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_fetch_transaction_tags")
+		http.Error(w, "Failed to fetch transactions", http.StatusInternalServerError)
+		return
+	}
+
+	attachments, err := model.GetTransactionAttachmentCounts(h.db, transactionIDs)
+	if err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_count_transaction_attachments")
+		http.Error(w, "Failed to fetch transactions", http.StatusInternalServerError)
+		return
+	}
+
+	transactionViews := make([]model.TransactionView, len(transactions))
+	for i, transaction := range transactions {
+		transactionViews[i] = transaction.ToView(account.Currency)
+		transactionViews[i].Splits = splits[transaction.ID]
+		transactionViews[i].Tags = tags[transaction.ID]
+		transactionViews[i].Attachments = attachments[transaction.ID]
+	}
+
+	canEdit := account.Role.Can(model.PermissionEdit)
+	if page > 1 {
+		view(w, r, pages.TransactionRows(transactionViews, nextURL, canEdit))
+		return
+	}
+	view(w, r, pages.TransactionTable(transactionViews, nextURL, canEdit))
+}
+
+// handleShowHistory renders the audit trail of the account, newest first
+func (h *AccountHandler) handleShowHistory(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	account := middleware.GetAccount(r.Context())
+	page, perPage := paginationParams(r)
+
+	logs, total, err := model.GetAuditLogsPage(
+		h.db,
+		model.AuditLogFilter{AccountID: account.ID},
+		perPage,
+		(page-1)*perPage,
+	)
+	if err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_fetch_audit_logs")
+		http.Error(w, "Failed to fetch history", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.AccountHistory(account.ToView(), logs, page, page*perPage < total))
+}
+
+// handleShowArchived renders the page listing soft deleted accounts
+func (h *AccountHandler) handleShowArchived(w http.ResponseWriter, r *http.Request) {
+	view(w, r, pages.ArchivedAccounts())
+}
+
+func (h *AccountHandler) handleShowArchivedList(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	accounts, err := model.GetArchivedAccountsByUserID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_archived_accounts")
+		http.Error(w, "Failed to fetch accounts", http.StatusInternalServerError)
+		return
+	}
+
+	accountViews := make([]model.AccountView, len(accounts))
+	for i, account := range accounts {
+		accountViews[i] = account.ToView()
+	}
+
+	view(w, r, pages.ArchivedAccountList(accountViews))
+}
+
+func (h *AccountHandler) handleRestore(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	if err := model.RestoreAccount(h.db, auditActor(r), account.ID); err != nil {
 		logger.WithError(err).WithFields(logrus.Fields{
 			"user_id":    userID,
-			"account_id": accountID,
-		}).Error("failed_to_fetch_account_for_deletion")
-		http.Error(w, "Failed to fetch account", http.StatusInternalServerError)
+			"account_id": account.ID,
+		}).Error("failed_to_restore_account")
+		TriggerErrorToast(w, "Failed to restore account")
 		return
 	}
 
-	if !account.IsOwnedByUserID(userID) {
-		logger.WithFields(logrus.Fields{
-			"account_id": accountID,
-			"user_id":    userID,
-		}).Warn("unauthorized_account_deletion_attempt")
-		TriggerErrorToast(w, "You are unauthorized for this action!")
+	logger.WithFields(logrus.Fields{
+		"account_id": account.ID,
+		"user_id":    userID,
+	}).Info("account_restored_successfully")
+
+	TriggerWithToast(w, "reloadArchivedAccounts", ToastSuccess, account.Name+" was restored")
+}
+
+func (h *AccountHandler) handleShowPurge(w http.ResponseWriter, r *http.Request) {
+	view(w, r, pages.PurgeAccountModal(middleware.GetAccount(r.Context()).ToView()))
+}
+
+// handlePurge permanently deletes an archived account once the user typed
+// its name to confirm
+func (h *AccountHandler) handlePurge(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	input := model.PurgeAccountInput{
+		Confirmation: r.FormValue("confirmation"),
+	}
+
+	v := validator.New()
+	errs := v.Validate(input)
+	if len(errs) == 0 && strings.TrimSpace(input.Confirmation) != account.Name {
+		errs = map[string]string{"confirmation": "Type the account name exactly to confirm"}
+	}
+
+	if len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors([]string{"confirmation"}, errs))
 		return
 	}
 
-	err = model.DeleteAccount(h.db, accountID)
+	// the files are looked up first, their records go with the account
+	attachmentKeys, err := model.GetAccountAttachmentKeys(h.db, account.ID)
 	if err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_fetch_attachments")
+		TriggerErrorToast(w, "Failed to delete account")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := model.PurgeAccount(h.db, auditActor(r), account); err != nil {
 		logger.WithError(err).WithFields(logrus.Fields{
 			"user_id":    userID,
-			"account_id": accountID,
-		}).Error("failed_to_delete_account")
+			"account_id": account.ID,
+		}).Error("failed_to_purge_account")
 		TriggerErrorToast(w, "Failed to delete account")
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	logger.WithFields(logrus.Fields{
-		"account_id": accountID,
+		"account_id": account.ID,
 		"user_id":    userID,
-	}).Info("account_deleted_successfully")
+	}).Info("account_purged_successfully")
+
+	h.attachmentService.DeleteObjects(r.Context(), attachmentKeys)
+
+	TriggerWithToast(w, "reloadArchivedAccounts", ToastSuccess, account.Name+" was permanently deleted")
+}
+
+// handleTogglePin pins the account to the front of its group for the current
+// user, or unpins it
+func (h *AccountHandler) handleTogglePin(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	if err := model.SetAccountPinned(h.db, userID, account.ID, !account.Pinned); err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"account_id": account.ID,
+		}).Error("failed_to_pin_account")
+		TriggerErrorToast(w, "Failed to pin account")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"account_id": account.ID,
+		"pinned":     !account.Pinned,
+	}).Info("account_pin_toggled")
 
 	TriggerHtmx(w, "reloadAccounts")
 }
+
+// handleReorder stores the order of the accounts in a group after one was
+// dragged, including accounts moved in from another group
+func (h *AccountHandler) handleReorder(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	accountIDs, err := formValuesAsInt64(r, "account_ids")
+	if err != nil {
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+	groupID := formValueAsInt64(r, "group_id")
+
+	if err := model.ReorderAccounts(h.db, userID, groupID, accountIDs); err != nil {
+		switch {
+		case errors.Is(err, model.ErrAccountGroupNotFound):
+			TriggerWithToast(w, "reloadAccounts", ToastError, "Group not found")
+		case errors.Is(err, model.ErrAccountForbidden):
+			TriggerWithToast(w, "reloadAccounts", ToastError, "Account not found")
+		default:
+			logger.WithError(err).WithFields(logrus.Fields{
+				"user_id":  userID,
+				"group_id": groupID,
+			}).Error("failed_to_reorder_accounts")
+			TriggerWithToast(w, "reloadAccounts", ToastError, "Failed to save the order")
+		}
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":        userID,
+		"group_id":       groupID,
+		"accounts_count": len(accountIDs),
+	}).Debug("accounts_reordered")
+
+	// subtotals change when an account moves between groups
+	TriggerHtmx(w, "reloadAccounts")
+}
+
+// handleShowGroups renders the page for managing account groups
+func (h *AccountHandler) handleShowGroups(w http.ResponseWriter, r *http.Request) {
+	view(w, r, pages.AccountGroups())
+}
+
+func (h *AccountHandler) handleShowGroupList(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	groups, err := model.GetAccountGroupsByUserID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_account_groups")
+		http.Error(w, "Failed to fetch groups", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.AccountGroupList(groups))
+}
+
+func (h *AccountHandler) handleCreateGroup(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	input := model.AccountGroupInput{
+		Name: strings.TrimSpace(r.FormValue("name")),
+	}
+
+	v := validator.New()
+	if errs := v.Validate(input); len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors([]string{"name"}, errs))
+		return
+	}
+
+	groupID, err := model.CreateAccountGroup(h.db, userID, input)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_create_account_group")
+		TriggerErrorToast(w, "Failed to create group")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":  userID,
+		"group_id": groupID,
+	}).Info("account_group_created_successfully")
+
+	TriggerWithToast(w, "reloadAccountGroups", ToastSuccess, "Group created!")
+	view(w, r, pages.SettingsFormErrors([]string{"name"}, nil))
+}
+
+func (h *AccountHandler) handleRenameGroup(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	groupID, err := routeParamAsInt64(r, "groupID")
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	input := model.AccountGroupInput{
+		Name: strings.TrimSpace(r.FormValue("name")),
+	}
+
+	v := validator.New()
+	if errs := v.Validate(input); len(errs) > 0 {
+		TriggerWithToast(w, "reloadAccountGroups", ToastError, "Group names need 1 to 50 characters")
+		return
+	}
+
+	if err := model.RenameAccountGroup(h.db, groupID, userID, input); err != nil {
+		if errors.Is(err, model.ErrAccountGroupNotFound) {
+			TriggerWithToast(w, "reloadAccountGroups", ToastError, "Group not found")
+			return
+		}
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":  userID,
+			"group_id": groupID,
+		}).Error("failed_to_rename_account_group")
+		TriggerErrorToast(w, "Failed to rename group")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":  userID,
+		"group_id": groupID,
+	}).Info("account_group_renamed_successfully")
+
+	TriggerWithToast(w, "reloadAccountGroups", ToastSuccess, "Group renamed")
+}
+
+func (h *AccountHandler) handleDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	groupID, err := routeParamAsInt64(r, "groupID")
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := model.DeleteAccountGroup(h.db, groupID, userID); err != nil {
+		if errors.Is(err, model.ErrAccountGroupNotFound) {
+			TriggerWithToast(w, "reloadAccountGroups", ToastError, "Group not found")
+			return
+		}
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":  userID,
+			"group_id": groupID,
+		}).Error("failed_to_delete_account_group")
+		TriggerErrorToast(w, "Failed to delete group")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":  userID,
+		"group_id": groupID,
+	}).Info("account_group_deleted_successfully")
+
+	TriggerWithToast(w, "reloadAccountGroups", ToastSuccess, "Group deleted")
+}
+
+func (h *AccountHandler) handleReorderGroups(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	groupIDs, err := formValuesAsInt64(r, "group_ids")
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := model.ReorderAccountGroups(h.db, userID, groupIDs); err != nil {
+		if errors.Is(err, model.ErrAccountGroupNotFound) {
+			TriggerWithToast(w, "reloadAccountGroups", ToastError, "Group not found")
+			return
+		}
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_reorder_account_groups")
+		TriggerWithToast(w, "reloadAccountGroups", ToastError, "Failed to save the order")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":      userID,
+		"groups_count": len(groupIDs),
+	}).Debug("account_groups_reordered")
+}
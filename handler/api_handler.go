@@ -0,0 +1,538 @@
+package handler
+
+import (
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/session"
+	"numera/pkg/validator"
+	"numera/services"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+const apiPrefix = "/api/v1"
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+type APIHandler struct {
+	db              *sql.DB
+	logger          *logrus.Logger
+	session         *session.Session
+	exchangeService *services.ExchangeService
+}
+
+func NewAPIHandler(
+	db *sql.DB,
+	logger *logrus.Logger,
+	session *session.Session,
+	exchangeService *services.ExchangeService,
+) *APIHandler {
+	return &APIHandler{
+		db:              db,
+		logger:          logger,
+		session:         session,
+		exchangeService: exchangeService,
+	}
+}
+
+func (h *APIHandler) RegisterRoutes(r *chi.Mux) {
+	r.Route(apiPrefix, func(r chi.Router) {
+		r.Use(middleware.WithLogger(h.logger))
+
+		r.Get("/openapi.json", h.handleOpenAPI)
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireAPIAuth(h.session, h.db))
+
+			r.With(middleware.RequireScope(model.ScopeReadProfile)).Get("/me", h.handleShowMe)
+			r.With(middleware.RequireScope(model.ScopeWriteProfile)).Put("/me/currency", h.handleChangeCurrency)
+
+			r.Group(func(r chi.Router) {
+				r.Use(middleware.RequireScope(model.ScopeReadAccounts))
+
+				r.Get("/accounts", h.handleListAccounts)
+				r.With(middleware.AuthorizeAccount(h.db, model.PermissionView)).Get("/accounts/{id}", h.handleShowAccount)
+				r.Get("/balances", h.handleShowBalances)
+				r.Get("/conversions", h.handleConvert)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(middleware.RequireScope(model.ScopeWriteAccounts))
+
+				r.Post("/accounts", h.handleCreateAccount)
+				r.With(middleware.AuthorizeAccount(h.db, model.PermissionEdit)).Put("/accounts/{id}", h.handleUpdateAccount)
+				r.With(middleware.AuthorizeAccount(h.db, model.PermissionManage)).Delete("/accounts/{id}", h.handleDestroyAccount)
+			})
+
+			r.With(
+				middleware.RequireScope(model.ScopeReadTransactions),
+				middleware.AuthorizeAccount(h.db, model.PermissionView),
+			).Get("/accounts/{id}/transactions", h.handleListTransactions)
+			r.With(
+				middleware.RequireScope(model.ScopeWriteTransactions),
+				middleware.AuthorizeAccount(h.db, model.PermissionEdit),
+			).Post("/accounts/{id}/transactions", h.handleCreateTransaction)
+		})
+	})
+}
+
+// CheckOpenAPIRoutes compares the routes registered under the api prefix with
+// the embedded OpenAPI document and returns every route that is not documented.
+func CheckOpenAPIRoutes(r chi.Routes) ([]string, error) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPIDocument, &doc); err != nil {
+		return nil, err
+	}
+
+	var undocumented []string
+	walkFunc := func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		path, ok := strings.CutPrefix(route, apiPrefix)
+		if !ok {
+			return nil
+		}
+		if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+			undocumented = append(undocumented, method+" "+route)
+		}
+		return nil
+	}
+	if err := chi.Walk(r, walkFunc); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(undocumented)
+	return undocumented, nil
+}
+
+func (h *APIHandler) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
+
+func (h *APIHandler) handleShowMe(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	user, err := model.GetUserByID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("user_fetch_failed")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to fetch user")
+		return
+	}
+
+	total, _, err := h.totalBalance(r, user)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to calculate total balance")
+		return
+	}
+
+	respondData(w, http.StatusOK, user.ToViewWithTotalBalance(total))
+}
+
+func (h *APIHandler) handleChangeCurrency(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	var input model.ChangeCurrencyRequest
+	if err := decodeJSON(r, &input); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_json", "Request body is not valid JSON")
+		return
+	}
+
+	v := validator.New()
+	if errors := v.Validate(input); len(errors) > 0 {
+		respondValidationError(w, errors)
+		return
+	}
+
+	if err := model.ChangeCurrencyByUserID(h.db, auditActor(r), input.Currency); err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_change_currency")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to change currency")
+		return
+	}
+
+	user, err := model.GetUserByID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("user_fetch_failed")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to fetch user")
+		return
+	}
+
+	respondData(w, http.StatusOK, user.ToView())
+}
+
+func (h *APIHandler) handleListAccounts(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	page, perPage := paginationParams(r)
+
+	accounts, total, err := model.GetAccountsPageByUserID(h.db, userID, perPage, (page-1)*perPage)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_accounts_by_user_id")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to fetch accounts")
+		return
+	}
+
+	accountViews := make([]model.AccountView, len(accounts))
+	for i, account := range accounts {
+		accountViews[i] = account.ToView()
+	}
+
+	respondPage(w, accountViews, APIMeta{Page: page, PerPage: perPage, Total: total})
+}
+
+func (h *APIHandler) handleCreateAccount(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	var input model.CreateAccountInput
+	if err := decodeJSON(r, &input); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_json", "Request body is not valid JSON")
+		return
+	}
+
+	v := validator.New()
+	if errors := v.Validate(input); len(errors) > 0 {
+		respondValidationError(w, errors)
+		return
+	}
+
+	if !h.canShare(w, r, input.HouseholdID) {
+		return
+	}
+
+	accountID, err := model.CreateAccount(h.db, auditActor(r), input)
+	if err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":      userID,
+			"account_name": input.Name,
+		}).Error("failed_to_create_account")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to create account")
+		return
+	}
+
+	account, err := model.GetAccountForUser(h.db, accountID, userID)
+	if err != nil {
+		logger.WithError(err).WithField("account_id", accountID).Error("failed_to_fetch_created_account")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to fetch account")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"account_id": accountID,
+		"user_id":    userID,
+	}).Info("account_created_successfully")
+
+	respondData(w, http.StatusCreated, account.ToView())
+}
+
+func (h *APIHandler) handleShowAccount(w http.ResponseWriter, r *http.Request) {
+	account := middleware.GetAccount(r.Context())
+
+	respondData(w, http.StatusOK, account.ToView())
+}
+
+func (h *APIHandler) handleUpdateAccount(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	input := model.UpdateAccountInput{HouseholdID: account.HouseholdID.Int64}
+	if err := decodeJSON(r, &input); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_json", "Request body is not valid JSON")
+		return
+	}
+	input.IsActive = 1
+
+	// only the owner decides who else gets to see the account
+	if account.Role != model.RoleOwner {
+		input.HouseholdID = account.HouseholdID.Int64
+	}
+
+	v := validator.New()
+	if errors := v.Validate(input); len(errors) > 0 {
+		respondValidationError(w, errors)
+		return
+	}
+
+	if input.HouseholdID != account.HouseholdID.Int64 && !h.canShare(w, r, input.HouseholdID) {
+		return
+	}
+
+	if err := model.UpdateAccount(h.db, auditActor(r), account.ID, input); err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_update_account")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to update account")
+		return
+	}
+
+	updated, err := model.GetAccountForUser(h.db, account.ID, userID)
+	if err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_fetch_updated_account")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to fetch account")
+		return
+	}
+
+	respondData(w, http.StatusOK, updated.ToView())
+}
+
+func (h *APIHandler) handleDestroyAccount(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	if err := model.DeleteAccount(h.db, auditActor(r), account.ID); err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_delete_account")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to delete account")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *APIHandler) handleListTransactions(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	account := middleware.GetAccount(r.Context())
+	page, perPage := paginationParams(r)
+	query := r.URL.Query()
+
+	input := model.TransactionSearchInput{
+		Search:    query.Get("q"),
+		From:      query.Get("from"),
+		To:        query.Get("to"),
+		Category:  query.Get("category"),
+		MinAmount: query.Get("min_amount"),
+		MaxAmount: query.Get("max_amount"),
+	}
+
+	v := validator.New()
+	if errors := v.Validate(input); len(errors) > 0 {
+		respondValidationError(w, errors)
+		return
+	}
+	filter := input.Filter(account.ID)
+
+	total, err := model.CountAccountTransactions(h.db, account.ID, filter)
+	if err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_count_transactions")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to fetch transactions")
+		return
+	}
+
+	transactions, err := model.GetAccountTransactionsPage(h.db, account, filter, perPage, (page-1)*perPage)
+	if err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_fetch_transactions")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to fetch transactions")
+		return
+	}
+
+	transactionIDs := make([]int64, len(transactions))
+	for i, transaction := range transactions {
+		transactionIDs[i] = transaction.ID
+	}
+
+	splits, err := model.GetTransactionSplits(h.db, transactionIDs)
+	if err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_fetch_transaction_splits")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to fetch transactions")
+		return
+	}
+
+	transactionViews := make([]model.TransactionView, len(transactions))
+	for i, transaction := range transactions {
+		transactionViews[i] = transaction.ToView(account.Currency)
+		transactionViews[i].Splits = splits[transaction.ID]
+	}
+
+	respondPage(w, transactionViews, APIMeta{Page: page, PerPage: perPage, Total: total})
+}
+
+func (h *APIHandler) handleCreateTransaction(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	var input model.CreateTransactionInput
+	if err := decodeJSON(r, &input); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_json", "Request body is not valid JSON")
+		return
+	}
+
+	v := validator.New()
+	errors := v.Validate(input)
+	if input.Amount.IsZero() {
+		errors = v.AddError(errors, "amount", "Amount can't be zero")
+	}
+	if len(errors) > 0 {
+		respondValidationError(w, errors)
+		return
+	}
+
+	transactionID, err := model.CreateTransaction(h.db, auditActor(r), account, input)
+	if err != nil {
+		if err == model.ErrTransactionNegativeBalance {
+			respondValidationError(w, map[string]string{
+				"amount": "This account can't go below zero",
+			})
+			return
+		}
+
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_create_transaction")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to create transaction")
+		return
+	}
+
+	transaction, err := model.GetAccountTransaction(h.db, account.ID, transactionID)
+	if err != nil {
+		logger.WithError(err).WithField("transaction_id", transactionID).Error("failed_to_fetch_created_transaction")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to fetch transaction")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"account_id":     account.ID,
+		"transaction_id": transactionID,
+	}).Info("transaction_created_successfully")
+
+	respondData(w, http.StatusCreated, transaction.ToView(account.Currency))
+}
+
+type balancesResponse struct {
+	Currency   model.Currency                     `json:"currency"`
+	Total      decimal.Decimal                    `json:"total"`
+	ByCurrency map[model.Currency]decimal.Decimal `json:"by_currency"`
+}
+
+func (h *APIHandler) handleShowBalances(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	user, err := model.GetUserByID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("user_fetch_failed")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to fetch user")
+		return
+	}
+
+	total, balancesByCurrency, err := h.totalBalance(r, user)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "internal_error", "Failed to calculate total balance")
+		return
+	}
+
+	respondData(w, http.StatusOK, balancesResponse{
+		Currency:   user.Currency,
+		Total:      total,
+		ByCurrency: balancesByCurrency,
+	})
+}
+
+type conversionRequest struct {
+	Amount decimal.Decimal `json:"amount"`
+	From   model.Currency  `json:"from" validate:"required,oneof=EUR USD RSD GBP JPY CHF"`
+	To     model.Currency  `json:"to" validate:"required,oneof=EUR USD RSD GBP JPY CHF"`
+}
+
+type conversionResponse struct {
+	conversionRequest
+	Converted decimal.Decimal `json:"converted"`
+}
+
+func (h *APIHandler) handleConvert(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	query := r.URL.Query()
+
+	v := validator.New()
+	input := conversionRequest{
+		From: model.Currency(query.Get("from")),
+		To:   model.Currency(query.Get("to")),
+	}
+
+	errors := v.Validate(input)
+	amount, err := decimal.NewFromString(query.Get("amount"))
+	if err != nil {
+		errors = v.AddError(errors, "amount", "Amount must be a number")
+	}
+	if len(errors) > 0 {
+		respondValidationError(w, errors)
+		return
+	}
+	input.Amount = amount
+
+	converted, err := h.exchangeService.ConvertAmount(r.Context(), input.Amount, input.From, input.To)
+	if err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"from": input.From,
+			"to":   input.To,
+		}).Warn("failed_to_convert_currency")
+		respondError(w, http.StatusBadGateway, "conversion_failed", "Failed to fetch exchange rate")
+		return
+	}
+
+	respondData(w, http.StatusOK, conversionResponse{
+		conversionRequest: input,
+		Converted:         converted,
+	})
+}
+
+// canShare checks the current user may share an account with the household,
+// writing the validation error when they may not
+func (h *APIHandler) canShare(w http.ResponseWriter, r *http.Request, householdID int64) bool {
+	userID := GetUserID(r.Context())
+
+	allowed, err := model.CanShareWithHousehold(h.db, householdID, userID)
+	if err != nil {
+		middleware.GetLogger(r.Context()).WithError(err).WithFields(logrus.Fields{
+			"user_id":      userID,
+			"household_id": householdID,
+		}).Error("failed_to_check_household_access")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Something went wrong")
+		return false
+	}
+
+	if !allowed {
+		respondValidationError(w, map[string]string{
+			"householdid": "You can't share accounts with this household",
+		})
+		return false
+	}
+
+	return true
+}
+
+// totalBalance converts all balances to user's preferred currency and sums them.
+func (h *APIHandler) totalBalance(
+	r *http.Request,
+	user *model.User,
+) (decimal.Decimal, map[model.Currency]decimal.Decimal, error) {
+	logger := middleware.GetLogger(r.Context())
+
+	balancesByCurrency, err := model.CalculateBalanceByCurrencies(h.db, user.ID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", user.ID).Warn("failed_to_calculate_total_balance")
+		return decimal.Zero, nil, err
+	}
+
+	total := decimal.Zero
+	for currency, balance := range balancesByCurrency {
+		convertedAmount, err := h.exchangeService.ConvertAmount(r.Context(), balance, currency, user.Currency)
+		if err != nil {
+			logger.WithError(err).
+				WithField("user_id", user.ID).
+				WithField("from_currency", currency).
+				WithField("to_currency", user.Currency).
+				Warn("failed_to_convert_currency")
+			return decimal.Zero, nil, err
+		}
+		total = total.Add(convertedAmount)
+	}
+
+	return total, balancesByCurrency, nil
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// APIError is the error body returned by every api endpoint.
+type APIError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// APIMeta describes the pagination state of a list response.
+type APIMeta struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+	Total   int `json:"total"`
+}
+
+type apiEnvelope struct {
+	Data  any       `json:"data,omitempty"`
+	Meta  *APIMeta  `json:"meta,omitempty"`
+	Error *APIError `json:"error,omitempty"`
+}
+
+// writeJSON encodes the body as json with the given status code.
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// respondData writes a successful response wrapped in the data envelope.
+func respondData(w http.ResponseWriter, status int, data any) {
+	writeJSON(w, status, apiEnvelope{Data: data})
+}
+
+// respondPage writes a paginated list response.
+func respondPage(w http.ResponseWriter, data any, meta APIMeta) {
+	writeJSON(w, http.StatusOK, apiEnvelope{Data: data, Meta: &meta})
+}
+
+// respondError writes an error response wrapped in the error envelope.
+func respondError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiEnvelope{Error: &APIError{Code: code, Message: message}})
+}
+
+// respondValidationError writes the field errors produced by validator.Validate.
+func respondValidationError(w http.ResponseWriter, fields map[string]string) {
+	writeJSON(w, http.StatusUnprocessableEntity, apiEnvelope{Error: &APIError{
+		Code:    "validation_failed",
+		Message: "Please check the request for errors",
+		Fields:  fields,
+	}})
+}
+
+// decodeJSON decodes the request body into dst, rejecting unknown fields.
+func decodeJSON(r *http.Request, dst any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	return dec.Decode(dst)
+}
+
+// paginationParams reads page and per_page query params, falling back to
+// defaults for missing or invalid values.
+func paginationParams(r *http.Request) (page, perPage int) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	perPage, err = strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = defaultPerPage
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+
+	return page, perPage
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/session"
+	"numera/pkg/validator"
+	"numera/services"
+	"numera/views/pages"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+const maxArchiveSize = 32 << 20
+
+type ArchiveHandler struct {
+	db             *sql.DB
+	logger         *logrus.Logger
+	session        *session.Session
+	archiveService *services.ArchiveService
+}
+
+func NewArchiveHandler(
+	db *sql.DB,
+	logger *logrus.Logger,
+	session *session.Session,
+	archiveService *services.ArchiveService,
+) *ArchiveHandler {
+	return &ArchiveHandler{
+		db:             db,
+		logger:         logger,
+		session:        session,
+		archiveService: archiveService,
+	}
+}
+
+func (h *ArchiveHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireGuest(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+
+		r.Get("/import", h.handleShowImport)
+		r.Post("/import", h.handleImport)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+
+		r.Get("/settings/export", h.handleExport)
+	})
+}
+
+// handleExport sends the logged in user's data as a zip archive. The archive
+// is built in a temporary file first, so a failure halfway through is still
+// answered with an error status instead of a truncated download.
+func (h *ArchiveHandler) handleExport(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	f, err := os.CreateTemp("", "numera-export-*.zip")
+	if err != nil {
+		logger.WithError(err).Error("failed_to_create_export_file")
+		http.Error(w, "Failed to export data", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := h.archiveService.Export(f, userID); err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_export_archive")
+		http.Error(w, "Failed to export data", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("numera-export-%s.zip", time.Now().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	http.ServeContent(w, r, filename, time.Now(), f)
+}
+
+// handleShowImport renders the restore from archive page
+func (h *ArchiveHandler) handleShowImport(w http.ResponseWriter, r *http.Request) {
+	view(w, r, pages.ImportArchive())
+}
+
+// handleImport creates a new user from an uploaded archive
+func (h *ArchiveHandler) handleImport(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxArchiveSize)
+	v := validator.New()
+	errors := make(map[string]string)
+
+	var archive *services.Archive
+	file, header, err := r.FormFile("archive")
+	if err != nil {
+		errors = v.AddError(errors, "archive", "Archive is required")
+	} else {
+		defer file.Close()
+
+		archive, err = h.archiveService.Read(file, header.Size)
+		if err != nil {
+			logger.WithError(err).Warn("archive_read_failed")
+			errors = v.AddError(errors, "archive", archiveErrorMessage(err))
+		}
+	}
+
+	input := model.CreateUserInput{
+		Email:           r.PostFormValue("email"),
+		Password:        r.PostFormValue("password"),
+		PasswordConfirm: r.PostFormValue("passwordconfirm"),
+	}
+	if archive != nil {
+		input.Name = archive.Profile.Name
+	}
+
+	for field, message := range v.Validate(input) {
+		if field == "name" {
+			// name comes from the archive, so it is reported on the archive field
+			if archive != nil {
+				errors = v.AddError(errors, "archive", "Archived profile: "+message)
+			}
+			continue
+		}
+		errors = v.AddError(errors, field, message)
+	}
+
+	user, _ := model.GetUserByEmail(h.db, input.Email)
+	if user != nil {
+		logger.WithField("email", input.Email).Error("email_already_taken")
+
+		errors = v.AddError(errors, "email", "This email address is already in use")
+	}
+
+	if len(errors) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.ImportFormErrors(errors))
+		return
+	}
+
+	result, err := h.archiveService.Import(archive, input, clientIP(r))
+	if err != nil {
+		logger.WithError(err).WithField("email", input.Email).Error("archive_import_failed")
+
+		TriggerErrorToast(w, "We could not restore this archive, please try again.")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":        result.UserID,
+		"email":          input.Email,
+		"accounts_count": len(result.AccountIDs),
+	}).Info("user_restored_from_archive")
+
+	RedirectUsingHtmx(w, "/login")
+}
+
+func archiveErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, services.ErrArchiveUnsupported):
+		return "This archive was created by a newer version of Numera"
+	case errors.Is(err, services.ErrArchiveTooLarge):
+		return "This archive is too large to be imported"
+	case errors.Is(err, services.ErrArchiveInvalid):
+		return "This file is not a valid Numera archive"
+	default:
+		return "Archive could not be read"
+	}
+}
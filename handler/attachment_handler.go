@@ -0,0 +1,367 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/session"
+	"numera/pkg/storage"
+	"numera/services"
+	"numera/views/pages"
+	"strconv"
+
+	"github.com/a-h/templ"
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// attachmentFormOverhead is room for the multipart framing around the file
+// on top of the largest file allowed
+const attachmentFormOverhead = 1 << 20
+
+type AttachmentHandler struct {
+	db                *sql.DB
+	logger            *logrus.Logger
+	session           *session.Session
+	attachmentService *services.AttachmentService
+}
+
+func NewAttachmentHandler(
+	db *sql.DB,
+	logger *logrus.Logger,
+	session *session.Session,
+	attachmentService *services.AttachmentService,
+) *AttachmentHandler {
+	return &AttachmentHandler{
+		db:                db,
+		logger:            logger,
+		session:           session,
+		attachmentService: attachmentService,
+	}
+}
+
+func (h *AttachmentHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
+
+		r.With(middleware.AuthorizeAccount(h.db, model.PermissionView)).Get("/accounts/{id}/attachments", h.handleShowAccountAttachments)
+		r.With(middleware.AuthorizeAccount(h.db, model.PermissionEdit)).Post("/accounts/{id}/attachments", h.handleUploadToAccount)
+		r.With(middleware.AuthorizeAccount(h.db, model.PermissionView)).Get("/accounts/{id}/attachments/{attachmentID}", h.handleDownload)
+		r.With(middleware.AuthorizeAccount(h.db, model.PermissionView)).Get("/accounts/{id}/attachments/{attachmentID}/thumbnail", h.handleThumbnail)
+		r.With(middleware.AuthorizeAccount(h.db, model.PermissionEdit)).Delete("/accounts/{id}/attachments/{attachmentID}", h.handleDelete)
+
+		r.With(middleware.AuthorizeAccount(h.db, model.PermissionView)).Get("/accounts/{id}/transactions/{transactionID}/attachments", h.handleShowTransactionAttachments)
+		r.With(middleware.AuthorizeAccount(h.db, model.PermissionView)).Get("/accounts/{id}/transactions/{transactionID}/attachments/list", h.handleShowTransactionAttachmentList)
+		r.With(middleware.AuthorizeAccount(h.db, model.PermissionEdit)).Post("/accounts/{id}/transactions/{transactionID}/attachments", h.handleUploadToTransaction)
+	})
+}
+
+// accountAttachment loads the attachment named in the url from the
+// authorized account, writing the response itself when there is none
+func (h *AttachmentHandler) accountAttachment(w http.ResponseWriter, r *http.Request) (*model.Attachment, bool) {
+	logger := middleware.GetLogger(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	attachmentID, err := routeParamAsInt64(r, "attachmentID")
+	if err != nil {
+		http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	attachment, err := model.GetAttachmentForAccount(h.db, account.ID, attachmentID)
+	if err != nil {
+		if errors.Is(err, model.ErrAttachmentNotFound) {
+			http.Error(w, "Attachment not found", http.StatusNotFound)
+			return nil, false
+		}
+		logger.WithError(err).WithFields(logrus.Fields{
+			"account_id":    account.ID,
+			"attachment_id": attachmentID,
+		}).Error("failed_to_fetch_attachment")
+		http.Error(w, "Failed to fetch attachment", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return attachment, true
+}
+
+// attachmentUsage gets how much of their quota the user has used, writing
+// the response itself when it can't
+func (h *AttachmentHandler) attachmentUsage(w http.ResponseWriter, r *http.Request) (model.AttachmentUsage, bool) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	usage, err := h.attachmentService.Usage(userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_attachment_usage")
+		http.Error(w, "Failed to fetch attachments", http.StatusInternalServerError)
+		return usage, false
+	}
+
+	return usage, true
+}
+
+func (h *AttachmentHandler) handleShowAccountAttachments(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	attachments, err := model.GetAccountAttachments(h.db, account.ID)
+	if err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_fetch_attachments")
+		http.Error(w, "Failed to fetch attachments", http.StatusInternalServerError)
+		return
+	}
+
+	usage, ok := h.attachmentUsage(w, r)
+	if !ok {
+		return
+	}
+
+	view(w, r, pages.AccountAttachments(account.ToView(), attachments, usage, h.attachmentService.MaxSize()))
+}
+
+func (h *AttachmentHandler) handleShowTransactionAttachments(w http.ResponseWriter, r *http.Request) {
+	h.showTransactionAttachments(w, r, pages.TransactionAttachmentsModal)
+}
+
+// handleShowTransactionAttachmentList renders the body of the transaction's
+// attachments modal, reloaded after every upload or delete
+func (h *AttachmentHandler) handleShowTransactionAttachmentList(w http.ResponseWriter, r *http.Request) {
+	h.showTransactionAttachments(w, r, pages.TransactionAttachmentList)
+}
+
+func (h *AttachmentHandler) showTransactionAttachments(
+	w http.ResponseWriter,
+	r *http.Request,
+	component func(model.TransactionView, []model.Attachment, model.AttachmentUsage, int64, bool) templ.Component,
+) {
+	logger := middleware.GetLogger(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	transaction, ok := accountTransaction(h.db, w, r)
+	if !ok {
+		return
+	}
+
+	attachments, err := model.GetTransactionAttachments(h.db, account.ID, transaction.ID)
+	if err != nil {
+		logger.WithError(err).WithField("transaction_id", transaction.ID).Error("failed_to_fetch_attachments")
+		http.Error(w, "Failed to fetch attachments", http.StatusInternalServerError)
+		return
+	}
+
+	usage, ok := h.attachmentUsage(w, r)
+	if !ok {
+		return
+	}
+
+	transactionView := transaction.ToView(account.Currency)
+	transactionView.Attachments = len(attachments)
+
+	view(w, r, component(
+		transactionView,
+		attachments,
+		usage,
+		h.attachmentService.MaxSize(),
+		account.Role.Can(model.PermissionEdit),
+	))
+}
+
+func (h *AttachmentHandler) handleUploadToAccount(w http.ResponseWriter, r *http.Request) {
+	h.upload(w, r, sql.NullInt64{})
+}
+
+func (h *AttachmentHandler) handleUploadToTransaction(w http.ResponseWriter, r *http.Request) {
+	transaction, ok := accountTransaction(h.db, w, r)
+	if !ok {
+		return
+	}
+
+	h.upload(w, r, sql.NullInt64{Int64: transaction.ID, Valid: true})
+}
+
+// upload attaches the file sent in the file field to the authorized account,
+// or to one of its transactions
+func (h *AttachmentHandler) upload(w http.ResponseWriter, r *http.Request, transactionID sql.NullInt64) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	maxSize := h.attachmentService.MaxSize()
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+attachmentFormOverhead)
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		message := "Choose a file to attach"
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			message = "Files can be at most " + model.FormatFileSize(maxSize)
+		}
+		TriggerErrorToast(w, message)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	defer file.Close()
+
+	attachment, err := h.attachmentService.Upload(r.Context(), services.AttachmentUpload{
+		UserID:        userID,
+		AccountID:     account.ID,
+		TransactionID: transactionID,
+		Filename:      header.Filename,
+		File:          file,
+	})
+	if err != nil {
+		message := ""
+		switch {
+		case errors.Is(err, services.ErrAttachmentEmpty):
+			message = "The file is empty"
+		case errors.Is(err, services.ErrAttachmentTooLarge):
+			message = "Files can be at most " + model.FormatFileSize(maxSize)
+		case errors.Is(err, services.ErrAttachmentType):
+			message = "Only JPEG, PNG, GIF and WebP images or PDF documents can be attached"
+		case errors.Is(err, model.ErrAttachmentQuotaExceeded):
+			message = "This file doesn't fit in what is left of your attachment storage"
+		}
+		if message != "" {
+			TriggerErrorToast(w, message)
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
+		logger.WithError(err).WithFields(logrus.Fields{
+			"account_id":     account.ID,
+			"transaction_id": transactionID.Int64,
+		}).Error("failed_to_upload_attachment")
+		TriggerErrorToast(w, "Failed to upload file")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":        userID,
+		"account_id":     account.ID,
+		"transaction_id": transactionID.Int64,
+		"attachment_id":  attachment.ID,
+		"content_type":   attachment.ContentType,
+		"size":           attachment.Size,
+	}).Info("attachment_uploaded_successfully")
+
+	TriggerWithToast(w, "reloadAttachments", ToastSuccess, attachment.Filename+" attached")
+}
+
+// handleDownload serves the attachment's file, shown in the browser unless
+// download is asked for
+func (h *AttachmentHandler) handleDownload(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	attachment, ok := h.accountAttachment(w, r)
+	if !ok {
+		return
+	}
+
+	file, ok := h.open(w, r, attachment, false)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	disposition := "inline"
+	if r.URL.Query().Has("download") {
+		disposition = "attachment"
+	}
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{
+		"filename": attachment.Filename,
+	}))
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	if _, err := io.Copy(w, file); err != nil {
+		logger.WithError(err).WithField("attachment_id", attachment.ID).Warn("attachment_download_interrupted")
+	}
+}
+
+func (h *AttachmentHandler) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	attachment, ok := h.accountAttachment(w, r)
+	if !ok {
+		return
+	}
+
+	if !attachment.HasThumbnail() {
+		http.Error(w, "Attachment has no thumbnail", http.StatusNotFound)
+		return
+	}
+
+	file, ok := h.open(w, r, attachment, true)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	if _, err := io.Copy(w, file); err != nil {
+		logger.WithError(err).WithField("attachment_id", attachment.ID).Warn("attachment_download_interrupted")
+	}
+}
+
+// open reads the attachment's file, or its thumbnail, from storage, writing
+// the response itself when it can't
+func (h *AttachmentHandler) open(
+	w http.ResponseWriter,
+	r *http.Request,
+	attachment *model.Attachment,
+	thumbnail bool,
+) (io.ReadCloser, bool) {
+	logger := middleware.GetLogger(r.Context())
+
+	file, err := h.attachmentService.Open(r.Context(), attachment, thumbnail)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			logger.WithField("attachment_id", attachment.ID).Warn("attachment_file_missing")
+			http.Error(w, "Attachment not found", http.StatusNotFound)
+			return nil, false
+		}
+		logger.WithError(err).WithField("attachment_id", attachment.ID).Error("failed_to_open_attachment")
+		http.Error(w, "Failed to fetch attachment", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return file, true
+}
+
+func (h *AttachmentHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	attachment, ok := h.accountAttachment(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.attachmentService.Delete(r.Context(), attachment); err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"account_id":    account.ID,
+			"attachment_id": attachment.ID,
+		}).Error("failed_to_delete_attachment")
+		TriggerErrorToast(w, "Failed to delete file")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":       userID,
+		"account_id":    account.ID,
+		"attachment_id": attachment.ID,
+	}).Info("attachment_deleted_successfully")
+
+	TriggerWithToast(w, "reloadAttachments", ToastSuccess, attachment.Filename+" deleted")
+}
@@ -2,29 +2,46 @@ package handler
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
 	"numera/middleware"
 	"numera/model"
 	"numera/pkg/session"
 	"numera/pkg/validator"
+	"numera/services"
 	"numera/views/pages"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// maxTwoFactorAttempts is how many wrong codes are accepted before the user
+// has to enter their password again
+const maxTwoFactorAttempts = 5
+
 type AuthHandler struct {
-	db      *sql.DB
-	logger  *logrus.Logger
-	session *session.Session
+	db               *sql.DB
+	logger           *logrus.Logger
+	session          *session.Session
+	twoFactorService *services.TwoFactorService
 }
 
-func NewAuthHandler(db *sql.DB, logger *logrus.Logger, session *session.Session) *AuthHandler {
+func NewAuthHandler(
+	db *sql.DB,
+	logger *logrus.Logger,
+	session *session.Session,
+	twoFactorService *services.TwoFactorService,
+) *AuthHandler {
 	return &AuthHandler{
-		db:      db,
-		logger:  logger,
-		session: session,
+		db:               db,
+		logger:           logger,
+		session:          session,
+		twoFactorService: twoFactorService,
 	}
 }
 
@@ -35,6 +52,8 @@ func (h *AuthHandler) RegisterRoutes(r *chi.Mux) {
 
 		r.Get("/login", h.handleShowLogin)
 		r.Post("/login", h.handleLogin)
+		r.Get("/login/2fa", h.handleShowTwoFactor)
+		r.Post("/login/2fa", h.handleTwoFactor)
 	})
 
 	r.Group(func(r chi.Router) {
@@ -68,8 +87,28 @@ func (h *AuthHandler) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := model.GetUserByEmail(h.db, input.Email)
+	ip := clientIP(r)
+
+	lockedUntil, err := h.loginLockout(ip, input.Email)
 	if err != nil {
+		logger.WithError(err).WithField("email", input.Email).Error("login_throttle_lookup_failed")
+
+		TriggerErrorToast(w, "Something went wrong, please try again")
+		return
+	}
+	if !lockedUntil.IsZero() {
+		logger.WithFields(logrus.Fields{
+			"security_event": "login_blocked",
+			"email":          input.Email,
+			"locked_until":   lockedUntil,
+		}).Warn("login_attempt_blocked")
+
+		h.rejectLockedLogin(w, r, lockedUntil)
+		return
+	}
+
+	user, err := model.GetUserByEmail(h.db, input.Email)
+	if err != nil && err != model.ErrUserNotFound {
 		logger.WithError(err).WithField("email", input.Email).Error("db_lookup_failed")
 
 		TriggerErrorToast(w, "Something went wrong, please try again")
@@ -96,6 +135,11 @@ func (h *AuthHandler) handleLogin(w http.ResponseWriter, r *http.Request) {
 	if user == nil || !passwordMatch {
 		logger.WithField("email", input.Email).Warn("login_attempt_denied")
 
+		if lockedUntil := h.recordLoginFailure(logger, ip, input.Email); !lockedUntil.IsZero() {
+			h.rejectLockedLogin(w, r, lockedUntil)
+			return
+		}
+
 		errors = v.AddError(errors, "email", "Invalid email or password")
 		TriggerErrorToast(w, "Please check the form for errors")
 		view(w, r, pages.FormErrors(errors))
@@ -109,12 +153,243 @@ func (h *AuthHandler) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	hasTOTP, hasPasskeys, err := h.secondFactors(user.ID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", user.ID).Error("two_factor_lookup_failed")
+
+		TriggerErrorToast(w, "Something went wrong, please try again")
+		return
+	}
+	// passkeys only stand in for the code, unless the user asked for one after
+	// the password
+	if hasPasskeys && !hasTOTP {
+		hasPasskeys, err = model.RequiresPasskeySecondFactor(h.db, user.ID)
+		if err != nil {
+			logger.WithError(err).WithField("user_id", user.ID).Error("two_factor_lookup_failed")
+
+			TriggerErrorToast(w, "Something went wrong, please try again")
+			return
+		}
+	}
+	if hasTOTP || hasPasskeys {
+		logger.WithField("user_id", user.ID).Info("two_factor_challenge_started")
+		h.session.SetPendingTwoFactor(r, user.ID)
+		RedirectUsingHtmx(w, "/login/2fa")
+		return
+	}
+
+	// with a second factor the failures are only cleared after it is checked
+	if err := model.ClearLoginFailures(h.db, model.EmailThrottlePolicy, user.Email); err != nil {
+		logger.WithError(err).WithField("email", user.Email).Error("failed_to_clear_login_failures")
+	}
+
 	logger.Infof("user_logged_in: %d", user.ID)
 	h.session.SetUserID(r, user.ID)
 	RedirectUsingHtmx(w, "/dashboard")
 }
 
+// secondFactors reports which second factors the user has set up, either
+// one of them is accepted after the password
+func (h *AuthHandler) secondFactors(userID int64) (hasTOTP, hasPasskeys bool, err error) {
+	_, err = model.GetTwoFactorByUserID(h.db, userID)
+	if err != nil && !errors.Is(err, model.ErrTwoFactorNotFound) {
+		return false, false, err
+	}
+	hasTOTP = err == nil
+
+	passkeys, err := model.GetPasskeysByUserID(h.db, userID)
+	if err != nil {
+		return false, false, err
+	}
+
+	return hasTOTP, len(passkeys) > 0, nil
+}
+
+// loginLockout returns the later of the client's and the account's lockouts
+func (h *AuthHandler) loginLockout(ip, email string) (time.Time, error) {
+	ipLockedUntil, err := model.GetLoginLockout(h.db, model.IPThrottlePolicy, ip)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	emailLockedUntil, err := model.GetLoginLockout(h.db, model.EmailThrottlePolicy, email)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if ipLockedUntil.After(emailLockedUntil) {
+		return ipLockedUntil, nil
+	}
+	return emailLockedUntil, nil
+}
+
+// recordLoginFailure counts a failed attempt against the client and the
+// account, returning the lockout if this attempt triggered one
+func (h *AuthHandler) recordLoginFailure(logger *logrus.Entry, ip, email string) time.Time {
+	var lockedUntil time.Time
+
+	for _, failure := range []struct {
+		policy model.ThrottlePolicy
+		key    string
+	}{
+		{model.IPThrottlePolicy, ip},
+		{model.EmailThrottlePolicy, email},
+	} {
+		until, err := model.RecordLoginFailure(h.db, failure.policy, failure.key)
+		if err != nil {
+			logger.WithError(err).WithField(failure.policy.Kind, failure.key).Error("failed_to_record_login_failure")
+			continue
+		}
+		if until.IsZero() {
+			continue
+		}
+
+		logger.WithFields(logrus.Fields{
+			"security_event":    "login_locked",
+			failure.policy.Kind: failure.key,
+			"locked_until":      until,
+		}).Warn("login_locked_out")
+
+		if until.After(lockedUntil) {
+			lockedUntil = until
+		}
+	}
+
+	return lockedUntil
+}
+
+func (h *AuthHandler) rejectLockedLogin(w http.ResponseWriter, r *http.Request, lockedUntil time.Time) {
+	wait := time.Until(lockedUntil)
+	minutes := int(math.Ceil(wait.Minutes()))
+
+	message := "Too many failed attempts, try again in a minute"
+	if minutes > 1 {
+		message = fmt.Sprintf("Too many failed attempts, try again in %d minutes", minutes)
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	TriggerErrorToast(w, "Please check the form for errors")
+	view(w, r, pages.FormErrors(map[string]string{"email": message}))
+}
+
+// handleShowTwoFactor renders the second login step
+func (h *AuthHandler) handleShowTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := h.session.GetPendingTwoFactor(r)
+	if userID == 0 {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	hasTOTP, hasPasskeys, err := h.secondFactors(userID)
+	if err != nil {
+		middleware.GetLogger(r.Context()).
+			WithError(err).
+			WithField("user_id", userID).
+			Error("two_factor_lookup_failed")
+		http.Error(w, "Something went wrong, please try again", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.TwoFactorLogin(hasTOTP, hasPasskeys))
+}
+
+// handleTwoFactor checks the code of a user who already entered their password
+// and finishes the login
+func (h *AuthHandler) handleTwoFactor(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	userID := h.session.GetPendingTwoFactor(r)
+	if userID == 0 {
+		TriggerErrorToast(w, "Your sign in expired, please enter your password again")
+		RedirectUsingHtmx(w, "/login")
+		return
+	}
+
+	input := model.TwoFactorCodeInput{
+		Code: r.PostFormValue("code"),
+	}
+
+	v := validator.New()
+	errs := v.Validate(input)
+
+	if len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.TwoFactorFormErrors(errs))
+		return
+	}
+
+	user, err := model.GetUserByID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("user_fetch_failed")
+
+		TriggerErrorToast(w, "Something went wrong, please try again")
+		return
+	}
+
+	if err := h.twoFactorService.Verify(userID, input.Code); err != nil {
+		if !errors.Is(err, services.ErrTwoFactorCodeInvalid) {
+			logger.WithError(err).WithField("user_id", userID).Error("two_factor_verify_failed")
+
+			TriggerErrorToast(w, "Something went wrong, please try again")
+			return
+		}
+
+		attempts := h.session.RecordTwoFactorAttempt(r)
+		logger.WithFields(logrus.Fields{
+			"user_id":  userID,
+			"attempts": attempts,
+		}).Warn("two_factor_code_rejected")
+
+		// wrong codes count towards the account lockout as well, otherwise the
+		// per session limit could be reset by entering the password again
+		lockedUntil, err := model.RecordLoginFailure(h.db, model.EmailThrottlePolicy, user.Email)
+		if err != nil {
+			logger.WithError(err).WithField("email", user.Email).Error("failed_to_record_login_failure")
+		} else if !lockedUntil.IsZero() {
+			logger.WithFields(logrus.Fields{
+				"security_event": "login_locked",
+				"email":          user.Email,
+				"locked_until":   lockedUntil,
+			}).Warn("login_locked_out")
+		}
+
+		if attempts >= maxTwoFactorAttempts || !lockedUntil.IsZero() {
+			h.session.ClearPendingTwoFactor(r)
+			TriggerErrorToast(w, "Too many attempts, please sign in again")
+			RedirectUsingHtmx(w, "/login")
+			return
+		}
+
+		errs = v.AddError(errs, "code", "Invalid code")
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.TwoFactorFormErrors(errs))
+		return
+	}
+
+	if err := h.session.RenewToken(r.Context()); err != nil {
+		logger.WithError(err).Error("session_token_renewable_failed")
+
+		TriggerErrorToast(w, "Something went wrong, please try again")
+		return
+	}
+
+	if err := model.ClearLoginFailures(h.db, model.EmailThrottlePolicy, user.Email); err != nil {
+		logger.WithError(err).WithField("email", user.Email).Error("failed_to_clear_login_failures")
+	}
+
+	logger.Infof("user_logged_in: %d", userID)
+	h.session.ClearPendingTwoFactor(r)
+	h.session.SetUserID(r, userID)
+	RedirectUsingHtmx(w, "/dashboard")
+}
+
 func (h *AuthHandler) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if err := model.DeleteUserSession(h.db, h.session.Token(r.Context())); err != nil {
+		middleware.GetLogger(r.Context()).
+			WithError(err).
+			Error("failed_to_delete_user_session")
+	}
+
 	if err := h.session.Destroy(r.Context()); err != nil {
 		middleware.GetLogger(r.Context()).
 			WithError(err).
@@ -0,0 +1,557 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/session"
+	"numera/pkg/validator"
+	"numera/services"
+	"numera/views/pages"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+type ContactHandler struct {
+	db              *sql.DB
+	logger          *logrus.Logger
+	session         *session.Session
+	exchangeService *services.ExchangeService
+}
+
+func NewContactHandler(
+	db *sql.DB,
+	logger *logrus.Logger,
+	session *session.Session,
+	exchangeService *services.ExchangeService,
+) *ContactHandler {
+	return &ContactHandler{
+		db:              db,
+		logger:          logger,
+		session:         session,
+		exchangeService: exchangeService,
+	}
+}
+
+func (h *ContactHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
+
+		r.Get("/contacts", h.handleShowIndex)
+		r.Get("/contacts/list", h.handleShowList)
+		r.Post("/contacts", h.handleCreate)
+
+		r.Route("/contacts/{id}", func(r chi.Router) {
+			r.Get("/", h.handleShow)
+			r.Get("/edit", h.handleShowUpdate)
+			r.Put("/", h.handleUpdate)
+			r.Delete("/", h.handleDelete)
+			r.Get("/ious", h.handleShowIOUs)
+			r.Post("/ious", h.handleCreateIOU)
+			r.Get("/ious/{iouID}/settle", h.handleShowSettleIOU)
+			r.Post("/ious/{iouID}/settle", h.handleSettleIOU)
+			r.Delete("/ious/{iouID}", h.handleDeleteIOU)
+		})
+	})
+}
+
+// contactForUser loads the contact named in the url, writing the response
+// itself when the user has no such contact
+func (h *ContactHandler) contactForUser(w http.ResponseWriter, r *http.Request) (*model.Contact, bool) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	contactID, err := routeParamAsInt64(r, "id")
+	if err != nil {
+		http.Error(w, "Invalid contact ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	contact, err := model.GetContactForUser(h.db, contactID, userID)
+	if err != nil {
+		if errors.Is(err, model.ErrContactNotFound) {
+			http.Error(w, "Contact not found", http.StatusNotFound)
+			return nil, false
+		}
+		logger.WithError(err).WithField("contact_id", contactID).Error("failed_to_fetch_contact")
+		http.Error(w, "Failed to fetch contact", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return contact, true
+}
+
+// contactIOU loads the iou named in the url, writing the response itself when
+// the contact has no such iou
+func (h *ContactHandler) contactIOU(w http.ResponseWriter, r *http.Request, contact *model.Contact) (*model.IOU, bool) {
+	logger := middleware.GetLogger(r.Context())
+
+	iouID, err := routeParamAsInt64(r, "iouID")
+	if err != nil {
+		http.Error(w, "Invalid IOU ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	iou, err := model.GetContactIOU(h.db, contact.ID, iouID)
+	if err != nil {
+		if errors.Is(err, model.ErrIOUNotFound) {
+			http.Error(w, "IOU not found", http.StatusNotFound)
+			return nil, false
+		}
+		logger.WithError(err).WithField("iou_id", iouID).Error("failed_to_fetch_iou")
+		http.Error(w, "Failed to fetch IOU", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return iou, true
+}
+
+// contactSummaries nets the open ious of every contact in the user's
+// currency. When a rate is unavailable the contact's net is left out rather
+// than failing the page.
+func (h *ContactHandler) contactSummaries(w http.ResponseWriter, r *http.Request, contacts []model.Contact) ([]model.ContactSummary, bool) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	user, err := model.GetUserByID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("user_fetch_failed")
+		http.Error(w, "Failed to fetch contacts", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	balances, err := model.GetContactBalances(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_contact_balances")
+		http.Error(w, "Failed to fetch contacts", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	summaries := make([]model.ContactSummary, len(contacts))
+	for i, contact := range contacts {
+		summary := model.ContactSummary{
+			Contact:  contact,
+			Balances: balances[contact.ID],
+			Currency: user.Currency,
+			HasNet:   true,
+		}
+		for _, balance := range summary.Balances {
+			if balance.Currency == user.Currency {
+				summary.Net = summary.Net.Add(balance.Amount)
+				continue
+			}
+			converted, err := h.exchangeService.ConvertAmount(r.Context(), balance.Amount, balance.Currency, user.Currency)
+			if err != nil {
+				logger.WithError(err).
+					WithField("contact_id", contact.ID).
+					WithField("from_currency", balance.Currency).
+					WithField("to_currency", user.Currency).
+					Warn("failed_to_convert_currency")
+				summary.HasNet = false
+				break
+			}
+			summary.Net = summary.Net.Add(converted)
+		}
+		summaries[i] = summary
+	}
+
+	return summaries, true
+}
+
+func (h *ContactHandler) handleShowIndex(w http.ResponseWriter, r *http.Request) {
+	view(w, r, pages.Contacts())
+}
+
+func (h *ContactHandler) handleShowList(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	contacts, err := model.GetContactsByUserID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_contacts")
+		http.Error(w, "Failed to fetch contacts", http.StatusInternalServerError)
+		return
+	}
+
+	summaries, ok := h.contactSummaries(w, r, contacts)
+	if !ok {
+		return
+	}
+
+	view(w, r, pages.ContactList(summaries))
+}
+
+func contactInput(r *http.Request) model.ContactInput {
+	return model.ContactInput{
+		Name:  strings.TrimSpace(r.FormValue("name")),
+		Email: strings.TrimSpace(r.FormValue("email")),
+	}
+}
+
+var contactFields = []string{"name", "email"}
+
+func (h *ContactHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	input := contactInput(r)
+	v := validator.New()
+	if errs := v.Validate(input); len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(contactFields, errs))
+		return
+	}
+
+	contactID, err := model.CreateContact(h.db, userID, input)
+	if err != nil {
+		if errors.Is(err, model.ErrContactExists) {
+			TriggerErrorToast(w, "Please check the form for errors")
+			view(w, r, pages.SettingsFormErrors(contactFields, map[string]string{
+				"name": "You already have a contact called " + input.Name,
+			}))
+			return
+		}
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_create_contact")
+		TriggerErrorToast(w, "Failed to create contact")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"contact_id": contactID,
+	}).Info("contact_created_successfully")
+
+	TriggerWithToast(w, "reloadContacts", ToastSuccess, "Contact created!")
+	view(w, r, pages.SettingsFormErrors(contactFields, nil))
+}
+
+func (h *ContactHandler) handleShow(w http.ResponseWriter, r *http.Request) {
+	contact, ok := h.contactForUser(w, r)
+	if !ok {
+		return
+	}
+
+	view(w, r, pages.Contact(*contact))
+}
+
+func (h *ContactHandler) handleShowUpdate(w http.ResponseWriter, r *http.Request) {
+	contact, ok := h.contactForUser(w, r)
+	if !ok {
+		return
+	}
+
+	view(w, r, pages.EditContactModal(*contact))
+}
+
+func (h *ContactHandler) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	contact, ok := h.contactForUser(w, r)
+	if !ok {
+		return
+	}
+
+	input := contactInput(r)
+	v := validator.New()
+	if errs := v.Validate(input); len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(contactFields, errs))
+		return
+	}
+
+	if err := model.UpdateContact(h.db, contact.ID, userID, input); err != nil {
+		if errors.Is(err, model.ErrContactExists) {
+			TriggerErrorToast(w, "Please check the form for errors")
+			view(w, r, pages.SettingsFormErrors(contactFields, map[string]string{
+				"name": "You already have a contact called " + input.Name,
+			}))
+			return
+		}
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"contact_id": contact.ID,
+		}).Error("failed_to_update_contact")
+		TriggerErrorToast(w, "Failed to update contact")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"contact_id": contact.ID,
+	}).Info("contact_updated_successfully")
+
+	TriggerWithToast(w, "reloadContacts", ToastSuccess, "Contact updated!")
+}
+
+// handleDelete deletes the contact, from their own page the user is sent
+// back to the list
+func (h *ContactHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	contact, ok := h.contactForUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := model.DeleteContact(h.db, contact.ID, userID); err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"contact_id": contact.ID,
+		}).Error("failed_to_delete_contact")
+		TriggerErrorToast(w, "Failed to delete contact")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"contact_id": contact.ID,
+	}).Info("contact_deleted_successfully")
+
+	if r.URL.Query().Has("redirect") {
+		RedirectUsingHtmx(w, "/contacts")
+		return
+	}
+
+	TriggerWithToast(w, "reloadContacts", ToastSuccess, "Contact deleted")
+}
+
+// handleShowIOUs renders the contact's net balance with their ious
+func (h *ContactHandler) handleShowIOUs(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	contact, ok := h.contactForUser(w, r)
+	if !ok {
+		return
+	}
+
+	summaries, ok := h.contactSummaries(w, r, []model.Contact{*contact})
+	if !ok {
+		return
+	}
+
+	ious, err := model.GetContactIOUs(h.db, contact.ID)
+	if err != nil {
+		logger.WithError(err).WithField("contact_id", contact.ID).Error("failed_to_fetch_ious")
+		http.Error(w, "Failed to fetch IOUs", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.ContactIOUs(summaries[0], ious, time.Now()))
+}
+
+var iouFields = []string{"direction", "amount", "currency", "description", "duedate"}
+
+func (h *ContactHandler) handleCreateIOU(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	contact, ok := h.contactForUser(w, r)
+	if !ok {
+		return
+	}
+
+	input := model.IOUInput{
+		Direction:   model.IOUDirection(r.FormValue("direction")),
+		Amount:      strings.TrimSpace(r.FormValue("amount")),
+		Currency:    model.Currency(r.FormValue("currency")),
+		Description: strings.TrimSpace(r.FormValue("description")),
+		DueDate:     r.FormValue("duedate"),
+	}
+
+	v := validator.New()
+	errs := v.Validate(input)
+	if amount, err := decimal.NewFromString(input.Amount); len(errs) == 0 && (err != nil || !amount.IsPositive()) {
+		errs = map[string]string{"amount": "Amount must be greater than zero"}
+	}
+	if len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(iouFields, errs))
+		return
+	}
+
+	iouID, err := model.CreateIOU(h.db, contact, input)
+	if err != nil {
+		logger.WithError(err).WithField("contact_id", contact.ID).Error("failed_to_create_iou")
+		TriggerErrorToast(w, "Failed to add IOU")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"contact_id": contact.ID,
+		"iou_id":     iouID,
+	}).Info("iou_created_successfully")
+
+	TriggerWithToast(w, "reloadContacts", ToastSuccess, "IOU added!")
+	view(w, r, pages.SettingsFormErrors(iouFields, nil))
+}
+
+// settleAccounts lists the accounts the user may record a settlement on,
+// the ones they can edit
+func (h *ContactHandler) settleAccounts(w http.ResponseWriter, r *http.Request) ([]model.AccountView, bool) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	accounts, err := model.GetAccounstByID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_accounts_by_user_id")
+		http.Error(w, "Failed to fetch accounts", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	accountViews := []model.AccountView{}
+	for _, account := range accounts {
+		if account.Role.Can(model.PermissionEdit) {
+			accountViews = append(accountViews, account.ToView())
+		}
+	}
+
+	return accountViews, true
+}
+
+func (h *ContactHandler) handleShowSettleIOU(w http.ResponseWriter, r *http.Request) {
+	contact, ok := h.contactForUser(w, r)
+	if !ok {
+		return
+	}
+
+	iou, ok := h.contactIOU(w, r, contact)
+	if !ok {
+		return
+	}
+
+	accounts, ok := h.settleAccounts(w, r)
+	if !ok {
+		return
+	}
+
+	view(w, r, pages.SettleIOUModal(*contact, *iou, accounts, time.Now()))
+}
+
+var iouSettleFields = []string{"accountid", "settledat"}
+
+// handleSettleIOU marks the iou as paid back, recording the payment on the
+// chosen account when there is one
+func (h *ContactHandler) handleSettleIOU(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	contact, ok := h.contactForUser(w, r)
+	if !ok {
+		return
+	}
+
+	iou, ok := h.contactIOU(w, r, contact)
+	if !ok {
+		return
+	}
+
+	input := model.IOUSettleInput{
+		AccountID: formValueAsInt64(r, "accountid"),
+		SettledAt: r.FormValue("settledat"),
+	}
+
+	v := validator.New()
+	if errs := v.Validate(input); len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(iouSettleFields, errs))
+		return
+	}
+
+	var account *model.Account
+	if input.AccountID != 0 {
+		var err error
+		account, err = model.GetAccountForUser(h.db, input.AccountID, userID)
+		if err != nil && !errors.Is(err, model.ErrAccountNotFound) {
+			logger.WithError(err).WithField("account_id", input.AccountID).Error("failed_to_fetch_account")
+			TriggerErrorToast(w, "Failed to settle IOU")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if err != nil || !account.Role.Can(model.PermissionEdit) {
+			TriggerErrorToast(w, "Please check the form for errors")
+			view(w, r, pages.SettingsFormErrors(iouSettleFields, map[string]string{
+				"accountid": "Choose one of your accounts",
+			}))
+			return
+		}
+	}
+
+	if err := model.SettleIOU(h.db, auditActor(r), iou, contact, account, input); err != nil {
+		var message string
+		switch {
+		case errors.Is(err, model.ErrIOUSettled):
+			message = "This IOU is already settled"
+		case errors.Is(err, model.ErrIOUCurrencyMismatch):
+			message = "Choose an account in " + string(iou.Currency)
+		case errors.Is(err, model.ErrIOUNegativeBalance):
+			message = "The account doesn't allow a negative balance"
+		default:
+			logger.WithError(err).WithFields(logrus.Fields{
+				"contact_id": contact.ID,
+				"iou_id":     iou.ID,
+			}).Error("failed_to_settle_iou")
+			TriggerErrorToast(w, "Failed to settle IOU")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(iouSettleFields, map[string]string{"accountid": message}))
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"contact_id": contact.ID,
+		"iou_id":     iou.ID,
+		"account_id": input.AccountID,
+	}).Info("iou_settled_successfully")
+
+	TriggerWithToast(w, "reloadContacts", ToastSuccess, "IOU settled!")
+	view(w, r, pages.SettingsFormErrors(iouSettleFields, nil))
+}
+
+func (h *ContactHandler) handleDeleteIOU(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	contact, ok := h.contactForUser(w, r)
+	if !ok {
+		return
+	}
+
+	iouID, err := routeParamAsInt64(r, "iouID")
+	if err != nil {
+		http.Error(w, "Invalid IOU ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := model.DeleteIOU(h.db, contact.ID, iouID); err != nil {
+		if errors.Is(err, model.ErrIOUNotFound) {
+			TriggerWithToast(w, "reloadContacts", ToastError, "IOU not found")
+			return
+		}
+		logger.WithError(err).WithFields(logrus.Fields{
+			"contact_id": contact.ID,
+			"iou_id":     iouID,
+		}).Error("failed_to_delete_iou")
+		TriggerErrorToast(w, "Failed to delete IOU")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"contact_id": contact.ID,
+		"iou_id":     iouID,
+	}).Info("iou_deleted_successfully")
+
+	TriggerWithToast(w, "reloadContacts", ToastSuccess, "IOU deleted")
+}
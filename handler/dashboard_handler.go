@@ -39,6 +39,7 @@ func (h *DashboardHandler) RegisterRoutes(r *chi.Mux) {
 	r.Group(func(r chi.Router) {
 		r.Use(middleware.RequireAuth(h.session))
 		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
 
 		r.Get("/dashboard", h.handleIndex)
 	})
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/session"
+	"numera/pkg/validator"
+	"numera/services"
+	"numera/views/pages"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+type ExportHandler struct {
+	db            *sql.DB
+	logger        *logrus.Logger
+	session       *session.Session
+	exportService *services.ExportService
+}
+
+func NewExportHandler(
+	db *sql.DB,
+	logger *logrus.Logger,
+	session *session.Session,
+	exportService *services.ExportService,
+) *ExportHandler {
+	return &ExportHandler{
+		db:            db,
+		logger:        logger,
+		session:       session,
+		exportService: exportService,
+	}
+}
+
+func (h *ExportHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
+
+		r.Get("/exports", h.handleShowIndex)
+		r.Get("/exports/transactions", h.handleExportTransactions)
+		r.Get("/exports/audit-log", h.handleExportAuditLog)
+	})
+}
+
+// handleShowIndex renders export page
+func (h *ExportHandler) handleShowIndex(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	accounts, err := model.GetAllAccountsForUser(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_accounts_by_user_id")
+		http.Error(w, "Failed to fetch accounts", http.StatusInternalServerError)
+		return
+	}
+
+	accountViews := make([]model.AccountView, len(accounts))
+	for i, account := range accounts {
+		accountViews[i] = account.ToView()
+	}
+
+	tags, err := model.GetTagsByUserID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_tags")
+		http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.Exports(accountViews, tags))
+}
+
+// handleExportTransactions streams transactions as a file download
+func (h *ExportHandler) handleExportTransactions(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	query := r.URL.Query()
+
+	accountID, _ := strconv.ParseInt(query.Get("account_id"), 10, 64)
+	tagID, _ := strconv.ParseInt(query.Get("tag_id"), 10, 64)
+	input := services.ExportTransactionsInput{
+		Format:    services.ExportFormat(query.Get("format")),
+		AccountID: accountID,
+		From:      query.Get("from"),
+		To:        query.Get("to"),
+		TagID:     tagID,
+	}
+
+	v := validator.New()
+	if errors := v.Validate(input); len(errors) > 0 {
+		logger.WithFields(logrus.Fields{
+			"user_id":     userID,
+			"error_count": len(errors),
+		}).Warn("transaction_export_validation_failed")
+		http.Error(w, "Invalid export options", http.StatusBadRequest)
+		return
+	}
+
+	if input.TagID != 0 {
+		if _, err := model.GetTagForUser(h.db, input.TagID, userID); err != nil {
+			if !errors.Is(err, model.ErrTagNotFound) {
+				logger.WithError(err).WithField("tag_id", input.TagID).Error("failed_to_fetch_tag")
+			}
+			http.Error(w, "Invalid export options", http.StatusBadRequest)
+			return
+		}
+	}
+
+	filename := fmt.Sprintf("numera-transactions-%s.%s", time.Now().Format("2006-01-02"), input.Format.Extension())
+	w.Header().Set("Content-Type", input.Format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	err := h.exportService.ExportTransactions(w, input.Format, input.Filter(userID))
+	if err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"format":  input.Format,
+		}).Error("failed_to_export_transactions")
+		http.Error(w, "Failed to export transactions", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleExportAuditLog downloads the audit trail of an account the user can
+// see, or without an account every change the user made themselves
+func (h *ExportHandler) handleExportAuditLog(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	filter := model.AuditLogFilter{ActorID: userID}
+	if accountID, _ := strconv.ParseInt(r.URL.Query().Get("account_id"), 10, 64); accountID != 0 {
+		if _, err := model.GetAccountForUser(h.db, accountID, userID); err != nil {
+			if errors.Is(err, model.ErrAccountNotFound) {
+				http.Error(w, "Account not found", http.StatusNotFound)
+				return
+			}
+			logger.WithError(err).WithField("account_id", accountID).Error("failed_to_fetch_account")
+			http.Error(w, "Failed to fetch account", http.StatusInternalServerError)
+			return
+		}
+		filter = model.AuditLogFilter{AccountID: accountID}
+	}
+
+	filename := fmt.Sprintf("numera-audit-log-%s.csv", time.Now().Format("2006-01-02"))
+	w.Header().Set("Content-Type", services.ExportFormatCSV.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if err := h.exportService.ExportAuditLog(w, filter); err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_export_audit_log")
+		http.Error(w, "Failed to export audit log", http.StatusInternalServerError)
+		return
+	}
+}
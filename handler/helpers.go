@@ -4,13 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"numera/model"
 	"strconv"
 
 	"github.com/a-h/templ"
 	"github.com/go-chi/chi/v5"
-	"github.com/shopspring/decimal"
 )
 
 // view renders a templ component and handles any rendering errors.
@@ -39,6 +39,41 @@ func routeParamAsInt64(r *http.Request, key string) (int64, error) {
 	return id, nil
 }
 
+// auditActor identifies the current user and where the request came from for
+// the audit trail
+func auditActor(r *http.Request) model.Actor {
+	return model.Actor{
+		UserID:    GetUserID(r.Context()),
+		IPAddress: clientIP(r),
+	}
+}
+
+// formValueAsInt64 reads an optional id from the form, blank or malformed
+// values read as zero
+func formValueAsInt64(r *http.Request, key string) int64 {
+	id, _ := strconv.ParseInt(r.FormValue(key), 10, 64)
+	return id
+}
+
+// formValuesAsInt64 reads a repeated form field as a list of ids, failing on
+// the first malformed one
+func formValuesAsInt64(r *http.Request, key string) ([]int64, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(r.Form[key]))
+	for _, val := range r.Form[key] {
+		id, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id format: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
 func GetUserID(ctx context.Context) int64 {
 	userID, ok := ctx.Value("USER_ID").(int64)
 	if !ok {
@@ -140,23 +175,13 @@ func TriggerWithToast(w http.ResponseWriter, event string, toastType ToastType,
 	w.Header().Set("HX-Trigger", string(jsonData))
 }
 
-func FormatBalance(total decimal.Decimal, currency model.Currency) string {
-	formatted := total.StringFixed(2)
-
-	switch currency {
-	case "USD":
-		return "$" + formatted
-	case "EUR":
-		return "€" + formatted
-	case "GBP":
-		return "£" + formatted
-	case "RSD":
-		return formatted + " дин"
-	case "JPY":
-		return "¥" + total.StringFixed(0)
-	case "CHF":
-		return "CHF " + formatted
-	default:
-		return formatted + " " + string(currency)
+// clientIP returns the address of the client without the port, RealIP has
+// already replaced RemoteAddr with the forwarded address when the request
+// came through a trusted proxy
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
+	return host
 }
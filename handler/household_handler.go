@@ -0,0 +1,471 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/mailer"
+	"numera/pkg/session"
+	"numera/pkg/validator"
+	"numera/views/pages"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+type HouseholdHandler struct {
+	db      *sql.DB
+	logger  *logrus.Logger
+	session *session.Session
+	mailer  mailer.Mailer
+	appURL  string
+}
+
+func NewHouseholdHandler(
+	db *sql.DB,
+	logger *logrus.Logger,
+	session *session.Session,
+	mailer mailer.Mailer,
+	appURL string,
+) *HouseholdHandler {
+	return &HouseholdHandler{
+		db:      db,
+		logger:  logger,
+		session: session,
+		mailer:  mailer,
+		appURL:  appURL,
+	}
+}
+
+func (h *HouseholdHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
+
+		r.Get("/households", h.handleShowIndex)
+		r.Get("/households/list", h.handleShowList)
+		r.Post("/households", h.handleCreate)
+
+		r.Get("/households/invitations/{token}", h.handleShowInvitation)
+		r.Post("/households/invitations/{token}", h.handleAcceptInvitation)
+
+		r.Route("/households/{id}", func(r chi.Router) {
+			r.Group(func(r chi.Router) {
+				r.Use(middleware.AuthorizeHousehold(h.db, model.PermissionView))
+
+				r.Get("/", h.handleShow)
+				r.Get("/members", h.handleShowMembers)
+				// members may remove themselves, anyone else needs manage
+				r.Delete("/members/{userID}", h.handleRemoveMember)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(middleware.AuthorizeHousehold(h.db, model.PermissionManage))
+
+				r.Delete("/", h.handleDestroy)
+				r.Put("/members/{userID}", h.handleUpdateMember)
+				r.Post("/invitations", h.handleInvite)
+				r.Delete("/invitations/{invitationID}", h.handleRevokeInvitation)
+			})
+		})
+	})
+}
+
+// handleShowIndex renders the households page
+func (h *HouseholdHandler) handleShowIndex(w http.ResponseWriter, r *http.Request) {
+	view(w, r, pages.Households())
+}
+
+func (h *HouseholdHandler) handleShowList(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	households, err := model.GetHouseholdsByUserID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_households")
+		http.Error(w, "Failed to fetch households", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.HouseholdList(households))
+}
+
+func (h *HouseholdHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	input := model.CreateHouseholdInput{
+		Name: strings.TrimSpace(r.FormValue("name")),
+	}
+
+	v := validator.New()
+	if errs := v.Validate(input); len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors([]string{"name"}, errs))
+		return
+	}
+
+	householdID, err := model.CreateHousehold(h.db, userID, input)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_create_household")
+		TriggerErrorToast(w, "Failed to create household")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":      userID,
+		"household_id": householdID,
+	}).Info("household_created_successfully")
+
+	TriggerWithToast(w, "reloadHouseholds", ToastSuccess, "Household created!")
+	view(w, r, pages.SettingsFormErrors([]string{"name"}, nil))
+}
+
+func (h *HouseholdHandler) handleShow(w http.ResponseWriter, r *http.Request) {
+	household := middleware.GetHousehold(r.Context())
+
+	view(w, r, pages.Household(*household, GetUserID(r.Context())))
+}
+
+func (h *HouseholdHandler) handleShowMembers(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	household := middleware.GetHousehold(r.Context())
+
+	members, err := model.GetHouseholdMembers(h.db, household.ID)
+	if err != nil {
+		logger.WithError(err).WithField("household_id", household.ID).Error("failed_to_fetch_household_members")
+		http.Error(w, "Failed to fetch members", http.StatusInternalServerError)
+		return
+	}
+
+	var invitations []model.HouseholdInvitation
+	if household.Role.Can(model.PermissionManage) {
+		invitations, err = model.GetPendingHouseholdInvitations(h.db, household.ID)
+		if err != nil {
+			logger.WithError(err).WithField("household_id", household.ID).Error("failed_to_fetch_household_invitations")
+			http.Error(w, "Failed to fetch invitations", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	view(w, r, pages.HouseholdMembers(*household, members, invitations, GetUserID(r.Context())))
+}
+
+func (h *HouseholdHandler) handleDestroy(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	household := middleware.GetHousehold(r.Context())
+
+	if err := model.DeleteHousehold(h.db, household.ID); err != nil {
+		logger.WithError(err).WithField("household_id", household.ID).Error("failed_to_delete_household")
+		TriggerErrorToast(w, "Failed to delete household")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":      userID,
+		"household_id": household.ID,
+	}).Info("household_deleted_successfully")
+
+	RedirectUsingHtmx(w, "/households")
+}
+
+func (h *HouseholdHandler) handleUpdateMember(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	household := middleware.GetHousehold(r.Context())
+
+	memberID, err := routeParamAsInt64(r, "userID")
+	if err != nil {
+		http.Error(w, "Invalid member ID", http.StatusBadRequest)
+		return
+	}
+
+	input := model.UpdateHouseholdMemberInput{
+		Role: model.Role(r.FormValue("role")),
+	}
+
+	v := validator.New()
+	if errs := v.Validate(input); len(errs) > 0 {
+		TriggerErrorToast(w, "Please choose a valid role")
+		return
+	}
+
+	if err := model.UpdateHouseholdMemberRole(h.db, household.ID, memberID, input.Role); err != nil {
+		switch {
+		case errors.Is(err, model.ErrLastHouseholdOwner):
+			TriggerWithToast(w, "reloadHousehold", ToastError, "A household needs at least one owner")
+		case errors.Is(err, model.ErrHouseholdMemberNotFound):
+			TriggerWithToast(w, "reloadHousehold", ToastError, "Member not found")
+		default:
+			logger.WithError(err).WithFields(logrus.Fields{
+				"household_id": household.ID,
+				"member_id":    memberID,
+			}).Error("failed_to_update_household_member")
+			TriggerErrorToast(w, "Failed to update member")
+		}
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":      userID,
+		"household_id": household.ID,
+		"member_id":    memberID,
+		"role":         input.Role,
+	}).Info("household_member_updated_successfully")
+
+	TriggerWithToast(w, "reloadHousehold", ToastSuccess, "Member role updated")
+}
+
+// handleRemoveMember removes someone from the household, or lets the current
+// user leave it
+func (h *HouseholdHandler) handleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	household := middleware.GetHousehold(r.Context())
+
+	memberID, err := routeParamAsInt64(r, "userID")
+	if err != nil {
+		http.Error(w, "Invalid member ID", http.StatusBadRequest)
+		return
+	}
+
+	leaving := memberID == userID
+	if !leaving && !household.Role.Can(model.PermissionManage) {
+		logger.WithFields(logrus.Fields{
+			"household_id": household.ID,
+			"user_id":      userID,
+			"member_id":    memberID,
+		}).Warn("unauthorized_household_access_attempt")
+		TriggerErrorToast(w, "You are unauthorized for this action!")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if err := model.RemoveHouseholdMember(h.db, household.ID, memberID); err != nil {
+		switch {
+		case errors.Is(err, model.ErrLastHouseholdOwner):
+			TriggerErrorToast(w, "Make someone else an owner first, or delete the household")
+		case errors.Is(err, model.ErrHouseholdMemberNotFound):
+			TriggerWithToast(w, "reloadHousehold", ToastError, "Member not found")
+		default:
+			logger.WithError(err).WithFields(logrus.Fields{
+				"household_id": household.ID,
+				"member_id":    memberID,
+			}).Error("failed_to_remove_household_member")
+			TriggerErrorToast(w, "Failed to remove member")
+		}
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":      userID,
+		"household_id": household.ID,
+		"member_id":    memberID,
+	}).Info("household_member_removed_successfully")
+
+	if leaving {
+		RedirectUsingHtmx(w, "/households")
+		return
+	}
+
+	TriggerWithToast(w, "reloadHousehold", ToastSuccess, "Member removed")
+}
+
+func (h *HouseholdHandler) handleInvite(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	household := middleware.GetHousehold(r.Context())
+
+	input := model.InviteHouseholdMemberInput{
+		Email: strings.TrimSpace(r.FormValue("email")),
+		Role:  model.Role(r.FormValue("role")),
+	}
+
+	v := validator.New()
+	if errs := v.Validate(input); len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors([]string{"email", "role"}, errs))
+		return
+	}
+
+	inviter, err := model.GetUserByID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_user")
+		TriggerErrorToast(w, "Failed to send invitation")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	token, err := model.CreateHouseholdInvitation(h.db, household.ID, userID, input)
+	if err != nil {
+		logger.WithError(err).WithField("household_id", household.ID).Error("failed_to_create_household_invitation")
+		TriggerErrorToast(w, "Failed to send invitation")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	h.sendInvitation(logger, household, inviter, input, token)
+
+	logger.WithFields(logrus.Fields{
+		"user_id":      userID,
+		"household_id": household.ID,
+		"role":         input.Role,
+	}).Info("household_invitation_created_successfully")
+
+	TriggerWithToast(w, "reloadHousehold", ToastSuccess, "Invitation sent to "+input.Email)
+	view(w, r, pages.SettingsFormErrors([]string{"email", "role"}, nil))
+}
+
+func (h *HouseholdHandler) sendInvitation(
+	logger *logrus.Entry,
+	household *model.Household,
+	inviter *model.User,
+	input model.InviteHouseholdMemberInput,
+	token string,
+) {
+	link := fmt.Sprintf("%s/households/invitations/%s", h.appURL, token)
+	msg := mailer.Message{
+		To:      input.Email,
+		Subject: fmt.Sprintf("%s invited you to %s on Numera", inviter.Name, household.Name),
+		Body: fmt.Sprintf(
+			"Hi,\n\n"+
+				"%s invited you to join the %s household on Numera as %s. "+
+				"Open the link below to accept:\n\n%s\n\n"+
+				"The link expires in %d days. You need a Numera account registered "+
+				"with this email address to accept it.\n",
+			inviter.Name,
+			household.Name,
+			input.Role,
+			link,
+			int(model.HouseholdInvitationTTL.Hours()/24),
+		),
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := h.mailer.Send(ctx, msg); err != nil {
+			logger.WithError(err).WithField("household_id", household.ID).Error("failed_to_send_household_invitation")
+			return
+		}
+		logger.WithField("household_id", household.ID).Info("household_invitation_sent")
+	}()
+}
+
+func (h *HouseholdHandler) handleRevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	household := middleware.GetHousehold(r.Context())
+
+	invitationID, err := routeParamAsInt64(r, "invitationID")
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := model.DeleteHouseholdInvitation(h.db, invitationID, household.ID); err != nil {
+		if errors.Is(err, model.ErrHouseholdInvitationNotFound) {
+			TriggerWithToast(w, "reloadHousehold", ToastError, "Invitation not found")
+			return
+		}
+		logger.WithError(err).WithField("invitation_id", invitationID).Error("failed_to_revoke_household_invitation")
+		TriggerErrorToast(w, "Failed to revoke invitation")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"household_id":  household.ID,
+		"invitation_id": invitationID,
+	}).Info("household_invitation_revoked_successfully")
+
+	TriggerWithToast(w, "reloadHousehold", ToastSuccess, "Invitation revoked")
+}
+
+// invitationFor looks up the invitation in the route and checks it was sent
+// to the current user, rendering the reason when it can't be used
+func (h *HouseholdHandler) invitationFor(w http.ResponseWriter, r *http.Request) (*model.HouseholdInvitation, bool) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	invitation, err := model.GetValidHouseholdInvitation(h.db, chi.URLParam(r, "token"))
+	if err != nil {
+		if !errors.Is(err, model.ErrHouseholdInvitationInvalid) {
+			logger.WithError(err).Error("failed_to_get_household_invitation")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return nil, false
+		}
+
+		w.WriteHeader(http.StatusGone)
+		view(w, r, pages.HouseholdInvitationInvalid("This invitation has expired, was revoked or has already been used."))
+		return nil, false
+	}
+
+	user, err := model.GetUserByID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_user")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !strings.EqualFold(user.Email, invitation.Email) {
+		logger.WithFields(logrus.Fields{
+			"user_id":       userID,
+			"invitation_id": invitation.ID,
+		}).Warn("household_invitation_email_mismatch")
+		w.WriteHeader(http.StatusForbidden)
+		view(w, r, pages.HouseholdInvitationInvalid(
+			"This invitation was sent to a different email address. Sign in with that address to accept it.",
+		))
+		return nil, false
+	}
+
+	return invitation, true
+}
+
+func (h *HouseholdHandler) handleShowInvitation(w http.ResponseWriter, r *http.Request) {
+	invitation, ok := h.invitationFor(w, r)
+	if !ok {
+		return
+	}
+
+	view(w, r, pages.HouseholdInvitation(*invitation, chi.URLParam(r, "token")))
+}
+
+func (h *HouseholdHandler) handleAcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	invitation, ok := h.invitationFor(w, r)
+	if !ok {
+		return
+	}
+
+	err := model.AcceptHouseholdInvitation(h.db, invitation, userID)
+	if err != nil && !errors.Is(err, model.ErrAlreadyHouseholdMember) {
+		if errors.Is(err, model.ErrHouseholdInvitationInvalid) {
+			w.WriteHeader(http.StatusGone)
+			view(w, r, pages.HouseholdInvitationInvalid("This invitation has already been used."))
+			return
+		}
+		logger.WithError(err).WithField("invitation_id", invitation.ID).Error("failed_to_accept_household_invitation")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":      userID,
+		"household_id": invitation.HouseholdID,
+		"role":         invitation.Role,
+	}).Info("household_invitation_accepted")
+
+	http.Redirect(w, r, fmt.Sprintf("/households/%d", invitation.HouseholdID), http.StatusSeeOther)
+}
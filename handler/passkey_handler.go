@@ -0,0 +1,334 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/session"
+	"numera/pkg/validator"
+	"numera/services"
+	"numera/views/pages"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/sirupsen/logrus"
+)
+
+// webAuthnSessionKey holds the challenge between the two steps of a ceremony
+const webAuthnSessionKey = "WEBAUTHN_SESSION"
+
+type PasskeyHandler struct {
+	db             *sql.DB
+	logger         *logrus.Logger
+	session        *session.Session
+	passkeyService *services.PasskeyService
+}
+
+func NewPasskeyHandler(
+	db *sql.DB,
+	logger *logrus.Logger,
+	session *session.Session,
+	passkeyService *services.PasskeyService,
+) *PasskeyHandler {
+	return &PasskeyHandler{
+		db:             db,
+		logger:         logger,
+		session:        session,
+		passkeyService: passkeyService,
+	}
+}
+
+func (h *PasskeyHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireGuest(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+
+		r.Post("/login/passkey/options", h.handleLoginOptions)
+		r.Post("/login/passkey", h.handleLogin)
+		r.Post("/login/2fa/passkey/options", h.handleTwoFactorOptions)
+		r.Post("/login/2fa/passkey", h.handleTwoFactor)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
+
+		r.Get("/settings/passkeys", h.handleShowIndex)
+		r.Get("/settings/passkeys/list", h.handleShowList)
+		r.Post("/settings/passkeys/options", h.handleRegisterOptions)
+		r.Post("/settings/passkeys", h.handleRegister)
+		r.Delete("/settings/passkeys/{id}", h.handleDestroy)
+		r.Post("/settings/passkeys/second-factor", h.handleSecondFactor)
+	})
+}
+
+func (h *PasskeyHandler) putCeremony(r *http.Request, data *webauthn.SessionData) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	h.session.Put(r.Context(), webAuthnSessionKey, string(encoded))
+	return nil
+}
+
+// popCeremony returns the pending ceremony and removes it from the session so
+// a challenge can only be answered once
+func (h *PasskeyHandler) popCeremony(r *http.Request) (webauthn.SessionData, bool) {
+	var data webauthn.SessionData
+	encoded := h.session.PopString(r.Context(), webAuthnSessionKey)
+	if encoded == "" {
+		return data, false
+	}
+	if err := json.Unmarshal([]byte(encoded), &data); err != nil {
+		return data, false
+	}
+	return data, true
+}
+
+// beginLogin answers with the assertion options for the browser
+func (h *PasskeyHandler) beginLogin(w http.ResponseWriter, r *http.Request, userID int64) {
+	logger := middleware.GetLogger(r.Context())
+
+	assertion, data, err := h.passkeyService.BeginLogin(userID)
+	if err == nil {
+		err = h.putCeremony(r, data)
+	}
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_begin_passkey_login")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Something went wrong, please try again")
+		return
+	}
+
+	respondData(w, http.StatusOK, assertion)
+}
+
+// finishLogin verifies the assertion and signs the user in
+func (h *PasskeyHandler) finishLogin(w http.ResponseWriter, r *http.Request, userID int64) {
+	logger := middleware.GetLogger(r.Context())
+
+	data, ok := h.popCeremony(r)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "ceremony_expired", "Passkey request expired, please try again")
+		return
+	}
+
+	userID, err := h.passkeyService.FinishLogin(userID, data, r)
+	if err != nil {
+		if errors.Is(err, services.ErrPasskeyCeremonyFailed) || errors.Is(err, services.ErrPasskeyCloned) {
+			respondError(w, http.StatusUnauthorized, "passkey_rejected", "Passkey could not be verified")
+			return
+		}
+
+		logger.WithError(err).Error("failed_to_finish_passkey_login")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Something went wrong, please try again")
+		return
+	}
+
+	if err := h.session.RenewToken(r.Context()); err != nil {
+		logger.WithError(err).Error("session_token_renewable_failed")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Something went wrong, please try again")
+		return
+	}
+
+	// the account's failed attempts are cleared once the passkey proved who
+	// the user is, the same as after a two factor code
+	if user, err := model.GetUserByID(h.db, userID); err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("user_fetch_failed")
+	} else if err := model.ClearLoginFailures(h.db, model.EmailThrottlePolicy, user.Email); err != nil {
+		logger.WithError(err).WithField("email", user.Email).Error("failed_to_clear_login_failures")
+	}
+
+	logger.WithField("method", "passkey").Infof("user_logged_in: %d", userID)
+	h.session.ClearPendingTwoFactor(r)
+	h.session.SetUserID(r, userID)
+	respondData(w, http.StatusOK, map[string]string{"redirect": "/dashboard"})
+}
+
+// handleLoginOptions starts a passwordless login with any discoverable passkey
+func (h *PasskeyHandler) handleLoginOptions(w http.ResponseWriter, r *http.Request) {
+	h.beginLogin(w, r, 0)
+}
+
+func (h *PasskeyHandler) handleLogin(w http.ResponseWriter, r *http.Request) {
+	h.finishLogin(w, r, 0)
+}
+
+// handleTwoFactorOptions starts a passkey check for a user who already entered
+// their password
+func (h *PasskeyHandler) handleTwoFactorOptions(w http.ResponseWriter, r *http.Request) {
+	userID := h.session.GetPendingTwoFactor(r)
+	if userID == 0 {
+		respondError(w, http.StatusUnauthorized, "unauthorized", "Your sign in expired, please enter your password again")
+		return
+	}
+
+	h.beginLogin(w, r, userID)
+}
+
+func (h *PasskeyHandler) handleTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := h.session.GetPendingTwoFactor(r)
+	if userID == 0 {
+		respondError(w, http.StatusUnauthorized, "unauthorized", "Your sign in expired, please enter your password again")
+		return
+	}
+
+	h.finishLogin(w, r, userID)
+}
+
+// handleShowIndex renders the passkeys page
+func (h *PasskeyHandler) handleShowIndex(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	required, err := model.RequiresPasskeySecondFactor(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("two_factor_lookup_failed")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.Passkeys(required))
+}
+
+func (h *PasskeyHandler) handleShowList(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	passkeys, err := model.GetPasskeysByUserID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_passkeys")
+		http.Error(w, "Failed to fetch passkeys", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.PasskeyList(passkeys))
+}
+
+// handleRegisterOptions validates the passkey name and answers with the
+// creation options for the browser
+func (h *PasskeyHandler) handleRegisterOptions(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	input := model.CreatePasskeyInput{
+		Name: r.FormValue("name"),
+	}
+
+	v := validator.New()
+	if errs := v.Validate(input); len(errs) > 0 {
+		respondValidationError(w, errs)
+		return
+	}
+
+	creation, data, err := h.passkeyService.BeginRegistration(userID)
+	if err == nil {
+		err = h.putCeremony(r, data)
+	}
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_begin_passkey_registration")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Something went wrong, please try again")
+		return
+	}
+
+	respondData(w, http.StatusOK, creation)
+}
+
+// handleRegister verifies the new credential, the name is passed in the query
+// string since the body holds the authenticator response
+func (h *PasskeyHandler) handleRegister(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	input := model.CreatePasskeyInput{
+		Name: r.URL.Query().Get("name"),
+	}
+
+	v := validator.New()
+	if errs := v.Validate(input); len(errs) > 0 {
+		respondValidationError(w, errs)
+		return
+	}
+
+	data, ok := h.popCeremony(r)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "ceremony_expired", "Passkey request expired, please try again")
+		return
+	}
+
+	if err := h.passkeyService.FinishRegistration(userID, input.Name, data, r); err != nil {
+		if errors.Is(err, services.ErrPasskeyCeremonyFailed) {
+			respondError(w, http.StatusBadRequest, "passkey_rejected", "Passkey could not be verified")
+			return
+		}
+
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_register_passkey")
+		respondError(w, http.StatusInternalServerError, "internal_error", "Something went wrong, please try again")
+		return
+	}
+
+	respondData(w, http.StatusCreated, map[string]string{"name": input.Name})
+}
+
+func (h *PasskeyHandler) handleDestroy(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	passkeyID, err := routeParamAsInt64(r, "id")
+	if err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"id":      chi.URLParam(r, "id"),
+		}).Error("invalid_passkey_id_parameter")
+		http.Error(w, "Invalid passkey ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := model.DeletePasskey(h.db, passkeyID, userID); err != nil {
+		if errors.Is(err, model.ErrPasskeyNotFound) {
+			TriggerErrorToast(w, "Passkey not found")
+			return
+		}
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"passkey_id": passkeyID,
+		}).Error("failed_to_delete_passkey")
+		TriggerErrorToast(w, "Failed to remove passkey")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"passkey_id": passkeyID,
+	}).Info("passkey_deleted")
+
+	TriggerWithToast(w, "reloadPasskeys", ToastSuccess, "Passkey removed")
+}
+
+// handleSecondFactor turns asking for a passkey after the password on or off,
+// it is off by default so a lost device never locks the user out
+func (h *PasskeyHandler) handleSecondFactor(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	required := r.PostFormValue("passkey_second_factor") == "true"
+	if err := model.SetPasskeySecondFactor(h.db, userID, required); err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_update_passkey_second_factor")
+		TriggerErrorToast(w, "Failed to save setting")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":  userID,
+		"required": required,
+	}).Info("passkey_second_factor_updated")
+
+	if required {
+		TriggerSuccessToast(w, "A passkey will be asked for after your password")
+	} else {
+		TriggerSuccessToast(w, "Your password is enough to sign in")
+	}
+}
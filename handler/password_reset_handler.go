@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/mailer"
+	"numera/pkg/session"
+	"numera/pkg/validator"
+	"numera/views/pages"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+type PasswordResetHandler struct {
+	db      *sql.DB
+	logger  *logrus.Logger
+	session *session.Session
+	mailer  mailer.Mailer
+	appURL  string
+}
+
+func NewPasswordResetHandler(
+	db *sql.DB,
+	logger *logrus.Logger,
+	session *session.Session,
+	mailer mailer.Mailer,
+	appURL string,
+) *PasswordResetHandler {
+	return &PasswordResetHandler{
+		db:      db,
+		logger:  logger,
+		session: session,
+		mailer:  mailer,
+		appURL:  appURL,
+	}
+}
+
+func (h *PasswordResetHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireGuest(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+
+		r.Get("/forgot-password", h.handleShowForgotPassword)
+		r.Post("/forgot-password", h.handleForgotPassword)
+		r.Get("/reset-password", h.handleShowResetPassword)
+		r.Post("/reset-password", h.handleResetPassword)
+	})
+}
+
+// handleShowForgotPassword renders the forgot password page
+func (h *PasswordResetHandler) handleShowForgotPassword(w http.ResponseWriter, r *http.Request) {
+	view(w, r, pages.ForgotPassword())
+}
+
+// handleForgotPassword emails a reset link if the address belongs to a user,
+// the response is the same either way so it can't be used to probe for
+// registered emails.
+func (h *PasswordResetHandler) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	input := model.ForgotPasswordInput{
+		Email: r.PostFormValue("email"),
+	}
+
+	v := validator.New()
+	errors := v.Validate(input)
+
+	if len(errors) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.ForgotPasswordFormErrors(errors))
+		return
+	}
+
+	user, err := model.GetUserByEmail(h.db, input.Email)
+	switch {
+	case err == nil:
+		h.sendResetLink(logger, user)
+	case err != model.ErrUserNotFound:
+		logger.WithError(err).WithField("email", input.Email).Error("db_lookup_failed")
+	default:
+		logger.WithField("email", input.Email).Info("password_reset_unknown_email")
+	}
+
+	view(w, r, pages.ForgotPasswordSent(input.Email))
+}
+
+func (h *PasswordResetHandler) sendResetLink(logger *logrus.Entry, user *model.User) {
+	token, err := model.CreatePasswordReset(h.db, user.ID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", user.ID).Error("failed_to_create_password_reset")
+		return
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", h.appURL, url.QueryEscape(token))
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Numera password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n"+
+				"Someone asked to reset the password for your Numera account. "+
+				"Open the link below to choose a new one:\n\n%s\n\n"+
+				"The link expires in %d minutes and can only be used once. "+
+				"If you didn't ask for this you can ignore this email.\n",
+			user.Name,
+			link,
+			int(model.PasswordResetTTL.Minutes()),
+		),
+	}
+
+	// sent in the background so the response time doesn't reveal whether the
+	// email belongs to an account
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := h.mailer.Send(ctx, msg); err != nil {
+			logger.WithError(err).WithField("user_id", user.ID).Error("failed_to_send_password_reset")
+			return
+		}
+		logger.WithField("user_id", user.ID).Info("password_reset_sent")
+	}()
+}
+
+// handleShowResetPassword renders the new password form for a valid token
+func (h *PasswordResetHandler) handleShowResetPassword(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	token := r.URL.Query().Get("token")
+
+	if _, err := model.GetValidPasswordReset(h.db, token); err != nil {
+		if !errors.Is(err, model.ErrPasswordResetInvalid) {
+			logger.WithError(err).Error("failed_to_get_password_reset")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusGone)
+		view(w, r, pages.ResetPasswordInvalid())
+		return
+	}
+
+	view(w, r, pages.ResetPassword(token))
+}
+
+// handleResetPassword sets the new password and logs the user out everywhere
+func (h *PasswordResetHandler) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	input := model.ResetPasswordInput{
+		Token:           r.PostFormValue("token"),
+		Password:        r.PostFormValue("password"),
+		PasswordConfirm: r.PostFormValue("passwordconfirm"),
+	}
+
+	v := validator.New()
+	errs := v.Validate(input)
+
+	if len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.FormErrors(errs))
+		return
+	}
+
+	userID, err := model.ResetPassword(h.db, input)
+	if err != nil {
+		if errors.Is(err, model.ErrPasswordResetInvalid) {
+			logger.Warn("password_reset_token_rejected")
+			TriggerErrorToast(w, "This reset link is invalid or has expired")
+			return
+		}
+
+		logger.WithError(err).Error("failed_to_reset_password")
+		TriggerErrorToast(w, "Something went wrong, please try again")
+		return
+	}
+
+	if err := h.session.DestroyUserSessions(r.Context(), userID); err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_destroy_user_sessions")
+	}
+
+	logger.WithField("user_id", userID).Info("password_reset")
+	TriggerSuccessToast(w, "Password changed, you can now sign in")
+	RedirectUsingHtmx(w, "/login")
+}
@@ -0,0 +1,429 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/session"
+	"numera/pkg/validator"
+	"numera/views/pages"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// payeeHistoryMonths is how many months of spending the payee page shows
+	payeeHistoryMonths = 12
+	// payeeSummaryTransactions is how many of the latest transactions the
+	// payee page lists
+	payeeSummaryTransactions = 50
+)
+
+type PayeeHandler struct {
+	db      *sql.DB
+	logger  *logrus.Logger
+	session *session.Session
+}
+
+func NewPayeeHandler(db *sql.DB, logger *logrus.Logger, session *session.Session) *PayeeHandler {
+	return &PayeeHandler{
+		db:      db,
+		logger:  logger,
+		session: session,
+	}
+}
+
+func (h *PayeeHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
+
+		r.Get("/payees", h.handleShowIndex)
+		r.Get("/payees/list", h.handleShowList)
+		r.Post("/payees", h.handleCreate)
+
+		r.Route("/payees/{id}", func(r chi.Router) {
+			r.Get("/", h.handleShow)
+			r.Get("/edit", h.handleShowUpdate)
+			r.Put("/", h.handleUpdate)
+			r.Delete("/", h.handleDelete)
+			r.Get("/summary", h.handleShowSummary)
+			r.Get("/aliases", h.handleShowAliases)
+			r.Post("/aliases", h.handleCreateAlias)
+			r.Delete("/aliases/{aliasID}", h.handleDeleteAlias)
+			r.Post("/merge", h.handleMerge)
+		})
+	})
+}
+
+// payeeForUser loads the payee named in the url, writing the response itself
+// when the user has no such payee
+func (h *PayeeHandler) payeeForUser(w http.ResponseWriter, r *http.Request) (*model.Payee, bool) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	payeeID, err := routeParamAsInt64(r, "id")
+	if err != nil {
+		http.Error(w, "Invalid payee ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	payee, err := model.GetPayeeForUser(h.db, payeeID, userID)
+	if err != nil {
+		if errors.Is(err, model.ErrPayeeNotFound) {
+			http.Error(w, "Payee not found", http.StatusNotFound)
+			return nil, false
+		}
+		logger.WithError(err).WithField("payee_id", payeeID).Error("failed_to_fetch_payee")
+		http.Error(w, "Failed to fetch payee", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return payee, true
+}
+
+func (h *PayeeHandler) handleShowIndex(w http.ResponseWriter, r *http.Request) {
+	view(w, r, pages.Payees())
+}
+
+func (h *PayeeHandler) handleShowList(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	payees, err := model.GetPayeesByUserID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_payees")
+		http.Error(w, "Failed to fetch payees", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.PayeeList(payees))
+}
+
+func payeeInput(r *http.Request) model.PayeeInput {
+	return model.PayeeInput{
+		Name:            strings.TrimSpace(r.FormValue("name")),
+		DefaultCategory: strings.TrimSpace(r.FormValue("defaultcategory")),
+	}
+}
+
+var payeeFields = []string{"name", "defaultcategory"}
+
+func (h *PayeeHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	input := payeeInput(r)
+	v := validator.New()
+	if errs := v.Validate(input); len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(payeeFields, errs))
+		return
+	}
+
+	payeeID, linked, err := model.CreatePayee(h.db, userID, input)
+	if err != nil {
+		if errors.Is(err, model.ErrPayeeExists) {
+			TriggerErrorToast(w, "Please check the form for errors")
+			view(w, r, pages.SettingsFormErrors(payeeFields, map[string]string{
+				"name": "You already have a payee called " + input.Name,
+			}))
+			return
+		}
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_create_payee")
+		TriggerErrorToast(w, "Failed to create payee")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":  userID,
+		"payee_id": payeeID,
+		"linked":   linked,
+	}).Info("payee_created_successfully")
+
+	TriggerWithToast(w, "reloadPayees", ToastSuccess, fmt.Sprintf("Payee created, %d transactions matched", linked))
+	view(w, r, pages.SettingsFormErrors(payeeFields, nil))
+}
+
+func (h *PayeeHandler) handleShow(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	payee, ok := h.payeeForUser(w, r)
+	if !ok {
+		return
+	}
+
+	payees, err := model.GetPayeesByUserID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_payees")
+		http.Error(w, "Failed to fetch payee", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.Payee(*payee, payees))
+}
+
+func (h *PayeeHandler) handleShowUpdate(w http.ResponseWriter, r *http.Request) {
+	payee, ok := h.payeeForUser(w, r)
+	if !ok {
+		return
+	}
+
+	view(w, r, pages.EditPayeeModal(*payee))
+}
+
+func (h *PayeeHandler) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	payee, ok := h.payeeForUser(w, r)
+	if !ok {
+		return
+	}
+
+	input := payeeInput(r)
+	v := validator.New()
+	if errs := v.Validate(input); len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(payeeFields, errs))
+		return
+	}
+
+	if err := model.UpdatePayee(h.db, payee, input); err != nil {
+		if errors.Is(err, model.ErrPayeeExists) {
+			TriggerErrorToast(w, "Please check the form for errors")
+			view(w, r, pages.SettingsFormErrors(payeeFields, map[string]string{
+				"name": "You already have a payee called " + input.Name,
+			}))
+			return
+		}
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":  userID,
+			"payee_id": payee.ID,
+		}).Error("failed_to_update_payee")
+		TriggerErrorToast(w, "Failed to update payee")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":  userID,
+		"payee_id": payee.ID,
+	}).Info("payee_updated_successfully")
+
+	TriggerWithToast(w, "reloadPayees", ToastSuccess, "Payee updated!")
+}
+
+// handleDelete deletes the payee, from its own page the user is sent back to
+// the list
+func (h *PayeeHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	payee, ok := h.payeeForUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := model.DeletePayee(h.db, payee); err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":  userID,
+			"payee_id": payee.ID,
+		}).Error("failed_to_delete_payee")
+		TriggerErrorToast(w, "Failed to delete payee")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":  userID,
+		"payee_id": payee.ID,
+	}).Info("payee_deleted_successfully")
+
+	if r.URL.Query().Has("redirect") {
+		RedirectUsingHtmx(w, "/payees")
+		return
+	}
+
+	TriggerWithToast(w, "reloadPayees", ToastSuccess, "Payee deleted")
+}
+
+// handleShowSummary renders what was spent with the payee month by month and
+// its latest transactions
+func (h *PayeeHandler) handleShowSummary(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	payee, ok := h.payeeForUser(w, r)
+	if !ok {
+		return
+	}
+
+	months, err := model.GetPayeeMonths(h.db, payee, payeeHistoryMonths, time.Now())
+	if err != nil {
+		logger.WithError(err).WithField("payee_id", payee.ID).Error("failed_to_fetch_payee_history")
+		http.Error(w, "Failed to fetch payee", http.StatusInternalServerError)
+		return
+	}
+
+	transactions, err := model.GetPayeeTransactions(h.db, payee, payeeSummaryTransactions)
+	if err != nil {
+		logger.WithError(err).WithField("payee_id", payee.ID).Error("failed_to_fetch_payee_transactions")
+		http.Error(w, "Failed to fetch payee", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.PayeeSummary(*payee, months, transactions))
+}
+
+func (h *PayeeHandler) handleShowAliases(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	payee, ok := h.payeeForUser(w, r)
+	if !ok {
+		return
+	}
+
+	aliases, err := model.GetPayeeAliases(h.db, payee.ID)
+	if err != nil {
+		logger.WithError(err).WithField("payee_id", payee.ID).Error("failed_to_fetch_payee_aliases")
+		http.Error(w, "Failed to fetch aliases", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.PayeeAliases(*payee, aliases))
+}
+
+var payeeAliasFields = []string{"pattern"}
+
+func (h *PayeeHandler) handleCreateAlias(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	payee, ok := h.payeeForUser(w, r)
+	if !ok {
+		return
+	}
+
+	input := model.PayeeAliasInput{Pattern: strings.TrimSpace(r.FormValue("pattern"))}
+	v := validator.New()
+	if errs := v.Validate(input); len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(payeeAliasFields, errs))
+		return
+	}
+
+	linked, err := model.AddPayeeAlias(h.db, payee, input)
+	if err != nil {
+		if errors.Is(err, model.ErrPayeeAliasExists) {
+			TriggerErrorToast(w, "Please check the form for errors")
+			view(w, r, pages.SettingsFormErrors(payeeAliasFields, map[string]string{
+				"pattern": "The payee already has this alias",
+			}))
+			return
+		}
+		logger.WithError(err).WithField("payee_id", payee.ID).Error("failed_to_create_payee_alias")
+		TriggerErrorToast(w, "Failed to add alias")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"payee_id": payee.ID,
+		"linked":   linked,
+	}).Info("payee_alias_created_successfully")
+
+	TriggerWithToast(w, "reloadPayees", ToastSuccess, fmt.Sprintf("Alias added, %d transactions matched", linked))
+	view(w, r, pages.SettingsFormErrors(payeeAliasFields, nil))
+}
+
+func (h *PayeeHandler) handleDeleteAlias(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	payee, ok := h.payeeForUser(w, r)
+	if !ok {
+		return
+	}
+
+	aliasID, err := routeParamAsInt64(r, "aliasID")
+	if err != nil {
+		http.Error(w, "Invalid alias ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := model.DeletePayeeAlias(h.db, payee.ID, aliasID); err != nil {
+		if errors.Is(err, model.ErrPayeeAliasNotFound) {
+			TriggerWithToast(w, "reloadPayees", ToastError, "Alias not found")
+			return
+		}
+		logger.WithError(err).WithFields(logrus.Fields{
+			"payee_id": payee.ID,
+			"alias_id": aliasID,
+		}).Error("failed_to_delete_payee_alias")
+		TriggerErrorToast(w, "Failed to remove alias")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"payee_id": payee.ID,
+		"alias_id": aliasID,
+	}).Info("payee_alias_deleted_successfully")
+
+	TriggerWithToast(w, "reloadPayees", ToastSuccess, "Alias removed")
+}
+
+// handleMerge folds the payee into the one picked in the form, rewriting its
+// transactions, and moves on to the payee that remains
+func (h *PayeeHandler) handleMerge(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	payee, ok := h.payeeForUser(w, r)
+	if !ok {
+		return
+	}
+
+	target, err := model.GetPayeeForUser(h.db, formValueAsInt64(r, "targetid"), userID)
+	if err != nil {
+		if errors.Is(err, model.ErrPayeeNotFound) {
+			TriggerErrorToast(w, "Choose one of your payees to merge into")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		logger.WithError(err).WithField("payee_id", payee.ID).Error("failed_to_fetch_payee")
+		TriggerErrorToast(w, "Failed to merge payees")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	moved, err := model.MergePayees(h.db, payee, target)
+	if err != nil {
+		if errors.Is(err, model.ErrPayeeMergeSelf) {
+			TriggerErrorToast(w, "A payee can't be merged into itself")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		logger.WithError(err).WithFields(logrus.Fields{
+			"payee_id":  payee.ID,
+			"target_id": target.ID,
+		}).Error("failed_to_merge_payees")
+		TriggerErrorToast(w, "Failed to merge payees")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":   userID,
+		"payee_id":  payee.ID,
+		"target_id": target.ID,
+		"moved":     moved,
+	}).Info("payees_merged_successfully")
+
+	RedirectUsingHtmx(w, fmt.Sprintf("/payees/%d", target.ID))
+}
@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/session"
+	"numera/pkg/validator"
+	"numera/views/pages"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+type ReconciliationHandler struct {
+	db      *sql.DB
+	logger  *logrus.Logger
+	session *session.Session
+}
+
+func NewReconciliationHandler(db *sql.DB, logger *logrus.Logger, session *session.Session) *ReconciliationHandler {
+	return &ReconciliationHandler{
+		db:      db,
+		logger:  logger,
+		session: session,
+	}
+}
+
+func (h *ReconciliationHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
+
+		r.Route("/accounts/{id}/reconcile", func(r chi.Router) {
+			r.Use(middleware.AuthorizeAccount(h.db, model.PermissionEdit))
+
+			r.Get("/", h.handleShow)
+			r.Post("/", h.handleStart)
+			r.Delete("/", h.handleCancel)
+			r.Put("/transactions/{transactionID}", h.handleToggleCleared)
+			r.Post("/complete", h.handleComplete)
+		})
+	})
+}
+
+// openReconciliation loads the reconciliation in progress on the account,
+// writing the response itself when there is none
+func (h *ReconciliationHandler) openReconciliation(w http.ResponseWriter, r *http.Request, account *model.Account) (*model.Reconciliation, bool) {
+	logger := middleware.GetLogger(r.Context())
+
+	reconciliation, err := model.GetOpenReconciliation(h.db, account.ID)
+	if err != nil {
+		if errors.Is(err, model.ErrReconciliationNotFound) {
+			RedirectUsingHtmx(w, fmt.Sprintf("/accounts/%d/reconcile", account.ID))
+			TriggerErrorToast(w, "This reconciliation is no longer in progress")
+			return nil, false
+		}
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_fetch_reconciliation")
+		TriggerErrorToast(w, "Failed to load reconciliation")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return reconciliation, true
+}
+
+// handleShow renders the reconciliation in progress, or the form to start
+// one when there is none
+func (h *ReconciliationHandler) handleShow(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	reconciliation, err := model.GetOpenReconciliation(h.db, account.ID)
+	if errors.Is(err, model.ErrReconciliationNotFound) {
+		view(w, r, pages.StartReconciliation(account.ToView()))
+		return
+	}
+	if err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_fetch_reconciliation")
+		http.Error(w, "Failed to load reconciliation", http.StatusInternalServerError)
+		return
+	}
+
+	transactions, err := model.GetReconciliationTransactions(h.db, account, reconciliation)
+	if err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_fetch_reconciliation_transactions")
+		http.Error(w, "Failed to load reconciliation", http.StatusInternalServerError)
+		return
+	}
+
+	summary, err := model.GetReconciliationSummary(h.db, account, reconciliation)
+	if err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_calculate_reconciliation_summary")
+		http.Error(w, "Failed to load reconciliation", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.Reconciliation(account.ToView(), *reconciliation, transactions, summary))
+}
+
+func (h *ReconciliationHandler) handleStart(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	input := model.StartReconciliationInput{
+		StatementDate:    r.FormValue("statementdate"),
+		StatementBalance: strings.TrimSpace(r.FormValue("statementbalance")),
+	}
+
+	fields := []string{"statementdate", "statementbalance"}
+	v := validator.New()
+	if errs := v.Validate(input); len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(fields, errs))
+		return
+	}
+
+	reconciliationID, err := model.StartReconciliation(h.db, account.ID, userID, input)
+	if err != nil && !errors.Is(err, model.ErrReconciliationInProgress) {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"account_id": account.ID,
+		}).Error("failed_to_start_reconciliation")
+		TriggerErrorToast(w, "Failed to start reconciliation")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":           userID,
+		"account_id":        account.ID,
+		"reconciliation_id": reconciliationID,
+	}).Info("reconciliation_started")
+
+	// a reconciliation someone else started is simply picked up
+	RedirectUsingHtmx(w, fmt.Sprintf("/accounts/%d/reconcile", account.ID))
+}
+
+// handleToggleCleared ticks a transaction off or back on and answers with the
+// updated summary
+func (h *ReconciliationHandler) handleToggleCleared(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	transactionID, err := routeParamAsInt64(r, "transactionID")
+	if err != nil {
+		http.Error(w, "Invalid transaction ID", http.StatusBadRequest)
+		return
+	}
+
+	reconciliation, ok := h.openReconciliation(w, r, account)
+	if !ok {
+		return
+	}
+
+	err = model.SetTransactionCleared(h.db, reconciliation, transactionID, r.FormValue("cleared") == "true")
+	if err != nil {
+		if errors.Is(err, model.ErrTransactionNotFound) {
+			TriggerErrorToast(w, "Transaction not found")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logger.WithError(err).WithFields(logrus.Fields{
+			"account_id":     account.ID,
+			"transaction_id": transactionID,
+		}).Error("failed_to_clear_transaction")
+		TriggerErrorToast(w, "Failed to update transaction")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	summary, err := model.GetReconciliationSummary(h.db, account, reconciliation)
+	if err != nil {
+		logger.WithError(err).WithField("account_id", account.ID).Error("failed_to_calculate_reconciliation_summary")
+		TriggerErrorToast(w, "Failed to update the difference")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.ReconciliationSummary(account.ToView(), summary))
+}
+
+// handleComplete locks the cleared transactions, closing a small difference
+// with an adjustment entry when the user asked for one
+func (h *ReconciliationHandler) handleComplete(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	reconciliation, ok := h.openReconciliation(w, r, account)
+	if !ok {
+		return
+	}
+
+	adjust := r.FormValue("adjust") == "true"
+	err := model.CompleteReconciliation(h.db, auditActor(r), account, reconciliation, adjust)
+	if err != nil {
+		if errors.Is(err, model.ErrReconciliationUnbalanced) {
+			TriggerErrorToast(w, "The cleared balance must match the statement")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":           userID,
+			"account_id":        account.ID,
+			"reconciliation_id": reconciliation.ID,
+		}).Error("failed_to_complete_reconciliation")
+		TriggerErrorToast(w, "Failed to complete reconciliation")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":           userID,
+		"account_id":        account.ID,
+		"reconciliation_id": reconciliation.ID,
+		"adjusted":          adjust,
+	}).Info("reconciliation_completed")
+
+	RedirectUsingHtmx(w, fmt.Sprintf("/accounts/%d", account.ID))
+}
+
+func (h *ReconciliationHandler) handleCancel(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	reconciliation, ok := h.openReconciliation(w, r, account)
+	if !ok {
+		return
+	}
+
+	if err := model.CancelReconciliation(h.db, reconciliation); err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"account_id":        account.ID,
+			"reconciliation_id": reconciliation.ID,
+		}).Error("failed_to_cancel_reconciliation")
+		TriggerErrorToast(w, "Failed to cancel reconciliation")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"account_id":        account.ID,
+		"reconciliation_id": reconciliation.ID,
+	}).Info("reconciliation_cancelled")
+
+	RedirectUsingHtmx(w, fmt.Sprintf("/accounts/%d", account.ID))
+}
@@ -0,0 +1,443 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/session"
+	"numera/pkg/validator"
+	"numera/services"
+	"numera/views/pages"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+type SavingsGoalHandler struct {
+	db              *sql.DB
+	logger          *logrus.Logger
+	session         *session.Session
+	exchangeService *services.ExchangeService
+}
+
+func NewSavingsGoalHandler(
+	db *sql.DB,
+	logger *logrus.Logger,
+	session *session.Session,
+	exchangeService *services.ExchangeService,
+) *SavingsGoalHandler {
+	return &SavingsGoalHandler{
+		db:              db,
+		logger:          logger,
+		session:         session,
+		exchangeService: exchangeService,
+	}
+}
+
+func (h *SavingsGoalHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
+
+		r.Get("/goals", h.handleShowIndex)
+		r.Get("/goals/list", h.handleShowList)
+		r.Get("/goals/summary", h.handleShowSummary)
+		r.Post("/goals", h.handleCreate)
+
+		r.Route("/goals/{id}", func(r chi.Router) {
+			r.Get("/", h.handleShow)
+			r.Get("/edit", h.handleShowUpdate)
+			r.Put("/", h.handleUpdate)
+			r.Delete("/", h.handleDelete)
+			r.Get("/contributions", h.handleShowContributions)
+			r.Post("/contributions", h.handleCreateContribution)
+			r.Delete("/contributions/{contributionID}", h.handleDeleteContribution)
+		})
+	})
+}
+
+// goalForUser loads the goal named in the url, writing the response itself
+// when the user has no such goal
+func (h *SavingsGoalHandler) goalForUser(w http.ResponseWriter, r *http.Request) (*model.SavingsGoal, bool) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	goalID, err := routeParamAsInt64(r, "id")
+	if err != nil {
+		http.Error(w, "Invalid goal ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	goal, err := model.GetSavingsGoalForUser(h.db, goalID, userID)
+	if err != nil {
+		if errors.Is(err, model.ErrSavingsGoalNotFound) {
+			http.Error(w, "Goal not found", http.StatusNotFound)
+			return nil, false
+		}
+		logger.WithError(err).WithField("goal_id", goalID).Error("failed_to_fetch_savings_goal")
+		http.Error(w, "Failed to fetch goal", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return goal, true
+}
+
+// goalView works out the goal's progress. A linked account's balance is
+// converted into the goal's currency, when no rate is available the progress
+// is left out rather than failing the page.
+func (h *SavingsGoalHandler) goalView(r *http.Request, goal *model.SavingsGoal) model.SavingsGoalView {
+	logger := middleware.GetLogger(r.Context())
+	now := time.Now()
+
+	if !goal.Linked {
+		return goal.ToView(goal.Contributed, true, now)
+	}
+	if goal.AccountCurrency == goal.Currency {
+		return goal.ToView(goal.AccountBalance, true, now)
+	}
+
+	saved, err := h.exchangeService.ConvertAmount(r.Context(), goal.AccountBalance, goal.AccountCurrency, goal.Currency)
+	if err != nil {
+		logger.WithError(err).
+			WithField("goal_id", goal.ID).
+			WithField("from_currency", goal.AccountCurrency).
+			WithField("to_currency", goal.Currency).
+			Warn("failed_to_convert_currency")
+		return goal.ToView(decimal.Zero, false, now)
+	}
+
+	return goal.ToView(saved, true, now)
+}
+
+func (h *SavingsGoalHandler) goalViews(w http.ResponseWriter, r *http.Request) ([]model.SavingsGoalView, bool) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	goals, err := model.GetSavingsGoalsByUserID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_savings_goals")
+		http.Error(w, "Failed to fetch goals", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	goalViews := make([]model.SavingsGoalView, len(goals))
+	for i := range goals {
+		goalViews[i] = h.goalView(r, &goals[i])
+	}
+
+	return goalViews, true
+}
+
+// accountViews lists the accounts a goal can be linked to
+func (h *SavingsGoalHandler) accountViews(w http.ResponseWriter, r *http.Request) ([]model.AccountView, bool) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	accounts, err := model.GetAccounstByID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_accounts_by_user_id")
+		http.Error(w, "Failed to fetch accounts", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	accountViews := make([]model.AccountView, len(accounts))
+	for i, account := range accounts {
+		accountViews[i] = account.ToView()
+	}
+
+	return accountViews, true
+}
+
+func (h *SavingsGoalHandler) handleShowIndex(w http.ResponseWriter, r *http.Request) {
+	accounts, ok := h.accountViews(w, r)
+	if !ok {
+		return
+	}
+
+	view(w, r, pages.SavingsGoals(accounts))
+}
+
+func (h *SavingsGoalHandler) handleShowList(w http.ResponseWriter, r *http.Request) {
+	goals, ok := h.goalViews(w, r)
+	if !ok {
+		return
+	}
+
+	view(w, r, pages.SavingsGoalList(goals))
+}
+
+// handleShowSummary renders the goals shown on the dashboard
+func (h *SavingsGoalHandler) handleShowSummary(w http.ResponseWriter, r *http.Request) {
+	goals, ok := h.goalViews(w, r)
+	if !ok {
+		return
+	}
+
+	view(w, r, pages.SavingsGoalSummary(goals))
+}
+
+// validateGoal checks the goal form, including that a linked account is one
+// the user can see
+func (h *SavingsGoalHandler) validateGoal(r *http.Request, input model.SavingsGoalInput) (map[string]string, error) {
+	v := validator.New()
+	errs := v.Validate(input)
+	if len(errs) > 0 {
+		return errs, nil
+	}
+
+	if amount, err := decimal.NewFromString(input.TargetAmount); err != nil || !amount.IsPositive() {
+		return map[string]string{"targetamount": "Target amount must be greater than zero"}, nil
+	}
+
+	if input.AccountID != 0 {
+		_, err := model.GetAccountForUser(h.db, input.AccountID, GetUserID(r.Context()))
+		if errors.Is(err, model.ErrAccountNotFound) {
+			return map[string]string{"accountid": "Choose one of your accounts"}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+func savingsGoalInput(r *http.Request) model.SavingsGoalInput {
+	return model.SavingsGoalInput{
+		Name:         strings.TrimSpace(r.FormValue("name")),
+		TargetAmount: strings.TrimSpace(r.FormValue("targetamount")),
+		Currency:     model.Currency(r.FormValue("currency")),
+		TargetDate:   r.FormValue("targetdate"),
+		AccountID:    formValueAsInt64(r, "accountid"),
+	}
+}
+
+var savingsGoalFields = []string{"name", "targetamount", "currency", "targetdate", "accountid"}
+
+func (h *SavingsGoalHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	input := savingsGoalInput(r)
+	errs, err := h.validateGoal(r, input)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_validate_savings_goal")
+		TriggerErrorToast(w, "Failed to create goal")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(savingsGoalFields, errs))
+		return
+	}
+
+	goalID, err := model.CreateSavingsGoal(h.db, userID, input)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_create_savings_goal")
+		TriggerErrorToast(w, "Failed to create goal")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"goal_id": goalID,
+	}).Info("savings_goal_created_successfully")
+
+	TriggerWithToast(w, "reloadGoals", ToastSuccess, "Goal created!")
+	view(w, r, pages.SettingsFormErrors(savingsGoalFields, nil))
+}
+
+func (h *SavingsGoalHandler) handleShow(w http.ResponseWriter, r *http.Request) {
+	goal, ok := h.goalForUser(w, r)
+	if !ok {
+		return
+	}
+
+	view(w, r, pages.SavingsGoal(h.goalView(r, goal)))
+}
+
+func (h *SavingsGoalHandler) handleShowUpdate(w http.ResponseWriter, r *http.Request) {
+	goal, ok := h.goalForUser(w, r)
+	if !ok {
+		return
+	}
+
+	accounts, ok := h.accountViews(w, r)
+	if !ok {
+		return
+	}
+
+	view(w, r, pages.EditSavingsGoalModal(h.goalView(r, goal), accounts))
+}
+
+func (h *SavingsGoalHandler) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	goal, ok := h.goalForUser(w, r)
+	if !ok {
+		return
+	}
+
+	input := savingsGoalInput(r)
+	errs, err := h.validateGoal(r, input)
+	if err != nil {
+		logger.WithError(err).WithField("goal_id", goal.ID).Error("failed_to_validate_savings_goal")
+		TriggerErrorToast(w, "Failed to update goal")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(savingsGoalFields, errs))
+		return
+	}
+
+	if err := model.UpdateSavingsGoal(h.db, goal.ID, userID, input); err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"goal_id": goal.ID,
+		}).Error("failed_to_update_savings_goal")
+		TriggerErrorToast(w, "Failed to update goal")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"goal_id": goal.ID,
+	}).Info("savings_goal_updated_successfully")
+
+	TriggerWithToast(w, "reloadGoals", ToastSuccess, "Goal updated!")
+}
+
+func (h *SavingsGoalHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	goal, ok := h.goalForUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := model.DeleteSavingsGoal(h.db, goal.ID, userID); err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"goal_id": goal.ID,
+		}).Error("failed_to_delete_savings_goal")
+		TriggerErrorToast(w, "Failed to delete goal")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"goal_id": goal.ID,
+	}).Info("savings_goal_deleted_successfully")
+
+	TriggerWithToast(w, "reloadGoals", ToastSuccess, "Goal deleted")
+}
+
+func (h *SavingsGoalHandler) handleShowContributions(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	goal, ok := h.goalForUser(w, r)
+	if !ok {
+		return
+	}
+
+	contributions, err := model.GetSavingsGoalContributions(h.db, goal.ID)
+	if err != nil {
+		logger.WithError(err).WithField("goal_id", goal.ID).Error("failed_to_fetch_savings_goal_contributions")
+		http.Error(w, "Failed to fetch contributions", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.SavingsGoalContributions(h.goalView(r, goal), contributions))
+}
+
+var savingsGoalContributionFields = []string{"amount", "note", "contributedat"}
+
+func (h *SavingsGoalHandler) handleCreateContribution(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	goal, ok := h.goalForUser(w, r)
+	if !ok {
+		return
+	}
+
+	input := model.SavingsGoalContributionInput{
+		Amount:        strings.TrimSpace(r.FormValue("amount")),
+		Note:          strings.TrimSpace(r.FormValue("note")),
+		ContributedAt: r.FormValue("contributedat"),
+	}
+
+	v := validator.New()
+	errs := v.Validate(input)
+	if amount, err := decimal.NewFromString(input.Amount); len(errs) == 0 && (err != nil || amount.IsZero()) {
+		errs = map[string]string{"amount": "Amount must not be zero"}
+	}
+	if len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(savingsGoalContributionFields, errs))
+		return
+	}
+
+	contributionID, err := model.AddSavingsGoalContribution(h.db, goal.ID, input)
+	if err != nil {
+		logger.WithError(err).WithField("goal_id", goal.ID).Error("failed_to_create_savings_goal_contribution")
+		TriggerErrorToast(w, "Failed to add contribution")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"goal_id":         goal.ID,
+		"contribution_id": contributionID,
+	}).Info("savings_goal_contribution_created_successfully")
+
+	TriggerWithToast(w, "reloadGoals", ToastSuccess, "Contribution added!")
+	view(w, r, pages.SettingsFormErrors(savingsGoalContributionFields, nil))
+}
+
+func (h *SavingsGoalHandler) handleDeleteContribution(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	goal, ok := h.goalForUser(w, r)
+	if !ok {
+		return
+	}
+
+	contributionID, err := routeParamAsInt64(r, "contributionID")
+	if err != nil {
+		http.Error(w, "Invalid contribution ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := model.DeleteSavingsGoalContribution(h.db, contributionID, goal.ID); err != nil {
+		if errors.Is(err, model.ErrSavingsGoalContributionNotFound) {
+			TriggerWithToast(w, "reloadGoals", ToastError, "Contribution not found")
+			return
+		}
+		logger.WithError(err).WithFields(logrus.Fields{
+			"goal_id":         goal.ID,
+			"contribution_id": contributionID,
+		}).Error("failed_to_delete_savings_goal_contribution")
+		TriggerErrorToast(w, "Failed to delete contribution")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"goal_id":         goal.ID,
+		"contribution_id": contributionID,
+	}).Info("savings_goal_contribution_deleted_successfully")
+
+	TriggerWithToast(w, "reloadGoals", ToastSuccess, "Contribution removed")
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/session"
+	"numera/views/pages"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+type SessionHandler struct {
+	db      *sql.DB
+	logger  *logrus.Logger
+	session *session.Session
+}
+
+func NewSessionHandler(db *sql.DB, logger *logrus.Logger, session *session.Session) *SessionHandler {
+	return &SessionHandler{
+		db:      db,
+		logger:  logger,
+		session: session,
+	}
+}
+
+func (h *SessionHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
+
+		r.Get("/settings/sessions", h.handleShowIndex)
+		r.Get("/settings/sessions/list", h.handleShowList)
+		r.Delete("/settings/sessions", h.handleDestroyOthers)
+		r.Delete("/settings/sessions/{id}", h.handleDestroy)
+	})
+}
+
+// handleShowIndex renders the active sessions page
+func (h *SessionHandler) handleShowIndex(w http.ResponseWriter, r *http.Request) {
+	view(w, r, pages.Sessions())
+}
+
+func (h *SessionHandler) handleShowList(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	sessions, err := model.GetUserSessions(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_sessions")
+		http.Error(w, "Failed to fetch sessions", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.SessionList(sessions, h.session.Token(r.Context())))
+}
+
+// handleDestroy signs out a single other device, the current one signs out
+// through the logout button
+func (h *SessionHandler) handleDestroy(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	sessionID, err := routeParamAsInt64(r, "id")
+	if err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"id":      chi.URLParam(r, "id"),
+		}).Error("invalid_session_id_parameter")
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	userSession, err := model.GetUserSessionByID(h.db, sessionID, userID)
+	if err != nil {
+		if errors.Is(err, model.ErrUserSessionNotFound) {
+			TriggerErrorToast(w, "Session not found")
+			return
+		}
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"session_id": sessionID,
+		}).Error("failed_to_fetch_session")
+		TriggerErrorToast(w, "Failed to sign out device")
+		return
+	}
+
+	if userSession.Token == h.session.Token(r.Context()) {
+		TriggerErrorToast(w, "Use sign out to end the current session")
+		return
+	}
+
+	if err := h.session.DestroyToken(userSession.Token); err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"session_id": sessionID,
+		}).Error("failed_to_destroy_session")
+		TriggerErrorToast(w, "Failed to sign out device")
+		return
+	}
+
+	if err := model.DeleteUserSession(h.db, userSession.Token); err != nil {
+		logger.WithError(err).WithField("session_id", sessionID).Error("failed_to_delete_user_session")
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"session_id": sessionID,
+	}).Info("session_revoked")
+
+	TriggerWithToast(w, "reloadSessions", ToastSuccess, "Device signed out")
+}
+
+// handleDestroyOthers signs out every device except the current one
+func (h *SessionHandler) handleDestroyOthers(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	if err := h.session.DestroyOtherUserSessions(r.Context(), userID); err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_destroy_other_sessions")
+		TriggerErrorToast(w, "Failed to sign out other devices")
+		return
+	}
+
+	logger.WithField("user_id", userID).Info("other_sessions_revoked")
+
+	TriggerWithToast(w, "reloadSessions", ToastSuccess, "Signed out everywhere else")
+}
@@ -0,0 +1,319 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/session"
+	"numera/pkg/validator"
+	"numera/services"
+	"numera/views/pages"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	profileFields  = []string{"name", "currency", "locale", "timezone"}
+	passwordFields = []string{"currentpassword", "password", "passwordconfirm"}
+	emailFields    = []string{"email", "password"}
+	deleteFields   = []string{"password"}
+)
+
+type SettingsHandler struct {
+	db                  *sql.DB
+	logger              *logrus.Logger
+	session             *session.Session
+	verificationService *services.VerificationService
+	attachmentService   *services.AttachmentService
+}
+
+func NewSettingsHandler(
+	db *sql.DB,
+	logger *logrus.Logger,
+	session *session.Session,
+	verificationService *services.VerificationService,
+	attachmentService *services.AttachmentService,
+) *SettingsHandler {
+	return &SettingsHandler{
+		db:                  db,
+		logger:              logger,
+		session:             session,
+		verificationService: verificationService,
+		attachmentService:   attachmentService,
+	}
+}
+
+func (h *SettingsHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
+
+		r.Get("/settings", h.handleShowProfile)
+		r.Post("/settings/profile", h.handleUpdateProfile)
+		r.Get("/settings/password", h.handleShowPassword)
+		r.Post("/settings/password", h.handleChangePassword)
+		r.Get("/settings/email", h.handleShowEmail)
+		r.Post("/settings/email", h.handleChangeEmail)
+		r.Get("/settings/delete", h.handleShowDelete)
+		r.Post("/settings/delete", h.handleDelete)
+	})
+}
+
+// checkPassword confirms the current password before a sensitive change,
+// adding a form error if it doesn't match
+func (h *SettingsHandler) checkPassword(
+	w http.ResponseWriter,
+	r *http.Request,
+	field, password string,
+	errs map[string]string,
+	fields []string,
+) bool {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	ok, err := model.CheckPassword(h.db, userID, password)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("password_check_failed")
+		TriggerErrorToast(w, "Something went wrong, please try again")
+		return false
+	}
+
+	if !ok {
+		logger.WithField("user_id", userID).Warn("settings_password_check_denied")
+
+		errs = validator.New().AddError(errs, field, "Incorrect password")
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(fields, errs))
+		return false
+	}
+
+	return true
+}
+
+func (h *SettingsHandler) currentUser(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	userID := GetUserID(r.Context())
+
+	user, err := model.GetUserByID(h.db, userID)
+	if err != nil {
+		middleware.GetLogger(r.Context()).WithError(err).WithField("user_id", userID).Error("user_fetch_failed")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// handleShowProfile renders the profile settings
+func (h *SettingsHandler) handleShowProfile(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	view(w, r, pages.ProfileSettings(user))
+}
+
+func (h *SettingsHandler) handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	input := model.UpdateProfileInput{
+		Name:     r.PostFormValue("name"),
+		Currency: model.Currency(r.PostFormValue("currency")),
+		Locale:   r.PostFormValue("locale"),
+		Timezone: r.PostFormValue("timezone"),
+	}
+
+	v := validator.New()
+	errs := v.Validate(input)
+
+	if len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(profileFields, errs))
+		return
+	}
+
+	if err := model.UpdateProfile(h.db, auditActor(r), input); err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_update_profile")
+		TriggerErrorToast(w, "Failed to save profile")
+		return
+	}
+
+	logger.WithField("user_id", userID).Info("profile_updated")
+
+	TriggerSuccessToast(w, "Profile saved")
+	view(w, r, pages.SettingsFormErrors(profileFields, errs))
+}
+
+func (h *SettingsHandler) handleShowPassword(w http.ResponseWriter, r *http.Request) {
+	view(w, r, pages.PasswordSettings())
+}
+
+// handleChangePassword replaces the password after confirming the current
+// one, every other session of the user is signed out
+func (h *SettingsHandler) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	input := model.ChangePasswordInput{
+		CurrentPassword: r.PostFormValue("currentpassword"),
+		Password:        r.PostFormValue("password"),
+		PasswordConfirm: r.PostFormValue("passwordconfirm"),
+	}
+
+	v := validator.New()
+	errs := v.Validate(input)
+
+	if len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(passwordFields, errs))
+		return
+	}
+
+	if !h.checkPassword(w, r, "currentpassword", input.CurrentPassword, errs, passwordFields) {
+		return
+	}
+
+	if err := model.ChangePassword(h.db, auditActor(r), input.Password); err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_change_password")
+		TriggerErrorToast(w, "Failed to change password")
+		return
+	}
+
+	if err := h.session.DestroyOtherUserSessions(r.Context(), userID); err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_destroy_other_sessions")
+	}
+
+	if err := h.session.RenewToken(r.Context()); err != nil {
+		logger.WithError(err).Error("session_token_renewable_failed")
+	}
+
+	logger.WithField("user_id", userID).Info("password_changed")
+
+	TriggerSuccessToast(w, "Password changed, other devices were signed out")
+	RedirectUsingHtmx(w, "/settings/password")
+}
+
+func (h *SettingsHandler) handleShowEmail(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	view(w, r, pages.EmailSettings(user))
+}
+
+// handleChangeEmail moves the account to a new address and sends a
+// verification link to it, the app is locked until it is confirmed
+func (h *SettingsHandler) handleChangeEmail(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	input := model.ChangeEmailInput{
+		Email:    r.PostFormValue("email"),
+		Password: r.PostFormValue("password"),
+	}
+
+	v := validator.New()
+	errs := v.Validate(input)
+
+	if errs["email"] == "" {
+		existing, err := model.GetUserByEmail(h.db, input.Email)
+		if err != nil && err != model.ErrUserNotFound {
+			logger.WithError(err).WithField("email", input.Email).Error("db_lookup_failed")
+			TriggerErrorToast(w, "Something went wrong, please try again")
+			return
+		}
+		if existing != nil {
+			errs = v.AddError(errs, "email", "This email address is already in use")
+		}
+	}
+
+	if len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(emailFields, errs))
+		return
+	}
+
+	if !h.checkPassword(w, r, "password", input.Password, errs, emailFields) {
+		return
+	}
+
+	if err := model.ChangeEmail(h.db, auditActor(r), input.Email); err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_change_email")
+		TriggerErrorToast(w, "Failed to change email")
+		return
+	}
+
+	logger.WithField("user_id", userID).Info("email_changed")
+
+	user, err := model.GetUserByID(h.db, userID)
+	if err == nil {
+		err = h.verificationService.Send(r.Context(), user)
+	}
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_send_verification_email")
+	}
+
+	TriggerSuccessToast(w, "Email changed, check your inbox to confirm it")
+	RedirectUsingHtmx(w, "/verify-email")
+}
+
+func (h *SettingsHandler) handleShowDelete(w http.ResponseWriter, r *http.Request) {
+	view(w, r, pages.DeleteAccountSettings())
+}
+
+// handleDelete removes the account with all of its data and signs the user
+// out everywhere
+func (h *SettingsHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	input := model.DeleteUserInput{
+		Password: r.PostFormValue("password"),
+	}
+
+	v := validator.New()
+	errs := v.Validate(input)
+
+	if len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(deleteFields, errs))
+		return
+	}
+
+	if !h.checkPassword(w, r, "password", input.Password, errs, deleteFields) {
+		return
+	}
+
+	// the files are looked up first, their records go with the user
+	attachmentKeys, err := model.GetUserAttachmentKeys(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_attachments")
+		TriggerErrorToast(w, "Failed to delete account")
+		return
+	}
+
+	if err := model.DeleteUser(h.db, userID); err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_delete_user")
+		TriggerErrorToast(w, "Failed to delete account")
+		return
+	}
+
+	if err := h.session.DestroyUserSessions(r.Context(), userID); err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_destroy_user_sessions")
+	}
+	if err := h.session.Destroy(r.Context()); err != nil {
+		logger.WithError(err).Error("session_token_destroy_failed")
+	}
+
+	h.attachmentService.DeleteObjects(r.Context(), attachmentKeys)
+
+	logger.WithField("user_id", userID).Info("user_deleted")
+
+	TriggerSuccessToast(w, "Your account was deleted")
+	RedirectUsingHtmx(w, "/login")
+}
@@ -0,0 +1,234 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/session"
+	"numera/pkg/validator"
+	"numera/services"
+	"numera/views/pages"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// tagSummaryTransactions is how many of the latest tagged transactions the
+// tag summary lists
+const tagSummaryTransactions = 50
+
+type TagHandler struct {
+	db              *sql.DB
+	logger          *logrus.Logger
+	session         *session.Session
+	exchangeService *services.ExchangeService
+}
+
+func NewTagHandler(
+	db *sql.DB,
+	logger *logrus.Logger,
+	session *session.Session,
+	exchangeService *services.ExchangeService,
+) *TagHandler {
+	return &TagHandler{
+		db:              db,
+		logger:          logger,
+		session:         session,
+		exchangeService: exchangeService,
+	}
+}
+
+func (h *TagHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
+
+		r.Get("/tags", h.handleShowIndex)
+		r.Get("/tags/list", h.handleShowList)
+		r.Get("/tags/suggestions", h.handleShowSuggestions)
+		r.Get("/tags/{id}", h.handleShow)
+		r.Put("/tags/{id}", h.handleRename)
+		r.Delete("/tags/{id}", h.handleDelete)
+	})
+}
+
+// tagForUser loads the tag named in the url, writing the response itself when
+// the user has no such tag
+func (h *TagHandler) tagForUser(w http.ResponseWriter, r *http.Request) (*model.Tag, bool) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	tagID, err := routeParamAsInt64(r, "id")
+	if err != nil {
+		http.Error(w, "Invalid tag ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	tag, err := model.GetTagForUser(h.db, tagID, userID)
+	if err != nil {
+		if errors.Is(err, model.ErrTagNotFound) {
+			http.Error(w, "Tag not found", http.StatusNotFound)
+			return nil, false
+		}
+		logger.WithError(err).WithField("tag_id", tagID).Error("failed_to_fetch_tag")
+		http.Error(w, "Failed to fetch tag", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return tag, true
+}
+
+func (h *TagHandler) handleShowIndex(w http.ResponseWriter, r *http.Request) {
+	view(w, r, pages.Tags())
+}
+
+func (h *TagHandler) handleShowList(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	tags, err := model.GetTagsByUserID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_tags")
+		http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.TagList(tags))
+}
+
+// handleShowSuggestions renders the options of the tag autocomplete for what
+// has been typed so far
+func (h *TagHandler) handleShowSuggestions(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	tags, err := model.SearchTags(h.db, userID, strings.TrimSpace(r.URL.Query().Get("tags")), 10)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_search_tags")
+		http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.TagSuggestions(tags))
+}
+
+// handleShow renders what the tag adds up to per account and currency, with a
+// grand total in the user's currency when every rate is available
+func (h *TagHandler) handleShow(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	tag, ok := h.tagForUser(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := model.GetUserByID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("user_fetch_failed")
+		http.Error(w, "Failed to fetch tag", http.StatusInternalServerError)
+		return
+	}
+
+	totals, err := model.GetTagAccountTotals(h.db, tag)
+	if err != nil {
+		logger.WithError(err).WithField("tag_id", tag.ID).Error("failed_to_calculate_tag_totals")
+		http.Error(w, "Failed to fetch tag", http.StatusInternalServerError)
+		return
+	}
+
+	transactions, err := model.GetTaggedTransactions(h.db, tag, tagSummaryTransactions)
+	if err != nil {
+		logger.WithError(err).WithField("tag_id", tag.ID).Error("failed_to_fetch_tagged_transactions")
+		http.Error(w, "Failed to fetch tag", http.StatusInternalServerError)
+		return
+	}
+
+	summary := model.TagSummary{
+		Tag:          *tag,
+		Totals:       totals,
+		Transactions: transactions,
+		Currency:     user.Currency,
+		HasTotal:     true,
+	}
+	for currency, total := range summary.TotalsByCurrency() {
+		if currency == user.Currency {
+			summary.Total = summary.Total.Add(total)
+			continue
+		}
+		converted, err := h.exchangeService.ConvertAmount(r.Context(), total, currency, user.Currency)
+		if err != nil {
+			logger.WithError(err).
+				WithField("tag_id", tag.ID).
+				WithField("from_currency", currency).
+				WithField("to_currency", user.Currency).
+				Warn("failed_to_convert_currency")
+			summary.HasTotal = false
+			break
+		}
+		summary.Total = summary.Total.Add(converted)
+	}
+
+	view(w, r, pages.TagSummary(summary))
+}
+
+func (h *TagHandler) handleRename(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	tag, ok := h.tagForUser(w, r)
+	if !ok {
+		return
+	}
+
+	input := model.TagInput{Name: strings.TrimSpace(r.FormValue("name"))}
+	v := validator.New()
+	if errs := v.Validate(input); len(errs) > 0 {
+		TriggerWithToast(w, "reloadTags", ToastError, "Tag names are 1 to 30 characters long")
+		return
+	}
+
+	if err := model.RenameTag(h.db, tag.ID, userID, input); err != nil {
+		if errors.Is(err, model.ErrTagExists) {
+			TriggerWithToast(w, "reloadTags", ToastError, "You already have a tag called "+input.Name)
+			return
+		}
+		logger.WithError(err).WithField("tag_id", tag.ID).Error("failed_to_rename_tag")
+		TriggerErrorToast(w, "Failed to rename tag")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"tag_id":  tag.ID,
+	}).Info("tag_renamed_successfully")
+
+	TriggerWithToast(w, "reloadTags", ToastSuccess, "Tag renamed")
+}
+
+func (h *TagHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	tag, ok := h.tagForUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := model.DeleteTag(h.db, tag.ID, userID); err != nil {
+		logger.WithError(err).WithField("tag_id", tag.ID).Error("failed_to_delete_tag")
+		TriggerErrorToast(w, "Failed to delete tag")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"tag_id":  tag.ID,
+	}).Info("tag_deleted_successfully")
+
+	TriggerWithToast(w, "reloadTags", ToastSuccess, "Tag deleted")
+}
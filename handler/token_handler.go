@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/session"
+	"numera/pkg/validator"
+	"numera/views/pages"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+type TokenHandler struct {
+	db      *sql.DB
+	logger  *logrus.Logger
+	session *session.Session
+}
+
+func NewTokenHandler(db *sql.DB, logger *logrus.Logger, session *session.Session) *TokenHandler {
+	return &TokenHandler{
+		db:      db,
+		logger:  logger,
+		session: session,
+	}
+}
+
+func (h *TokenHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
+
+		r.Get("/settings/tokens", h.handleShowIndex)
+		r.Get("/settings/tokens/list", h.handleShowList)
+		r.Post("/settings/tokens", h.handleCreate)
+		r.Delete("/settings/tokens/{id}", h.handleDestroy)
+	})
+}
+
+// handleShowIndex renders personal access tokens page
+func (h *TokenHandler) handleShowIndex(w http.ResponseWriter, r *http.Request) {
+	view(w, r, pages.APITokens(model.AllAPITokenScopes))
+}
+
+func (h *TokenHandler) handleShowList(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	tokens, err := model.GetAPITokensByUserID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_fetch_api_tokens")
+		http.Error(w, "Failed to fetch tokens", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.APITokenList(tokens))
+}
+
+func (h *TokenHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	expiresInDays, _ := strconv.Atoi(r.FormValue("expires_in_days"))
+	input := model.CreateAPITokenInput{
+		Name:          r.FormValue("name"),
+		ExpiresInDays: expiresInDays,
+	}
+	for _, scope := range r.Form["scopes"] {
+		input.Scopes = append(input.Scopes, model.APITokenScope(scope))
+	}
+
+	v := validator.New()
+	errors := v.Validate(input)
+
+	if len(errors) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.APITokenFormErrors(errors))
+		return
+	}
+
+	token, err := model.CreateAPIToken(h.db, userID, input)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_create_api_token")
+		TriggerErrorToast(w, "Failed to create token")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"name":    input.Name,
+		"scopes":  input.Scopes,
+	}).Info("api_token_created_successfully")
+
+	TriggerWithToast(w, "reloadTokens", ToastSuccess, "Token created, copy it now!")
+	view(w, r, pages.NewAPIToken(token))
+}
+
+func (h *TokenHandler) handleDestroy(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	tokenID, err := routeParamAsInt64(r, "id")
+	if err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"id":      chi.URLParam(r, "id"),
+		}).Error("invalid_api_token_id_parameter")
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := model.DeleteAPIToken(h.db, tokenID, userID); err != nil {
+		if errors.Is(err, model.ErrAPITokenNotFound) {
+			TriggerErrorToast(w, "Token not found")
+			return
+		}
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":  userID,
+			"token_id": tokenID,
+		}).Error("failed_to_revoke_api_token")
+		TriggerErrorToast(w, "Failed to revoke token")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"user_id":  userID,
+		"token_id": tokenID,
+	}).Info("api_token_revoked_successfully")
+
+	TriggerWithToast(w, "reloadTokens", ToastSuccess, "Token revoked")
+}
@@ -0,0 +1,296 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/locale"
+	"numera/pkg/session"
+	"numera/pkg/validator"
+	"numera/views/pages"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+type TransactionHandler struct {
+	db      *sql.DB
+	logger  *logrus.Logger
+	session *session.Session
+}
+
+func NewTransactionHandler(db *sql.DB, logger *logrus.Logger, session *session.Session) *TransactionHandler {
+	return &TransactionHandler{
+		db:      db,
+		logger:  logger,
+		session: session,
+	}
+}
+
+func (h *TransactionHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
+
+		r.Route("/accounts/{id}/transactions/{transactionID}", func(r chi.Router) {
+			r.With(middleware.AuthorizeAccount(h.db, model.PermissionEdit)).Get("/split", h.handleShowSplit)
+			r.With(middleware.AuthorizeAccount(h.db, model.PermissionEdit)).Put("/split", h.handleSplit)
+			r.With(middleware.AuthorizeAccount(h.db, model.PermissionEdit)).Delete("/split", h.handleUnsplit)
+			// tags are personal, anyone who can see the transaction may tag it
+			r.With(middleware.AuthorizeAccount(h.db, model.PermissionView)).Get("/tags", h.handleShowTags)
+			r.With(middleware.AuthorizeAccount(h.db, model.PermissionView)).Put("/tags", h.handleSetTags)
+		})
+	})
+}
+
+// accountTransaction loads the transaction named in the url from the
+// authorized account, writing the response itself when there is none
+func accountTransaction(db *sql.DB, w http.ResponseWriter, r *http.Request) (*model.Transaction, bool) {
+	logger := middleware.GetLogger(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	transactionID, err := routeParamAsInt64(r, "transactionID")
+	if err != nil {
+		http.Error(w, "Invalid transaction ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	transaction, err := model.GetAccountTransaction(db, account.ID, transactionID)
+	if err != nil {
+		if errors.Is(err, model.ErrTransactionNotFound) {
+			TriggerErrorToast(w, "Transaction not found")
+			w.WriteHeader(http.StatusNotFound)
+			return nil, false
+		}
+		logger.WithError(err).WithFields(logrus.Fields{
+			"account_id":     account.ID,
+			"transaction_id": transactionID,
+		}).Error("failed_to_fetch_transaction")
+		TriggerErrorToast(w, "Failed to load transaction")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return transaction, true
+}
+
+func (h *TransactionHandler) handleShowSplit(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	transaction, ok := accountTransaction(h.db, w, r)
+	if !ok {
+		return
+	}
+
+	splits, err := model.GetTransactionSplits(h.db, []int64{transaction.ID})
+	if err != nil {
+		logger.WithError(err).WithField("transaction_id", transaction.ID).Error("failed_to_fetch_transaction_splits")
+		TriggerErrorToast(w, "Failed to load transaction")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	transactionView := transaction.ToView(account.Currency)
+	transactionView.Splits = splits[transaction.ID]
+
+	view(w, r, pages.SplitTransactionModal(transactionView))
+}
+
+// handleSplit replaces the transaction's split lines with the ones submitted,
+// sent as repeated category, amount and notes fields
+func (h *TransactionHandler) handleSplit(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	transaction, ok := accountTransaction(h.db, w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	categories, amounts, notes := r.Form["category"], r.Form["amount"], r.Form["notes"]
+	if len(categories) != len(amounts) || len(notes) != len(amounts) {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	fields := []string{"splits"}
+	v := validator.New()
+	lines := make([]model.TransactionSplitInput, len(amounts))
+	for i := range amounts {
+		lines[i] = model.TransactionSplitInput{
+			Category: strings.TrimSpace(categories[i]),
+			Amount:   strings.TrimSpace(amounts[i]),
+			Notes:    strings.TrimSpace(notes[i]),
+		}
+		if errs := v.Validate(lines[i]); len(errs) > 0 {
+			TriggerErrorToast(w, "Please check the form for errors")
+			view(w, r, pages.SettingsFormErrors(fields, map[string]string{
+				"splits": "Every line needs an amount, with a category of up to 50 characters",
+			}))
+			return
+		}
+	}
+
+	err := model.SplitTransaction(h.db, transaction, lines)
+	if err != nil {
+		message := ""
+		switch {
+		case errors.Is(err, model.ErrSplitTooFewLines):
+			message = "A split needs at least two lines"
+		case errors.Is(err, model.ErrSplitZeroLine):
+			message = "Every line needs an amount"
+		case errors.Is(err, model.ErrSplitUnbalanced):
+			message = "The lines must add up to " + model.FormatBalance(locale.FromContext(r.Context()), transaction.Amount, account.Currency)
+		}
+		if message != "" {
+			TriggerErrorToast(w, "Please check the form for errors")
+			view(w, r, pages.SettingsFormErrors(fields, map[string]string{"splits": message}))
+			return
+		}
+
+		logger.WithError(err).WithFields(logrus.Fields{
+			"account_id":     account.ID,
+			"transaction_id": transaction.ID,
+		}).Error("failed_to_split_transaction")
+		TriggerErrorToast(w, "Failed to split transaction")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"account_id":     account.ID,
+		"transaction_id": transaction.ID,
+		"lines":          len(lines),
+	}).Info("transaction_split_successfully")
+
+	TriggerWithToast(w, "reloadTransactions", ToastSuccess, "Transaction split!")
+	view(w, r, pages.SettingsFormErrors(fields, nil))
+}
+
+func (h *TransactionHandler) handleUnsplit(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	transaction, ok := accountTransaction(h.db, w, r)
+	if !ok {
+		return
+	}
+
+	if err := model.UnsplitTransaction(h.db, transaction.ID); err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"account_id":     account.ID,
+			"transaction_id": transaction.ID,
+		}).Error("failed_to_unsplit_transaction")
+		TriggerErrorToast(w, "Failed to remove split")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"account_id":     account.ID,
+		"transaction_id": transaction.ID,
+	}).Info("transaction_unsplit_successfully")
+
+	TriggerWithToast(w, "reloadTransactions", ToastSuccess, "Split removed")
+}
+
+func (h *TransactionHandler) handleShowTags(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	transaction, ok := accountTransaction(h.db, w, r)
+	if !ok {
+		return
+	}
+
+	tags, err := model.GetTransactionTags(h.db, userID, []int64{transaction.ID})
+	if err != nil {
+		logger.WithError(err).WithField("transaction_id", transaction.ID).Error("failed_to_fetch_transaction_tags")
+		TriggerErrorToast(w, "Failed to load transaction")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	transactionView := transaction.ToView(account.Currency)
+	transactionView.Tags = tags[transaction.ID]
+
+	view(w, r, pages.TransactionTagsModal(transactionView))
+}
+
+// handleSetTags replaces the user's tags on the transaction with the ones
+// submitted as repeated tags fields, names differing only in case are one tag.
+// The autocomplete input is named alike so a tag typed but not added yet is
+// saved too.
+func (h *TransactionHandler) handleSetTags(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+	account := middleware.GetAccount(r.Context())
+
+	transaction, ok := accountTransaction(h.db, w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	fields := []string{"tags"}
+	v := validator.New()
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, name := range r.Form["tags"] {
+		input := model.TagInput{Name: strings.TrimSpace(name)}
+		if input.Name == "" || seen[strings.ToLower(input.Name)] {
+			continue
+		}
+		if errs := v.Validate(input); len(errs) > 0 {
+			TriggerErrorToast(w, "Please check the form for errors")
+			view(w, r, pages.SettingsFormErrors(fields, map[string]string{
+				"tags": "Tags can be at most 30 characters long",
+			}))
+			return
+		}
+		seen[strings.ToLower(input.Name)] = true
+		names = append(names, input.Name)
+	}
+
+	if len(names) > model.MaxTransactionTags {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.SettingsFormErrors(fields, map[string]string{
+			"tags": fmt.Sprintf("A transaction can have at most %d tags", model.MaxTransactionTags),
+		}))
+		return
+	}
+
+	if err := model.SetTransactionTags(h.db, userID, transaction.ID, names); err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"account_id":     account.ID,
+			"transaction_id": transaction.ID,
+		}).Error("failed_to_tag_transaction")
+		TriggerErrorToast(w, "Failed to save tags")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"account_id":     account.ID,
+		"transaction_id": transaction.ID,
+		"tags":           len(names),
+	}).Info("transaction_tagged_successfully")
+
+	TriggerWithToast(w, "reloadTransactions", ToastSuccess, "Tags saved!")
+	view(w, r, pages.SettingsFormErrors(fields, nil))
+}
@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/session"
+	"numera/pkg/validator"
+	"numera/services"
+	"numera/views/pages"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// twoFactorSetupKey holds the encrypted secret between showing the QR code
+// and the user confirming their first code
+const twoFactorSetupKey = "2FA_SETUP_SECRET"
+
+type TwoFactorHandler struct {
+	db               *sql.DB
+	logger           *logrus.Logger
+	session          *session.Session
+	twoFactorService *services.TwoFactorService
+}
+
+func NewTwoFactorHandler(
+	db *sql.DB,
+	logger *logrus.Logger,
+	session *session.Session,
+	twoFactorService *services.TwoFactorService,
+) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		db:               db,
+		logger:           logger,
+		session:          session,
+		twoFactorService: twoFactorService,
+	}
+}
+
+func (h *TwoFactorHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
+
+		r.Get("/settings/2fa", h.handleShowIndex)
+		r.Post("/settings/2fa/setup", h.handleSetup)
+		r.Post("/settings/2fa/enable", h.handleEnable)
+		r.Post("/settings/2fa/disable", h.handleDisable)
+	})
+}
+
+// handleShowIndex renders the two factor settings page
+func (h *TwoFactorHandler) handleShowIndex(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	_, err := model.GetTwoFactorByUserID(h.db, userID)
+	if err != nil && !errors.Is(err, model.ErrTwoFactorNotFound) {
+		logger.WithError(err).WithField("user_id", userID).Error("two_factor_lookup_failed")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	enabled := err == nil
+
+	remaining, err := model.CountUnusedRecoveryCodes(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_count_recovery_codes")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	view(w, r, pages.TwoFactorSettings(enabled, remaining))
+}
+
+// handleSetup generates a new secret and shows it as a QR code
+func (h *TwoFactorHandler) handleSetup(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	user, err := model.GetUserByID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("user_fetch_failed")
+		TriggerErrorToast(w, "Something went wrong, please try again")
+		return
+	}
+
+	setup, err := h.twoFactorService.NewSetup(user.Email)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_create_two_factor_setup")
+		TriggerErrorToast(w, "Something went wrong, please try again")
+		return
+	}
+
+	h.session.Put(r.Context(), twoFactorSetupKey, setup.EncryptedSecret)
+	view(w, r, pages.TwoFactorSetup(setup))
+}
+
+// handleEnable confirms the first code from the authenticator app and turns
+// two factor authentication on
+func (h *TwoFactorHandler) handleEnable(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	encryptedSecret := h.session.GetString(r.Context(), twoFactorSetupKey)
+	if encryptedSecret == "" {
+		TriggerErrorToast(w, "Setup expired, please start again")
+		return
+	}
+
+	input := model.TwoFactorCodeInput{
+		Code: r.PostFormValue("code"),
+	}
+
+	v := validator.New()
+	errs := v.Validate(input)
+
+	if len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.TwoFactorFormErrors(errs))
+		return
+	}
+
+	codes, err := h.twoFactorService.Enable(userID, encryptedSecret, input.Code)
+	if err != nil {
+		if errors.Is(err, services.ErrTwoFactorCodeInvalid) {
+			errs = v.AddError(errs, "code", "Invalid code, check the time on your device")
+			TriggerErrorToast(w, "Please check the form for errors")
+			view(w, r, pages.TwoFactorFormErrors(errs))
+			return
+		}
+
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_enable_two_factor")
+		TriggerErrorToast(w, "Something went wrong, please try again")
+		return
+	}
+
+	h.session.Remove(r.Context(), twoFactorSetupKey)
+
+	TriggerSuccessToast(w, "Two-factor authentication enabled")
+	view(w, r, pages.TwoFactorRecoveryCodes(codes))
+}
+
+// handleDisable turns two factor authentication off after the user confirms
+// their password
+func (h *TwoFactorHandler) handleDisable(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	input := model.DisableTwoFactorInput{
+		Password: r.PostFormValue("password"),
+	}
+
+	v := validator.New()
+	errs := v.Validate(input)
+
+	if len(errs) > 0 {
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.TwoFactorFormErrors(errs))
+		return
+	}
+
+	ok, err := model.CheckPassword(h.db, userID, input.Password)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("password_check_failed")
+		TriggerErrorToast(w, "Something went wrong, please try again")
+		return
+	}
+
+	if !ok {
+		logger.WithField("user_id", userID).Warn("two_factor_disable_denied")
+
+		errs = v.AddError(errs, "password", "Incorrect password")
+		TriggerErrorToast(w, "Please check the form for errors")
+		view(w, r, pages.TwoFactorFormErrors(errs))
+		return
+	}
+
+	if err := h.twoFactorService.Disable(userID); err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_disable_two_factor")
+		TriggerErrorToast(w, "Something went wrong, please try again")
+		return
+	}
+
+	TriggerSuccessToast(w, "Two-factor authentication disabled")
+	view(w, r, pages.TwoFactorDisabled())
+}
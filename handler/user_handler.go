@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"numera/middleware"
 	"numera/model"
+	"numera/pkg/locale"
 	"numera/pkg/session"
 	"numera/pkg/validator"
 	"numera/services"
@@ -17,10 +18,11 @@ import (
 )
 
 type UserHandler struct {
-	db              *sql.DB
-	logger          *logrus.Logger
-	session         *session.Session
-	exchangeService *services.ExchangeService
+	db                  *sql.DB
+	logger              *logrus.Logger
+	session             *session.Session
+	exchangeService     *services.ExchangeService
+	verificationService *services.VerificationService
 }
 
 func NewUserHandler(
@@ -28,12 +30,14 @@ func NewUserHandler(
 	logger *logrus.Logger,
 	session *session.Session,
 	exchangeService *services.ExchangeService,
+	verificationService *services.VerificationService,
 ) *UserHandler {
 	return &UserHandler{
-		db:              db,
-		logger:          logger,
-		session:         session,
-		exchangeService: exchangeService,
+		db:                  db,
+		logger:              logger,
+		session:             session,
+		exchangeService:     exchangeService,
+		verificationService: verificationService,
 	}
 }
 
@@ -49,6 +53,7 @@ func (h *UserHandler) RegisterRoutes(r *chi.Mux) {
 	r.Group(func(r chi.Router) {
 		r.Use(middleware.RequireAuth(h.session))
 		r.Use(middleware.WithLogger(h.logger))
+		r.Use(middleware.RequireVerified(h.db))
 
 		r.Put("/currency-change", h.handleChangeCurrency)
 	})
@@ -86,7 +91,7 @@ func (h *UserHandler) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := model.CreateUser(h.db, input)
+	userID, err := model.CreateUser(h.db, input)
 	if err != nil {
 		logger.WithField("email", input.Email).Error("user_failed_to_create")
 
@@ -95,6 +100,13 @@ func (h *UserHandler) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	logger.WithField("email", input.Email).Info("user_successfully_created")
+
+	// a failed send isn't fatal, the user can ask for a new link after login
+	user = &model.User{ID: userID, Name: input.Name, Email: input.Email}
+	if err := h.verificationService.Send(r.Context(), user); err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_send_verification_email")
+	}
+
 	RedirectUsingHtmx(w, "/login")
 }
 
@@ -114,7 +126,7 @@ func (h *UserHandler) handleChangeCurrency(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err := model.ChangeCurrencyByUserID(h.db, userID, currency)
+	err := model.ChangeCurrencyByUserID(h.db, auditActor(r), currency)
 	if err != nil {
 		logger.WithError(err).Error("failed_to_change_currency")
 		TriggerErrorToast(w, "Failed to change currency")
@@ -152,6 +164,6 @@ func (h *UserHandler) handleChangeCurrency(w http.ResponseWriter, r *http.Reques
 	}
 
 	tmpl := template.Must(template.New("index").Parse(`<p id="currency" class="text-6xl font-light">{{.}}</p>`))
-	tmpl.Execute(w, FormatBalance(total, user.Currency))
+	tmpl.Execute(w, model.FormatBalance(locale.FromContext(r.Context()), total, user.Currency))
 	TriggerSuccessToast(w, "Currency changed successfully.")
 }
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"numera/middleware"
+	"numera/model"
+	"numera/pkg/session"
+	"numera/services"
+	"numera/views/pages"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+type VerificationHandler struct {
+	db                  *sql.DB
+	logger              *logrus.Logger
+	session             *session.Session
+	verificationService *services.VerificationService
+}
+
+func NewVerificationHandler(
+	db *sql.DB,
+	logger *logrus.Logger,
+	session *session.Session,
+	verificationService *services.VerificationService,
+) *VerificationHandler {
+	return &VerificationHandler{
+		db:                  db,
+		logger:              logger,
+		session:             session,
+		verificationService: verificationService,
+	}
+}
+
+func (h *VerificationHandler) RegisterRoutes(r *chi.Mux) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth(h.session))
+		r.Use(middleware.WithLogger(h.logger))
+
+		r.Get("/verify-email", h.handleShowVerifyEmail)
+		r.Post("/verify-email/resend", h.handleResendVerification)
+	})
+
+	// links are opened from an email client, possibly on another device, so
+	// the signature is enough and no session is needed
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.WithLogger(h.logger))
+
+		r.Get("/verify-email/confirm", h.handleConfirmEmail)
+	})
+}
+
+// handleShowVerifyEmail renders the notice shown to unverified users
+func (h *VerificationHandler) handleShowVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	user, err := model.GetUserByID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("user_fetch_failed")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if user.IsEmailVerified() {
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	view(w, r, pages.VerifyEmail(user.Email))
+}
+
+// handleResendVerification sends a new verification link, limited to one
+// email every few minutes
+func (h *VerificationHandler) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+	userID := GetUserID(r.Context())
+
+	user, err := model.GetUserByID(h.db, userID)
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("user_fetch_failed")
+		TriggerErrorToast(w, "Something went wrong, please try again")
+		return
+	}
+
+	if user.IsEmailVerified() {
+		RedirectUsingHtmx(w, "/dashboard")
+		return
+	}
+
+	if err := h.verificationService.Send(r.Context(), user); err != nil {
+		if errors.Is(err, model.ErrVerificationThrottled) {
+			logger.WithField("user_id", userID).Warn("verification_resend_throttled")
+			TriggerErrorToast(w, "Please wait a few minutes before requesting another email")
+			return
+		}
+
+		logger.WithError(err).WithField("user_id", userID).Error("failed_to_send_verification_email")
+		TriggerErrorToast(w, "Failed to send email, please try again")
+		return
+	}
+
+	TriggerSuccessToast(w, "Confirmation email sent")
+}
+
+// handleConfirmEmail checks a signed link and marks the email as verified
+func (h *VerificationHandler) handleConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	logger := middleware.GetLogger(r.Context())
+
+	userID, err := h.verificationService.Verify(r.URL.Query())
+	if err != nil {
+		expired := errors.Is(err, services.ErrVerificationLinkExpired)
+		if !expired && !errors.Is(err, services.ErrVerificationLinkInvalid) {
+			logger.WithError(err).Error("failed_to_verify_email")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.WithError(err).Warn("email_verification_rejected")
+		w.WriteHeader(http.StatusBadRequest)
+		view(w, r, pages.VerifyEmailInvalid(expired))
+		return
+	}
+
+	logger.WithField("user_id", userID).Info("email_verified")
+
+	if h.session.GetUserID(r) == 0 {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
@@ -2,8 +2,14 @@ package middleware
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"numera/model"
 	"numera/pkg/session"
+	"slices"
+	"strings"
 )
 
 // RequireAuth prevents unauthorized users from accessing protected routes.
@@ -27,6 +33,35 @@ func RequireAuth(sessionMgr *session.Session) func(http.Handler) http.Handler {
 	}
 }
 
+// RequireVerified keeps users who haven't confirmed their email address out
+// of the app, they are sent to the verification notice instead. It must run
+// after RequireAuth.
+func RequireVerified(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, _ := r.Context().Value("USER_ID").(int64)
+
+			verified, err := model.IsEmailVerified(db, userID)
+			if err != nil {
+				GetLogger(r.Context()).WithError(err).WithField("user_id", userID).Error("email_verified_lookup_failed")
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			if !verified {
+				if r.Header.Get("HX-Request") == "true" {
+					w.Header().Set("HX-Redirect", "/verify-email")
+				} else {
+					http.Redirect(w, r, "/verify-email", http.StatusSeeOther)
+				}
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // RequireGuest redirects authenticated users away from auth pages
 func RequireGuest(sessionMgr *session.Session) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -42,3 +77,81 @@ func RequireGuest(sessionMgr *session.Session) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+type scopesKey struct{}
+
+// RequireAPIAuth prevents unauthorized clients from accessing api routes,
+// answering with a json error instead of redirecting to the login page.
+// Clients can authenticate with the session cookie or with a personal access
+// token sent as "Authorization: Bearer <token>".
+func RequireAPIAuth(sessionMgr *session.Session, db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				apiToken, err := model.AuthenticateAPIToken(db, strings.TrimSpace(token))
+				if err != nil {
+					if !errors.Is(err, model.ErrAPITokenNotFound) && !errors.Is(err, model.ErrAPITokenExpired) {
+						GetLogger(r.Context()).WithError(err).Error("api_token_lookup_failed")
+					}
+					writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Invalid or expired token")
+					return
+				}
+
+				ctx := context.WithValue(r.Context(), "USER_ID", apiToken.UserID)
+				ctx = context.WithValue(ctx, scopesKey{}, apiToken.Scopes)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			userID := sessionMgr.GetUserID(r)
+
+			if userID == 0 {
+				writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Authentication required")
+				return
+			}
+
+			verified, err := model.IsEmailVerified(db, userID)
+			if err != nil {
+				GetLogger(r.Context()).WithError(err).WithField("user_id", userID).Error("email_verified_lookup_failed")
+				writeAPIError(w, http.StatusInternalServerError, "internal_error", "Something went wrong")
+				return
+			}
+			if !verified {
+				writeAPIError(w, http.StatusForbidden, "email_unverified", "Email address has not been verified")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), "USER_ID", userID)
+			ctx = context.WithValue(ctx, scopesKey{}, model.AllAPITokenScopes)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireScope rejects api requests whose token was not granted the scope.
+// Requests authenticated by session are granted every scope.
+func RequireScope(scope model.APITokenScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes, _ := r.Context().Value(scopesKey{}).([]model.APITokenScope)
+
+			if !slices.Contains(scopes, scope) {
+				writeAPIError(w, http.StatusForbidden, "insufficient_scope", "Token is missing the "+string(scope)+" scope")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]string{
+			"code":    code,
+			"message": message,
+		},
+	})
+}
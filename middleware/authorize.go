@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"numera/model"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+type accountKey struct{}
+type householdKey struct{}
+
+// AuthorizeAccount loads the account named by the {id} route param and makes
+// sure the current user's role on it grants the permission. The account is
+// put in the context for the handler, see GetAccount. It must run after
+// RequireAuth or RequireAPIAuth.
+func AuthorizeAccount(db *sql.DB, permission model.Permission) func(http.Handler) http.Handler {
+	return authorizeAccount(db, model.GetAccountForUser, permission)
+}
+
+// AuthorizeArchivedAccount is AuthorizeAccount for soft deleted accounts,
+// which only their owner can manage.
+func AuthorizeArchivedAccount(db *sql.DB) func(http.Handler) http.Handler {
+	return authorizeAccount(db, model.GetArchivedAccountForUser, model.PermissionManage)
+}
+
+func authorizeAccount(
+	db *sql.DB,
+	load func(db *sql.DB, id, userID int64) (*model.Account, error),
+	permission model.Permission,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger := GetLogger(r.Context())
+			userID, _ := r.Context().Value("USER_ID").(int64)
+
+			id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+			if err != nil {
+				reject(w, r, http.StatusBadRequest, "invalid_id", "Invalid account ID")
+				return
+			}
+
+			account, err := load(db, id, userID)
+			if err != nil {
+				if errors.Is(err, model.ErrAccountNotFound) {
+					reject(w, r, http.StatusNotFound, "not_found", "Account not found")
+					return
+				}
+				logger.WithError(err).WithField("account_id", id).Error("failed_to_fetch_account")
+				reject(w, r, http.StatusInternalServerError, "internal_error", "Failed to fetch account")
+				return
+			}
+
+			if !account.Role.Can(permission) {
+				logger.WithFields(logrus.Fields{
+					"account_id": id,
+					"user_id":    userID,
+					"permission": permission,
+				}).Warn("unauthorized_account_access_attempt")
+				reject(w, r, http.StatusForbidden, "forbidden", "You are not allowed to do this with this account")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), accountKey{}, account)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GetAccount retrieves the account loaded by AuthorizeAccount or
+// AuthorizeArchivedAccount.
+func GetAccount(ctx context.Context) *model.Account {
+	account, _ := ctx.Value(accountKey{}).(*model.Account)
+	return account
+}
+
+// AuthorizeHousehold loads the household named by the {id} route param and
+// makes sure the current user is a member whose role grants the permission.
+// Non members get a 404 so household ids don't leak. The household is put in
+// the context for the handler, see GetHousehold.
+func AuthorizeHousehold(db *sql.DB, permission model.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger := GetLogger(r.Context())
+			userID, _ := r.Context().Value("USER_ID").(int64)
+
+			id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+			if err != nil {
+				reject(w, r, http.StatusBadRequest, "invalid_id", "Invalid household ID")
+				return
+			}
+
+			household, err := model.GetHouseholdForUser(db, id, userID)
+			if err != nil {
+				if errors.Is(err, model.ErrHouseholdNotFound) {
+					reject(w, r, http.StatusNotFound, "not_found", "Household not found")
+					return
+				}
+				logger.WithError(err).WithField("household_id", id).Error("failed_to_fetch_household")
+				reject(w, r, http.StatusInternalServerError, "internal_error", "Failed to fetch household")
+				return
+			}
+
+			if !household.Role.Can(permission) {
+				logger.WithFields(logrus.Fields{
+					"household_id": id,
+					"user_id":      userID,
+					"permission":   permission,
+				}).Warn("unauthorized_household_access_attempt")
+				reject(w, r, http.StatusForbidden, "forbidden", "You are not allowed to do this in this household")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), householdKey{}, household)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GetHousehold retrieves the household loaded by AuthorizeHousehold.
+func GetHousehold(ctx context.Context) *model.Household {
+	household, _ := ctx.Value(householdKey{}).(*model.Household)
+	return household
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"database/sql"
+	"net/http"
+	"numera/model"
+	"numera/pkg/locale"
+	"numera/pkg/session"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Preferences makes the signed in user's locale and timezone available to
+// views, guests get the defaults. They are only loaded once something is
+// rendered with them. It must run after the session is loaded.
+func Preferences(sessionMgr *session.Session, db *sql.DB, log *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID := sessionMgr.GetUserID(r)
+			if userID == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			preferences := sync.OnceValue(func() locale.Preferences {
+				preferences, err := model.GetUserPreferences(db, userID)
+				if err != nil {
+					log.WithError(err).WithField("user_id", userID).Error("failed_to_load_preferences")
+				}
+				return preferences
+			})
+
+			ctx := locale.WithPreferences(r.Context(), preferences)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
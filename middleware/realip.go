@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// RealIP replaces RemoteAddr with the client address a trusted proxy
+// forwarded. Anyone can send X-Forwarded-For, so the headers are ignored
+// unless the peer is one of the proxies, and the forwarded chain is read from
+// the right, skipping further trusted hops, up to the first address a proxy
+// did not add itself.
+func RealIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	isTrusted := func(addr netip.Addr) bool {
+		return slices.ContainsFunc(trusted, func(prefix netip.Prefix) bool {
+			return prefix.Contains(addr.Unmap())
+		})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer, ok := parseAddr(r.RemoteAddr)
+			if ok && isTrusted(peer) {
+				if client, ok := forwardedFor(r, isTrusted); ok {
+					r.RemoteAddr = client.String()
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedFor finds the client in X-Forwarded-For, falling back to
+// X-Real-IP when the proxy only sets that one
+func forwardedFor(r *http.Request, isTrusted func(netip.Addr) bool) (netip.Addr, bool) {
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		if !isTrusted(addr) || i == 0 {
+			return addr, true
+		}
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+	return addr, err == nil
+}
+
+// parseAddr reads the address out of RemoteAddr, which normally carries the
+// port as well
+func parseAddr(remoteAddr string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	return addr, err == nil
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"numera/config"
+	"strconv"
+	"strings"
+
+	"github.com/a-h/templ"
+)
+
+// SecureHeaders sets the browser security headers on every response. Each
+// request gets a fresh nonce for the Content-Security-Policy, it is put in
+// the context where layouts pick it up with templ.GetNonce.
+func SecureHeaders(cfg *config.Config) func(http.Handler) http.Handler {
+	cspHeader := "Content-Security-Policy"
+	if cfg.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce := newNonce()
+
+			h := w.Header()
+			h.Set(cspHeader, contentSecurityPolicy(cfg, nonce))
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("Referrer-Policy", cfg.ReferrerPolicy)
+			h.Set("Permissions-Policy", cfg.PermissionsPolicy)
+			h.Set("Cross-Origin-Opener-Policy", "same-origin")
+
+			// older browsers ignore frame-ancestors
+			if len(cfg.FrameAncestors) == 1 && cfg.FrameAncestors[0] == "'none'" {
+				h.Set("X-Frame-Options", "DENY")
+			}
+
+			if cfg.IsProd() && cfg.HSTSMaxAge > 0 {
+				h.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(cfg.HSTSMaxAge)+"; includeSubDomains")
+			}
+
+			ctx := templ.WithNonce(r.Context(), nonce)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// contentSecurityPolicy only allows scripts from the app itself or carrying
+// the nonce. Alpine evaluates its attributes with new Function so
+// 'unsafe-eval' is needed, inline styles are allowed for htmx indicators and
+// Alpine's x-show.
+func contentSecurityPolicy(cfg *config.Config, nonce string) string {
+	directives := []string{
+		"default-src 'self'",
+		"script-src 'self' 'nonce-" + nonce + "' 'unsafe-eval'",
+		"style-src 'self' 'unsafe-inline'",
+		"img-src 'self' data:",
+		"connect-src 'self'",
+		"object-src 'none'",
+		"base-uri 'self'",
+		"form-action 'self'",
+		"frame-ancestors " + strings.Join(cfg.FrameAncestors, " "),
+	}
+
+	if cfg.CSPReportURI != "" {
+		directives = append(directives, "report-uri "+cfg.CSPReportURI)
+	}
+
+	return strings.Join(directives, "; ")
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"database/sql"
+	"net"
+	"net/http"
+	"numera/model"
+	"numera/pkg/session"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TrackSession records the device, address and last activity of signed in
+// sessions so they can be listed and signed out from the settings. It must
+// run after the session is loaded.
+func TrackSession(sessionMgr *session.Session, db *sql.DB, log *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID := sessionMgr.GetUserID(r)
+
+			if userID != 0 && sessionMgr.NeedsTracking(r) {
+				ip, _, err := net.SplitHostPort(r.RemoteAddr)
+				if err != nil {
+					ip = r.RemoteAddr
+				}
+
+				err = model.TouchUserSession(db, sessionMgr.Token(r.Context()), userID, r.UserAgent(), ip)
+				if err != nil {
+					log.WithError(err).WithField("user_id", userID).Error("failed_to_track_session")
+				} else {
+					sessionMgr.MarkTracked(r)
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE transactions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    account_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    amount REAL NOT NULL,
+    payee TEXT NOT NULL DEFAULT '',
+    category TEXT NOT NULL DEFAULT '',
+    notes TEXT NOT NULL DEFAULT '',
+    occurred_at DATETIME NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (account_id) REFERENCES accounts(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_transactions_user_id ON transactions(user_id);
+CREATE INDEX idx_transactions_account_id_occurred_at ON transactions(account_id, occurred_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_transactions_account_id_occurred_at;
+DROP INDEX IF EXISTS idx_transactions_user_id;
+DROP TABLE IF EXISTS transactions;
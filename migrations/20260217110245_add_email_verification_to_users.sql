@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN email_verified_at DATETIME;
+ALTER TABLE users ADD COLUMN verification_sent_at DATETIME;
+
+-- accounts created before verification existed are trusted
+UPDATE users SET email_verified_at = created_at;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN verification_sent_at;
+ALTER TABLE users DROP COLUMN email_verified_at;
@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE passkeys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    credential_id BLOB NOT NULL UNIQUE,
+    credential TEXT NOT NULL,
+    last_used_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_passkeys_user_id ON passkeys(user_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_passkeys_user_id;
+DROP TABLE IF EXISTS passkeys;
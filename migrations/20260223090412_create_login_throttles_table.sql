@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE login_throttles (
+    kind TEXT NOT NULL,
+    key TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at DATETIME NOT NULL,
+    locked_until DATETIME,
+    PRIMARY KEY (kind, key)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS login_throttles;
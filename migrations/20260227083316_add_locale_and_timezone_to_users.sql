@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN locale TEXT NOT NULL DEFAULT 'en-US';
+ALTER TABLE users ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC';
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN timezone;
+ALTER TABLE users DROP COLUMN locale;
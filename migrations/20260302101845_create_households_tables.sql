@@ -0,0 +1,50 @@
+-- +goose Up
+CREATE TABLE households (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE household_members (
+    household_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (household_id, user_id),
+    FOREIGN KEY (household_id) REFERENCES households(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_household_members_user_id ON household_members(user_id);
+
+CREATE TABLE household_invitations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    household_id INTEGER NOT NULL,
+    email TEXT NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    token_hash TEXT NOT NULL UNIQUE,
+    invited_by INTEGER NOT NULL,
+    expires_at DATETIME NOT NULL,
+    accepted_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (household_id) REFERENCES households(id) ON DELETE CASCADE,
+    FOREIGN KEY (invited_by) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_household_invitations_household_id ON household_invitations(household_id);
+
+-- an account shared with a household is visible to its members according to
+-- their role, accounts without one are only visible to their owner
+ALTER TABLE accounts ADD COLUMN household_id INTEGER REFERENCES households(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_accounts_household_id ON accounts(household_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_accounts_household_id;
+ALTER TABLE accounts DROP COLUMN household_id;
+DROP INDEX IF EXISTS idx_household_invitations_household_id;
+DROP TABLE IF EXISTS household_invitations;
+DROP INDEX IF EXISTS idx_household_members_user_id;
+DROP TABLE IF EXISTS household_members;
+DROP TABLE IF EXISTS households;
@@ -0,0 +1,38 @@
+-- +goose Up
+-- audit_logs is append-only, rows reference users and accounts without
+-- foreign keys so the trail outlives what it describes
+CREATE TABLE audit_logs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor_id INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    entity_type TEXT NOT NULL,
+    entity_id INTEGER NOT NULL,
+    account_id INTEGER,
+    ip_address TEXT NOT NULL DEFAULT '',
+    changes TEXT NOT NULL DEFAULT '{}',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_audit_logs_account_id ON audit_logs(account_id);
+CREATE INDEX idx_audit_logs_actor_id ON audit_logs(actor_id);
+
+-- +goose StatementBegin
+CREATE TRIGGER audit_logs_no_update BEFORE UPDATE ON audit_logs
+BEGIN
+    SELECT RAISE(ABORT, 'audit_logs is append-only');
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER audit_logs_no_delete BEFORE DELETE ON audit_logs
+BEGIN
+    SELECT RAISE(ABORT, 'audit_logs is append-only');
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER IF EXISTS audit_logs_no_delete;
+DROP TRIGGER IF EXISTS audit_logs_no_update;
+DROP INDEX IF EXISTS idx_audit_logs_actor_id;
+DROP INDEX IF EXISTS idx_audit_logs_account_id;
+DROP TABLE IF EXISTS audit_logs;
@@ -0,0 +1,33 @@
+-- +goose Up
+CREATE TABLE account_groups (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_account_groups_user_id ON account_groups(user_id);
+
+-- how each user lays out the accounts they can see, kept apart from accounts
+-- because members of a household order shared accounts independently
+CREATE TABLE account_preferences (
+    user_id INTEGER NOT NULL,
+    account_id INTEGER NOT NULL,
+    group_id INTEGER,
+    position INTEGER,
+    pinned INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, account_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (account_id) REFERENCES accounts(id) ON DELETE CASCADE,
+    FOREIGN KEY (group_id) REFERENCES account_groups(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_account_preferences_account_id ON account_preferences(account_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_account_preferences_account_id;
+DROP TABLE IF EXISTS account_preferences;
+DROP INDEX IF EXISTS idx_account_groups_user_id;
+DROP TABLE IF EXISTS account_groups;
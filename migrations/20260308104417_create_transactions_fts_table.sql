@@ -0,0 +1,41 @@
+-- +goose Up
+-- full-text index over payee and notes, an external content table kept in
+-- sync with transactions by the triggers below
+CREATE VIRTUAL TABLE transactions_fts USING fts5(
+    payee,
+    notes,
+    content = 'transactions',
+    content_rowid = 'id'
+);
+
+INSERT INTO transactions_fts (transactions_fts) VALUES ('rebuild');
+
+-- +goose StatementBegin
+CREATE TRIGGER transactions_fts_insert AFTER INSERT ON transactions
+BEGIN
+    INSERT INTO transactions_fts (rowid, payee, notes) VALUES (new.id, new.payee, new.notes);
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER transactions_fts_delete AFTER DELETE ON transactions
+BEGIN
+    INSERT INTO transactions_fts (transactions_fts, rowid, payee, notes)
+    VALUES ('delete', old.id, old.payee, old.notes);
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER transactions_fts_update AFTER UPDATE OF payee, notes ON transactions
+BEGIN
+    INSERT INTO transactions_fts (transactions_fts, rowid, payee, notes)
+    VALUES ('delete', old.id, old.payee, old.notes);
+    INSERT INTO transactions_fts (rowid, payee, notes) VALUES (new.id, new.payee, new.notes);
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER IF EXISTS transactions_fts_update;
+DROP TRIGGER IF EXISTS transactions_fts_delete;
+DROP TRIGGER IF EXISTS transactions_fts_insert;
+DROP TABLE IF EXISTS transactions_fts;
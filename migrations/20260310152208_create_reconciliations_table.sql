@@ -0,0 +1,37 @@
+-- +goose Up
+-- a reconciliation matches an account against a bank statement, the account
+-- has at most one in progress at a time
+CREATE TABLE reconciliations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    account_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    statement_date DATE NOT NULL,
+    statement_balance REAL NOT NULL,
+    adjustment REAL NOT NULL DEFAULT 0,
+    completed_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (account_id) REFERENCES accounts(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX idx_reconciliations_open ON reconciliations(account_id) WHERE completed_at IS NULL;
+
+ALTER TABLE transactions ADD COLUMN cleared INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN reconciliation_id INTEGER;
+
+-- reconciled transactions are locked, their amount and date already agree
+-- with a statement
+-- +goose StatementBegin
+CREATE TRIGGER transactions_reconciled_locked BEFORE UPDATE OF account_id, amount, occurred_at, cleared ON transactions
+WHEN old.reconciliation_id IS NOT NULL
+BEGIN
+    SELECT RAISE(ABORT, 'transaction is reconciled');
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER IF EXISTS transactions_reconciled_locked;
+ALTER TABLE transactions DROP COLUMN reconciliation_id;
+ALTER TABLE transactions DROP COLUMN cleared;
+DROP INDEX IF EXISTS idx_reconciliations_open;
+DROP TABLE IF EXISTS reconciliations;
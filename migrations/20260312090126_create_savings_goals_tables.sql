@@ -0,0 +1,37 @@
+-- +goose Up
+CREATE TABLE savings_goals (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    target_amount REAL NOT NULL,
+    currency TEXT NOT NULL,
+    target_date DATE NOT NULL,
+    -- progress follows the linked account's balance when set, the goal's
+    -- contributions otherwise
+    account_id INTEGER,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (account_id) REFERENCES accounts(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_savings_goals_user_id ON savings_goals(user_id);
+
+-- money earmarked for a goal without moving it to a dedicated account
+CREATE TABLE savings_goal_contributions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    goal_id INTEGER NOT NULL,
+    amount REAL NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    contributed_at DATE NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (goal_id) REFERENCES savings_goals(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_savings_goal_contributions_goal_id ON savings_goal_contributions(goal_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_savings_goal_contributions_goal_id;
+DROP TABLE IF EXISTS savings_goal_contributions;
+DROP INDEX IF EXISTS idx_savings_goals_user_id;
+DROP TABLE IF EXISTS savings_goals;
@@ -0,0 +1,34 @@
+-- +goose Up
+-- a split spreads one transaction over several categories, its lines add up
+-- to the transaction's amount
+CREATE TABLE transaction_splits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    transaction_id INTEGER NOT NULL,
+    category TEXT NOT NULL DEFAULT '',
+    amount REAL NOT NULL,
+    notes TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (transaction_id) REFERENCES transactions(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_transaction_splits_transaction_id ON transaction_splits(transaction_id);
+
+-- transaction_lines has a row per category line: the split lines of split
+-- transactions and every other transaction as a whole. Anything totalling by
+-- category reads it instead of transactions.
+CREATE VIEW transaction_lines AS
+SELECT
+    t.id AS transaction_id, t.account_id, t.user_id, t.occurred_at,
+    t.category, t.amount, t.notes
+FROM transactions t
+WHERE NOT EXISTS (SELECT 1 FROM transaction_splits s WHERE s.transaction_id = t.id)
+UNION ALL
+SELECT
+    t.id, t.account_id, t.user_id, t.occurred_at,
+    s.category, s.amount, s.notes
+FROM transaction_splits s
+JOIN transactions t ON t.id = s.transaction_id;
+
+-- +goose Down
+DROP VIEW IF EXISTS transaction_lines;
+DROP INDEX IF EXISTS idx_transaction_splits_transaction_id;
+DROP TABLE IF EXISTS transaction_splits;
@@ -0,0 +1,27 @@
+-- +goose Up
+-- tags are the user's own labels, several of them can be put on a transaction
+CREATE TABLE tags (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX idx_tags_user_id_name ON tags(user_id, name COLLATE NOCASE);
+
+CREATE TABLE transaction_tags (
+    transaction_id INTEGER NOT NULL,
+    tag_id INTEGER NOT NULL,
+    PRIMARY KEY (transaction_id, tag_id),
+    FOREIGN KEY (transaction_id) REFERENCES transactions(id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_transaction_tags_tag_id ON transaction_tags(tag_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_transaction_tags_tag_id;
+DROP TABLE IF EXISTS transaction_tags;
+DROP INDEX IF EXISTS idx_tags_user_id_name;
+DROP TABLE IF EXISTS tags;
@@ -0,0 +1,28 @@
+-- +goose Up
+-- attachments are receipts and documents kept on an account or on one of its
+-- transactions, the files themselves live in storage under storage_key
+CREATE TABLE attachments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    account_id INTEGER NOT NULL,
+    transaction_id INTEGER,
+    filename TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    storage_key TEXT NOT NULL,
+    thumbnail_key TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (account_id) REFERENCES accounts(id) ON DELETE CASCADE,
+    FOREIGN KEY (transaction_id) REFERENCES transactions(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_attachments_account_id ON attachments(account_id, transaction_id);
+CREATE INDEX idx_attachments_transaction_id ON attachments(transaction_id);
+CREATE INDEX idx_attachments_user_id ON attachments(user_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_attachments_user_id;
+DROP INDEX IF EXISTS idx_attachments_transaction_id;
+DROP INDEX IF EXISTS idx_attachments_account_id;
+DROP TABLE IF EXISTS attachments;
@@ -0,0 +1,63 @@
+-- +goose Up
+-- payees are the canonical names behind the raw descriptions banks put on
+-- transactions, transactions keep the raw text in payee and link the payee
+CREATE TABLE payees (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    default_category TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX idx_payees_user_id_name ON payees(user_id, name COLLATE NOCASE);
+
+-- an alias maps raw descriptions to a payee, pattern is an uppercased glob
+-- matched against the uppercased description
+CREATE TABLE payee_aliases (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    payee_id INTEGER NOT NULL,
+    pattern TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (payee_id) REFERENCES payees(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX idx_payee_aliases_payee_id_pattern ON payee_aliases(payee_id, pattern);
+
+ALTER TABLE transactions ADD COLUMN payee_id INTEGER REFERENCES payees(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_transactions_payee_id ON transactions(payee_id);
+
+-- new transactions are matched against the aliases of the account owner's
+-- payees, the longest pattern being the most specific, and take the payee's
+-- default category when they come without one
+-- +goose StatementBegin
+CREATE TRIGGER transactions_match_payee AFTER INSERT ON transactions
+WHEN new.payee_id IS NULL
+BEGIN
+    UPDATE transactions SET payee_id = (
+        SELECT pa.payee_id
+        FROM payee_aliases pa
+        JOIN payees p ON p.id = pa.payee_id
+        JOIN accounts a ON a.user_id = p.user_id
+        WHERE a.id = new.account_id AND UPPER(new.payee) GLOB pa.pattern
+        ORDER BY LENGTH(pa.pattern) DESC, pa.id
+        LIMIT 1
+    )
+    WHERE id = new.id;
+
+    UPDATE transactions SET category = (
+        SELECT default_category FROM payees WHERE id = transactions.payee_id
+    )
+    WHERE id = new.id AND category = '' AND payee_id IS NOT NULL;
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER IF EXISTS transactions_match_payee;
+DROP INDEX IF EXISTS idx_transactions_payee_id;
+ALTER TABLE transactions DROP COLUMN payee_id;
+DROP INDEX IF EXISTS idx_payee_aliases_payee_id_pattern;
+DROP TABLE IF EXISTS payee_aliases;
+DROP INDEX IF EXISTS idx_payees_user_id_name;
+DROP TABLE IF EXISTS payees;
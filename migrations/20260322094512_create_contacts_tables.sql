@@ -0,0 +1,46 @@
+-- +goose Up
+-- contacts are the people the user lends money to or borrows it from, they
+-- are only known to the user
+CREATE TABLE contacts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX idx_contacts_user_id_name ON contacts(user_id, name COLLATE NOCASE);
+
+-- an iou is money lent to the contact or borrowed from them, amount is always
+-- positive and direction says who owes whom. Settling one can record the
+-- payment as a transaction on one of the user's accounts.
+CREATE TABLE ious (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    contact_id INTEGER NOT NULL,
+    direction TEXT NOT NULL CHECK (direction IN ('lent', 'borrowed')),
+    amount REAL NOT NULL,
+    currency TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    due_date DATE,
+    settled_at DATE,
+    transaction_id INTEGER,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (contact_id) REFERENCES contacts(id) ON DELETE CASCADE,
+    FOREIGN KEY (transaction_id) REFERENCES transactions(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_ious_contact_id ON ious(contact_id);
+CREATE INDEX idx_ious_user_id ON ious(user_id);
+CREATE INDEX idx_ious_transaction_id ON ious(transaction_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_ious_transaction_id;
+DROP INDEX IF EXISTS idx_ious_user_id;
+DROP INDEX IF EXISTS idx_ious_contact_id;
+DROP TABLE IF EXISTS ious;
+DROP INDEX IF EXISTS idx_contacts_user_id_name;
+DROP TABLE IF EXISTS contacts;
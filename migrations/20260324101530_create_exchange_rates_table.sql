@@ -0,0 +1,17 @@
+-- +goose Up
+-- exchange rates as they were fetched, one per currency pair and day, so
+-- exports can value past transactions at the rates of their time
+CREATE TABLE exchange_rates (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    from_currency TEXT NOT NULL,
+    to_currency TEXT NOT NULL,
+    rate REAL NOT NULL,
+    rate_date DATE NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_exchange_rates_pair_date ON exchange_rates(from_currency, to_currency, rate_date);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_exchange_rates_pair_date;
+DROP TABLE IF EXISTS exchange_rates;
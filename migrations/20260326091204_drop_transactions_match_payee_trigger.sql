@@ -0,0 +1,26 @@
+-- +goose Up
+-- new transactions are matched against payee aliases where they are inserted
+DROP TRIGGER IF EXISTS transactions_match_payee;
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE TRIGGER transactions_match_payee AFTER INSERT ON transactions
+WHEN new.payee_id IS NULL
+BEGIN
+    UPDATE transactions SET payee_id = (
+        SELECT pa.payee_id
+        FROM payee_aliases pa
+        JOIN payees p ON p.id = pa.payee_id
+        JOIN accounts a ON a.user_id = p.user_id
+        WHERE a.id = new.account_id AND UPPER(new.payee) GLOB pa.pattern
+        ORDER BY LENGTH(pa.pattern) DESC, pa.id
+        LIMIT 1
+    )
+    WHERE id = new.id;
+
+    UPDATE transactions SET category = (
+        SELECT default_category FROM payees WHERE id = transactions.payee_id
+    )
+    WHERE id = new.id AND category = '' AND payee_id IS NOT NULL;
+END;
+-- +goose StatementEnd
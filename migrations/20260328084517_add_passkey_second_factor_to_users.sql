@@ -0,0 +1,7 @@
+-- +goose Up
+-- passkeys are only asked for after the password when the user opts in, so
+-- losing the device never locks them out of their password login
+ALTER TABLE users ADD COLUMN passkey_second_factor BOOLEAN NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN passkey_second_factor;
@@ -3,6 +3,7 @@ package model
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -10,6 +11,10 @@ import (
 
 var (
 	ErrAccountNotFound = errors.New("account not found")
+	ErrAccountInactive = errors.New("account is inactive")
+	// ErrAccountForbidden is returned when the account exists but the user is
+	// neither its owner nor a member of the household it is shared with
+	ErrAccountForbidden = errors.New("account is not accessible")
 )
 
 type AccountType string
@@ -30,19 +35,39 @@ type Account struct {
 	AllowsNegativeBalance bool            `db:"allows_negative_balance"`
 	IsActive              int             `db:"is_active"`
 	UserID                int64           `db:"user_id"`
-	CreatedAt             time.Time       `db:"created_at"`
-	UpdatedAt             time.Time       `db:"updated_at"`
+	HouseholdID           sql.NullInt64   `db:"household_id"`
+	// Role, Pinned and GroupID describe the account for the user it was
+	// loaded for, they are only set by the queries that take a user
+	Role      Role          `db:"role"`
+	Pinned    bool          `db:"pinned"`
+	GroupID   sql.NullInt64 `db:"group_id"`
+	CreatedAt time.Time     `db:"created_at"`
+	UpdatedAt time.Time     `db:"updated_at"`
 }
 
 type AccountView struct {
-	ID                    int64           `db:"id"`
-	Name                  string          `db:"name"`
-	AccountType           AccountType     `db:"account_type"`
-	Balance               decimal.Decimal `db:"balance"`
-	Color                 string          `db:"color"`
-	Currency              Currency        `db:"currency"`
-	AllowsNegativeBalance bool            `db:"allows_negative_balance"`
-	IsActive              int             `db:"is_active"`
+	ID                    int64           `db:"id" json:"id"`
+	Name                  string          `db:"name" json:"name"`
+	AccountType           AccountType     `db:"account_type" json:"account_type"`
+	Balance               decimal.Decimal `db:"balance" json:"balance"`
+	Color                 string          `db:"color" json:"color"`
+	Currency              Currency        `db:"currency" json:"currency"`
+	AllowsNegativeBalance bool            `db:"allows_negative_balance" json:"allows_negative_balance"`
+	IsActive              int             `db:"is_active" json:"is_active"`
+	HouseholdID           int64           `db:"household_id" json:"household_id,omitempty"`
+	Role                  Role            `db:"role" json:"role,omitempty"`
+	Pinned                bool            `db:"pinned" json:"pinned"`
+	GroupID               int64           `db:"group_id" json:"group_id,omitempty"`
+}
+
+// IsShared reports whether the account is shared with a household
+func (av *AccountView) IsShared() bool {
+	return av.HouseholdID != 0
+}
+
+// Can reports whether the viewer's role on the account grants the permission
+func (av *AccountView) Can(p Permission) bool {
+	return av.Role.Can(p)
 }
 
 func (av *AccountView) GetColorClass() string {
@@ -72,10 +97,6 @@ func (av *AccountView) GetColorClass() string {
 	}
 }
 
-func (av *AccountView) GetBalanceWithCurrency() string {
-	return FormatBalance(av.Balance, av.Currency)
-}
-
 func (a *Account) ToView() AccountView {
 	return AccountView{
 		ID:                    a.ID,
@@ -86,6 +107,10 @@ func (a *Account) ToView() AccountView {
 		Currency:              a.Currency,
 		AllowsNegativeBalance: a.AllowsNegativeBalance,
 		IsActive:              a.IsActive,
+		HouseholdID:           a.HouseholdID.Int64,
+		Role:                  a.Role,
+		Pinned:                a.Pinned,
+		GroupID:               a.GroupID.Int64,
 	}
 }
 
@@ -93,17 +118,110 @@ func (a *Account) IsOwnedByUserID(userID int64) bool {
 	return a.UserID == userID
 }
 
+// accountAccessSelect selects accounts together with the role the user has on
+// them. Owners get RoleOwner, household members get their household role
+// except household owners, who may edit but not delete accounts they do not
+// own. The user's layout preferences come along. It takes the user id three
+// times, the role is NULL for everyone else.
+const accountAccessSelect = `
+	SELECT
+		a.id, a.name, a.account_type, a.balance, a.color, a.currency,
+		a.allows_negative_balance, a.is_active, a.user_id, a.household_id,
+		CASE
+			WHEN a.user_id = ? THEN 'owner'
+			WHEN m.role = 'owner' THEN 'editor'
+			ELSE m.role
+		END,
+		COALESCE(p.pinned, 0), p.group_id,
+		a.created_at, a.updated_at
+	FROM accounts a
+	LEFT JOIN household_members m ON m.household_id = a.household_id AND m.user_id = ?
+	LEFT JOIN account_preferences p ON p.account_id = a.id AND p.user_id = ?
+`
+
+// visibleAccountIDs selects the ids of every account the user owns, archived
+// ones included, and of the active accounts shared with them through a
+// household. It takes the user id twice.
+const visibleAccountIDs = `
+	SELECT a.id FROM accounts a
+	LEFT JOIN household_members m ON m.household_id = a.household_id AND m.user_id = ?
+	WHERE a.user_id = ? OR (a.is_active = 1 AND m.user_id IS NOT NULL)
+`
+
+// accountLayoutOrder orders accounts the way the user laid them out, pinned
+// ones first and accounts they never moved ahead of the rest, newest first
+const accountLayoutOrder = `
+	ORDER BY COALESCE(p.pinned, 0) DESC, p.position IS NOT NULL, p.position, a.created_at DESC, a.id DESC
+`
+
+func scanAccountWithRole(scanner interface{ Scan(...any) error }) (Account, error) {
+	var account Account
+	var role sql.NullString
+	err := scanner.Scan(
+		&account.ID,
+		&account.Name,
+		&account.AccountType,
+		&account.Balance,
+		&account.Color,
+		&account.Currency,
+		&account.AllowsNegativeBalance,
+		&account.IsActive,
+		&account.UserID,
+		&account.HouseholdID,
+		&role,
+		&account.Pinned,
+		&account.GroupID,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	)
+	account.Role = Role(role.String)
+	return account, err
+}
+
+// GetAccountForUser gets an active account along with the role the user has
+// on it. Accounts the user has no role on and archived ones fail with
+// ErrAccountNotFound like missing ones, so ids of other users' accounts
+// can't be told apart from unused ones.
+func GetAccountForUser(db *sql.DB, id, userID int64) (*Account, error) {
+	account, err := scanAccountWithRole(db.QueryRow(
+		accountAccessSelect+` WHERE a.id = ? LIMIT 1`,
+		userID,
+		userID,
+		userID,
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAccountNotFound
+		}
+		return nil, err
+	}
+	if account.Role == "" || account.IsActive == 0 {
+		return nil, ErrAccountNotFound
+	}
+
+	return &account, nil
+}
+
 // GetAccountByID gets an account using id
 func GetAccountByID(db *sql.DB, id int64) (*Account, error) {
+	return queryAccountByID(db, id)
+}
+
+// queryAccountByID loads an active account through either the database or a
+// transaction
+func queryAccountByID(q interface {
+	QueryRow(query string, args ...any) *sql.Row
+}, id int64) (*Account, error) {
 	query := `
 		SELECT
 			id, name, account_type, balance, color, currency,
-			allows_negative_balance, is_active, user_id,
+			allows_negative_balance, is_active, user_id, household_id,
 			created_at, updated_at
 		FROM accounts WHERE id = ? LIMIT 1
 	`
 	var account Account
-	err := db.QueryRow(query, id).Scan(
+	err := q.QueryRow(query, id).Scan(
 		&account.ID,
 		&account.Name,
 		&account.AccountType,
@@ -113,6 +231,7 @@ func GetAccountByID(db *sql.DB, id int64) (*Account, error) {
 		&account.AllowsNegativeBalance,
 		&account.IsActive,
 		&account.UserID,
+		&account.HouseholdID,
 		&account.CreatedAt,
 		&account.UpdatedAt,
 	)
@@ -123,24 +242,38 @@ func GetAccountByID(db *sql.DB, id int64) (*Account, error) {
 		return nil, err
 	}
 	if account.IsActive == 0 {
-		return nil, errors.New("account is inactive")
+		return nil, ErrAccountInactive
 	}
 
 	return &account, nil
 }
 
-// GetAccountsByUserID gets all active accounts for a user
+// auditState is the part of the account recorded in the audit trail
+func (a *Account) auditState() map[string]any {
+	var householdID any
+	if a.HouseholdID.Valid {
+		householdID = a.HouseholdID.Int64
+	}
+
+	return map[string]any{
+		"name":                    a.Name,
+		"account_type":            a.AccountType,
+		"balance":                 a.Balance,
+		"color":                   a.Color,
+		"currency":                a.Currency,
+		"allows_negative_balance": a.AllowsNegativeBalance,
+		"is_active":               a.IsActive,
+		"household_id":            householdID,
+	}
+}
+
+// GetAccountsByUserID gets all active accounts a user owns or can see through
+// a household, in the order the user arranged them
 func GetAccounstByID(db *sql.DB, userID int64) ([]Account, error) {
-	query := `
-		SELECT
-			id, name, account_type, balance, color, currency,
-			allows_negative_balance, is_active, user_id,
-			created_at, updated_at
-		FROM accounts
-		WHERE user_id = ? AND is_active = 1
-		ORDER BY created_at DESC
-	`
-	rows, err := db.Query(query, userID)
+	query := accountAccessSelect + `
+		WHERE a.is_active = 1 AND (a.user_id = ? OR m.user_id IS NOT NULL)
+	` + accountLayoutOrder
+	rows, err := db.Query(query, userID, userID, userID, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -148,20 +281,7 @@ func GetAccounstByID(db *sql.DB, userID int64) ([]Account, error) {
 
 	var accounts []Account
 	for rows.Next() {
-		var account Account
-		err = rows.Scan(
-			&account.ID,
-			&account.Name,
-			&account.AccountType,
-			&account.Balance,
-			&account.Color,
-			&account.Currency,
-			&account.AllowsNegativeBalance,
-			&account.IsActive,
-			&account.UserID,
-			&account.CreatedAt,
-			&account.UpdatedAt,
-		)
+		account, err := scanAccountWithRole(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -175,33 +295,89 @@ func GetAccounstByID(db *sql.DB, userID int64) ([]Account, error) {
 	return accounts, nil
 }
 
+// GetAccountsPageByUserID gets a single page of active accounts a user owns
+// or can see through a household, along with their total number
+func GetAccountsPageByUserID(db *sql.DB, userID int64, limit, offset int) ([]Account, int, error) {
+	var total int
+	err := db.QueryRow(
+		`SELECT COUNT(*) FROM accounts a
+		LEFT JOIN household_members m ON m.household_id = a.household_id AND m.user_id = ?
+		WHERE a.is_active = 1 AND (a.user_id = ? OR m.user_id IS NOT NULL)`,
+		userID,
+		userID,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := accountAccessSelect + `
+		WHERE a.is_active = 1 AND (a.user_id = ? OR m.user_id IS NOT NULL)
+		ORDER BY a.created_at DESC, a.id DESC
+		LIMIT ? OFFSET ?
+	`
+	rows, err := db.Query(query, userID, userID, userID, userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	accounts := []Account{}
+	for rows.Next() {
+		account, err := scanAccountWithRole(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return accounts, total, nil
+}
+
 type CreateAccountInput struct {
-	Name                  string          `form:"name" validate:"required,min=1,max=100"`
-	AccountType           AccountType     `form:"account_type" validate:"required,oneof=checking savings cash"`
-	Balance               decimal.Decimal `form:"balance"`
-	Color                 string          `form:"color" validate:"required"`
-	Currency              Currency        `form:"currency" validate:"required,oneof=EUR USD RSD GBP JPY CHF"`
-	AllowsNegativeBalance bool            `form:"allows_negative_balance"`
+	Name                  string          `form:"name" json:"name" validate:"required,min=1,max=100"`
+	AccountType           AccountType     `form:"account_type" json:"account_type" validate:"required,oneof=checking savings cash"`
+	Balance               decimal.Decimal `form:"balance" json:"balance"`
+	Color                 string          `form:"color" json:"color" validate:"required"`
+	Currency              Currency        `form:"currency" json:"currency" validate:"required,oneof=EUR USD RSD GBP JPY CHF"`
+	AllowsNegativeBalance bool            `form:"allows_negative_balance" json:"allows_negative_balance"`
+	// HouseholdID shares the account with a household, zero keeps it private
+	HouseholdID int64 `form:"household_id" json:"household_id" validate:"min=0"`
 }
 
 type UpdateAccountInput struct {
-	Name                  string      `form:"name" validate:"required,min=1,max=100"`
-	AccountType           AccountType `form:"account_type" validate:"required,oneof=checking savings cash"`
-	Color                 string      `form:"color" validate:"required"`
-	Currency              Currency    `form:"currency" validate:"required,oneof=EUR USD RSD GBP JPY CHF"`
-	AllowsNegativeBalance bool        `form:"allows_negative_balance"`
-	IsActive              int         `form:"is_active" validate:"oneof=0 1"`
+	Name                  string      `form:"name" json:"name" validate:"required,min=1,max=100"`
+	AccountType           AccountType `form:"account_type" json:"account_type" validate:"required,oneof=checking savings cash"`
+	Color                 string      `form:"color" json:"color" validate:"required"`
+	Currency              Currency    `form:"currency" json:"currency" validate:"required,oneof=EUR USD RSD GBP JPY CHF"`
+	AllowsNegativeBalance bool        `form:"allows_negative_balance" json:"allows_negative_balance"`
+	IsActive              int         `form:"is_active" json:"-" validate:"oneof=0 1"`
+	HouseholdID           int64       `form:"household_id" json:"household_id" validate:"min=0"`
+}
+
+type PurgeAccountInput struct {
+	Confirmation string `form:"confirmation" validate:"required"`
 }
 
-// CreateAccount creates a new account for a user
-func CreateAccount(db *sql.DB, userID int64, input CreateAccountInput) (int64, error) {
+// CreateAccount creates a new account owned by the actor
+func CreateAccount(db *sql.DB, actor Actor, input CreateAccountInput) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	query := `
 		INSERT INTO accounts(
-			name, account_type, balance, color, currency, allows_negative_balance, user_id
+			name, account_type, balance, color, currency, allows_negative_balance, user_id,
+			household_id
 		) VALUES
-			(?, ?, ?, ?, ?, ?, ?)
+			(?, ?, ?, ?, ?, ?, ?, NULLIF(?, 0))
 	`
-	result, err := db.Exec(
+	result, err := tx.Exec(
 		query,
 		input.Name,
 		input.AccountType,
@@ -209,17 +385,50 @@ func CreateAccount(db *sql.DB, userID int64, input CreateAccountInput) (int64, e
 		input.Color,
 		input.Currency,
 		input.AllowsNegativeBalance,
-		userID,
+		actor.UserID,
+		input.HouseholdID,
 	)
 	if err != nil {
 		return 0, err
 	}
 
-	return result.LastInsertId()
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	account, err := queryAccountByID(tx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	err = recordAudit(tx, actor, auditEntry{
+		Action:     AuditAccountCreated,
+		EntityType: AuditEntityAccount,
+		EntityID:   id,
+		AccountID:  id,
+		Changes:    diffAudit(nil, account.auditState()),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
 }
 
 // UpdateAccount updates an existing account
-func UpdateAccount(db *sql.DB, id int64, input UpdateAccountInput) error {
+func UpdateAccount(db *sql.DB, actor Actor, id int64, input UpdateAccountInput) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := queryAccountByID(tx, id)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE accounts
 		SET
@@ -229,10 +438,11 @@ func UpdateAccount(db *sql.DB, id int64, input UpdateAccountInput) error {
 			currency = ?,
 			allows_negative_balance = ?,
 			is_active = ?,
+			household_id = NULLIF(?, 0),
 			updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`
-	result, err := db.Exec(
+	_, err = tx.Exec(
 		query,
 		input.Name,
 		input.AccountType,
@@ -240,35 +450,230 @@ func UpdateAccount(db *sql.DB, id int64, input UpdateAccountInput) error {
 		input.Currency,
 		input.AllowsNegativeBalance,
 		input.IsActive,
+		input.HouseholdID,
 		id,
 	)
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
+	afterState := before.auditState()
+	after, err := queryAccountByID(tx, id)
+	switch {
+	case err == nil:
+		afterState = after.auditState()
+	case errors.Is(err, ErrAccountInactive):
+		afterState["is_active"] = 0
+	default:
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return ErrAccountNotFound
+	changes := diffAudit(before.auditState(), afterState)
+	if len(changes) > 0 {
+		err = recordAudit(tx, actor, auditEntry{
+			Action:     AuditAccountUpdated,
+			EntityType: AuditEntityAccount,
+			EntityID:   id,
+			AccountID:  id,
+			Changes:    changes,
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 // DeleteAccount soft deletes an account
-func DeleteAccount(db *sql.DB, id int64) error {
+func DeleteAccount(db *sql.DB, actor Actor, id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := queryAccountByID(tx, id)
+	if err != nil {
+		if errors.Is(err, ErrAccountInactive) {
+			return ErrAccountNotFound
+		}
+		return err
+	}
+
 	query := `
 		UPDATE accounts
 		SET is_active = 0, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`
-	result, err := db.Exec(query, id)
+	if _, err := tx.Exec(query, id); err != nil {
+		return err
+	}
+
+	afterState := before.auditState()
+	afterState["is_active"] = 0
+
+	err = recordAudit(tx, actor, auditEntry{
+		Action:     AuditAccountDeleted,
+		EntityType: AuditEntityAccount,
+		EntityID:   id,
+		AccountID:  id,
+		Changes:    diffAudit(before.auditState(), afterState),
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetArchivedAccountsByUserID gets the soft deleted accounts of a user, most
+// recently archived first
+func GetArchivedAccountsByUserID(db *sql.DB, userID int64) ([]Account, error) {
+	query := accountAccessSelect + `
+		WHERE a.is_active = 0 AND a.user_id = ?
+		ORDER BY a.updated_at DESC, a.id DESC
+	`
+	rows, err := db.Query(query, userID, userID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []Account
+	for rows.Next() {
+		account, err := scanAccountWithRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// GetArchivedAccountForUser gets a soft deleted account, only its owner can
+// see it once archived so everyone else gets ErrAccountNotFound
+func GetArchivedAccountForUser(db *sql.DB, id, userID int64) (*Account, error) {
+	account, err := scanAccountWithRole(db.QueryRow(
+		accountAccessSelect+` WHERE a.id = ? LIMIT 1`,
+		userID,
+		userID,
+		userID,
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAccountNotFound
+		}
+		return nil, err
+	}
+	if account.Role != RoleOwner || account.IsActive != 0 {
+		return nil, ErrAccountNotFound
+	}
+
+	return &account, nil
+}
+
+// RestoreAccount brings a soft deleted account back
+func RestoreAccount(db *sql.DB, actor Actor, id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`UPDATE accounts SET is_active = 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND is_active = 0`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrAccountNotFound
+	}
+
+	err = recordAudit(tx, actor, auditEntry{
+		Action:     AuditAccountRestored,
+		EntityType: AuditEntityAccount,
+		EntityID:   id,
+		AccountID:  id,
+		Changes:    AuditChanges{"is_active": {Before: 0, After: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PurgeAccount permanently removes a soft deleted account along with
+// everything that belongs to it, only the audit trail is kept
+func PurgeAccount(db *sql.DB, actor Actor, account *Account) error {
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	// foreign keys aren't enforced on the connection so dependent rows are
+	// removed explicitly, children first
+	if _, err := tx.Exec(
+		`DELETE FROM transaction_splits WHERE transaction_id IN (SELECT id FROM transactions WHERE account_id = ?)`,
+		account.ID,
+	); err != nil {
+		return fmt.Errorf("failed to delete transaction splits: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		`DELETE FROM transaction_tags WHERE transaction_id IN (SELECT id FROM transactions WHERE account_id = ?)`,
+		account.ID,
+	); err != nil {
+		return fmt.Errorf("failed to delete transaction tags: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM attachments WHERE account_id = ?`, account.ID); err != nil {
+		return fmt.Errorf("failed to delete attachments: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE ious SET transaction_id = NULL WHERE transaction_id IN (SELECT id FROM transactions WHERE account_id = ?)`,
+		account.ID,
+	); err != nil {
+		return fmt.Errorf("failed to unlink ious: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM transactions WHERE account_id = ?`, account.ID); err != nil {
+		return fmt.Errorf("failed to delete transactions: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM reconciliations WHERE account_id = ?`, account.ID); err != nil {
+		return fmt.Errorf("failed to delete reconciliations: %w", err)
+	}
+
+	if _, err := tx.Exec(`UPDATE savings_goals SET account_id = NULL WHERE account_id = ?`, account.ID); err != nil {
+		return fmt.Errorf("failed to unlink savings goals: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM account_preferences WHERE account_id = ?`, account.ID); err != nil {
+		return fmt.Errorf("failed to delete account preferences: %w", err)
+	}
+
+	result, err := tx.Exec(`DELETE FROM accounts WHERE id = ? AND is_active = 0`, account.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete account: %w", err)
+	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
@@ -279,5 +684,16 @@ func DeleteAccount(db *sql.DB, id int64) error {
 		return ErrAccountNotFound
 	}
 
-	return nil
+	err = recordAudit(tx, actor, auditEntry{
+		Action:     AuditAccountPurged,
+		EntityType: AuditEntityAccount,
+		EntityID:   account.ID,
+		AccountID:  account.ID,
+		Changes:    diffAudit(account.auditState(), nil),
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
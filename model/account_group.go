@@ -0,0 +1,291 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var ErrAccountGroupNotFound = errors.New("account group not found")
+
+// AccountGroup is a named row of accounts on the user's dashboard, groups are
+// personal so members of a household can file shared accounts differently
+type AccountGroup struct {
+	ID        int64     `db:"id"`
+	UserID    int64     `db:"user_id"`
+	Name      string    `db:"name"`
+	Position  int       `db:"position"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type AccountGroupInput struct {
+	Name string `form:"name" validate:"required,min=1,max=50"`
+}
+
+// AccountGroupView is a group together with the accounts filed under it, the
+// accounts outside of any group are collected under the zero ID
+type AccountGroupView struct {
+	ID       int64
+	Name     string
+	Accounts []AccountView
+	// Subtotal is the sum of the accounts in Currency, it is left unset when
+	// a balance could not be converted
+	Subtotal    decimal.Decimal
+	Currency    Currency
+	HasSubtotal bool
+}
+
+// BalancesByCurrency sums the balances of the group's accounts per currency
+func (gv *AccountGroupView) BalancesByCurrency() map[Currency]decimal.Decimal {
+	balances := make(map[Currency]decimal.Decimal)
+	for _, account := range gv.Accounts {
+		balances[account.Currency] = balances[account.Currency].Add(account.Balance)
+	}
+	return balances
+}
+
+// GroupAccounts files the accounts under the user's groups, keeping their
+// order. Ungrouped accounts come first, empty groups are kept so accounts can
+// be dropped into them.
+func GroupAccounts(groups []AccountGroup, accounts []Account) []AccountGroupView {
+	views := make([]AccountGroupView, 0, len(groups)+1)
+	views = append(views, AccountGroupView{})
+
+	index := make(map[int64]int, len(groups))
+	for _, group := range groups {
+		index[group.ID] = len(views)
+		views = append(views, AccountGroupView{ID: group.ID, Name: group.Name})
+	}
+
+	for _, account := range accounts {
+		i, ok := index[account.GroupID.Int64]
+		if !ok {
+			i = 0
+		}
+		views[i].Accounts = append(views[i].Accounts, account.ToView())
+	}
+
+	return views
+}
+
+// GetAccountGroupsByUserID gets the user's groups in the order they arranged
+// them
+func GetAccountGroupsByUserID(db *sql.DB, userID int64) ([]AccountGroup, error) {
+	rows, err := db.Query(
+		`SELECT id, user_id, name, position, created_at FROM account_groups
+		WHERE user_id = ? ORDER BY position, id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []AccountGroup
+	for rows.Next() {
+		var group AccountGroup
+		err := rows.Scan(&group.ID, &group.UserID, &group.Name, &group.Position, &group.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// CreateAccountGroup adds a group after the user's existing ones
+func CreateAccountGroup(db *sql.DB, userID int64, input AccountGroupInput) (int64, error) {
+	result, err := db.Exec(
+		`INSERT INTO account_groups (user_id, name, position)
+		VALUES (?, ?, (SELECT COALESCE(MAX(position), -1) + 1 FROM account_groups WHERE user_id = ?))`,
+		userID,
+		input.Name,
+		userID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert account group: %w", err)
+	}
+
+	return result.LastInsertId()
+}
+
+// RenameAccountGroup renames one of the user's groups
+func RenameAccountGroup(db *sql.DB, id, userID int64, input AccountGroupInput) error {
+	result, err := db.Exec(
+		`UPDATE account_groups SET name = ? WHERE id = ? AND user_id = ?`,
+		input.Name,
+		id,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrAccountGroupNotFound
+	}
+
+	return nil
+}
+
+// DeleteAccountGroup removes one of the user's groups, its accounts become
+// ungrouped and keep their position
+func DeleteAccountGroup(db *sql.DB, id, userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM account_groups WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrAccountGroupNotFound
+	}
+
+	_, err = tx.Exec(
+		`UPDATE account_preferences SET group_id = NULL WHERE group_id = ? AND user_id = ?`,
+		id,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to ungroup accounts: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// ReorderAccountGroups stores the order of the user's groups, ids is the full
+// list of groups in their new order
+func ReorderAccountGroups(db *sql.DB, userID int64, ids []int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for position, id := range ids {
+		result, err := tx.Exec(
+			`UPDATE account_groups SET position = ? WHERE id = ? AND user_id = ?`,
+			position,
+			id,
+			userID,
+		)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrAccountGroupNotFound
+		}
+	}
+
+	return tx.Commit()
+}
+
+// canSeeAccount reports whether the account is active and the user owns it or
+// can see it through a household
+func canSeeAccount(tx *sql.Tx, accountID, userID int64) (bool, error) {
+	var count int
+	err := tx.QueryRow(
+		`SELECT COUNT(*) FROM accounts a
+		LEFT JOIN household_members m ON m.household_id = a.household_id AND m.user_id = ?
+		WHERE a.id = ? AND a.is_active = 1 AND (a.user_id = ? OR m.user_id IS NOT NULL)`,
+		userID,
+		accountID,
+		userID,
+	).Scan(&count)
+	return count > 0, err
+}
+
+// ReorderAccounts files the accounts under a group, zero meaning no group,
+// and stores their order within it. accountIDs is the full list of accounts in
+// the group in their new order.
+func ReorderAccounts(db *sql.DB, userID, groupID int64, accountIDs []int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if groupID != 0 {
+		var count int
+		err := tx.QueryRow(
+			`SELECT COUNT(*) FROM account_groups WHERE id = ? AND user_id = ?`,
+			groupID,
+			userID,
+		).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrAccountGroupNotFound
+		}
+	}
+
+	for position, accountID := range accountIDs {
+		ok, err := canSeeAccount(tx, accountID, userID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrAccountForbidden
+		}
+
+		_, err = tx.Exec(
+			`INSERT INTO account_preferences (user_id, account_id, group_id, position)
+			VALUES (?, ?, NULLIF(?, 0), ?)
+			ON CONFLICT (user_id, account_id) DO UPDATE SET
+				group_id = excluded.group_id,
+				position = excluded.position`,
+			userID,
+			accountID,
+			groupID,
+			position,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to store account position: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetAccountPinned pins the account to the front of its group for the user
+// or unpins it
+func SetAccountPinned(db *sql.DB, userID, accountID int64, pinned bool) error {
+	_, err := db.Exec(
+		`INSERT INTO account_preferences (user_id, account_id, pinned) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, account_id) DO UPDATE SET pinned = excluded.pinned`,
+		userID,
+		accountID,
+		pinned,
+	)
+	return err
+}
@@ -0,0 +1,232 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAPITokenNotFound = errors.New("api token not found")
+	ErrAPITokenExpired  = errors.New("api token expired")
+)
+
+const apiTokenPrefix = "nmr_"
+
+type APITokenScope string
+
+const (
+	ScopeReadProfile       APITokenScope = "read:profile"
+	ScopeWriteProfile      APITokenScope = "write:profile"
+	ScopeReadAccounts      APITokenScope = "read:accounts"
+	ScopeWriteAccounts     APITokenScope = "write:accounts"
+	ScopeReadTransactions  APITokenScope = "read:transactions"
+	ScopeWriteTransactions APITokenScope = "write:transactions"
+)
+
+// AllAPITokenScopes lists every scope a token can be granted.
+var AllAPITokenScopes = []APITokenScope{
+	ScopeReadProfile,
+	ScopeWriteProfile,
+	ScopeReadAccounts,
+	ScopeWriteAccounts,
+	ScopeReadTransactions,
+	ScopeWriteTransactions,
+}
+
+type APIToken struct {
+	ID          int64           `db:"id"`
+	UserID      int64           `db:"user_id"`
+	Name        string          `db:"name"`
+	TokenHash   string          `db:"token_hash"`
+	TokenPrefix string          `db:"token_prefix"`
+	Scopes      []APITokenScope `db:"scopes"`
+	ExpiresAt   sql.NullTime    `db:"expires_at"`
+	LastUsedAt  sql.NullTime    `db:"last_used_at"`
+	CreatedAt   time.Time       `db:"created_at"`
+}
+
+func (t *APIToken) IsExpired() bool {
+	return t.ExpiresAt.Valid && time.Now().After(t.ExpiresAt.Time)
+}
+
+func (t *APIToken) HasScope(scope APITokenScope) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+type CreateAPITokenInput struct {
+	Name          string          `form:"name" validate:"required,min=1,max=100"`
+	Scopes        []APITokenScope `form:"scopes" validate:"required,dive,oneof=read:profile write:profile read:accounts write:accounts read:transactions write:transactions"`
+	ExpiresInDays int             `form:"expires_in_days" validate:"oneof=0 30 90 365"`
+}
+
+func joinScopes(scopes []APITokenScope) string {
+	parts := make([]string, len(scopes))
+	for i, scope := range scopes {
+		parts[i] = string(scope)
+	}
+	return strings.Join(parts, " ")
+}
+
+func splitScopes(scopes string) []APITokenScope {
+	var result []APITokenScope
+	for _, scope := range strings.Fields(scopes) {
+		result = append(result, APITokenScope(scope))
+	}
+	return result
+}
+
+// CreateAPIToken generates a new token for a user and stores its hash, the
+// plaintext token is returned once and can not be recovered afterwards.
+func CreateAPIToken(db *sql.DB, userID int64, input CreateAPITokenInput) (string, error) {
+	token, err := generateToken(apiTokenPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	var expiresAt sql.NullTime
+	if input.ExpiresInDays > 0 {
+		expiresAt = sql.NullTime{
+			Time:  time.Now().UTC().AddDate(0, 0, input.ExpiresInDays),
+			Valid: true,
+		}
+	}
+
+	query := `
+		INSERT INTO api_tokens(
+			user_id, name, token_hash, token_prefix, scopes, expires_at
+		) VALUES
+			(?, ?, ?, ?, ?, ?)
+	`
+	_, err = db.Exec(
+		query,
+		userID,
+		input.Name,
+		hashToken(token),
+		token[:len(apiTokenPrefix)+6],
+		joinScopes(input.Scopes),
+		expiresAt,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to insert api token: %w", err)
+	}
+
+	return token, nil
+}
+
+// GetAPITokensByUserID gets all tokens for a user, newest first
+func GetAPITokensByUserID(db *sql.DB, userID int64) ([]APIToken, error) {
+	query := `
+		SELECT
+			id, user_id, name, token_hash, token_prefix, scopes,
+			expires_at, last_used_at, created_at
+		FROM api_tokens
+		WHERE user_id = ?
+		ORDER BY created_at DESC, id DESC
+	`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		var token APIToken
+		var scopes string
+		err = rows.Scan(
+			&token.ID,
+			&token.UserID,
+			&token.Name,
+			&token.TokenHash,
+			&token.TokenPrefix,
+			&scopes,
+			&token.ExpiresAt,
+			&token.LastUsedAt,
+			&token.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		token.Scopes = splitScopes(scopes)
+		tokens = append(tokens, token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// AuthenticateAPIToken looks up a token by its plaintext value, rejects expired
+// tokens and records the time it was last used.
+func AuthenticateAPIToken(db *sql.DB, plaintext string) (*APIToken, error) {
+	if !strings.HasPrefix(plaintext, apiTokenPrefix) {
+		return nil, ErrAPITokenNotFound
+	}
+
+	query := `
+		SELECT
+			id, user_id, name, token_hash, token_prefix, scopes,
+			expires_at, last_used_at, created_at
+		FROM api_tokens WHERE token_hash = ? LIMIT 1
+	`
+	var token APIToken
+	var scopes string
+	err := db.QueryRow(query, hashToken(plaintext)).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		&token.TokenHash,
+		&token.TokenPrefix,
+		&scopes,
+		&token.ExpiresAt,
+		&token.LastUsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAPITokenNotFound
+		}
+		return nil, err
+	}
+	token.Scopes = splitScopes(scopes)
+
+	if token.IsExpired() {
+		return nil, ErrAPITokenExpired
+	}
+
+	_, err = db.Exec(
+		`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`,
+		time.Now().UTC(),
+		token.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update token last used: %w", err)
+	}
+
+	return &token, nil
+}
+
+// DeleteAPIToken revokes a token owned by the user
+func DeleteAPIToken(db *sql.DB, id, userID int64) error {
+	result, err := db.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrAPITokenNotFound
+	}
+
+	return nil
+}
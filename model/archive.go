@@ -0,0 +1,558 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GetAllAccountsByUserID gets every account for a user, including inactive ones
+func GetAllAccountsByUserID(db *sql.DB, userID int64) ([]Account, error) {
+	return queryAllAccounts(db, `user_id = ?`, userID)
+}
+
+// GetAllAccountsForUser gets every account the user owns, including inactive
+// ones, along with the active accounts shared with them
+func GetAllAccountsForUser(db *sql.DB, userID int64) ([]Account, error) {
+	return queryAllAccounts(db, `id IN (`+visibleAccountIDs+`)`, userID, userID)
+}
+
+func queryAllAccounts(db *sql.DB, where string, args ...any) ([]Account, error) {
+	query := `
+		SELECT
+			id, name, account_type, balance, color, currency,
+			allows_negative_balance, is_active, user_id,
+			created_at, updated_at
+		FROM accounts
+		WHERE ` + where + `
+		ORDER BY id
+	`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []Account
+	for rows.Next() {
+		var account Account
+		err = rows.Scan(
+			&account.ID,
+			&account.Name,
+			&account.AccountType,
+			&account.Balance,
+			&account.Color,
+			&account.Currency,
+			&account.AllowsNegativeBalance,
+			&account.IsActive,
+			&account.UserID,
+			&account.CreatedAt,
+			&account.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// AccountPreference is where the user put one of their accounts
+type AccountPreference struct {
+	AccountID int64         `db:"account_id"`
+	GroupID   sql.NullInt64 `db:"group_id"`
+	Position  sql.NullInt64 `db:"position"`
+	Pinned    bool          `db:"pinned"`
+}
+
+// TransactionTag puts one of the user's tags on a transaction
+type TransactionTag struct {
+	TransactionID int64 `db:"transaction_id"`
+	TagID         int64 `db:"tag_id"`
+}
+
+// GetAccountPreferencesByUserID gets how the user laid out the accounts they
+// own
+func GetAccountPreferencesByUserID(db *sql.DB, userID int64) ([]AccountPreference, error) {
+	rows, err := db.Query(
+		`SELECT p.account_id, p.group_id, p.position, p.pinned
+		FROM account_preferences p
+		JOIN accounts a ON a.id = p.account_id AND a.user_id = p.user_id
+		WHERE p.user_id = ?
+		ORDER BY p.account_id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var preferences []AccountPreference
+	for rows.Next() {
+		var preference AccountPreference
+		err := rows.Scan(&preference.AccountID, &preference.GroupID, &preference.Position, &preference.Pinned)
+		if err != nil {
+			return nil, err
+		}
+		preferences = append(preferences, preference)
+	}
+
+	return preferences, rows.Err()
+}
+
+// GetReconciliationsByUserID gets every reconciliation of the accounts the
+// user owns, whoever did them
+func GetReconciliationsByUserID(db *sql.DB, userID int64) ([]Reconciliation, error) {
+	rows, err := db.Query(
+		`SELECT
+			id, account_id, user_id, statement_date, statement_balance,
+			adjustment, completed_at, created_at
+		FROM reconciliations
+		WHERE account_id IN (SELECT id FROM accounts WHERE user_id = ?)
+		ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reconciliations []Reconciliation
+	for rows.Next() {
+		var reconciliation Reconciliation
+		err := rows.Scan(
+			&reconciliation.ID,
+			&reconciliation.AccountID,
+			&reconciliation.UserID,
+			&reconciliation.StatementDate,
+			&reconciliation.StatementBalance,
+			&reconciliation.Adjustment,
+			&reconciliation.CompletedAt,
+			&reconciliation.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reconciliations = append(reconciliations, reconciliation)
+	}
+
+	return reconciliations, rows.Err()
+}
+
+// GetTransactionTagsByUserID gets the user's tags on transactions of the
+// accounts they own
+func GetTransactionTagsByUserID(db *sql.DB, userID int64) ([]TransactionTag, error) {
+	rows, err := db.Query(
+		`SELECT tt.transaction_id, tt.tag_id
+		FROM transaction_tags tt
+		JOIN tags g ON g.id = tt.tag_id AND g.user_id = ?
+		JOIN transactions t ON t.id = tt.transaction_id
+		WHERE t.account_id IN (SELECT id FROM accounts WHERE user_id = ?)
+		ORDER BY tt.transaction_id, tt.tag_id`,
+		userID,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactionTags []TransactionTag
+	for rows.Next() {
+		var transactionTag TransactionTag
+		if err := rows.Scan(&transactionTag.TransactionID, &transactionTag.TagID); err != nil {
+			return nil, err
+		}
+		transactionTags = append(transactionTags, transactionTag)
+	}
+
+	return transactionTags, rows.Err()
+}
+
+// ImportInput holds everything needed to restore an archive into a fresh
+// user. Records refer to each other by their ids in the archive.
+type ImportInput struct {
+	User               CreateUserInput
+	Currency           Currency
+	AccountGroups      []AccountGroup
+	Accounts           []Account
+	AccountPreferences []AccountPreference
+	Payees             []Payee
+	PayeeAliases       []PayeeAlias
+	Reconciliations    []Reconciliation
+	// Transactions come with their split lines
+	Transactions    []Transaction
+	Tags            []Tag
+	TransactionTags []TransactionTag
+	SavingsGoals    []SavingsGoal
+	Contributions   []SavingsGoalContribution
+	Contacts        []Contact
+	IOUs            []IOU
+	// IPAddress is recorded in the audit trail of the restored accounts
+	IPAddress string
+}
+
+// ImportResult maps ids from the archive to the ids of the restored records
+type ImportResult struct {
+	UserID     int64
+	AccountIDs map[int64]int64
+}
+
+// importIDs maps the ids of one kind of archived record to the restored ones
+type importIDs map[int64]int64
+
+// get maps an optional reference, references to records that were not
+// restored are dropped
+func (ids importIDs) get(id sql.NullInt64) sql.NullInt64 {
+	newID, ok := ids[id.Int64]
+	return sql.NullInt64{Int64: newID, Valid: id.Valid && ok}
+}
+
+// nullDate stores an optional date the way the forms do, as a plain date
+func nullDate(t sql.NullTime) any {
+	if !t.Valid {
+		return nil
+	}
+	return t.Time.Format("2006-01-02")
+}
+
+// insertImported inserts one restored record and keeps track of its new id
+func insertImported(tx *sql.Tx, ids importIDs, id int64, kind, query string, args ...any) error {
+	var newID int64
+	if err := tx.QueryRow(query+` RETURNING id`, args...).Scan(&newID); err != nil {
+		return fmt.Errorf("failed to insert %s %d: %w", kind, id, err)
+	}
+	ids[id] = newID
+	return nil
+}
+
+// ImportUserData creates a new user and restores the archived records into it
+// in a single transaction, so a failed import leaves nothing behind.
+func ImportUserData(db *sql.DB, input ImportInput) (*ImportResult, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.User.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	userResult, err := tx.Exec(
+		`INSERT INTO users (name, email, password, currency) VALUES (?, ?, ?, ?)`,
+		input.User.Name,
+		input.User.Email,
+		hashedPassword,
+		input.Currency,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert user: %w", err)
+	}
+
+	result := &ImportResult{AccountIDs: make(map[int64]int64, len(input.Accounts))}
+	result.UserID, err = userResult.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	userID := result.UserID
+
+	groupIDs := importIDs{}
+	for _, group := range input.AccountGroups {
+		err := insertImported(tx, groupIDs, group.ID, "account group",
+			`INSERT INTO account_groups (user_id, name, position, created_at) VALUES (?, ?, ?, ?)`,
+			userID, group.Name, group.Position, group.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	accountIDs := importIDs(result.AccountIDs)
+	for _, account := range input.Accounts {
+		err := insertImported(tx, accountIDs, account.ID, "account",
+			`INSERT INTO accounts(
+				name, account_type, balance, color, currency, allows_negative_balance,
+				is_active, user_id, created_at, updated_at
+			) VALUES
+				(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			account.Name,
+			account.AccountType,
+			account.Balance,
+			account.Color,
+			account.Currency,
+			account.AllowsNegativeBalance,
+			account.IsActive,
+			userID,
+			account.CreatedAt,
+			account.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		accountID := accountIDs[account.ID]
+		err = recordAudit(tx, Actor{UserID: userID, IPAddress: input.IPAddress}, auditEntry{
+			Action:     AuditAccountImported,
+			EntityType: AuditEntityAccount,
+			EntityID:   accountID,
+			AccountID:  accountID,
+			Changes:    diffAudit(nil, account.auditState()),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, preference := range input.AccountPreferences {
+		accountID, ok := accountIDs[preference.AccountID]
+		if !ok {
+			return nil, fmt.Errorf("account preference references unknown account %d", preference.AccountID)
+		}
+
+		_, err := tx.Exec(
+			`INSERT INTO account_preferences (user_id, account_id, group_id, position, pinned)
+			VALUES (?, ?, ?, ?, ?)`,
+			userID,
+			accountID,
+			groupIDs.get(preference.GroupID),
+			preference.Position,
+			preference.Pinned,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert account preference %d: %w", preference.AccountID, err)
+		}
+	}
+
+	payeeIDs := importIDs{}
+	for _, payee := range input.Payees {
+		err := insertImported(tx, payeeIDs, payee.ID, "payee",
+			`INSERT INTO payees (user_id, name, default_category, created_at) VALUES (?, ?, ?, ?)`,
+			userID, payee.Name, payee.DefaultCategory, payee.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, alias := range input.PayeeAliases {
+		payeeID, ok := payeeIDs[alias.PayeeID]
+		if !ok {
+			return nil, fmt.Errorf("payee alias references unknown payee %d", alias.PayeeID)
+		}
+
+		_, err := tx.Exec(
+			`INSERT INTO payee_aliases (payee_id, pattern, created_at) VALUES (?, ?, ?)
+			ON CONFLICT DO NOTHING`,
+			payeeID,
+			alias.Pattern,
+			alias.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert payee alias: %w", err)
+		}
+	}
+
+	reconciliationIDs := importIDs{}
+	for _, reconciliation := range input.Reconciliations {
+		accountID, ok := accountIDs[reconciliation.AccountID]
+		if !ok {
+			return nil, fmt.Errorf("reconciliation %d references unknown account %d", reconciliation.ID, reconciliation.AccountID)
+		}
+
+		err := insertImported(tx, reconciliationIDs, reconciliation.ID, "reconciliation",
+			`INSERT INTO reconciliations (
+				account_id, user_id, statement_date, statement_balance, adjustment,
+				completed_at, created_at
+			) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			accountID,
+			userID,
+			reconciliation.StatementDate.Format("2006-01-02"),
+			reconciliation.StatementBalance,
+			reconciliation.Adjustment,
+			reconciliation.CompletedAt,
+			reconciliation.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	transactionIDs := importIDs{}
+	for _, transaction := range input.Transactions {
+		accountID, ok := accountIDs[transaction.AccountID]
+		if !ok {
+			return nil, fmt.Errorf("transaction %d references unknown account %d", transaction.ID, transaction.AccountID)
+		}
+
+		// transactions exported without a payee are matched against the
+		// imported aliases like new ones, split ones keep their lines' categories
+		payee := payeeMatch{ID: payeeIDs.get(transaction.PayeeID)}
+		category := transaction.Category
+		if !payee.ID.Valid {
+			payee, err = matchPayee(tx, accountID, transaction.Payee)
+			if err != nil {
+				return nil, err
+			}
+			if len(transaction.Splits) == 0 {
+				category = payee.category(category)
+			}
+		}
+
+		err = insertImported(tx, transactionIDs, transaction.ID, "transaction",
+			`INSERT INTO transactions(
+				account_id, user_id, amount, payee, payee_id, category, notes,
+				occurred_at, created_at, updated_at, reconciliation_id, cleared
+			) VALUES
+				(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			accountID,
+			userID,
+			transaction.Amount,
+			transaction.Payee,
+			payee.ID,
+			category,
+			transaction.Notes,
+			transaction.OccurredAt,
+			transaction.CreatedAt,
+			transaction.UpdatedAt,
+			reconciliationIDs.get(transaction.ReconciliationID),
+			transaction.Cleared,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, split := range transaction.Splits {
+			_, err := tx.Exec(
+				`INSERT INTO transaction_splits (transaction_id, category, amount, notes) VALUES (?, ?, ?, ?)`,
+				transactionIDs[transaction.ID],
+				split.Category,
+				split.Amount,
+				split.Notes,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("failed to insert split of transaction %d: %w", transaction.ID, err)
+			}
+		}
+	}
+
+	tagIDs := importIDs{}
+	for _, tag := range input.Tags {
+		err := insertImported(tx, tagIDs, tag.ID, "tag",
+			`INSERT INTO tags (user_id, name, created_at) VALUES (?, ?, ?)`,
+			userID, tag.Name, tag.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, transactionTag := range input.TransactionTags {
+		transactionID, ok := transactionIDs[transactionTag.TransactionID]
+		tagID, tagOK := tagIDs[transactionTag.TagID]
+		if !ok || !tagOK {
+			return nil, fmt.Errorf("tag %d on transaction %d is unknown", transactionTag.TagID, transactionTag.TransactionID)
+		}
+
+		_, err := tx.Exec(
+			`INSERT INTO transaction_tags (transaction_id, tag_id) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+			transactionID,
+			tagID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to tag transaction %d: %w", transactionTag.TransactionID, err)
+		}
+	}
+
+	goalIDs := importIDs{}
+	for _, goal := range input.SavingsGoals {
+		err := insertImported(tx, goalIDs, goal.ID, "savings goal",
+			`INSERT INTO savings_goals (
+				user_id, name, target_amount, currency, target_date, account_id,
+				created_at, updated_at
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			userID,
+			goal.Name,
+			goal.TargetAmount,
+			goal.Currency,
+			goal.TargetDate.Format("2006-01-02"),
+			accountIDs.get(goal.AccountID),
+			goal.CreatedAt,
+			goal.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, contribution := range input.Contributions {
+		goalID, ok := goalIDs[contribution.GoalID]
+		if !ok {
+			return nil, fmt.Errorf("contribution references unknown savings goal %d", contribution.GoalID)
+		}
+
+		_, err := tx.Exec(
+			`INSERT INTO savings_goal_contributions (goal_id, amount, note, contributed_at, created_at)
+			VALUES (?, ?, ?, ?, ?)`,
+			goalID,
+			contribution.Amount,
+			contribution.Note,
+			contribution.ContributedAt.Format("2006-01-02"),
+			contribution.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert contribution to savings goal %d: %w", contribution.GoalID, err)
+		}
+	}
+
+	contactIDs := importIDs{}
+	for _, contact := range input.Contacts {
+		err := insertImported(tx, contactIDs, contact.ID, "contact",
+			`INSERT INTO contacts (user_id, name, email, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`,
+			userID, contact.Name, contact.Email, contact.CreatedAt, contact.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, iou := range input.IOUs {
+		contactID, ok := contactIDs[iou.ContactID]
+		if !ok {
+			return nil, fmt.Errorf("iou references unknown contact %d", iou.ContactID)
+		}
+
+		_, err := tx.Exec(
+			`INSERT INTO ious (
+				user_id, contact_id, direction, amount, currency, description,
+				due_date, settled_at, transaction_id, created_at
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			userID,
+			contactID,
+			iou.Direction,
+			iou.Amount,
+			iou.Currency,
+			iou.Description,
+			nullDate(iou.DueDate),
+			nullDate(iou.SettledAt),
+			transactionIDs.get(iou.TransactionID),
+			iou.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert iou of contact %d: %w", iou.ContactID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
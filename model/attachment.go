@@ -0,0 +1,312 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAttachmentNotFound      = errors.New("attachment not found")
+	ErrAttachmentQuotaExceeded = errors.New("attachment quota exceeded")
+)
+
+// Attachment is a receipt or document kept on an account, or on one of its
+// transactions when TransactionID is set. The file is kept in storage, the
+// thumbnail only exists for images.
+type Attachment struct {
+	ID            int64         `db:"id"`
+	UserID        int64         `db:"user_id"`
+	AccountID     int64         `db:"account_id"`
+	TransactionID sql.NullInt64 `db:"transaction_id"`
+	Filename      string        `db:"filename"`
+	ContentType   string        `db:"content_type"`
+	Size          int64         `db:"size"`
+	StorageKey    string        `db:"storage_key"`
+	ThumbnailKey  string        `db:"thumbnail_key"`
+	CreatedAt     time.Time     `db:"created_at"`
+}
+
+func (a *Attachment) HasThumbnail() bool {
+	return a.ThumbnailKey != ""
+}
+
+func (a *Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
+
+func (a *Attachment) FormatSize() string {
+	return FormatFileSize(a.Size)
+}
+
+// StorageKeys lists the objects the attachment keeps in storage
+func (a *Attachment) StorageKeys() []string {
+	if a.HasThumbnail() {
+		return []string{a.StorageKey, a.ThumbnailKey}
+	}
+	return []string{a.StorageKey}
+}
+
+// AttachmentUsage is how much of their attachment quota the user has used
+type AttachmentUsage struct {
+	Used  int64
+	Quota int64
+}
+
+func (u AttachmentUsage) Remaining() int64 {
+	return max(u.Quota-u.Used, 0)
+}
+
+func (u AttachmentUsage) Percent() int {
+	if u.Quota <= 0 {
+		return 100
+	}
+	return int(min(u.Used*100/u.Quota, 100))
+}
+
+func (u AttachmentUsage) String() string {
+	return FormatFileSize(u.Used) + " of " + FormatFileSize(u.Quota) + " used"
+}
+
+// FormatFileSize formats a size in bytes for people to read
+func FormatFileSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGT"[exp])
+}
+
+const attachmentColumns = `id, user_id, account_id, transaction_id, filename, content_type,
+	size, storage_key, thumbnail_key, created_at`
+
+func scanAttachment(row interface{ Scan(...any) error }, attachment *Attachment) error {
+	return row.Scan(
+		&attachment.ID,
+		&attachment.UserID,
+		&attachment.AccountID,
+		&attachment.TransactionID,
+		&attachment.Filename,
+		&attachment.ContentType,
+		&attachment.Size,
+		&attachment.StorageKey,
+		&attachment.ThumbnailKey,
+		&attachment.CreatedAt,
+	)
+}
+
+func queryAttachments(db *sql.DB, query string, args ...any) ([]Attachment, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []Attachment{}
+	for rows.Next() {
+		var attachment Attachment
+		if err := scanAttachment(rows, &attachment); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
+}
+
+// CreateAttachment records an attachment whose files are already in storage.
+// The quota is checked by the insert itself so concurrent uploads can't go
+// over it together.
+func CreateAttachment(db *sql.DB, attachment *Attachment, quota int64) error {
+	err := db.QueryRow(
+		`INSERT INTO attachments (
+			user_id, account_id, transaction_id, filename, content_type, size, storage_key, thumbnail_key
+		)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE (SELECT COALESCE(SUM(size), 0) FROM attachments WHERE user_id = ?) + ? <= ?
+		RETURNING id, created_at`,
+		attachment.UserID,
+		attachment.AccountID,
+		attachment.TransactionID,
+		attachment.Filename,
+		attachment.ContentType,
+		attachment.Size,
+		attachment.StorageKey,
+		attachment.ThumbnailKey,
+		attachment.UserID,
+		attachment.Size,
+		quota,
+	).Scan(&attachment.ID, &attachment.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAttachmentQuotaExceeded
+		}
+		return fmt.Errorf("failed to insert attachment: %w", err)
+	}
+
+	return nil
+}
+
+// GetAccountAttachments gets the documents kept on the account itself, the
+// ones attached to its transactions are left out
+func GetAccountAttachments(db *sql.DB, accountID int64) ([]Attachment, error) {
+	return queryAttachments(
+		db,
+		`SELECT `+attachmentColumns+`
+		FROM attachments
+		WHERE account_id = ? AND transaction_id IS NULL
+		ORDER BY created_at DESC, id DESC`,
+		accountID,
+	)
+}
+
+func GetTransactionAttachments(db *sql.DB, accountID, transactionID int64) ([]Attachment, error) {
+	return queryAttachments(
+		db,
+		`SELECT `+attachmentColumns+`
+		FROM attachments
+		WHERE account_id = ? AND transaction_id = ?
+		ORDER BY created_at DESC, id DESC`,
+		accountID,
+		transactionID,
+	)
+}
+
+// GetAttachmentForAccount gets one of the attachments on the account or its
+// transactions
+func GetAttachmentForAccount(db *sql.DB, accountID, id int64) (*Attachment, error) {
+	var attachment Attachment
+	err := scanAttachment(db.QueryRow(
+		`SELECT `+attachmentColumns+` FROM attachments WHERE id = ? AND account_id = ?`,
+		id,
+		accountID,
+	), &attachment)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+
+	return &attachment, nil
+}
+
+// GetTransactionAttachmentCounts counts the attachments of the given
+// transactions, transactions without any are left out
+func GetTransactionAttachmentCounts(db *sql.DB, transactionIDs []int64) (map[int64]int, error) {
+	counts := make(map[int64]int)
+	if len(transactionIDs) == 0 {
+		return counts, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(transactionIDs)), ", ")
+	args := make([]any, len(transactionIDs))
+	for i, id := range transactionIDs {
+		args[i] = id
+	}
+
+	rows, err := db.Query(
+		`SELECT transaction_id, COUNT(*)
+		FROM attachments
+		WHERE transaction_id IN (`+placeholders+`)
+		GROUP BY transaction_id`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transactionID int64
+		var count int
+		if err := rows.Scan(&transactionID, &count); err != nil {
+			return nil, err
+		}
+		counts[transactionID] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+func DeleteAttachment(db *sql.DB, id int64) error {
+	result, err := db.Exec(`DELETE FROM attachments WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrAttachmentNotFound
+	}
+
+	return nil
+}
+
+// GetAttachmentUsage sums the size of everything the user uploaded, on their
+// own accounts and on the ones shared with them
+func GetAttachmentUsage(db *sql.DB, userID int64) (int64, error) {
+	var used int64
+	err := db.QueryRow(
+		`SELECT COALESCE(SUM(size), 0) FROM attachments WHERE user_id = ?`,
+		userID,
+	).Scan(&used)
+	return used, err
+}
+
+// GetAccountAttachmentKeys lists the storage objects of every attachment on
+// the account, so they can be removed once the account is purged
+func GetAccountAttachmentKeys(db *sql.DB, accountID int64) ([]string, error) {
+	attachments, err := queryAttachments(
+		db,
+		`SELECT `+attachmentColumns+` FROM attachments WHERE account_id = ?`,
+		accountID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return attachmentKeys(attachments), nil
+}
+
+// GetUserAttachmentKeys lists the storage objects of every attachment that
+// DeleteUser removes, whatever is on the user's accounts
+func GetUserAttachmentKeys(db *sql.DB, userID int64) ([]string, error) {
+	attachments, err := queryAttachments(
+		db,
+		`SELECT `+attachmentColumns+`
+		FROM attachments
+		WHERE account_id IN (SELECT id FROM accounts WHERE user_id = ?)`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return attachmentKeys(attachments), nil
+}
+
+func attachmentKeys(attachments []Attachment) []string {
+	keys := []string{}
+	for _, attachment := range attachments {
+		keys = append(keys, attachment.StorageKeys()...)
+	}
+	return keys
+}
@@ -0,0 +1,313 @@
+package model
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Actor is who made a change and from where, it is recorded with every audit
+// entry
+type Actor struct {
+	UserID    int64
+	IPAddress string
+}
+
+type AuditAction string
+
+const (
+	AuditAccountCreated    AuditAction = "account.created"
+	AuditAccountUpdated    AuditAction = "account.updated"
+	AuditAccountDeleted    AuditAction = "account.deleted"
+	AuditAccountImported   AuditAction = "account.imported"
+	AuditAccountRestored   AuditAction = "account.restored"
+	AuditAccountPurged     AuditAction = "account.purged"
+	AuditAccountReconciled AuditAction = "account.reconciled"
+	AuditAccountIOUSettled AuditAction = "account.iou_settled"
+	AuditTransactionAdded  AuditAction = "account.transaction_added"
+	AuditProfileUpdated    AuditAction = "user.profile_updated"
+	AuditCurrencyChanged   AuditAction = "user.currency_changed"
+	AuditPasswordChanged   AuditAction = "user.password_changed"
+	AuditEmailChanged      AuditAction = "user.email_changed"
+)
+
+// Label returns a human readable description of the action
+func (a AuditAction) Label() string {
+	switch a {
+	case AuditAccountCreated:
+		return "Created account"
+	case AuditAccountUpdated:
+		return "Updated account"
+	case AuditAccountDeleted:
+		return "Deleted account"
+	case AuditAccountImported:
+		return "Imported account"
+	case AuditAccountRestored:
+		return "Restored account"
+	case AuditAccountPurged:
+		return "Permanently deleted account"
+	case AuditAccountReconciled:
+		return "Reconciled account"
+	case AuditAccountIOUSettled:
+		return "Settled IOU"
+	case AuditTransactionAdded:
+		return "Added transaction"
+	case AuditProfileUpdated:
+		return "Updated profile"
+	case AuditCurrencyChanged:
+		return "Changed currency"
+	case AuditPasswordChanged:
+		return "Changed password"
+	case AuditEmailChanged:
+		return "Changed email"
+	default:
+		return string(a)
+	}
+}
+
+type AuditEntity string
+
+const (
+	AuditEntityAccount AuditEntity = "account"
+	AuditEntityUser    AuditEntity = "user"
+)
+
+// auditRedacted replaces values that must never be written to the trail
+const auditRedacted = "[redacted]"
+
+// AuditChange is the value of a single field before and after a change,
+// Before is nil for created records
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// AuditChanges maps field names to how they changed
+type AuditChanges map[string]AuditChange
+
+// diffAudit keeps the fields whose json encoding differs between the two
+// states, either of which may be nil
+func diffAudit(before, after map[string]any) AuditChanges {
+	changes := AuditChanges{}
+	for field, value := range after {
+		old, existed := before[field]
+		if existed && sameAuditValue(old, value) {
+			continue
+		}
+		changes[field] = AuditChange{Before: old, After: value}
+	}
+	for field, old := range before {
+		if _, ok := after[field]; !ok {
+			changes[field] = AuditChange{Before: old}
+		}
+	}
+	return changes
+}
+
+func sameAuditValue(a, b any) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
+
+// auditEntry is a change about to be recorded
+type auditEntry struct {
+	Action     AuditAction
+	EntityType AuditEntity
+	EntityID   int64
+	// AccountID links the entry to an account's history, zero for changes
+	// that aren't about an account
+	AccountID int64
+	Changes   AuditChanges
+}
+
+// recordAudit appends the entry to the trail, it takes the transaction of the
+// change so either both are stored or neither is
+func recordAudit(tx *sql.Tx, actor Actor, entry auditEntry) error {
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit changes: %w", err)
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO audit_logs (actor_id, action, entity_type, entity_id, account_id, ip_address, changes)
+		VALUES (?, ?, ?, ?, NULLIF(?, 0), ?, ?)`,
+		actor.UserID,
+		entry.Action,
+		entry.EntityType,
+		entry.EntityID,
+		entry.AccountID,
+		actor.IPAddress,
+		string(changes),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+
+	return nil
+}
+
+type AuditLog struct {
+	ID      int64 `db:"id"`
+	ActorID int64 `db:"actor_id"`
+	// ActorName is empty when the actor has since deleted their account
+	ActorName  string        `db:"actor_name"`
+	Action     AuditAction   `db:"action"`
+	EntityType AuditEntity   `db:"entity_type"`
+	EntityID   int64         `db:"entity_id"`
+	AccountID  sql.NullInt64 `db:"account_id"`
+	IPAddress  string        `db:"ip_address"`
+	Changes    AuditChanges  `db:"changes"`
+	CreatedAt  time.Time     `db:"created_at"`
+}
+
+// Fields returns the names of the changed fields in a stable order
+func (l *AuditLog) Fields() []string {
+	fields := make([]string, 0, len(l.Changes))
+	for field := range l.Changes {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// FormatAuditValue renders a value from the trail for display
+func FormatAuditValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "—"
+	case string:
+		if v == "" {
+			return `""`
+		}
+		return v
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(encoded)
+	}
+}
+
+// AuditLogFilter narrows down the trail, zero values are ignored
+type AuditLogFilter struct {
+	AccountID int64
+	ActorID   int64
+}
+
+func (f AuditLogFilter) where() (string, []any) {
+	conditions := []string{"1 = 1"}
+	var args []any
+
+	if f.AccountID != 0 {
+		conditions = append(conditions, "l.account_id = ?")
+		args = append(args, f.AccountID)
+	}
+	if f.ActorID != 0 {
+		conditions = append(conditions, "l.actor_id = ?")
+		args = append(args, f.ActorID)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+const auditLogSelect = `
+	SELECT
+		l.id, l.actor_id, COALESCE(u.name, ''), l.action, l.entity_type, l.entity_id,
+		l.account_id, l.ip_address, l.changes, l.created_at
+	FROM audit_logs l
+	LEFT JOIN users u ON u.id = l.actor_id
+`
+
+func scanAuditLog(scanner interface{ Scan(...any) error }) (AuditLog, error) {
+	var log AuditLog
+	var changes string
+	err := scanner.Scan(
+		&log.ID,
+		&log.ActorID,
+		&log.ActorName,
+		&log.Action,
+		&log.EntityType,
+		&log.EntityID,
+		&log.AccountID,
+		&log.IPAddress,
+		&changes,
+		&log.CreatedAt,
+	)
+	if err != nil {
+		return log, err
+	}
+
+	if err := json.Unmarshal([]byte(changes), &log.Changes); err != nil {
+		return log, fmt.Errorf("failed to decode audit changes %d: %w", log.ID, err)
+	}
+
+	return log, nil
+}
+
+// GetAuditLogsPage gets a page of the trail matching the filter, newest
+// first, along with the total number of entries
+func GetAuditLogsPage(db *sql.DB, filter AuditLogFilter, limit, offset int) ([]AuditLog, int, error) {
+	where, args := filter.where()
+
+	var total int
+	err := db.QueryRow(`SELECT COUNT(*) FROM audit_logs l WHERE `+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(
+		auditLogSelect+` WHERE `+where+` ORDER BY l.id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	logs := []AuditLog{}
+	for rows.Next() {
+		log, err := scanAuditLog(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		logs = append(logs, log)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return logs, total, nil
+}
+
+// StreamAuditLogs calls fn for every entry matching the filter, oldest first,
+// without loading them all into memory.
+//
+// The callback runs while the rows are open, it must not query the database.
+func StreamAuditLogs(db *sql.DB, filter AuditLogFilter, fn func(AuditLog) error) error {
+	where, args := filter.where()
+
+	rows, err := db.Query(auditLogSelect+` WHERE `+where+` ORDER BY l.id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		log, err := scanAuditLog(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(log); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
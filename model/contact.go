@@ -0,0 +1,263 @@
+package model
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrContactNotFound = errors.New("contact not found")
+	ErrContactExists   = errors.New("contact with that name already exists")
+)
+
+// Contact is someone the user lends money to or borrows it from. Contacts
+// are personal, they don't need an account of their own.
+type Contact struct {
+	ID        int64     `db:"id"`
+	UserID    int64     `db:"user_id"`
+	Name      string    `db:"name"`
+	Email     string    `db:"email"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+type ContactInput struct {
+	Name  string `form:"name" validate:"required,max=60"`
+	Email string `form:"email" validate:"omitempty,email,max=100"`
+}
+
+// ContactBalance is what is still open with a contact in one currency,
+// positive when the contact owes the user
+type ContactBalance struct {
+	ContactID int64           `db:"contact_id"`
+	Currency  Currency        `db:"currency"`
+	Count     int             `db:"count"`
+	Amount    decimal.Decimal `db:"amount"`
+}
+
+// ContactSummary is a contact with their open ious netted per currency and,
+// when every rate is available, in the user's currency
+type ContactSummary struct {
+	Contact  Contact
+	Balances []ContactBalance
+	// Net is in the user's currency, HasNet is false when one of the
+	// balances could not be converted
+	Net      decimal.Decimal
+	HasNet   bool
+	Currency Currency
+}
+
+// OpenCount is how many ious are still open with the contact
+func (s *ContactSummary) OpenCount() int {
+	count := 0
+	for _, balance := range s.Balances {
+		count += balance.Count
+	}
+	return count
+}
+
+// SortedBalances lists the open balance in every currency, ordered by
+// currency
+func (s *ContactSummary) SortedBalances() []ContactBalance {
+	balances := slices.Clone(s.Balances)
+	slices.SortFunc(balances, func(a, b ContactBalance) int {
+		return cmp.Compare(a.Currency, b.Currency)
+	})
+	return balances
+}
+
+// GetContactsByUserID gets the user's contacts by name
+func GetContactsByUserID(db *sql.DB, userID int64) ([]Contact, error) {
+	rows, err := db.Query(
+		`SELECT id, user_id, name, email, created_at, updated_at
+		FROM contacts
+		WHERE user_id = ?
+		ORDER BY name COLLATE NOCASE`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []Contact{}
+	for rows.Next() {
+		var contact Contact
+		err := rows.Scan(
+			&contact.ID,
+			&contact.UserID,
+			&contact.Name,
+			&contact.Email,
+			&contact.CreatedAt,
+			&contact.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+// GetContactForUser gets one of the user's contacts
+func GetContactForUser(db *sql.DB, id, userID int64) (*Contact, error) {
+	var contact Contact
+	err := db.QueryRow(
+		`SELECT id, user_id, name, email, created_at, updated_at FROM contacts WHERE id = ? AND user_id = ?`,
+		id,
+		userID,
+	).Scan(
+		&contact.ID,
+		&contact.UserID,
+		&contact.Name,
+		&contact.Email,
+		&contact.CreatedAt,
+		&contact.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrContactNotFound
+		}
+		return nil, err
+	}
+
+	return &contact, nil
+}
+
+// GetContactBalances nets the user's open ious per contact and currency
+func GetContactBalances(db *sql.DB, userID int64) (map[int64][]ContactBalance, error) {
+	rows, err := db.Query(
+		`SELECT
+			contact_id, currency, COUNT(*),
+			SUM(CASE direction WHEN 'lent' THEN amount ELSE -amount END)
+		FROM ious
+		WHERE user_id = ? AND settled_at IS NULL
+		GROUP BY contact_id, currency`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := make(map[int64][]ContactBalance)
+	for rows.Next() {
+		var balance ContactBalance
+		if err := rows.Scan(&balance.ContactID, &balance.Currency, &balance.Count, &balance.Amount); err != nil {
+			return nil, err
+		}
+		balances[balance.ContactID] = append(balances[balance.ContactID], balance)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return balances, nil
+}
+
+func contactNameTaken(db *sql.DB, userID int64, name string, exceptID int64) (bool, error) {
+	var count int
+	err := db.QueryRow(
+		`SELECT COUNT(*) FROM contacts WHERE user_id = ? AND name = ? COLLATE NOCASE AND id != ?`,
+		userID,
+		name,
+		exceptID,
+	).Scan(&count)
+	return count > 0, err
+}
+
+func CreateContact(db *sql.DB, userID int64, input ContactInput) (int64, error) {
+	taken, err := contactNameTaken(db, userID, input.Name, 0)
+	if err != nil {
+		return 0, err
+	}
+	if taken {
+		return 0, ErrContactExists
+	}
+
+	result, err := db.Exec(
+		`INSERT INTO contacts (user_id, name, email) VALUES (?, ?, ?)`,
+		userID,
+		input.Name,
+		input.Email,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert contact: %w", err)
+	}
+
+	return result.LastInsertId()
+}
+
+func UpdateContact(db *sql.DB, id, userID int64, input ContactInput) error {
+	taken, err := contactNameTaken(db, userID, input.Name, id)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrContactExists
+	}
+
+	result, err := db.Exec(
+		`UPDATE contacts SET name = ?, email = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ?`,
+		input.Name,
+		input.Email,
+		id,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrContactNotFound
+	}
+
+	return nil
+}
+
+// DeleteContact removes the contact along with their ious, transactions
+// recorded when settling them stay on their accounts
+func DeleteContact(db *sql.DB, id, userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM contacts WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrContactNotFound
+	}
+
+	if _, err := tx.Exec(`DELETE FROM ious WHERE contact_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete ious: %w", err)
+	}
+
+	return tx.Commit()
+}
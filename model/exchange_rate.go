@@ -0,0 +1,78 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ExchangeRate is what one unit of From was worth in To on a day
+type ExchangeRate struct {
+	ID        int64           `db:"id"`
+	From      Currency        `db:"from_currency"`
+	To        Currency        `db:"to_currency"`
+	Rate      decimal.Decimal `db:"rate"`
+	Date      time.Time       `db:"rate_date"`
+	CreatedAt time.Time       `db:"created_at"`
+}
+
+// SaveExchangeRate stores a fetched rate, a later fetch on the same day
+// replaces it
+func SaveExchangeRate(db *sql.DB, rate ExchangeRate) error {
+	_, err := db.Exec(
+		`INSERT INTO exchange_rates (from_currency, to_currency, rate, rate_date) VALUES (?, ?, ?, ?)
+		ON CONFLICT (from_currency, to_currency, rate_date) DO UPDATE SET rate = excluded.rate`,
+		rate.From,
+		rate.To,
+		rate.Rate,
+		rate.Date.Format("2006-01-02"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save exchange rate: %w", err)
+	}
+
+	return nil
+}
+
+// GetExchangeRates gets the stored rates from the given currencies, oldest
+// first. A non zero until leaves out rates after that day.
+func GetExchangeRates(db *sql.DB, from []Currency, until time.Time) ([]ExchangeRate, error) {
+	if len(from) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(from)), ", ")
+	query := `SELECT id, from_currency, to_currency, rate, rate_date, created_at
+		FROM exchange_rates
+		WHERE from_currency IN (` + placeholders + `)`
+	args := make([]any, 0, len(from)+1)
+	for _, currency := range from {
+		args = append(args, currency)
+	}
+	if !until.IsZero() {
+		query += ` AND rate_date <= ?`
+		args = append(args, until.Format("2006-01-02"))
+	}
+	query += ` ORDER BY rate_date, from_currency, to_currency`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []ExchangeRate
+	for rows.Next() {
+		var rate ExchangeRate
+		err := rows.Scan(&rate.ID, &rate.From, &rate.To, &rate.Rate, &rate.Date, &rate.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, rows.Err()
+}
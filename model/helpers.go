@@ -1,23 +1,49 @@
 package model
 
-import "github.com/shopspring/decimal"
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"numera/pkg/locale"
 
-func FormatBalance(amount decimal.Decimal, currency Currency) string {
-	formatted := amount.StringFixed(2)
+	"github.com/shopspring/decimal"
+)
+
+// generateToken returns a url safe random token with the given prefix
+func generateToken(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex encoded sha256 of a plaintext token, tokens are
+// random enough that a fast hash is sufficient.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// FormatBalance writes the amount with its currency the way the user's locale
+// does
+func FormatBalance(p locale.Preferences, amount decimal.Decimal, currency Currency) string {
 	switch currency {
 	case CurrencyUSD:
-		return "$" + formatted
+		return p.Amount(amount, currency.Decimals(), "$")
 	case CurrencyEUR:
-		return "€" + formatted
+		return p.Amount(amount, currency.Decimals(), "€")
 	case CurrencyGBP:
-		return "£" + formatted
+		return p.Amount(amount, currency.Decimals(), "£")
 	case CurrencyRSD:
-		return formatted + " дин"
+		return p.Amount(amount, currency.Decimals(), "") + " дин"
 	case CurrencyJPY:
-		return "¥" + amount.StringFixed(0)
+		return p.Amount(amount, currency.Decimals(), "¥")
 	case CurrencyCHF:
-		return "CHF " + formatted
+		return p.Amount(amount, currency.Decimals(), "CHF ")
 	default:
-		return formatted + " " + string(currency)
+		return p.Amount(amount, currency.Decimals(), "") + " " + string(currency)
 	}
 }
@@ -0,0 +1,585 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrHouseholdNotFound           = errors.New("household not found")
+	ErrHouseholdMemberNotFound     = errors.New("household member not found")
+	ErrLastHouseholdOwner          = errors.New("household must keep at least one owner")
+	ErrHouseholdInvitationInvalid  = errors.New("household invitation is invalid or expired")
+	ErrHouseholdInvitationNotFound = errors.New("household invitation not found")
+	ErrAlreadyHouseholdMember      = errors.New("user is already a household member")
+)
+
+// HouseholdInvitationTTL is how long an invitation link stays valid
+const HouseholdInvitationTTL = 7 * 24 * time.Hour
+
+// Role is what a user may do in a household, or with an account
+type Role string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleEditor Role = "editor"
+	RoleViewer Role = "viewer"
+)
+
+// Roles lists every role a household member can have
+var Roles = []Role{RoleOwner, RoleEditor, RoleViewer}
+
+// Permission is an action checked against a role
+type Permission string
+
+const (
+	// PermissionView allows reading
+	PermissionView Permission = "view"
+	// PermissionEdit allows changing data but not deleting it
+	PermissionEdit Permission = "edit"
+	// PermissionManage allows deleting and, for households, managing members
+	PermissionManage Permission = "manage"
+)
+
+// Can reports whether the role grants the permission, the zero role grants
+// nothing
+func (r Role) Can(p Permission) bool {
+	switch p {
+	case PermissionView:
+		return r == RoleOwner || r == RoleEditor || r == RoleViewer
+	case PermissionEdit:
+		return r == RoleOwner || r == RoleEditor
+	case PermissionManage:
+		return r == RoleOwner
+	default:
+		return false
+	}
+}
+
+type Household struct {
+	ID          int64     `db:"id"`
+	Name        string    `db:"name"`
+	Role        Role      `db:"role"`
+	MemberCount int       `db:"member_count"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+type HouseholdMember struct {
+	HouseholdID int64     `db:"household_id"`
+	UserID      int64     `db:"user_id"`
+	Name        string    `db:"name"`
+	Email       string    `db:"email"`
+	Role        Role      `db:"role"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+type HouseholdInvitation struct {
+	ID            int64        `db:"id"`
+	HouseholdID   int64        `db:"household_id"`
+	HouseholdName string       `db:"household_name"`
+	Email         string       `db:"email"`
+	Role          Role         `db:"role"`
+	InvitedBy     int64        `db:"invited_by"`
+	ExpiresAt     time.Time    `db:"expires_at"`
+	AcceptedAt    sql.NullTime `db:"accepted_at"`
+	CreatedAt     time.Time    `db:"created_at"`
+}
+
+type CreateHouseholdInput struct {
+	Name string `form:"name" validate:"required,min=1,max=100"`
+}
+
+type InviteHouseholdMemberInput struct {
+	Email string `form:"email" validate:"required,email,max=100"`
+	Role  Role   `form:"role" validate:"required,oneof=owner editor viewer"`
+}
+
+type UpdateHouseholdMemberInput struct {
+	Role Role `form:"role" validate:"required,oneof=owner editor viewer"`
+}
+
+// CreateHousehold creates a household with the user as its owner
+func CreateHousehold(db *sql.DB, userID int64, input CreateHouseholdInput) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO households (name) VALUES (?)`, input.Name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert household: %w", err)
+	}
+
+	householdID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO household_members (household_id, user_id, role) VALUES (?, ?, ?)`,
+		householdID,
+		userID,
+		RoleOwner,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert household owner: %w", err)
+	}
+
+	return householdID, tx.Commit()
+}
+
+const householdSelect = `
+	SELECT
+		h.id, h.name, m.role,
+		(SELECT COUNT(*) FROM household_members WHERE household_id = h.id),
+		h.created_at
+	FROM households h
+	JOIN household_members m ON m.household_id = h.id AND m.user_id = ?
+`
+
+func scanHousehold(scanner interface{ Scan(...any) error }) (Household, error) {
+	var household Household
+	err := scanner.Scan(
+		&household.ID,
+		&household.Name,
+		&household.Role,
+		&household.MemberCount,
+		&household.CreatedAt,
+	)
+	return household, err
+}
+
+// GetHouseholdsByUserID gets the households the user is a member of, along
+// with their role in each
+func GetHouseholdsByUserID(db *sql.DB, userID int64) ([]Household, error) {
+	rows, err := db.Query(householdSelect+` ORDER BY h.name, h.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var households []Household
+	for rows.Next() {
+		household, err := scanHousehold(rows)
+		if err != nil {
+			return nil, err
+		}
+		households = append(households, household)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return households, nil
+}
+
+// GetHouseholdForUser gets a household the user is a member of, failing with
+// ErrHouseholdNotFound for everyone else
+func GetHouseholdForUser(db *sql.DB, id, userID int64) (*Household, error) {
+	household, err := scanHousehold(db.QueryRow(householdSelect+` WHERE h.id = ?`, userID, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrHouseholdNotFound
+		}
+		return nil, err
+	}
+
+	return &household, nil
+}
+
+// GetHouseholdMembers gets every member of a household, owners first
+func GetHouseholdMembers(db *sql.DB, householdID int64) ([]HouseholdMember, error) {
+	query := `
+		SELECT m.household_id, m.user_id, u.name, u.email, m.role, m.created_at
+		FROM household_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.household_id = ?
+		ORDER BY CASE m.role WHEN 'owner' THEN 0 WHEN 'editor' THEN 1 ELSE 2 END, u.name
+	`
+	rows, err := db.Query(query, householdID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []HouseholdMember
+	for rows.Next() {
+		var member HouseholdMember
+		err := rows.Scan(
+			&member.HouseholdID,
+			&member.UserID,
+			&member.Name,
+			&member.Email,
+			&member.Role,
+			&member.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// ensureOtherOwner fails with ErrLastHouseholdOwner if the user is the only
+// owner of the household
+func ensureOtherOwner(tx *sql.Tx, householdID, userID int64) error {
+	var others int
+	err := tx.QueryRow(
+		`SELECT COUNT(*) FROM household_members
+		WHERE household_id = ? AND role = 'owner' AND user_id != ?`,
+		householdID,
+		userID,
+	).Scan(&others)
+	if err != nil {
+		return err
+	}
+
+	if others == 0 {
+		return ErrLastHouseholdOwner
+	}
+
+	return nil
+}
+
+// UpdateHouseholdMemberRole changes the role of a member, the last owner can
+// not be demoted
+func UpdateHouseholdMemberRole(db *sql.DB, householdID, userID int64, role Role) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if role != RoleOwner {
+		if err := ensureOtherOwner(tx, householdID, userID); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(
+		`UPDATE household_members SET role = ? WHERE household_id = ? AND user_id = ?`,
+		role,
+		householdID,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrHouseholdMemberNotFound
+	}
+
+	return tx.Commit()
+}
+
+// RemoveHouseholdMember removes a member from the household and stops sharing
+// their accounts with it, the last owner can not leave
+func RemoveHouseholdMember(db *sql.DB, householdID, userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := ensureOtherOwner(tx, householdID, userID); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(
+		`DELETE FROM household_members WHERE household_id = ? AND user_id = ?`,
+		householdID,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrHouseholdMemberNotFound
+	}
+
+	_, err = tx.Exec(
+		`UPDATE accounts SET household_id = NULL WHERE household_id = ? AND user_id = ?`,
+		householdID,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to unshare accounts: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// DeleteHousehold removes the household, its accounts go back to being
+// private to their owners
+func DeleteHousehold(db *sql.DB, id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE accounts SET household_id = NULL WHERE household_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to unshare accounts: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM household_invitations WHERE household_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM household_members WHERE household_id = ?`, id); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM households WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrHouseholdNotFound
+	}
+
+	return tx.Commit()
+}
+
+// CreateHouseholdInvitation issues an invitation for the email, only the hash
+// of the token is stored so the plaintext is returned once.
+func CreateHouseholdInvitation(db *sql.DB, householdID, invitedBy int64, input InviteHouseholdMemberInput) (string, error) {
+	token, err := generateToken("")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO household_invitations (household_id, email, role, token_hash, invited_by, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		householdID,
+		strings.ToLower(strings.TrimSpace(input.Email)),
+		input.Role,
+		hashToken(token),
+		invitedBy,
+		time.Now().UTC().Add(HouseholdInvitationTTL),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to insert household invitation: %w", err)
+	}
+
+	return token, nil
+}
+
+const invitationSelect = `
+	SELECT
+		i.id, i.household_id, h.name, i.email, i.role, i.invited_by,
+		i.expires_at, i.accepted_at, i.created_at
+	FROM household_invitations i
+	JOIN households h ON h.id = i.household_id
+`
+
+func scanHouseholdInvitation(scanner interface{ Scan(...any) error }) (HouseholdInvitation, error) {
+	var invitation HouseholdInvitation
+	err := scanner.Scan(
+		&invitation.ID,
+		&invitation.HouseholdID,
+		&invitation.HouseholdName,
+		&invitation.Email,
+		&invitation.Role,
+		&invitation.InvitedBy,
+		&invitation.ExpiresAt,
+		&invitation.AcceptedAt,
+		&invitation.CreatedAt,
+	)
+	return invitation, err
+}
+
+// GetPendingHouseholdInvitations gets the invitations of a household that
+// were neither accepted nor expired, newest first
+func GetPendingHouseholdInvitations(db *sql.DB, householdID int64) ([]HouseholdInvitation, error) {
+	rows, err := db.Query(
+		invitationSelect+`
+		WHERE i.household_id = ? AND i.accepted_at IS NULL AND i.expires_at > ?
+		ORDER BY i.created_at DESC, i.id DESC`,
+		householdID,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []HouseholdInvitation
+	for rows.Next() {
+		invitation, err := scanHouseholdInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// GetValidHouseholdInvitation looks up a pending invitation by its plaintext
+// token
+func GetValidHouseholdInvitation(db *sql.DB, token string) (*HouseholdInvitation, error) {
+	if token == "" {
+		return nil, ErrHouseholdInvitationInvalid
+	}
+
+	invitation, err := scanHouseholdInvitation(db.QueryRow(
+		invitationSelect+`
+		WHERE i.token_hash = ? AND i.accepted_at IS NULL AND i.expires_at > ?`,
+		hashToken(token),
+		time.Now().UTC(),
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrHouseholdInvitationInvalid
+		}
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+// AcceptHouseholdInvitation adds the user to the household with the invited
+// role and uses up the invitation
+func AcceptHouseholdInvitation(db *sql.DB, invitation *HouseholdInvitation, userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`UPDATE household_invitations SET accepted_at = ? WHERE id = ? AND accepted_at IS NULL`,
+		time.Now().UTC(),
+		invitation.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrHouseholdInvitationInvalid
+	}
+
+	result, err = tx.Exec(
+		`INSERT INTO household_members (household_id, user_id, role) VALUES (?, ?, ?)
+		ON CONFLICT (household_id, user_id) DO NOTHING`,
+		invitation.HouseholdID,
+		userID,
+		invitation.Role,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert household member: %w", err)
+	}
+
+	rowsAffected, err = result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrAlreadyHouseholdMember
+	}
+
+	return nil
+}
+
+// DeleteHouseholdInvitation revokes a pending invitation of the household
+func DeleteHouseholdInvitation(db *sql.DB, id, householdID int64) error {
+	result, err := db.Exec(
+		`DELETE FROM household_invitations WHERE id = ? AND household_id = ? AND accepted_at IS NULL`,
+		id,
+		householdID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrHouseholdInvitationNotFound
+	}
+
+	return nil
+}
+
+// GetShareableHouseholds gets the households the user may share accounts
+// with, which are the ones where they can edit
+func GetShareableHouseholds(db *sql.DB, userID int64) ([]Household, error) {
+	households, err := GetHouseholdsByUserID(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	shareable := households[:0]
+	for _, household := range households {
+		if household.Role.Can(PermissionEdit) {
+			shareable = append(shareable, household)
+		}
+	}
+
+	return shareable, nil
+}
+
+// CanShareWithHousehold reports whether the user may share an account with
+// the household, zero stands for not sharing and is always allowed
+func CanShareWithHousehold(db *sql.DB, householdID, userID int64) (bool, error) {
+	if householdID == 0 {
+		return true, nil
+	}
+
+	household, err := GetHouseholdForUser(db, householdID, userID)
+	if err != nil {
+		if errors.Is(err, ErrHouseholdNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return household.Role.Can(PermissionEdit), nil
+}
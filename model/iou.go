@@ -0,0 +1,297 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrIOUNotFound = errors.New("iou not found")
+	ErrIOUSettled  = errors.New("iou is already settled")
+	// ErrIOUCurrencyMismatch is returned when settling onto an account kept in
+	// another currency than the iou
+	ErrIOUCurrencyMismatch = errors.New("account currency does not match the iou")
+	// ErrIOUNegativeBalance is returned when paying back would take an
+	// account that doesn't allow it below zero
+	ErrIOUNegativeBalance = errors.New("settlement would take the account below zero")
+)
+
+type IOUDirection string
+
+const (
+	// IOULent is money the user lent, the contact owes it to them
+	IOULent IOUDirection = "lent"
+	// IOUBorrowed is money the user borrowed, they owe it to the contact
+	IOUBorrowed IOUDirection = "borrowed"
+)
+
+// IOU is money lent to or borrowed from a contact. Amount is always positive,
+// Direction says who owes whom.
+type IOU struct {
+	ID          int64           `db:"id"`
+	UserID      int64           `db:"user_id"`
+	ContactID   int64           `db:"contact_id"`
+	Direction   IOUDirection    `db:"direction"`
+	Amount      decimal.Decimal `db:"amount"`
+	Currency    Currency        `db:"currency"`
+	Description string          `db:"description"`
+	DueDate     sql.NullTime    `db:"due_date"`
+	SettledAt   sql.NullTime    `db:"settled_at"`
+	// TransactionID is the transaction recorded when settling, AccountID and
+	// AccountName describe the account it was recorded on
+	TransactionID sql.NullInt64 `db:"transaction_id"`
+	AccountID     int64         `db:"account_id"`
+	AccountName   string        `db:"account_name"`
+	CreatedAt     time.Time     `db:"created_at"`
+}
+
+type IOUInput struct {
+	Direction   IOUDirection `form:"direction" validate:"required,oneof=lent borrowed"`
+	Amount      string       `form:"amount" validate:"required,numeric"`
+	Currency    Currency     `form:"currency" validate:"required,oneof=EUR USD RSD GBP JPY CHF"`
+	Description string       `form:"description" validate:"max=200"`
+	DueDate     string       `form:"duedate" validate:"omitempty,datetime=2006-01-02"`
+}
+
+type IOUSettleInput struct {
+	// AccountID is the account the payment is recorded on, 0 settles the iou
+	// without recording a transaction
+	AccountID int64  `form:"accountid" validate:"gte=0"`
+	SettledAt string `form:"settledat" validate:"required,datetime=2006-01-02"`
+}
+
+func (i *IOU) IsSettled() bool {
+	return i.SettledAt.Valid
+}
+
+// IsOverdue reports whether the iou is still open past its due date
+func (i *IOU) IsOverdue(now time.Time) bool {
+	return !i.IsSettled() && i.DueDate.Valid && i.DueDate.Time.Before(now.Truncate(24*time.Hour))
+}
+
+// SignedAmount is the amount as it moves the user's money when settled,
+// positive when it is paid back to them
+func (i *IOU) SignedAmount() decimal.Decimal {
+	if i.Direction == IOUBorrowed {
+		return i.Amount.Neg()
+	}
+	return i.Amount
+}
+
+const iouSelect = `
+	SELECT
+		i.id, i.user_id, i.contact_id, i.direction, i.amount, i.currency, i.description,
+		i.due_date, i.settled_at, i.transaction_id, COALESCE(a.id, 0), COALESCE(a.name, ''),
+		i.created_at
+	FROM ious i
+	LEFT JOIN transactions t ON t.id = i.transaction_id
+	LEFT JOIN accounts a ON a.id = t.account_id
+`
+
+func scanIOU(scanner interface{ Scan(...any) error }) (IOU, error) {
+	var iou IOU
+	err := scanner.Scan(
+		&iou.ID,
+		&iou.UserID,
+		&iou.ContactID,
+		&iou.Direction,
+		&iou.Amount,
+		&iou.Currency,
+		&iou.Description,
+		&iou.DueDate,
+		&iou.SettledAt,
+		&iou.TransactionID,
+		&iou.AccountID,
+		&iou.AccountName,
+		&iou.CreatedAt,
+	)
+	return iou, err
+}
+
+// GetContactIOUs gets the ious with a contact, open ones first by due date
+// and then the settled ones, most recent first
+func GetContactIOUs(db *sql.DB, contactID int64) ([]IOU, error) {
+	rows, err := db.Query(
+		iouSelect+`
+		WHERE i.contact_id = ?
+		ORDER BY
+			i.settled_at IS NOT NULL,
+			CASE WHEN i.settled_at IS NULL THEN COALESCE(i.due_date, '9999-12-31') END,
+			i.settled_at DESC, i.created_at DESC, i.id DESC`,
+		contactID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ious := []IOU{}
+	for rows.Next() {
+		iou, err := scanIOU(rows)
+		if err != nil {
+			return nil, err
+		}
+		ious = append(ious, iou)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ious, nil
+}
+
+// GetContactIOU gets one of the ious with a contact
+func GetContactIOU(db *sql.DB, contactID, id int64) (*IOU, error) {
+	iou, err := scanIOU(db.QueryRow(iouSelect+` WHERE i.id = ? AND i.contact_id = ?`, id, contactID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrIOUNotFound
+		}
+		return nil, err
+	}
+
+	return &iou, nil
+}
+
+func CreateIOU(db *sql.DB, contact *Contact, input IOUInput) (int64, error) {
+	amount, err := decimal.NewFromString(input.Amount)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := db.Exec(
+		`INSERT INTO ious (user_id, contact_id, direction, amount, currency, description, due_date)
+		VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''))`,
+		contact.UserID,
+		contact.ID,
+		input.Direction,
+		amount,
+		input.Currency,
+		input.Description,
+		input.DueDate,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert iou: %w", err)
+	}
+
+	return result.LastInsertId()
+}
+
+// SettleIOU marks the iou as paid back. With an account the payment is
+// recorded on it as a transaction with the contact as payee, moving its
+// balance, and the change is added to the account's audit trail.
+func SettleIOU(db *sql.DB, actor Actor, iou *IOU, contact *Contact, account *Account, input IOUSettleInput) error {
+	if iou.IsSettled() {
+		return ErrIOUSettled
+	}
+	if account != nil && account.Currency != iou.Currency {
+		return ErrIOUCurrencyMismatch
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var transactionID sql.NullInt64
+	if account != nil {
+		payee, err := matchPayee(tx, account.ID, contact.Name)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRow(
+			`INSERT INTO transactions (account_id, user_id, amount, payee, payee_id, category, notes, occurred_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id`,
+			account.ID,
+			iou.UserID,
+			iou.SignedAmount(),
+			contact.Name,
+			payee.ID,
+			payee.DefaultCategory,
+			iou.Description,
+			input.SettledAt,
+		).Scan(&transactionID)
+		if err != nil {
+			return fmt.Errorf("failed to insert settlement: %w", err)
+		}
+
+		var balance decimal.Decimal
+		err = tx.QueryRow(
+			`UPDATE accounts SET balance = balance + ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND (allows_negative_balance = 1 OR balance + ? >= 0)
+			RETURNING balance`,
+			iou.SignedAmount(),
+			account.ID,
+			iou.SignedAmount(),
+		).Scan(&balance)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrIOUNegativeBalance
+			}
+			return fmt.Errorf("failed to update balance: %w", err)
+		}
+
+		err = recordAudit(tx, actor, auditEntry{
+			Action:     AuditAccountIOUSettled,
+			EntityType: AuditEntityAccount,
+			EntityID:   account.ID,
+			AccountID:  account.ID,
+			Changes: AuditChanges{
+				"contact": {After: contact.Name},
+				"balance": {Before: balance.Sub(iou.SignedAmount()), After: balance},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(
+		`UPDATE ious SET settled_at = ?, transaction_id = ? WHERE id = ? AND settled_at IS NULL`,
+		input.SettledAt,
+		transactionID,
+		iou.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrIOUSettled
+	}
+
+	return tx.Commit()
+}
+
+// DeleteIOU removes the iou, a transaction recorded when settling it stays
+// on its account
+func DeleteIOU(db *sql.DB, contactID, id int64) error {
+	result, err := db.Exec(`DELETE FROM ious WHERE id = ? AND contact_id = ?`, id, contactID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrIOUNotFound
+	}
+
+	return nil
+}
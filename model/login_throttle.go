@@ -0,0 +1,132 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ThrottlePolicy decides when repeated login failures lock a key out. Once
+// Threshold failures are reached every further failure doubles the lockout,
+// starting at BaseLockout and capped at MaxLockout. Failures older than
+// Window are forgotten.
+type ThrottlePolicy struct {
+	Kind        string
+	Threshold   int
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	Window      time.Duration
+}
+
+var (
+	// EmailThrottlePolicy protects a single account from password guessing
+	EmailThrottlePolicy = ThrottlePolicy{
+		Kind:        "email",
+		Threshold:   5,
+		BaseLockout: time.Minute,
+		MaxLockout:  time.Hour,
+		Window:      time.Hour,
+	}
+
+	// IPThrottlePolicy slows down a single client trying many accounts, it is
+	// more lenient since addresses can be shared
+	IPThrottlePolicy = ThrottlePolicy{
+		Kind:        "ip",
+		Threshold:   20,
+		BaseLockout: time.Minute,
+		MaxLockout:  time.Hour,
+		Window:      time.Hour,
+	}
+)
+
+func (p ThrottlePolicy) lockout(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+
+	lockout := p.BaseLockout
+	for i := p.Threshold; i < failures && lockout < p.MaxLockout; i++ {
+		lockout *= 2
+	}
+
+	return min(lockout, p.MaxLockout)
+}
+
+func normalizeThrottleKey(key string) string {
+	return strings.ToLower(strings.TrimSpace(key))
+}
+
+// GetLoginLockout returns until when the key is locked out, or the zero time
+// if it isn't
+func GetLoginLockout(db *sql.DB, policy ThrottlePolicy, key string) (time.Time, error) {
+	var lockedUntil sql.NullTime
+	err := db.QueryRow(
+		`SELECT locked_until FROM login_throttles WHERE kind = ? AND key = ?`,
+		policy.Kind,
+		normalizeThrottleKey(key),
+	).Scan(&lockedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+
+	if !lockedUntil.Valid || time.Now().After(lockedUntil.Time) {
+		return time.Time{}, nil
+	}
+
+	return lockedUntil.Time, nil
+}
+
+// RecordLoginFailure counts a failed attempt for the key and returns the new
+// lockout, or the zero time if the key isn't locked yet
+func RecordLoginFailure(db *sql.DB, policy ThrottlePolicy, key string) (time.Time, error) {
+	now := time.Now().UTC()
+
+	var failures int
+	err := db.QueryRow(
+		`INSERT INTO login_throttles (kind, key, failures, last_failure_at)
+		VALUES (?, ?, 1, ?)
+		ON CONFLICT (kind, key) DO UPDATE SET
+			failures = CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END,
+			last_failure_at = excluded.last_failure_at
+		RETURNING failures`,
+		policy.Kind,
+		normalizeThrottleKey(key),
+		now,
+		now.Add(-policy.Window),
+	).Scan(&failures)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	lockout := policy.lockout(failures)
+	if lockout == 0 {
+		return time.Time{}, nil
+	}
+
+	lockedUntil := now.Add(lockout)
+	_, err = db.Exec(
+		`UPDATE login_throttles SET locked_until = ? WHERE kind = ? AND key = ?`,
+		lockedUntil,
+		policy.Kind,
+		normalizeThrottleKey(key),
+	)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return lockedUntil, nil
+}
+
+// ClearLoginFailures forgets the failures of a key after a successful login
+func ClearLoginFailures(db *sql.DB, policy ThrottlePolicy, key string) error {
+	_, err := db.Exec(
+		`DELETE FROM login_throttles WHERE kind = ? AND key = ?`,
+		policy.Kind,
+		normalizeThrottleKey(key),
+	)
+	return err
+}
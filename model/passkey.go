@@ -0,0 +1,169 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrPasskeyNotFound = errors.New("passkey not found")
+)
+
+type Passkey struct {
+	ID           int64  `db:"id"`
+	UserID       int64  `db:"user_id"`
+	Name         string `db:"name"`
+	CredentialID []byte `db:"credential_id"`
+	// Credential is the json encoded webauthn credential, including the public
+	// key and signature counter
+	Credential []byte       `db:"credential"`
+	LastUsedAt sql.NullTime `db:"last_used_at"`
+	CreatedAt  time.Time    `db:"created_at"`
+}
+
+type CreatePasskeyInput struct {
+	Name string `form:"name" validate:"required,min=1,max=100"`
+}
+
+// CreatePasskey stores a newly registered credential for the user
+func CreatePasskey(db *sql.DB, userID int64, name string, credentialID, credential []byte) error {
+	_, err := db.Exec(
+		`INSERT INTO passkeys (user_id, name, credential_id, credential) VALUES (?, ?, ?, ?)`,
+		userID,
+		name,
+		credentialID,
+		string(credential),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert passkey: %w", err)
+	}
+
+	return nil
+}
+
+func scanPasskey(scanner interface{ Scan(...any) error }) (Passkey, error) {
+	var passkey Passkey
+	var credential string
+	err := scanner.Scan(
+		&passkey.ID,
+		&passkey.UserID,
+		&passkey.Name,
+		&passkey.CredentialID,
+		&credential,
+		&passkey.LastUsedAt,
+		&passkey.CreatedAt,
+	)
+	passkey.Credential = []byte(credential)
+	return passkey, err
+}
+
+// GetPasskeysByUserID gets all passkeys of a user, oldest first
+func GetPasskeysByUserID(db *sql.DB, userID int64) ([]Passkey, error) {
+	query := `
+		SELECT id, user_id, name, credential_id, credential, last_used_at, created_at
+		FROM passkeys
+		WHERE user_id = ?
+		ORDER BY created_at, id
+	`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passkeys []Passkey
+	for rows.Next() {
+		passkey, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, passkey)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return passkeys, nil
+}
+
+// GetPasskeyByCredentialID gets a passkey using the id the authenticator
+// reported
+func GetPasskeyByCredentialID(db *sql.DB, credentialID []byte) (*Passkey, error) {
+	query := `
+		SELECT id, user_id, name, credential_id, credential, last_used_at, created_at
+		FROM passkeys WHERE credential_id = ? LIMIT 1
+	`
+	passkey, err := scanPasskey(db.QueryRow(query, credentialID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPasskeyNotFound
+		}
+		return nil, err
+	}
+
+	return &passkey, nil
+}
+
+// UpdatePasskeyCredential saves the credential after a successful login, so
+// the new signature counter is used to detect cloned authenticators.
+func UpdatePasskeyCredential(db *sql.DB, credentialID, credential []byte) error {
+	_, err := db.Exec(
+		`UPDATE passkeys SET credential = ?, last_used_at = ? WHERE credential_id = ?`,
+		string(credential),
+		time.Now().UTC(),
+		credentialID,
+	)
+	return err
+}
+
+// DeletePasskey removes a passkey owned by the user
+func DeletePasskey(db *sql.DB, id, userID int64) error {
+	result, err := db.Exec(`DELETE FROM passkeys WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrPasskeyNotFound
+	}
+
+	return nil
+}
+
+// RequiresPasskeySecondFactor reports whether the user asked for a passkey
+// after their password
+func RequiresPasskeySecondFactor(db *sql.DB, userID int64) (bool, error) {
+	var required bool
+	err := db.QueryRow(`SELECT passkey_second_factor FROM users WHERE id = ?`, userID).Scan(&required)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrUserNotFound
+		}
+		return false, err
+	}
+
+	return required, nil
+}
+
+// SetPasskeySecondFactor turns asking for a passkey after the password on or
+// off
+func SetPasskeySecondFactor(db *sql.DB, userID int64, required bool) error {
+	_, err := db.Exec(
+		`UPDATE users SET passkey_second_factor = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		required,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update passkey second factor: %w", err)
+	}
+
+	return nil
+}
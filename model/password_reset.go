@@ -0,0 +1,169 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrPasswordResetInvalid = errors.New("password reset token is invalid or expired")
+)
+
+// PasswordResetTTL is how long a reset link stays valid
+const PasswordResetTTL = time.Hour
+
+type PasswordReset struct {
+	ID        int64        `db:"id"`
+	UserID    int64        `db:"user_id"`
+	TokenHash string       `db:"token_hash"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+	CreatedAt time.Time    `db:"created_at"`
+}
+
+type ForgotPasswordInput struct {
+	Email string `form:"email" validate:"required,email,max=100"`
+}
+
+type ResetPasswordInput struct {
+	Token           string `form:"token" validate:"required"`
+	Password        string `form:"password" validate:"required,min=8"`
+	PasswordConfirm string `form:"password_confirm" validate:"required,eqfield=Password"`
+}
+
+// CreatePasswordReset issues a new reset token for the user and invalidates
+// any earlier ones, only the hash is stored so the plaintext is returned once.
+func CreatePasswordReset(db *sql.DB, userID int64) (string, error) {
+	token, err := generateToken("")
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	_, err = tx.Exec(
+		`UPDATE password_resets SET used_at = ? WHERE user_id = ? AND used_at IS NULL`,
+		now,
+		userID,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to invalidate password resets: %w", err)
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES (?, ?, ?)`,
+		userID,
+		hashToken(token),
+		now.Add(PasswordResetTTL),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to insert password reset: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// GetValidPasswordReset looks up an unused and unexpired reset by its
+// plaintext token.
+func GetValidPasswordReset(db *sql.DB, token string) (*PasswordReset, error) {
+	query := `
+		SELECT id, user_id, token_hash, expires_at, used_at, created_at
+		FROM password_resets WHERE token_hash = ? LIMIT 1
+	`
+
+	var reset PasswordReset
+	err := db.QueryRow(query, hashToken(token)).Scan(
+		&reset.ID,
+		&reset.UserID,
+		&reset.TokenHash,
+		&reset.ExpiresAt,
+		&reset.UsedAt,
+		&reset.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPasswordResetInvalid
+		}
+		return nil, err
+	}
+
+	if reset.UsedAt.Valid || time.Now().After(reset.ExpiresAt) {
+		return nil, ErrPasswordResetInvalid
+	}
+
+	return &reset, nil
+}
+
+// ResetPassword consumes the reset token and sets the new password, the token
+// is marked as used in the same statement that checks it so it can only be
+// redeemed once.
+func ResetPassword(db *sql.DB, input ResetPasswordInput) (int64, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	var userID int64
+	err = tx.QueryRow(
+		`UPDATE password_resets SET used_at = ?
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING user_id`,
+		now,
+		hashToken(input.Token),
+		now,
+	).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrPasswordResetInvalid
+		}
+		return 0, err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE users SET password = ?, updated_at = ? WHERE id = ?`,
+		hashedPassword,
+		now,
+		userID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update password: %w", err)
+	}
+
+	// any other outstanding links for this user are no longer needed
+	_, err = tx.Exec(
+		`UPDATE password_resets SET used_at = ? WHERE user_id = ? AND used_at IS NULL`,
+		now,
+		userID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to invalidate password resets: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
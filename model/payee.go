@@ -0,0 +1,588 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrPayeeNotFound      = errors.New("payee not found")
+	ErrPayeeExists        = errors.New("payee with that name already exists")
+	ErrPayeeAliasNotFound = errors.New("payee alias not found")
+	ErrPayeeAliasExists   = errors.New("payee already has that alias")
+	ErrPayeeMergeSelf     = errors.New("payee can not be merged into itself")
+)
+
+// Payee is the canonical name behind the raw descriptions of transactions.
+// Payees belong to the account owner, transactions on their accounts whose
+// description matches one of the payee's aliases are linked to it.
+type Payee struct {
+	ID              int64     `db:"id"`
+	UserID          int64     `db:"user_id"`
+	Name            string    `db:"name"`
+	DefaultCategory string    `db:"default_category"`
+	CreatedAt       time.Time `db:"created_at"`
+	// TransactionCount and AliasCount are only set by GetPayeesByUserID
+	TransactionCount int `db:"transaction_count"`
+	AliasCount       int `db:"alias_count"`
+}
+
+type PayeeInput struct {
+	Name            string `form:"name" validate:"required,max=60"`
+	DefaultCategory string `form:"defaultcategory" validate:"max=50"`
+}
+
+// PayeeAlias maps raw descriptions to a payee. Patterns are matched ignoring
+// case, * stands for any text and ? for a single character.
+type PayeeAlias struct {
+	ID        int64     `db:"id"`
+	PayeeID   int64     `db:"payee_id"`
+	Pattern   string    `db:"pattern"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type PayeeAliasInput struct {
+	Pattern string `form:"pattern" validate:"required,max=100"`
+}
+
+// String returns the pattern the way the user typed it, uppercased. Every
+// bracket in the stored glob opens a one character class, [[] for a bracket
+// the user typed or [*] and [?] for wildcards taken literally from a payee
+// name, so each class is shown as its character.
+func (a PayeeAlias) String() string {
+	var b strings.Builder
+	for i := 0; i < len(a.Pattern); i++ {
+		if a.Pattern[i] == '[' && i+2 < len(a.Pattern) && a.Pattern[i+2] == ']' {
+			b.WriteByte(a.Pattern[i+1])
+			i += 2
+			continue
+		}
+		b.WriteByte(a.Pattern[i])
+	}
+	return b.String()
+}
+
+// payeeAliasPattern turns what the user typed into the glob stored for the
+// alias. Brackets are escaped since only * and ? are wildcards to the user.
+func payeeAliasPattern(pattern string) string {
+	return strings.ReplaceAll(strings.ToUpper(strings.TrimSpace(pattern)), "[", "[[]")
+}
+
+// payeeNamePattern is the glob matching a payee name exactly, the name is
+// escaped in one pass so wildcards in it are taken literally
+func payeeNamePattern(name string) string {
+	return strings.NewReplacer("[", "[[]", "*", "[*]", "?", "[?]").Replace(strings.ToUpper(strings.TrimSpace(name)))
+}
+
+// PayeeMonth is what was spent with a payee in one month and currency
+type PayeeMonth struct {
+	Month    time.Time       `db:"month"`
+	Currency Currency        `db:"currency"`
+	Count    int             `db:"count"`
+	Total    decimal.Decimal `db:"total"`
+}
+
+// PayeeTransaction is a transaction linked to a payee along with the account
+// it was made on
+type PayeeTransaction struct {
+	TransactionView
+	AccountName string
+}
+
+// GetPayeesByUserID gets the user's payees by name, with how many aliases and
+// transactions each of them has
+func GetPayeesByUserID(db *sql.DB, userID int64) ([]Payee, error) {
+	rows, err := db.Query(
+		`SELECT
+			p.id, p.user_id, p.name, p.default_category, p.created_at,
+			(SELECT COUNT(*) FROM transactions t WHERE t.payee_id = p.id),
+			(SELECT COUNT(*) FROM payee_aliases pa WHERE pa.payee_id = p.id)
+		FROM payees p
+		WHERE p.user_id = ?
+		ORDER BY p.name COLLATE NOCASE`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payees := []Payee{}
+	for rows.Next() {
+		var payee Payee
+		err := rows.Scan(
+			&payee.ID,
+			&payee.UserID,
+			&payee.Name,
+			&payee.DefaultCategory,
+			&payee.CreatedAt,
+			&payee.TransactionCount,
+			&payee.AliasCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		payees = append(payees, payee)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return payees, nil
+}
+
+// GetPayeeForUser gets one of the user's payees
+func GetPayeeForUser(db *sql.DB, id, userID int64) (*Payee, error) {
+	var payee Payee
+	err := db.QueryRow(
+		`SELECT id, user_id, name, default_category, created_at FROM payees WHERE id = ? AND user_id = ?`,
+		id,
+		userID,
+	).Scan(&payee.ID, &payee.UserID, &payee.Name, &payee.DefaultCategory, &payee.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPayeeNotFound
+		}
+		return nil, err
+	}
+
+	return &payee, nil
+}
+
+func payeeNameTaken(db *sql.DB, userID int64, name string, exceptID int64) (bool, error) {
+	var count int
+	err := db.QueryRow(
+		`SELECT COUNT(*) FROM payees WHERE user_id = ? AND name = ? COLLATE NOCASE AND id != ?`,
+		userID,
+		name,
+		exceptID,
+	).Scan(&count)
+	return count > 0, err
+}
+
+// CreatePayee creates a payee with its own name as the first alias and links
+// the user's transactions described that way to it. It returns the payee's
+// id and how many transactions were linked.
+func CreatePayee(db *sql.DB, userID int64, input PayeeInput) (int64, int64, error) {
+	taken, err := payeeNameTaken(db, userID, input.Name, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	if taken {
+		return 0, 0, ErrPayeeExists
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var payeeID int64
+	err = tx.QueryRow(
+		`INSERT INTO payees (user_id, name, default_category) VALUES (?, ?, ?) RETURNING id`,
+		userID,
+		input.Name,
+		input.DefaultCategory,
+	).Scan(&payeeID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to insert payee: %w", err)
+	}
+
+	pattern := payeeNamePattern(input.Name)
+	if _, err := tx.Exec(`INSERT INTO payee_aliases (payee_id, pattern) VALUES (?, ?)`, payeeID, pattern); err != nil {
+		return 0, 0, fmt.Errorf("failed to insert payee alias: %w", err)
+	}
+
+	linked, err := linkPayeeTransactions(tx, payeeID, userID, pattern)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := fillPayeeCategory(tx, payeeID, input.DefaultCategory); err != nil {
+		return 0, 0, err
+	}
+
+	return payeeID, linked, tx.Commit()
+}
+
+// UpdatePayee renames the payee and sets its default category, which fills
+// in the category of its uncategorized transactions
+func UpdatePayee(db *sql.DB, payee *Payee, input PayeeInput) error {
+	taken, err := payeeNameTaken(db, payee.UserID, input.Name, payee.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrPayeeExists
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`UPDATE payees SET name = ?, default_category = ? WHERE id = ? AND user_id = ?`,
+		input.Name,
+		input.DefaultCategory,
+		payee.ID,
+		payee.UserID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update payee: %w", err)
+	}
+
+	if err := fillPayeeCategory(tx, payee.ID, input.DefaultCategory); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeletePayee deletes the payee and its aliases, its transactions keep their
+// descriptions and categories
+func DeletePayee(db *sql.DB, payee *Payee) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE transactions SET payee_id = NULL WHERE payee_id = ?`, payee.ID); err != nil {
+		return fmt.Errorf("failed to unlink transactions: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM payee_aliases WHERE payee_id = ?`, payee.ID); err != nil {
+		return fmt.Errorf("failed to delete payee aliases: %w", err)
+	}
+
+	result, err := tx.Exec(`DELETE FROM payees WHERE id = ? AND user_id = ?`, payee.ID, payee.UserID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrPayeeNotFound
+	}
+
+	return tx.Commit()
+}
+
+func GetPayeeAliases(db *sql.DB, payeeID int64) ([]PayeeAlias, error) {
+	rows, err := db.Query(
+		`SELECT id, payee_id, pattern, created_at FROM payee_aliases WHERE payee_id = ? ORDER BY pattern`,
+		payeeID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := []PayeeAlias{}
+	for rows.Next() {
+		var alias PayeeAlias
+		if err := rows.Scan(&alias.ID, &alias.PayeeID, &alias.Pattern, &alias.CreatedAt); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return aliases, nil
+}
+
+// AddPayeeAlias adds an alias to the payee and links the user's transactions
+// it matches that have no payee yet, returning how many were linked
+func AddPayeeAlias(db *sql.DB, payee *Payee, input PayeeAliasInput) (int64, error) {
+	pattern := payeeAliasPattern(input.Pattern)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`INSERT INTO payee_aliases (payee_id, pattern) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+		payee.ID,
+		pattern,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert payee alias: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if rowsAffected == 0 {
+		return 0, ErrPayeeAliasExists
+	}
+
+	linked, err := linkPayeeTransactions(tx, payee.ID, payee.UserID, pattern)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := fillPayeeCategory(tx, payee.ID, payee.DefaultCategory); err != nil {
+		return 0, err
+	}
+
+	return linked, tx.Commit()
+}
+
+// DeletePayeeAlias removes the alias, transactions it already linked stay
+// with the payee
+func DeletePayeeAlias(db *sql.DB, payeeID, aliasID int64) error {
+	result, err := db.Exec(`DELETE FROM payee_aliases WHERE id = ? AND payee_id = ?`, aliasID, payeeID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrPayeeAliasNotFound
+	}
+
+	return nil
+}
+
+// MergePayees folds source into target: the source's transactions and
+// aliases move over, its name becomes an alias of the target and the source
+// is deleted. It returns how many transactions moved.
+func MergePayees(db *sql.DB, source, target *Payee) (int64, error) {
+	if source.ID == target.ID {
+		return 0, ErrPayeeMergeSelf
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE transactions SET payee_id = ? WHERE payee_id = ?`, target.ID, source.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to move transactions: %w", err)
+	}
+
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO payee_aliases (payee_id, pattern)
+		SELECT ?, pattern FROM payee_aliases WHERE payee_id = ?
+		UNION SELECT ?, ?
+		ON CONFLICT DO NOTHING`,
+		target.ID,
+		source.ID,
+		target.ID,
+		payeeNamePattern(source.Name),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to move payee aliases: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM payee_aliases WHERE payee_id = ?`, source.ID); err != nil {
+		return 0, fmt.Errorf("failed to delete payee aliases: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM payees WHERE id = ? AND user_id = ?`, source.ID, source.UserID); err != nil {
+		return 0, fmt.Errorf("failed to delete payee: %w", err)
+	}
+
+	if err := fillPayeeCategory(tx, target.ID, target.DefaultCategory); err != nil {
+		return 0, err
+	}
+
+	return moved, tx.Commit()
+}
+
+// linkPayeeTransactions links the transactions on the user's accounts whose
+// description matches the pattern and that have no payee yet
+func linkPayeeTransactions(tx *sql.Tx, payeeID, userID int64, pattern string) (int64, error) {
+	result, err := tx.Exec(
+		`UPDATE transactions SET payee_id = ?
+		WHERE payee_id IS NULL
+			AND account_id IN (SELECT id FROM accounts WHERE user_id = ?)
+			AND UPPER(payee) GLOB ?`,
+		payeeID,
+		userID,
+		pattern,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to link transactions: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// fillPayeeCategory gives the payee's uncategorized transactions its default
+// category, split transactions are categorized by their lines instead
+func fillPayeeCategory(tx *sql.Tx, payeeID int64, category string) error {
+	if category == "" {
+		return nil
+	}
+
+	_, err := tx.Exec(
+		`UPDATE transactions SET category = ?
+		WHERE payee_id = ? AND category = ''
+			AND id NOT IN (SELECT transaction_id FROM transaction_splits)`,
+		category,
+		payeeID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to categorize transactions: %w", err)
+	}
+
+	return nil
+}
+
+// payeeMatch is the payee a new transaction's description resolves to
+type payeeMatch struct {
+	ID              sql.NullInt64
+	DefaultCategory string
+}
+
+// category is the transaction's own category, or the payee's default one
+// when it came without
+func (m payeeMatch) category(category string) string {
+	if category == "" {
+		return m.DefaultCategory
+	}
+	return category
+}
+
+// matchPayee resolves a new transaction's description through the aliases of
+// the account owner's payees, the longest pattern being the most specific
+func matchPayee(tx *sql.Tx, accountID int64, description string) (payeeMatch, error) {
+	var match payeeMatch
+	err := tx.QueryRow(
+		`SELECT p.id, p.default_category
+		FROM payee_aliases pa
+		JOIN payees p ON p.id = pa.payee_id
+		JOIN accounts a ON a.user_id = p.user_id
+		WHERE a.id = ? AND UPPER(?) GLOB pa.pattern
+		ORDER BY LENGTH(pa.pattern) DESC, pa.id
+		LIMIT 1`,
+		accountID,
+		description,
+	).Scan(&match.ID, &match.DefaultCategory)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return match, fmt.Errorf("failed to match payee: %w", err)
+	}
+
+	return match, nil
+}
+
+// GetPayeeMonths sums the payee's transactions per month and currency over
+// the given number of months, newest first
+func GetPayeeMonths(db *sql.DB, payee *Payee, months int, now time.Time) ([]PayeeMonth, error) {
+	since := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1-months, 0)
+
+	rows, err := db.Query(
+		`SELECT strftime('%Y-%m', t.occurred_at) AS month, a.currency, COUNT(*), SUM(t.amount)
+		FROM transactions t
+		JOIN accounts a ON a.id = t.account_id AND a.is_active = 1
+		WHERE t.payee_id = ? AND t.occurred_at >= ?
+		GROUP BY month, a.currency
+		ORDER BY month DESC, a.currency`,
+		payee.ID,
+		since.Format("2006-01-02"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payeeMonths := []PayeeMonth{}
+	for rows.Next() {
+		var month string
+		var payeeMonth PayeeMonth
+		err := rows.Scan(&month, &payeeMonth.Currency, &payeeMonth.Count, &payeeMonth.Total)
+		if err != nil {
+			return nil, err
+		}
+		payeeMonth.Month, err = time.Parse("2006-01", month)
+		if err != nil {
+			return nil, err
+		}
+		payeeMonths = append(payeeMonths, payeeMonth)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return payeeMonths, nil
+}
+
+// GetPayeeTransactions gets the payee's most recent transactions on active
+// accounts
+func GetPayeeTransactions(db *sql.DB, payee *Payee, limit int) ([]PayeeTransaction, error) {
+	rows, err := db.Query(
+		`SELECT t.id, t.account_id, a.name, a.currency, t.amount, t.payee, t.category, t.notes, t.occurred_at
+		FROM transactions t
+		JOIN accounts a ON a.id = t.account_id AND a.is_active = 1
+		WHERE t.payee_id = ?
+		ORDER BY t.occurred_at DESC, t.id DESC
+		LIMIT ?`,
+		payee.ID,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []PayeeTransaction{}
+	for rows.Next() {
+		var transaction PayeeTransaction
+		err := rows.Scan(
+			&transaction.ID,
+			&transaction.AccountID,
+			&transaction.AccountName,
+			&transaction.Currency,
+			&transaction.Amount,
+			&transaction.Payee,
+			&transaction.Category,
+			&transaction.Notes,
+			&transaction.OccurredAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		transaction.PayeeID = payee.ID
+		transaction.PayeeName = payee.Name
+		transactions = append(transactions, transaction)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
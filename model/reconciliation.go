@@ -0,0 +1,393 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrReconciliationNotFound   = errors.New("reconciliation not found")
+	ErrReconciliationInProgress = errors.New("account already has a reconciliation in progress")
+	ErrReconciliationUnbalanced = errors.New("cleared balance does not match the statement")
+)
+
+// MaxReconciliationAdjustment is the largest difference, in the account's
+// currency, that may be closed with an automatic adjustment entry
+var MaxReconciliationAdjustment = decimal.NewFromInt(10)
+
+// ReconciliationAdjustmentPayee names the entries created to close a small
+// difference
+const ReconciliationAdjustmentPayee = "Reconciliation adjustment"
+
+// Reconciliation matches an account's cleared transactions against a bank
+// statement
+type Reconciliation struct {
+	ID               int64           `db:"id"`
+	AccountID        int64           `db:"account_id"`
+	UserID           int64           `db:"user_id"`
+	StatementDate    time.Time       `db:"statement_date"`
+	StatementBalance decimal.Decimal `db:"statement_balance"`
+	Adjustment       decimal.Decimal `db:"adjustment"`
+	CompletedAt      sql.NullTime    `db:"completed_at"`
+	CreatedAt        time.Time       `db:"created_at"`
+}
+
+type StartReconciliationInput struct {
+	StatementDate    string `form:"statement_date" validate:"required,datetime=2006-01-02"`
+	StatementBalance string `form:"statement_balance" validate:"required,numeric"`
+}
+
+// ReconciliationSummary compares what the user ticked off with the statement
+type ReconciliationSummary struct {
+	StatementBalance decimal.Decimal
+	ClearedBalance   decimal.Decimal
+	Difference       decimal.Decimal
+	ClearedCount     int
+	Currency         Currency
+}
+
+// IsBalanced reports whether the cleared balance matches the statement
+func (s *ReconciliationSummary) IsBalanced() bool {
+	return s.Difference.IsZero()
+}
+
+// CanAdjust reports whether the remaining difference is small enough to be
+// closed with an adjustment entry
+func (s *ReconciliationSummary) CanAdjust() bool {
+	return !s.IsBalanced() && s.Difference.Abs().LessThanOrEqual(MaxReconciliationAdjustment)
+}
+
+// ReconciliationTransaction is a transaction waiting to be reconciled
+type ReconciliationTransaction struct {
+	TransactionView
+	Cleared bool
+}
+
+// StartReconciliation opens a reconciliation of the account against a
+// statement, failing with ErrReconciliationInProgress when one is open
+func StartReconciliation(db *sql.DB, accountID, userID int64, input StartReconciliationInput) (int64, error) {
+	statementBalance, err := decimal.NewFromString(input.StatementBalance)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRow(
+		`SELECT COUNT(*) FROM reconciliations WHERE account_id = ? AND completed_at IS NULL`,
+		accountID,
+	).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, ErrReconciliationInProgress
+	}
+
+	result, err := tx.Exec(
+		`INSERT INTO reconciliations (account_id, user_id, statement_date, statement_balance)
+		VALUES (?, ?, ?, ?)`,
+		accountID,
+		userID,
+		input.StatementDate,
+		statementBalance,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert reconciliation: %w", err)
+	}
+
+	// ticks left over from a cancelled attempt don't carry over
+	_, err = tx.Exec(
+		`UPDATE transactions SET cleared = 0 WHERE account_id = ? AND reconciliation_id IS NULL`,
+		accountID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// GetOpenReconciliation gets the reconciliation in progress on the account
+func GetOpenReconciliation(db *sql.DB, accountID int64) (*Reconciliation, error) {
+	var reconciliation Reconciliation
+	err := db.QueryRow(
+		`SELECT
+			id, account_id, user_id, statement_date, statement_balance,
+			adjustment, completed_at, created_at
+		FROM reconciliations
+		WHERE account_id = ? AND completed_at IS NULL`,
+		accountID,
+	).Scan(
+		&reconciliation.ID,
+		&reconciliation.AccountID,
+		&reconciliation.UserID,
+		&reconciliation.StatementDate,
+		&reconciliation.StatementBalance,
+		&reconciliation.Adjustment,
+		&reconciliation.CompletedAt,
+		&reconciliation.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrReconciliationNotFound
+		}
+		return nil, err
+	}
+
+	return &reconciliation, nil
+}
+
+// statementCutoff is the first day after the statement, transactions before
+// it are covered by the statement
+func (r *Reconciliation) statementCutoff() string {
+	return r.StatementDate.AddDate(0, 0, 1).Format("2006-01-02")
+}
+
+// GetReconciliationTransactions gets the account's unreconciled transactions
+// up to the statement date, oldest first like on a statement
+func GetReconciliationTransactions(db *sql.DB, account *Account, reconciliation *Reconciliation) ([]ReconciliationTransaction, error) {
+	rows, err := db.Query(
+		`SELECT id, amount, payee, category, notes, occurred_at, cleared
+		FROM transactions
+		WHERE account_id = ? AND reconciliation_id IS NULL AND occurred_at < ?
+		ORDER BY occurred_at, id`,
+		account.ID,
+		reconciliation.statementCutoff(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []ReconciliationTransaction{}
+	for rows.Next() {
+		transaction := ReconciliationTransaction{
+			TransactionView: TransactionView{Currency: account.Currency},
+		}
+		err := rows.Scan(
+			&transaction.ID,
+			&transaction.Amount,
+			&transaction.Payee,
+			&transaction.Category,
+			&transaction.Notes,
+			&transaction.OccurredAt,
+			&transaction.Cleared,
+		)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// SetTransactionCleared ticks a transaction off the statement or back on,
+// only unreconciled transactions covered by the statement can be changed
+func SetTransactionCleared(db *sql.DB, reconciliation *Reconciliation, transactionID int64, cleared bool) error {
+	result, err := db.Exec(
+		`UPDATE transactions SET cleared = ?
+		WHERE id = ? AND account_id = ? AND reconciliation_id IS NULL AND occurred_at < ?`,
+		cleared,
+		transactionID,
+		reconciliation.AccountID,
+		reconciliation.statementCutoff(),
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTransactionNotFound
+	}
+
+	return nil
+}
+
+// GetReconciliationSummary works out the cleared balance and how far it is
+// from the statement
+func GetReconciliationSummary(db *sql.DB, account *Account, reconciliation *Reconciliation) (ReconciliationSummary, error) {
+	return reconciliationSummary(db, account, reconciliation)
+}
+
+// reconciliationSummary takes the balance before the account's first
+// transaction, worked back from its current balance, and adds everything
+// already reconciled or cleared since
+func reconciliationSummary(q interface {
+	QueryRow(string, ...any) *sql.Row
+}, account *Account, reconciliation *Reconciliation) (ReconciliationSummary, error) {
+	summary := ReconciliationSummary{
+		StatementBalance: reconciliation.StatementBalance,
+		Currency:         account.Currency,
+	}
+
+	var total, cleared decimal.Decimal
+	err := q.QueryRow(
+		`SELECT
+			COALESCE(SUM(amount), 0),
+			COALESCE(SUM(CASE WHEN reconciliation_id IS NOT NULL OR (cleared = 1 AND occurred_at < ?) THEN amount END), 0),
+			COUNT(CASE WHEN reconciliation_id IS NULL AND cleared = 1 AND occurred_at < ? THEN 1 END)
+		FROM transactions
+		WHERE account_id = ?`,
+		reconciliation.statementCutoff(),
+		reconciliation.statementCutoff(),
+		account.ID,
+	).Scan(&total, &cleared, &summary.ClearedCount)
+	if err != nil {
+		return summary, err
+	}
+
+	decimals := account.Currency.Decimals()
+	summary.ClearedBalance = account.Balance.Sub(total).Add(cleared).Round(decimals)
+	summary.Difference = summary.StatementBalance.Sub(summary.ClearedBalance).Round(decimals)
+
+	return summary, nil
+}
+
+// CompleteReconciliation locks the cleared transactions as reconciled. When
+// the cleared balance is off by no more than MaxReconciliationAdjustment and
+// adjust is set, an adjustment entry closing the difference is added on the
+// statement date and the account balance moves with it, any other difference
+// fails with ErrReconciliationUnbalanced.
+func CompleteReconciliation(db *sql.DB, actor Actor, account *Account, reconciliation *Reconciliation, adjust bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	summary, err := reconciliationSummary(tx, account, reconciliation)
+	if err != nil {
+		return err
+	}
+
+	if !summary.IsBalanced() {
+		if !adjust || !summary.CanAdjust() {
+			return ErrReconciliationUnbalanced
+		}
+
+		payee, err := matchPayee(tx, account.ID, ReconciliationAdjustmentPayee)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			`INSERT INTO transactions (account_id, user_id, amount, payee, payee_id, category, occurred_at, cleared)
+			VALUES (?, ?, ?, ?, ?, 'Adjustment', ?, 1)`,
+			account.ID,
+			account.UserID,
+			summary.Difference,
+			ReconciliationAdjustmentPayee,
+			payee.ID,
+			reconciliation.StatementDate.Format("2006-01-02"),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert adjustment: %w", err)
+		}
+
+		_, err = tx.Exec(
+			`UPDATE accounts SET balance = balance + ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+			summary.Difference,
+			account.ID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to adjust balance: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(
+		`UPDATE transactions SET reconciliation_id = ?
+		WHERE account_id = ? AND reconciliation_id IS NULL AND cleared = 1 AND occurred_at < ?`,
+		reconciliation.ID,
+		account.ID,
+		reconciliation.statementCutoff(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to lock transactions: %w", err)
+	}
+
+	_, err = tx.Exec(
+		`UPDATE reconciliations SET adjustment = ?, completed_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		summary.Difference,
+		reconciliation.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	changes := AuditChanges{
+		"statement_date":    {After: reconciliation.StatementDate.Format("2006-01-02")},
+		"statement_balance": {After: reconciliation.StatementBalance},
+	}
+	if !summary.IsBalanced() {
+		changes["balance"] = AuditChange{
+			Before: account.Balance,
+			After:  account.Balance.Add(summary.Difference),
+		}
+	}
+
+	err = recordAudit(tx, actor, auditEntry{
+		Action:     AuditAccountReconciled,
+		EntityType: AuditEntityAccount,
+		EntityID:   account.ID,
+		AccountID:  account.ID,
+		Changes:    changes,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CancelReconciliation abandons the reconciliation in progress and clears the
+// ticks made during it
+func CancelReconciliation(db *sql.DB, reconciliation *Reconciliation) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`DELETE FROM reconciliations WHERE id = ? AND completed_at IS NULL`,
+		reconciliation.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE transactions SET cleared = 0 WHERE account_id = ? AND reconciliation_id IS NULL`,
+		reconciliation.AccountID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,375 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrSavingsGoalNotFound             = errors.New("savings goal not found")
+	ErrSavingsGoalContributionNotFound = errors.New("savings goal contribution not found")
+)
+
+// SavingsGoal is an amount the user wants to have saved by a date. Progress
+// follows the linked account's balance when there is one, or the money
+// earmarked through contributions otherwise.
+type SavingsGoal struct {
+	ID           int64           `db:"id"`
+	UserID       int64           `db:"user_id"`
+	Name         string          `db:"name"`
+	TargetAmount decimal.Decimal `db:"target_amount"`
+	Currency     Currency        `db:"currency"`
+	TargetDate   time.Time       `db:"target_date"`
+	AccountID    sql.NullInt64   `db:"account_id"`
+	// Linked is set while the linked account is active and visible to the
+	// user, the Account fields describe it
+	Linked          bool            `db:"linked"`
+	AccountName     string          `db:"account_name"`
+	AccountBalance  decimal.Decimal `db:"account_balance"`
+	AccountCurrency Currency        `db:"account_currency"`
+	// Contributed is the sum of the goal's contributions, in its currency
+	Contributed decimal.Decimal `db:"contributed"`
+	CreatedAt   time.Time       `db:"created_at"`
+	UpdatedAt   time.Time       `db:"updated_at"`
+}
+
+type SavingsGoalContribution struct {
+	ID            int64           `db:"id"`
+	GoalID        int64           `db:"goal_id"`
+	Amount        decimal.Decimal `db:"amount"`
+	Note          string          `db:"note"`
+	ContributedAt time.Time       `db:"contributed_at"`
+	CreatedAt     time.Time       `db:"created_at"`
+}
+
+type SavingsGoalInput struct {
+	Name         string   `form:"name" validate:"required,min=1,max=100"`
+	TargetAmount string   `form:"target_amount" validate:"required,numeric"`
+	Currency     Currency `form:"currency" validate:"required,oneof=EUR USD RSD GBP JPY CHF"`
+	TargetDate   string   `form:"target_date" validate:"required,datetime=2006-01-02"`
+	AccountID    int64    `form:"account_id" validate:"gte=0"`
+}
+
+type SavingsGoalContributionInput struct {
+	Amount        string `form:"amount" validate:"required,numeric"`
+	Note          string `form:"note" validate:"max=200"`
+	ContributedAt string `form:"contributed_at" validate:"required,datetime=2006-01-02"`
+}
+
+type SavingsGoalView struct {
+	ID           int64
+	Name         string
+	TargetAmount decimal.Decimal
+	Currency     Currency
+	TargetDate   time.Time
+	AccountID    int64
+	AccountName  string
+	Linked       bool
+	// Saved is left unset when the linked account's balance could not be
+	// converted into the goal's currency
+	Saved           decimal.Decimal
+	HasSaved        bool
+	Remaining       decimal.Decimal
+	MonthlyRequired decimal.Decimal
+	MonthsLeft      int
+}
+
+// ToView works out the goal's progress from the amount saved so far, in the
+// goal's currency
+func (g *SavingsGoal) ToView(saved decimal.Decimal, hasSaved bool, now time.Time) SavingsGoalView {
+	gv := SavingsGoalView{
+		ID:           g.ID,
+		Name:         g.Name,
+		TargetAmount: g.TargetAmount,
+		Currency:     g.Currency,
+		TargetDate:   g.TargetDate,
+		AccountID:    g.AccountID.Int64,
+		AccountName:  g.AccountName,
+		Linked:       g.Linked,
+		MonthsLeft:   monthsUntil(now, g.TargetDate),
+	}
+	if !hasSaved {
+		return gv
+	}
+
+	gv.Saved = saved
+	gv.HasSaved = true
+	gv.Remaining = decimal.Max(g.TargetAmount.Sub(saved), decimal.Zero)
+	gv.MonthlyRequired = gv.Remaining.
+		Div(decimal.NewFromInt(int64(max(gv.MonthsLeft, 1)))).
+		RoundUp(g.Currency.Decimals())
+
+	return gv
+}
+
+// monthsUntil counts the calendar months left before the date, the current
+// month included while there are days left in it
+func monthsUntil(now, date time.Time) int {
+	months := (date.Year()-now.Year())*12 + int(date.Month()-now.Month())
+	if date.Day() > now.Day() {
+		months++
+	}
+	return max(months, 0)
+}
+
+// Progress is how much of the target has been saved, from 0 to 100
+func (gv *SavingsGoalView) Progress() int {
+	if !gv.HasSaved || !gv.TargetAmount.IsPositive() || gv.Saved.IsNegative() {
+		return 0
+	}
+	progress := gv.Saved.Div(gv.TargetAmount).Mul(decimal.NewFromInt(100)).IntPart()
+	return int(min(progress, 100))
+}
+
+func (gv *SavingsGoalView) IsReached() bool {
+	return gv.HasSaved && gv.Remaining.IsZero()
+}
+
+// savingsGoalSelect selects goals with their linked account, which only
+// counts while it is active and the goal's owner can still see it
+const savingsGoalSelect = `
+	SELECT
+		g.id, g.user_id, g.name, g.target_amount, g.currency, g.target_date, g.account_id,
+		a.id IS NOT NULL, COALESCE(a.name, ''), COALESCE(a.balance, 0), COALESCE(a.currency, ''),
+		(SELECT COALESCE(SUM(amount), 0) FROM savings_goal_contributions WHERE goal_id = g.id),
+		g.created_at, g.updated_at
+	FROM savings_goals g
+	LEFT JOIN accounts a ON a.id = g.account_id AND a.is_active = 1 AND (
+		a.user_id = g.user_id OR EXISTS (
+			SELECT 1 FROM household_members m
+			WHERE m.household_id = a.household_id AND m.user_id = g.user_id
+		)
+	)
+`
+
+func scanSavingsGoal(scanner interface{ Scan(...any) error }) (SavingsGoal, error) {
+	var goal SavingsGoal
+	err := scanner.Scan(
+		&goal.ID,
+		&goal.UserID,
+		&goal.Name,
+		&goal.TargetAmount,
+		&goal.Currency,
+		&goal.TargetDate,
+		&goal.AccountID,
+		&goal.Linked,
+		&goal.AccountName,
+		&goal.AccountBalance,
+		&goal.AccountCurrency,
+		&goal.Contributed,
+		&goal.CreatedAt,
+		&goal.UpdatedAt,
+	)
+	return goal, err
+}
+
+// GetSavingsGoalsByUserID gets the user's goals, the nearest target first
+func GetSavingsGoalsByUserID(db *sql.DB, userID int64) ([]SavingsGoal, error) {
+	rows, err := db.Query(savingsGoalSelect+` WHERE g.user_id = ? ORDER BY g.target_date, g.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var goals []SavingsGoal
+	for rows.Next() {
+		goal, err := scanSavingsGoal(rows)
+		if err != nil {
+			return nil, err
+		}
+		goals = append(goals, goal)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return goals, nil
+}
+
+// GetSavingsGoalForUser gets one of the user's goals
+func GetSavingsGoalForUser(db *sql.DB, id, userID int64) (*SavingsGoal, error) {
+	goal, err := scanSavingsGoal(db.QueryRow(savingsGoalSelect+` WHERE g.id = ? AND g.user_id = ?`, id, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSavingsGoalNotFound
+		}
+		return nil, err
+	}
+
+	return &goal, nil
+}
+
+func CreateSavingsGoal(db *sql.DB, userID int64, input SavingsGoalInput) (int64, error) {
+	targetAmount, err := decimal.NewFromString(input.TargetAmount)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := db.Exec(
+		`INSERT INTO savings_goals (user_id, name, target_amount, currency, target_date, account_id)
+		VALUES (?, ?, ?, ?, ?, NULLIF(?, 0))`,
+		userID,
+		input.Name,
+		targetAmount,
+		input.Currency,
+		input.TargetDate,
+		input.AccountID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert savings goal: %w", err)
+	}
+
+	return result.LastInsertId()
+}
+
+func UpdateSavingsGoal(db *sql.DB, id, userID int64, input SavingsGoalInput) error {
+	targetAmount, err := decimal.NewFromString(input.TargetAmount)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(
+		`UPDATE savings_goals
+		SET
+			name = ?,
+			target_amount = ?,
+			currency = ?,
+			target_date = ?,
+			account_id = NULLIF(?, 0),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ?`,
+		input.Name,
+		targetAmount,
+		input.Currency,
+		input.TargetDate,
+		input.AccountID,
+		id,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrSavingsGoalNotFound
+	}
+
+	return nil
+}
+
+// DeleteSavingsGoal removes the goal along with its contributions
+func DeleteSavingsGoal(db *sql.DB, id, userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM savings_goals WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrSavingsGoalNotFound
+	}
+
+	if _, err := tx.Exec(`DELETE FROM savings_goal_contributions WHERE goal_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete contributions: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetSavingsGoalContributions gets the goal's contributions, newest first
+func GetSavingsGoalContributions(db *sql.DB, goalID int64) ([]SavingsGoalContribution, error) {
+	rows, err := db.Query(
+		`SELECT id, goal_id, amount, note, contributed_at, created_at
+		FROM savings_goal_contributions
+		WHERE goal_id = ?
+		ORDER BY contributed_at DESC, id DESC`,
+		goalID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contributions []SavingsGoalContribution
+	for rows.Next() {
+		var contribution SavingsGoalContribution
+		err := rows.Scan(
+			&contribution.ID,
+			&contribution.GoalID,
+			&contribution.Amount,
+			&contribution.Note,
+			&contribution.ContributedAt,
+			&contribution.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		contributions = append(contributions, contribution)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return contributions, nil
+}
+
+// AddSavingsGoalContribution earmarks money for the goal, negative amounts
+// take it back
+func AddSavingsGoalContribution(db *sql.DB, goalID int64, input SavingsGoalContributionInput) (int64, error) {
+	amount, err := decimal.NewFromString(input.Amount)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := db.Exec(
+		`INSERT INTO savings_goal_contributions (goal_id, amount, note, contributed_at) VALUES (?, ?, ?, ?)`,
+		goalID,
+		amount,
+		input.Note,
+		input.ContributedAt,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert contribution: %w", err)
+	}
+
+	return result.LastInsertId()
+}
+
+func DeleteSavingsGoalContribution(db *sql.DB, id, goalID int64) error {
+	result, err := db.Exec(`DELETE FROM savings_goal_contributions WHERE id = ? AND goal_id = ?`, id, goalID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrSavingsGoalContributionNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,423 @@
+package model
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrTagNotFound = errors.New("tag not found")
+	ErrTagExists   = errors.New("tag with that name already exists")
+)
+
+// MaxTransactionTags is how many tags one user may put on a transaction
+const MaxTransactionTags = 20
+
+// Tag is a free-form label of the user's. Tags are personal, on a shared
+// account every member only sees their own.
+type Tag struct {
+	ID        int64     `db:"id"`
+	UserID    int64     `db:"user_id"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at"`
+	// TransactionCount is only set by GetTagsByUserID
+	TransactionCount int `db:"transaction_count"`
+}
+
+type TagInput struct {
+	Name string `form:"name" validate:"required,max=30"`
+}
+
+// TagAccountTotal is what a tag adds up to on one account
+type TagAccountTotal struct {
+	AccountID   int64           `db:"account_id"`
+	AccountName string          `db:"account_name"`
+	Currency    Currency        `db:"currency"`
+	Count       int             `db:"count"`
+	Total       decimal.Decimal `db:"total"`
+}
+
+// TagSummary is what a tag adds up to across the accounts the user can see
+type TagSummary struct {
+	Tag          Tag
+	Totals       []TagAccountTotal
+	Transactions []TaggedTransaction
+	// Total is the grand total in the user's currency, HasTotal is false when
+	// one of the totals could not be converted
+	Total    decimal.Decimal
+	HasTotal bool
+	Currency Currency
+}
+
+// TotalsByCurrency sums the account totals per currency
+func (s *TagSummary) TotalsByCurrency() map[Currency]decimal.Decimal {
+	totals := make(map[Currency]decimal.Decimal)
+	for _, total := range s.Totals {
+		totals[total.Currency] = totals[total.Currency].Add(total.Total)
+	}
+	return totals
+}
+
+// TagCurrencyTotal is what a tag adds up to in one currency
+type TagCurrencyTotal struct {
+	Currency Currency
+	Total    decimal.Decimal
+}
+
+// SortedTotalsByCurrency lists the per currency totals ordered by currency
+func (s *TagSummary) SortedTotalsByCurrency() []TagCurrencyTotal {
+	totals := s.TotalsByCurrency()
+	sorted := make([]TagCurrencyTotal, 0, len(totals))
+	for currency, total := range totals {
+		sorted = append(sorted, TagCurrencyTotal{Currency: currency, Total: total})
+	}
+	slices.SortFunc(sorted, func(a, b TagCurrencyTotal) int {
+		return cmp.Compare(a.Currency, b.Currency)
+	})
+	return sorted
+}
+
+// TaggedTransaction is a tagged transaction along with the account it was
+// made on
+type TaggedTransaction struct {
+	TransactionView
+	AccountName string
+}
+
+// visibleTransactionJoin limits transactions to the active accounts the user
+// can see. It takes the user id twice.
+const visibleTransactionJoin = `
+	JOIN accounts a ON a.id = t.account_id AND a.is_active = 1 AND (
+		a.user_id = ? OR EXISTS (
+			SELECT 1 FROM household_members m
+			WHERE m.household_id = a.household_id AND m.user_id = ?
+		)
+	)
+`
+
+// GetTagsByUserID gets the user's tags by name, with how many transactions
+// carry each of them
+func GetTagsByUserID(db *sql.DB, userID int64) ([]Tag, error) {
+	rows, err := db.Query(
+		`SELECT g.id, g.user_id, g.name, g.created_at, COUNT(tt.transaction_id)
+		FROM tags g
+		LEFT JOIN transaction_tags tt ON tt.tag_id = g.id
+		WHERE g.user_id = ?
+		GROUP BY g.id
+		ORDER BY g.name COLLATE NOCASE`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var tag Tag
+		err := rows.Scan(&tag.ID, &tag.UserID, &tag.Name, &tag.CreatedAt, &tag.TransactionCount)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// SearchTags gets the user's tags starting with the prefix, for autocomplete
+func SearchTags(db *sql.DB, userID int64, prefix string, limit int) ([]Tag, error) {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+	rows, err := db.Query(
+		`SELECT id, user_id, name, created_at FROM tags
+		WHERE user_id = ? AND name LIKE ? ESCAPE '\'
+		ORDER BY name COLLATE NOCASE
+		LIMIT ?`,
+		userID,
+		escaped+"%",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.UserID, &tag.Name, &tag.CreatedAt); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// GetTagForUser gets one of the user's tags
+func GetTagForUser(db *sql.DB, id, userID int64) (*Tag, error) {
+	var tag Tag
+	err := db.QueryRow(
+		`SELECT id, user_id, name, created_at FROM tags WHERE id = ? AND user_id = ?`,
+		id,
+		userID,
+	).Scan(&tag.ID, &tag.UserID, &tag.Name, &tag.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTagNotFound
+		}
+		return nil, err
+	}
+
+	return &tag, nil
+}
+
+// RenameTag renames one of the user's tags, failing with ErrTagExists when
+// another of their tags already has the name
+func RenameTag(db *sql.DB, id, userID int64, input TagInput) error {
+	var count int
+	err := db.QueryRow(
+		`SELECT COUNT(*) FROM tags WHERE user_id = ? AND name = ? COLLATE NOCASE AND id != ?`,
+		userID,
+		input.Name,
+		id,
+	).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrTagExists
+	}
+
+	result, err := db.Exec(`UPDATE tags SET name = ? WHERE id = ? AND user_id = ?`, input.Name, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTagNotFound
+	}
+
+	return nil
+}
+
+// DeleteTag removes the tag from the user's transactions and deletes it
+func DeleteTag(db *sql.DB, id, userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM tags WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTagNotFound
+	}
+
+	if _, err := tx.Exec(`DELETE FROM transaction_tags WHERE tag_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to untag transactions: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetTransactionTags gets the user's tags on the given transactions keyed by
+// transaction, untagged transactions are left out
+func GetTransactionTags(db *sql.DB, userID int64, transactionIDs []int64) (map[int64][]Tag, error) {
+	tags := make(map[int64][]Tag)
+	if len(transactionIDs) == 0 {
+		return tags, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(transactionIDs)), ", ")
+	args := []any{userID}
+	for _, id := range transactionIDs {
+		args = append(args, id)
+	}
+
+	rows, err := db.Query(
+		`SELECT tt.transaction_id, g.id, g.user_id, g.name, g.created_at
+		FROM transaction_tags tt
+		JOIN tags g ON g.id = tt.tag_id AND g.user_id = ?
+		WHERE tt.transaction_id IN (`+placeholders+`)
+		ORDER BY g.name COLLATE NOCASE`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transactionID int64
+		var tag Tag
+		if err := rows.Scan(&transactionID, &tag.ID, &tag.UserID, &tag.Name, &tag.CreatedAt); err != nil {
+			return nil, err
+		}
+		tags[transactionID] = append(tags[transactionID], tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// SetTransactionTags replaces the user's tags on the transaction with the
+// named ones, creating the tags the user doesn't have yet. Other users' tags
+// on the transaction are left alone.
+func SetTransactionTags(db *sql.DB, userID, transactionID int64, names []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`DELETE FROM transaction_tags
+		WHERE transaction_id = ? AND tag_id IN (SELECT id FROM tags WHERE user_id = ?)`,
+		transactionID,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to untag transaction: %w", err)
+	}
+
+	for _, name := range names {
+		if _, err := tx.Exec(`INSERT INTO tags (user_id, name) VALUES (?, ?) ON CONFLICT DO NOTHING`, userID, name); err != nil {
+			return fmt.Errorf("failed to insert tag: %w", err)
+		}
+
+		_, err := tx.Exec(
+			`INSERT OR IGNORE INTO transaction_tags (transaction_id, tag_id)
+			SELECT ?, id FROM tags WHERE user_id = ? AND name = ? COLLATE NOCASE`,
+			transactionID,
+			userID,
+			name,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to tag transaction: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetTagAccountTotals sums the tagged transactions per account, over the
+// accounts the user can still see
+func GetTagAccountTotals(db *sql.DB, tag *Tag) ([]TagAccountTotal, error) {
+	rows, err := db.Query(
+		`SELECT a.id, a.name, a.currency, COUNT(*), SUM(t.amount)
+		FROM transaction_tags tt
+		JOIN transactions t ON t.id = tt.transaction_id`+visibleTransactionJoin+`
+		WHERE tt.tag_id = ?
+		GROUP BY a.id
+		ORDER BY a.name COLLATE NOCASE, a.id`,
+		tag.UserID,
+		tag.UserID,
+		tag.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []TagAccountTotal{}
+	for rows.Next() {
+		var total TagAccountTotal
+		err := rows.Scan(
+			&total.AccountID,
+			&total.AccountName,
+			&total.Currency,
+			&total.Count,
+			&total.Total,
+		)
+		if err != nil {
+			return nil, err
+		}
+		totals = append(totals, total)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return totals, nil
+}
+
+// GetTaggedTransactions gets the most recent tagged transactions across the
+// accounts the user can still see
+func GetTaggedTransactions(db *sql.DB, tag *Tag, limit int) ([]TaggedTransaction, error) {
+	rows, err := db.Query(
+		`SELECT t.id, t.account_id, a.name, a.currency, t.amount, t.payee, t.category, t.notes, t.occurred_at
+		FROM transaction_tags tt
+		JOIN transactions t ON t.id = tt.transaction_id`+visibleTransactionJoin+`
+		WHERE tt.tag_id = ?
+		ORDER BY t.occurred_at DESC, t.id DESC
+		LIMIT ?`,
+		tag.UserID,
+		tag.UserID,
+		tag.ID,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []TaggedTransaction{}
+	for rows.Next() {
+		var transaction TaggedTransaction
+		err := rows.Scan(
+			&transaction.ID,
+			&transaction.AccountID,
+			&transaction.AccountName,
+			&transaction.Currency,
+			&transaction.Amount,
+			&transaction.Payee,
+			&transaction.Category,
+			&transaction.Notes,
+			&transaction.OccurredAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
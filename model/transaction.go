@@ -0,0 +1,481 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrTransactionNotFound = errors.New("transaction not found")
+	// ErrTransactionNegativeBalance is returned when a transaction would take
+	// an account that doesn't allow it below zero
+	ErrTransactionNegativeBalance = errors.New("transaction would take the account below zero")
+)
+
+type Transaction struct {
+	ID        int64           `db:"id"`
+	AccountID int64           `db:"account_id"`
+	UserID    int64           `db:"user_id"`
+	Amount    decimal.Decimal `db:"amount"`
+	Payee     string          `db:"payee"`
+	// PayeeID links the transaction to the canonical payee its description
+	// matched, PayeeName is only set by GetAccountTransactionsPage
+	PayeeID    sql.NullInt64 `db:"payee_id"`
+	PayeeName  string        `db:"payee_name"`
+	Category   string        `db:"category"`
+	Notes      string        `db:"notes"`
+	OccurredAt time.Time     `db:"occurred_at"`
+	CreatedAt  time.Time     `db:"created_at"`
+	UpdatedAt  time.Time     `db:"updated_at"`
+	// Reconciled transactions agree with a bank statement and are locked
+	Reconciled bool `db:"reconciled"`
+	// ReconciliationID and Cleared are only set by StreamTransactions
+	ReconciliationID sql.NullInt64 `db:"reconciliation_id"`
+	Cleared          bool          `db:"cleared"`
+	// RunningBalance is the account balance right after the transaction, it
+	// is only set by GetAccountTransactionsPage
+	RunningBalance decimal.Decimal `db:"running_balance"`
+	// Splits holds the category lines of a split transaction, it is only set
+	// by StreamTransactions
+	Splits []TransactionSplit `db:"-"`
+}
+
+type TransactionView struct {
+	ID             int64           `json:"id"`
+	AccountID      int64           `json:"account_id"`
+	Payee          string          `json:"payee"`
+	PayeeID        int64           `json:"payee_id,omitempty"`
+	PayeeName      string          `json:"payee_name,omitempty"`
+	Category       string          `json:"category"`
+	Notes          string          `json:"notes"`
+	Amount         decimal.Decimal `json:"amount"`
+	RunningBalance decimal.Decimal `json:"running_balance"`
+	Currency       Currency        `json:"currency"`
+	OccurredAt     time.Time       `json:"occurred_at"`
+	Reconciled     bool            `json:"reconciled"`
+	// Splits holds the category lines of a split transaction
+	Splits []TransactionSplit `json:"splits,omitempty"`
+	// Tags holds the viewer's own tags on the transaction
+	Tags []Tag `json:"-"`
+	// Attachments counts the files attached to the transaction
+	Attachments int `json:"-"`
+}
+
+type CreateTransactionInput struct {
+	Amount     decimal.Decimal `json:"amount"`
+	Payee      string          `json:"payee" validate:"required,max=100"`
+	Category   string          `json:"category" validate:"max=50"`
+	Notes      string          `json:"notes" validate:"max=200"`
+	OccurredAt string          `json:"occurred_at" validate:"required,datetime=2006-01-02"`
+}
+
+func (t *Transaction) ToView(currency Currency) TransactionView {
+	return TransactionView{
+		ID:             t.ID,
+		AccountID:      t.AccountID,
+		Payee:          t.Payee,
+		PayeeID:        t.PayeeID.Int64,
+		PayeeName:      t.PayeeName,
+		Category:       t.Category,
+		Notes:          t.Notes,
+		Amount:         t.Amount,
+		RunningBalance: t.RunningBalance,
+		Currency:       currency,
+		OccurredAt:     t.OccurredAt,
+		Reconciled:     t.Reconciled,
+	}
+}
+
+// DisplayPayee is the canonical payee name when the transaction is linked to
+// one, otherwise its raw description
+func (tv *TransactionView) DisplayPayee() string {
+	if tv.PayeeName != "" {
+		return tv.PayeeName
+	}
+	return tv.Payee
+}
+
+func (tv *TransactionView) IsSplit() bool {
+	return len(tv.Splits) > 0
+}
+
+// TransactionFilter narrows down transactions, zero values are ignored.
+type TransactionFilter struct {
+	// UserID limits transactions to the accounts the user can see, whoever
+	// entered them
+	UserID int64
+	// OwnerID limits transactions to the accounts the user owns
+	OwnerID   int64
+	AccountID int64
+	From      time.Time
+	To        time.Time
+	// Search is free text matched against payee and notes
+	Search    string
+	Category  string
+	MinAmount decimal.NullDecimal
+	MaxAmount decimal.NullDecimal
+	// TagID must be one of the viewer's tags, tags are personal
+	TagID int64
+}
+
+func (f TransactionFilter) where() (string, []any) {
+	conditions := []string{"1 = 1"}
+	args := []any{}
+
+	if f.UserID != 0 {
+		conditions = append(conditions, "account_id IN ("+visibleAccountIDs+")")
+		args = append(args, f.UserID, f.UserID)
+	}
+	if f.OwnerID != 0 {
+		conditions = append(conditions, "account_id IN (SELECT id FROM accounts WHERE user_id = ?)")
+		args = append(args, f.OwnerID)
+	}
+	if f.AccountID != 0 {
+		conditions = append(conditions, "account_id = ?")
+		args = append(args, f.AccountID)
+	}
+	if !f.From.IsZero() {
+		conditions = append(conditions, "occurred_at >= ?")
+		args = append(args, f.From.Format("2006-01-02"))
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, "occurred_at < ?")
+		args = append(args, f.To.AddDate(0, 0, 1).Format("2006-01-02"))
+	}
+	if match := ftsQuery(f.Search); match != "" {
+		conditions = append(conditions, "id IN (SELECT rowid FROM transactions_fts WHERE transactions_fts MATCH ?)")
+		args = append(args, match)
+	}
+	if f.Category != "" {
+		// a split transaction matches the categories of its lines
+		conditions = append(conditions, "id IN (SELECT transaction_id FROM transaction_lines WHERE category = ?)")
+		args = append(args, f.Category)
+	}
+	if f.TagID != 0 {
+		conditions = append(conditions, "id IN (SELECT transaction_id FROM transaction_tags WHERE tag_id = ?)")
+		args = append(args, f.TagID)
+	}
+	if f.MinAmount.Valid {
+		conditions = append(conditions, "amount >= ?")
+		args = append(args, f.MinAmount.Decimal.InexactFloat64())
+	}
+	if f.MaxAmount.Valid {
+		conditions = append(conditions, "amount <= ?")
+		args = append(args, f.MaxAmount.Decimal.InexactFloat64())
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// ftsQuery turns free text into an FTS5 query matching every word as a
+// prefix. Words are quoted so punctuation typed by the user is never read as
+// query syntax.
+func ftsQuery(search string) string {
+	words := strings.FieldsFunc(search, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = `"` + word + `"*`
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// TransactionSearchInput is the filter form on the account page
+type TransactionSearchInput struct {
+	Search    string `form:"q" validate:"max=100"`
+	From      string `form:"from" validate:"omitempty,datetime=2006-01-02"`
+	To        string `form:"to" validate:"omitempty,datetime=2006-01-02"`
+	Category  string `form:"category" validate:"max=50"`
+	MinAmount string `form:"min_amount" validate:"omitempty,numeric"`
+	MaxAmount string `form:"max_amount" validate:"omitempty,numeric"`
+	TagID     int64  `form:"tag_id" validate:"gte=0"`
+}
+
+// Filter converts the validated input into a filter over the account's
+// transactions
+func (i TransactionSearchInput) Filter(accountID int64) TransactionFilter {
+	filter := TransactionFilter{
+		AccountID: accountID,
+		Search:    i.Search,
+		Category:  i.Category,
+		TagID:     i.TagID,
+	}
+	filter.From, _ = time.Parse("2006-01-02", i.From)
+	filter.To, _ = time.Parse("2006-01-02", i.To)
+	if amount, err := decimal.NewFromString(i.MinAmount); err == nil {
+		filter.MinAmount = decimal.NewNullDecimal(amount)
+	}
+	if amount, err := decimal.NewFromString(i.MaxAmount); err == nil {
+		filter.MaxAmount = decimal.NewNullDecimal(amount)
+	}
+	return filter
+}
+
+// GetAccountTransactionsPage gets a page of the account's transactions
+// matching the filter, newest first. The running balance is worked back from
+// the account's current balance over all of its transactions, so it stays
+// correct while filtering.
+func GetAccountTransactionsPage(db *sql.DB, account *Account, filter TransactionFilter, limit, offset int) ([]Transaction, error) {
+	filter.AccountID = account.ID
+	where, args := filter.where()
+	query := `
+		SELECT
+			id, account_id, user_id, amount, payee, payee_id,
+			COALESCE((SELECT name FROM payees WHERE payees.id = payee_id), ''),
+			category, notes, occurred_at, created_at, updated_at,
+			reconciliation_id IS NOT NULL, running_balance
+		FROM (
+			SELECT
+				*,
+				? - COALESCE(SUM(amount) OVER (
+					ORDER BY occurred_at DESC, id DESC
+					ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING
+				), 0) AS running_balance
+			FROM transactions
+			WHERE account_id = ?
+		)
+		WHERE ` + where + `
+		ORDER BY occurred_at DESC, id DESC
+		LIMIT ? OFFSET ?
+	`
+	args = append([]any{account.Balance.InexactFloat64(), account.ID}, args...)
+	args = append(args, limit, offset)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []Transaction{}
+	for rows.Next() {
+		var transaction Transaction
+		err = rows.Scan(
+			&transaction.ID,
+			&transaction.AccountID,
+			&transaction.UserID,
+			&transaction.Amount,
+			&transaction.Payee,
+			&transaction.PayeeID,
+			&transaction.PayeeName,
+			&transaction.Category,
+			&transaction.Notes,
+			&transaction.OccurredAt,
+			&transaction.CreatedAt,
+			&transaction.UpdatedAt,
+			&transaction.Reconciled,
+			&transaction.RunningBalance,
+		)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// CountAccountTransactions counts the account's transactions matching the
+// filter
+func CountAccountTransactions(db *sql.DB, accountID int64, filter TransactionFilter) (int, error) {
+	filter.AccountID = accountID
+	where, args := filter.where()
+
+	var total int
+	err := db.QueryRow(`SELECT COUNT(*) FROM transactions WHERE `+where, args...).Scan(&total)
+	return total, err
+}
+
+// CreateTransaction records a transaction on the account and moves its
+// balance, the change is added to the account's audit trail
+func CreateTransaction(db *sql.DB, actor Actor, account *Account, input CreateTransactionInput) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	payee, err := matchPayee(tx, account.ID, input.Payee)
+	if err != nil {
+		return 0, err
+	}
+
+	var transactionID int64
+	err = tx.QueryRow(
+		`INSERT INTO transactions (account_id, user_id, amount, payee, payee_id, category, notes, occurred_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`,
+		account.ID,
+		actor.UserID,
+		input.Amount,
+		input.Payee,
+		payee.ID,
+		payee.category(input.Category),
+		input.Notes,
+		input.OccurredAt,
+	).Scan(&transactionID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert transaction: %w", err)
+	}
+
+	var balance decimal.Decimal
+	err = tx.QueryRow(
+		`UPDATE accounts SET balance = balance + ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND (allows_negative_balance = 1 OR balance + ? >= 0)
+		RETURNING balance`,
+		input.Amount,
+		account.ID,
+		input.Amount,
+	).Scan(&balance)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrTransactionNegativeBalance
+		}
+		return 0, fmt.Errorf("failed to update balance: %w", err)
+	}
+
+	err = recordAudit(tx, actor, auditEntry{
+		Action:     AuditTransactionAdded,
+		EntityType: AuditEntityAccount,
+		EntityID:   account.ID,
+		AccountID:  account.ID,
+		Changes: AuditChanges{
+			"payee":   {After: input.Payee},
+			"balance": {Before: balance.Sub(input.Amount), After: balance},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return transactionID, nil
+}
+
+// GetTransactionCategories gets the distinct categories used on an account,
+// split lines included, for the filter dropdown
+func GetTransactionCategories(db *sql.DB, accountID int64) ([]string, error) {
+	rows, err := db.Query(
+		`SELECT DISTINCT category FROM transaction_lines
+		WHERE account_id = ? AND category != '' ORDER BY category`,
+		accountID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []string
+	for rows.Next() {
+		var category string
+		if err := rows.Scan(&category); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+
+	return categories, rows.Err()
+}
+
+// StreamTransactions calls fn for every transaction matching the filter,
+// ordered by account and date, without loading them all into memory. Split
+// transactions come with their lines in Splits.
+//
+// The callback runs while the rows are open, it must not query the database.
+func StreamTransactions(db *sql.DB, filter TransactionFilter, fn func(Transaction) error) error {
+	where, args := filter.where()
+	query := `
+		SELECT
+			t.id, t.account_id, t.user_id, t.amount, t.payee, t.payee_id, t.category, t.notes,
+			t.occurred_at, t.created_at, t.updated_at, t.reconciliation_id, t.cleared,
+			s.id, s.category, s.amount, s.notes
+		FROM (
+			SELECT * FROM transactions WHERE ` + where + `
+		) t
+		LEFT JOIN transaction_splits s ON s.transaction_id = t.id
+		ORDER BY t.account_id, t.occurred_at, t.id, s.id
+	`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// a split transaction spans one row per line, it is passed on once the
+	// next transaction starts
+	var pending *Transaction
+	for rows.Next() {
+		var transaction Transaction
+		var splitID sql.NullInt64
+		var splitCategory, splitNotes sql.NullString
+		var splitAmount decimal.NullDecimal
+		err = rows.Scan(
+			&transaction.ID,
+			&transaction.AccountID,
+			&transaction.UserID,
+			&transaction.Amount,
+			&transaction.Payee,
+			&transaction.PayeeID,
+			&transaction.Category,
+			&transaction.Notes,
+			&transaction.OccurredAt,
+			&transaction.CreatedAt,
+			&transaction.UpdatedAt,
+			&transaction.ReconciliationID,
+			&transaction.Cleared,
+			&splitID,
+			&splitCategory,
+			&splitAmount,
+			&splitNotes,
+		)
+		if err != nil {
+			return err
+		}
+		transaction.Reconciled = transaction.ReconciliationID.Valid
+
+		if pending == nil || pending.ID != transaction.ID {
+			if pending != nil {
+				if err := fn(*pending); err != nil {
+					return err
+				}
+			}
+			pending = &transaction
+		}
+
+		if splitID.Valid {
+			pending.Splits = append(pending.Splits, TransactionSplit{
+				ID:            splitID.Int64,
+				TransactionID: transaction.ID,
+				Category:      splitCategory.String,
+				Amount:        splitAmount.Decimal,
+				Notes:         splitNotes.String,
+			})
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if pending != nil {
+		return fn(*pending)
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrSplitTooFewLines = errors.New("split needs at least two lines")
+	ErrSplitZeroLine    = errors.New("split line amount is zero")
+	ErrSplitUnbalanced  = errors.New("split lines do not add up to the transaction amount")
+)
+
+// TransactionSplit is one category line of a split transaction. Category
+// totals count the lines in place of the transaction they belong to.
+type TransactionSplit struct {
+	ID            int64           `db:"id" json:"id"`
+	TransactionID int64           `db:"transaction_id" json:"-"`
+	Category      string          `db:"category" json:"category"`
+	Amount        decimal.Decimal `db:"amount" json:"amount"`
+	Notes         string          `db:"notes" json:"notes"`
+}
+
+type TransactionSplitInput struct {
+	Category string `form:"category" validate:"max=50"`
+	Amount   string `form:"amount" validate:"required,numeric"`
+	Notes    string `form:"notes" validate:"max=200"`
+}
+
+// Lines returns the transaction's category lines the way transaction_lines
+// has them: its split lines, or the whole transaction as one line when it
+// isn't split. Splits must have been loaded.
+func (t *Transaction) Lines() []TransactionSplit {
+	if len(t.Splits) > 0 {
+		return t.Splits
+	}
+	return []TransactionSplit{{
+		TransactionID: t.ID,
+		Category:      t.Category,
+		Amount:        t.Amount,
+		Notes:         t.Notes,
+	}}
+}
+
+// GetAccountTransaction gets one of the account's transactions
+func GetAccountTransaction(db *sql.DB, accountID, id int64) (*Transaction, error) {
+	var transaction Transaction
+	err := db.QueryRow(
+		`SELECT
+			id, account_id, user_id, amount, payee, category, notes,
+			occurred_at, created_at, updated_at, reconciliation_id IS NOT NULL
+		FROM transactions
+		WHERE id = ? AND account_id = ?`,
+		id,
+		accountID,
+	).Scan(
+		&transaction.ID,
+		&transaction.AccountID,
+		&transaction.UserID,
+		&transaction.Amount,
+		&transaction.Payee,
+		&transaction.Category,
+		&transaction.Notes,
+		&transaction.OccurredAt,
+		&transaction.CreatedAt,
+		&transaction.UpdatedAt,
+		&transaction.Reconciled,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTransactionNotFound
+		}
+		return nil, err
+	}
+
+	return &transaction, nil
+}
+
+// GetTransactionSplits gets the split lines of the given transactions keyed
+// by transaction, transactions that aren't split are left out
+func GetTransactionSplits(db *sql.DB, transactionIDs []int64) (map[int64][]TransactionSplit, error) {
+	splits := make(map[int64][]TransactionSplit)
+	if len(transactionIDs) == 0 {
+		return splits, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(transactionIDs)), ", ")
+	args := make([]any, len(transactionIDs))
+	for i, id := range transactionIDs {
+		args[i] = id
+	}
+
+	rows, err := db.Query(
+		`SELECT id, transaction_id, category, amount, notes
+		FROM transaction_splits
+		WHERE transaction_id IN (`+placeholders+`)
+		ORDER BY transaction_id, id`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var split TransactionSplit
+		err := rows.Scan(
+			&split.ID,
+			&split.TransactionID,
+			&split.Category,
+			&split.Amount,
+			&split.Notes,
+		)
+		if err != nil {
+			return nil, err
+		}
+		splits[split.TransactionID] = append(splits[split.TransactionID], split)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return splits, nil
+}
+
+// SplitTransaction replaces the transaction's split lines. The lines have to
+// add up to the transaction's amount exactly, each of them moving some money.
+func SplitTransaction(db *sql.DB, transaction *Transaction, lines []TransactionSplitInput) error {
+	if len(lines) < 2 {
+		return ErrSplitTooFewLines
+	}
+
+	amounts := make([]decimal.Decimal, len(lines))
+	total := decimal.Zero
+	for i, line := range lines {
+		amount, err := decimal.NewFromString(line.Amount)
+		if err != nil {
+			return err
+		}
+		if amount.IsZero() {
+			return ErrSplitZeroLine
+		}
+		amounts[i] = amount
+		total = total.Add(amount)
+	}
+
+	if !total.Equal(transaction.Amount) {
+		return ErrSplitUnbalanced
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM transaction_splits WHERE transaction_id = ?`, transaction.ID); err != nil {
+		return fmt.Errorf("failed to delete split lines: %w", err)
+	}
+
+	for i, line := range lines {
+		_, err := tx.Exec(
+			`INSERT INTO transaction_splits (transaction_id, category, amount, notes) VALUES (?, ?, ?, ?)`,
+			transaction.ID,
+			line.Category,
+			amounts[i],
+			line.Notes,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert split line: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UnsplitTransaction removes the transaction's split lines, its own category
+// counts again
+func UnsplitTransaction(db *sql.DB, transactionID int64) error {
+	_, err := db.Exec(`DELETE FROM transaction_splits WHERE transaction_id = ?`, transactionID)
+	return err
+}
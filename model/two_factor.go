@@ -0,0 +1,179 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTwoFactorNotFound   = errors.New("two factor authentication is not enabled")
+	ErrTOTPCodeReused      = errors.New("totp code was already used")
+	ErrRecoveryCodeInvalid = errors.New("recovery code is invalid or already used")
+)
+
+type TwoFactor struct {
+	UserID int64 `db:"user_id"`
+	// Secret is the encrypted TOTP secret
+	Secret       string    `db:"secret"`
+	LastUsedStep int64     `db:"last_used_step"`
+	EnabledAt    time.Time `db:"enabled_at"`
+}
+
+type TwoFactorCodeInput struct {
+	Code string `form:"code" validate:"required,min=6,max=20"`
+}
+
+type DisableTwoFactorInput struct {
+	Password string `form:"password" validate:"required"`
+}
+
+// NormalizeRecoveryCode strips formatting so codes can be typed with or
+// without the dash and in any case
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
+
+// GetTwoFactorByUserID gets the two factor settings of a user
+func GetTwoFactorByUserID(db *sql.DB, userID int64) (*TwoFactor, error) {
+	query := `
+		SELECT user_id, secret, last_used_step, enabled_at
+		FROM two_factor WHERE user_id = ? LIMIT 1
+	`
+
+	var twoFactor TwoFactor
+	err := db.QueryRow(query, userID).Scan(
+		&twoFactor.UserID,
+		&twoFactor.Secret,
+		&twoFactor.LastUsedStep,
+		&twoFactor.EnabledAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTwoFactorNotFound
+		}
+		return nil, err
+	}
+
+	return &twoFactor, nil
+}
+
+// EnableTwoFactor stores the encrypted secret and replaces the user's
+// recovery codes, step is the time step of the code used to confirm setup.
+func EnableTwoFactor(db *sql.DB, userID int64, secret string, step int64, recoveryCodes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT OR REPLACE INTO two_factor (user_id, secret, last_used_step, enabled_at) VALUES (?, ?, ?, ?)`,
+		userID,
+		secret,
+		step,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert two factor: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	for _, code := range recoveryCodes {
+		_, err := tx.Exec(
+			`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`,
+			userID,
+			hashToken(NormalizeRecoveryCode(code)),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert recovery code: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableTwoFactor removes the secret and recovery codes of a user
+func DisableTwoFactor(db *sql.DB, userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM two_factor WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete two factor: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// ConsumeTOTPStep records the time step of an accepted code, a code from the
+// same or an earlier step is rejected so it can't be replayed.
+func ConsumeTOTPStep(db *sql.DB, userID, step int64) error {
+	result, err := db.Exec(
+		`UPDATE two_factor SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?`,
+		step,
+		userID,
+		step,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTOTPCodeReused
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks one of the user's recovery codes as used
+func UseRecoveryCode(db *sql.DB, userID int64, code string) error {
+	result, err := db.Exec(
+		`UPDATE recovery_codes SET used_at = ?
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		time.Now().UTC(),
+		userID,
+		hashToken(NormalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecoveryCodeInvalid
+	}
+
+	return nil
+}
+
+// CountUnusedRecoveryCodes counts the recovery codes a user has left
+func CountUnusedRecoveryCodes(db *sql.DB, userID int64) (int, error) {
+	var count int
+	err := db.QueryRow(
+		`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`,
+		userID,
+	).Scan(&count)
+	return count, err
+}
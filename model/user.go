@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"numera/pkg/locale"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -11,7 +12,8 @@ import (
 )
 
 var (
-	ErrUserNotFound = errors.New("user not found")
+	ErrUserNotFound          = errors.New("user not found")
+	ErrVerificationThrottled = errors.New("verification email sent too recently")
 )
 
 type Currency string
@@ -25,22 +27,60 @@ const (
 	CurrencyCHF Currency = "CHF"
 )
 
+// Currencies lists every supported currency
+var Currencies = []Currency{
+	CurrencyEUR,
+	CurrencyUSD,
+	CurrencyRSD,
+	CurrencyGBP,
+	CurrencyJPY,
+	CurrencyCHF,
+}
+
+// Locales lists the supported locales with the name shown in the settings
+var Locales = []struct {
+	Code string
+	Name string
+}{
+	{"en-US", "English (United States)"},
+	{"en-GB", "English (United Kingdom)"},
+	{"de-DE", "Deutsch"},
+	{"fr-FR", "Français"},
+	{"sr-RS", "Srpski"},
+	{"ja-JP", "日本語"},
+}
+
+// Decimals returns the number of minor unit digits used when displaying amounts
+func (c Currency) Decimals() int32 {
+	if c == CurrencyJPY {
+		return 0
+	}
+	return 2
+}
+
 type User struct {
-	ID        int64     `db:"id"`
-	Name      string    `db:"name"`
-	Email     string    `db:"email"`
-	Password  string    `db:"password"`
-	Currency  Currency  `db:"currency"`
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
+	ID              int64        `db:"id"`
+	Name            string       `db:"name"`
+	Email           string       `db:"email"`
+	Password        string       `db:"password"`
+	Currency        Currency     `db:"currency"`
+	Locale          string       `db:"locale"`
+	Timezone        string       `db:"timezone"`
+	EmailVerifiedAt sql.NullTime `db:"email_verified_at"`
+	CreatedAt       time.Time    `db:"created_at"`
+	UpdatedAt       time.Time    `db:"updated_at"`
+}
+
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt.Valid
 }
 
 type UserView struct {
-	ID           int64    `db:"id"`
-	Name         string   `db:"name"`
-	Email        string   `db:"email"`
-	Currency     Currency `db:"currency"`
-	TotalBalance decimal.Decimal
+	ID           int64           `db:"id" json:"id"`
+	Name         string          `db:"name" json:"name"`
+	Email        string          `db:"email" json:"email"`
+	Currency     Currency        `db:"currency" json:"currency"`
+	TotalBalance decimal.Decimal `json:"total_balance"`
 }
 
 func (u *User) ToView() UserView {
@@ -62,15 +102,11 @@ func (u *User) ToViewWithTotalBalance(total decimal.Decimal) UserView {
 	}
 }
 
-func (uv *UserView) GetTotalBalanceWithCurrency() string {
-	return FormatBalance(uv.TotalBalance, uv.Currency)
-}
-
 // GetUserByID gets a user using id
 func GetUserByID(db *sql.DB, id int64) (*User, error) {
 	query := `
 		SELECT
-			id, name, email, currency, created_at, updated_at 
+			id, name, email, currency, locale, timezone, email_verified_at, created_at, updated_at 
     FROM users WHERE id = ? LIMIT 1
 	`
 
@@ -80,6 +116,9 @@ func GetUserByID(db *sql.DB, id int64) (*User, error) {
 		&user.Name,
 		&user.Email,
 		&user.Currency,
+		&user.Locale,
+		&user.Timezone,
+		&user.EmailVerifiedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -98,7 +137,7 @@ func GetUserByID(db *sql.DB, id int64) (*User, error) {
 func GetUserByEmail(db *sql.DB, email string) (*User, error) {
 	query := `
 		SELECT
-			id, name, email, password, currency, created_at, updated_at 
+			id, name, email, password, currency, locale, timezone, email_verified_at, created_at, updated_at 
     FROM users WHERE email = ? LIMIT 1
 	`
 
@@ -109,6 +148,9 @@ func GetUserByEmail(db *sql.DB, email string) (*User, error) {
 		&user.Email,
 		&user.Password,
 		&user.Currency,
+		&user.Locale,
+		&user.Timezone,
+		&user.EmailVerifiedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -135,11 +177,12 @@ type LoginInput struct {
 	Password string `form:"password" validate:"required,min=8"`
 }
 
-// CreateUser hashes the user's password and persists the record to the db
-func CreateUser(db *sql.DB, input CreateUserInput) error {
+// CreateUser hashes the user's password and persists the record to the db,
+// returning the id of the new user
+func CreateUser(db *sql.DB, input CreateUserInput) (int64, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
 	if err != nil {
-		return fmt.Errorf("failed to hash password: %w", err)
+		return 0, fmt.Errorf("failed to hash password: %w", err)
 	}
 
 	query := `
@@ -148,9 +191,438 @@ func CreateUser(db *sql.DB, input CreateUserInput) error {
 		VALUES
 			(?, ?, ?, ?)
 	`
-	_, err = db.Exec(query, input.Name, input.Email, hashedPassword, CurrencyUSD)
+	result, err := db.Exec(query, input.Name, input.Email, hashedPassword, CurrencyUSD)
 	if err != nil {
-		return fmt.Errorf("failed to insert user: %w", err)
+		return 0, fmt.Errorf("failed to insert user: %w", err)
+	}
+
+	return result.LastInsertId()
+}
+
+type UpdateProfileInput struct {
+	Name     string   `form:"name" validate:"required,min=3,max=100"`
+	Currency Currency `form:"currency" validate:"required,oneof=EUR USD RSD GBP JPY CHF"`
+	Locale   string   `form:"locale" validate:"required,oneof=en-US en-GB de-DE fr-FR sr-RS ja-JP"`
+	Timezone string   `form:"timezone" validate:"required,timezone"`
+}
+
+type ChangePasswordInput struct {
+	CurrentPassword string `form:"current_password" validate:"required"`
+	Password        string `form:"password" validate:"required,min=8"`
+	PasswordConfirm string `form:"password_confirm" validate:"required,eqfield=Password"`
+}
+
+type ChangeEmailInput struct {
+	Email    string `form:"email" validate:"required,email,max=100"`
+	Password string `form:"password" validate:"required"`
+}
+
+type DeleteUserInput struct {
+	Password string `form:"password" validate:"required"`
+}
+
+// UpdateProfile saves the user's name and display preferences
+func UpdateProfile(db *sql.DB, actor Actor, input UpdateProfileInput) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := userAuditState(tx, actor.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE users
+		SET name = ?, currency = ?, locale = ?, timezone = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		input.Name,
+		input.Currency,
+		input.Locale,
+		input.Timezone,
+		actor.UserID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update profile: %w", err)
+	}
+
+	if err := recordUserChange(tx, actor, AuditProfileUpdated, before); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ChangePassword replaces the user's password
+func ChangePassword(db *sql.DB, actor Actor, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`UPDATE users SET password = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		hashedPassword,
+		actor.UserID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to change password: %w", err)
+	}
+
+	err = recordAudit(tx, actor, auditEntry{
+		Action:     AuditPasswordChanged,
+		EntityType: AuditEntityUser,
+		EntityID:   actor.UserID,
+		Changes: AuditChanges{
+			"password": {Before: auditRedacted, After: auditRedacted},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ChangeEmail replaces the user's email address, the new address has to be
+// verified again before the user can use the app
+func ChangeEmail(db *sql.DB, actor Actor, email string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := userAuditState(tx, actor.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE users
+		SET email = ?, email_verified_at = NULL, verification_sent_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		email,
+		actor.UserID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to change email: %w", err)
+	}
+
+	if err := recordUserChange(tx, actor, AuditEmailChanged, before); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// userAuditState loads the settings of a user that are recorded in the audit
+// trail
+func userAuditState(tx *sql.Tx, userID int64) (map[string]any, error) {
+	var name, email, locale, timezone string
+	var currency Currency
+	err := tx.QueryRow(
+		`SELECT name, email, currency, locale, timezone FROM users WHERE id = ?`,
+		userID,
+	).Scan(&name, &email, &currency, &locale, &timezone)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return map[string]any{
+		"name":     name,
+		"email":    email,
+		"currency": currency,
+		"locale":   locale,
+		"timezone": timezone,
+	}, nil
+}
+
+// recordUserChange audits the difference between the user's settings before
+// the change and their current state in the transaction, nothing is recorded
+// when nothing changed
+func recordUserChange(tx *sql.Tx, actor Actor, action AuditAction, before map[string]any) error {
+	after, err := userAuditState(tx, actor.UserID)
+	if err != nil {
+		return err
+	}
+
+	changes := diffAudit(before, after)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	return recordAudit(tx, actor, auditEntry{
+		Action:     action,
+		EntityType: AuditEntityUser,
+		EntityID:   actor.UserID,
+		Changes:    changes,
+	})
+}
+
+// DeleteUser removes the user and everything they own, transactions and
+// attachments they added to other users' accounts are kept
+func DeleteUser(db *sql.DB, userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// foreign keys aren't enforced on the connection so dependent rows are
+	// removed explicitly, children first. What the user entered on accounts
+	// other users own stays, those accounts' balances still count it.
+	ownTransactions := `SELECT id FROM transactions WHERE account_id IN (SELECT id FROM accounts WHERE user_id = ?)`
+
+	if _, err := tx.Exec(
+		`DELETE FROM transaction_splits WHERE transaction_id IN (`+ownTransactions+`)`,
+		userID,
+	); err != nil {
+		return fmt.Errorf("failed to delete transaction splits: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		`DELETE FROM transaction_tags
+		WHERE tag_id IN (SELECT id FROM tags WHERE user_id = ?) OR transaction_id IN (`+ownTransactions+`)`,
+		userID,
+		userID,
+	); err != nil {
+		return fmt.Errorf("failed to delete transaction tags: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		`DELETE FROM attachments WHERE account_id IN (SELECT id FROM accounts WHERE user_id = ?)`,
+		userID,
+	); err != nil {
+		return fmt.Errorf("failed to delete attachments: %w", err)
+	}
+
+	// settlements other users recorded on the user's accounts disappear with
+	// them, their ious stay settled
+	if _, err := tx.Exec(
+		`UPDATE ious SET transaction_id = NULL WHERE transaction_id IN (`+ownTransactions+`)`,
+		userID,
+	); err != nil {
+		return fmt.Errorf("failed to unlink ious: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		`DELETE FROM transactions WHERE account_id IN (SELECT id FROM accounts WHERE user_id = ?)`,
+		userID,
+	); err != nil {
+		return fmt.Errorf("failed to delete account transactions: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		`DELETE FROM reconciliations WHERE account_id IN (SELECT id FROM accounts WHERE user_id = ?)`,
+		userID,
+	); err != nil {
+		return fmt.Errorf("failed to delete reconciliations: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		`DELETE FROM savings_goal_contributions WHERE goal_id IN (SELECT id FROM savings_goals WHERE user_id = ?)`,
+		userID,
+	); err != nil {
+		return fmt.Errorf("failed to delete savings goal contributions: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		`DELETE FROM payee_aliases WHERE payee_id IN (SELECT id FROM payees WHERE user_id = ?)`,
+		userID,
+	); err != nil {
+		return fmt.Errorf("failed to delete payee aliases: %w", err)
+	}
+
+	// other members may have arranged the user's shared accounts
+	if _, err := tx.Exec(
+		`DELETE FROM account_preferences WHERE account_id IN (SELECT id FROM accounts WHERE user_id = ?)`,
+		userID,
+	); err != nil {
+		return fmt.Errorf("failed to delete account preferences: %w", err)
+	}
+
+	if err := leaveHouseholds(tx, userID); err != nil {
+		return err
+	}
+
+	for _, table := range []string{
+		"account_preferences",
+		"account_groups",
+		"savings_goals",
+		"tags",
+		"payees",
+		"ious",
+		"contacts",
+		"accounts",
+		"api_tokens",
+		"password_resets",
+		"recovery_codes",
+		"two_factor",
+		"passkeys",
+		"user_sessions",
+	} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", table, err)
+		}
+	}
+
+	result, err := tx.Exec(`DELETE FROM users WHERE id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return tx.Commit()
+}
+
+// leaveHouseholds removes the user from every household they belong to.
+// Households left without an owner promote their longest standing member and
+// households left without members are deleted.
+func leaveHouseholds(tx *sql.Tx, userID int64) error {
+	if _, err := tx.Exec(`DELETE FROM household_invitations WHERE invited_by = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete household invitations: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM household_members WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete household memberships: %w", err)
+	}
+
+	_, err := tx.Exec(`
+		UPDATE household_members SET role = 'owner'
+		WHERE rowid IN (
+			SELECT (
+				SELECT m.rowid FROM household_members m
+				WHERE m.household_id = h.id
+				ORDER BY m.created_at, m.rowid
+				LIMIT 1
+			)
+			FROM households h
+			WHERE NOT EXISTS (
+				SELECT 1 FROM household_members o
+				WHERE o.household_id = h.id AND o.role = 'owner'
+			)
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to promote household owners: %w", err)
+	}
+
+	orphaned := `SELECT id FROM households h
+		WHERE NOT EXISTS (SELECT 1 FROM household_members m WHERE m.household_id = h.id)`
+	if _, err := tx.Exec(`UPDATE accounts SET household_id = NULL WHERE household_id IN (` + orphaned + `)`); err != nil {
+		return fmt.Errorf("failed to unshare accounts: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM household_invitations WHERE household_id IN (` + orphaned + `)`); err != nil {
+		return fmt.Errorf("failed to delete household invitations: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM households WHERE id IN (` + orphaned + `)`); err != nil {
+		return fmt.Errorf("failed to delete households: %w", err)
+	}
+
+	return nil
+}
+
+// CheckPassword reports whether password matches the user's current password
+func CheckPassword(db *sql.DB, userID int64, password string) (bool, error) {
+	var hashedPassword string
+	err := db.QueryRow(`SELECT password FROM users WHERE id = ?`, userID).Scan(&hashedPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrUserNotFound
+		}
+		return false, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	return err == nil, nil
+}
+
+// IsEmailVerified reports whether the user has confirmed their email address
+// GetUserPreferences gets how the user wants dates and amounts shown
+func GetUserPreferences(db *sql.DB, userID int64) (locale.Preferences, error) {
+	var code, timezone string
+	err := db.QueryRow(`SELECT locale, timezone FROM users WHERE id = ?`, userID).Scan(&code, &timezone)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return locale.Default, ErrUserNotFound
+		}
+		return locale.Default, err
+	}
+
+	return locale.New(code, timezone), nil
+}
+
+func IsEmailVerified(db *sql.DB, userID int64) (bool, error) {
+	var verified bool
+	err := db.QueryRow(
+		`SELECT email_verified_at IS NOT NULL FROM users WHERE id = ?`,
+		userID,
+	).Scan(&verified)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrUserNotFound
+		}
+		return false, err
+	}
+
+	return verified, nil
+}
+
+// MarkEmailVerified records that the user confirmed the given email, nothing
+// changes if the email was changed since the link was sent
+func MarkEmailVerified(db *sql.DB, userID int64, email string) error {
+	_, err := db.Exec(
+		`UPDATE users SET email_verified_at = COALESCE(email_verified_at, ?)
+		WHERE id = ? AND email = ?`,
+		time.Now().UTC(),
+		userID,
+		email,
+	)
+	return err
+}
+
+// ReserveVerificationEmail records that a verification email is about to be
+// sent, failing with ErrVerificationThrottled if one went out less than
+// interval ago.
+func ReserveVerificationEmail(db *sql.DB, userID int64, interval time.Duration) error {
+	now := time.Now().UTC()
+	result, err := db.Exec(
+		`UPDATE users SET verification_sent_at = ?
+		WHERE id = ? AND (verification_sent_at IS NULL OR verification_sent_at <= ?)`,
+		now,
+		userID,
+		now.Add(-interval),
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrVerificationThrottled
 	}
 
 	return nil
@@ -158,13 +630,14 @@ func CreateUser(db *sql.DB, input CreateUserInput) error {
 
 func CalculateBalanceByCurrencies(db *sql.DB, userID int64) (map[Currency]decimal.Decimal, error) {
 	query := `
-		SELECT currency, COALESCE(SUM(balance), 0)
-		FROM accounts
-		WHERE user_id = ? AND is_active = 1
-		GROUP BY currency
-		ORDER BY currency`
-
-	rows, err := db.Query(query, userID)
+		SELECT a.currency, COALESCE(SUM(a.balance), 0)
+		FROM accounts a
+		LEFT JOIN household_members m ON m.household_id = a.household_id AND m.user_id = ?
+		WHERE a.is_active = 1 AND (a.user_id = ? OR m.user_id IS NOT NULL)
+		GROUP BY a.currency
+		ORDER BY a.currency`
+
+	rows, err := db.Query(query, userID, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -184,25 +657,34 @@ func CalculateBalanceByCurrencies(db *sql.DB, userID int64) (map[Currency]decima
 }
 
 type ChangeCurrencyRequest struct {
-	Currency Currency `validate:"required,oneof=EUR USD RSD GBP JPY CHF"`
+	Currency Currency `json:"currency" validate:"required,oneof=EUR USD RSD GBP JPY CHF"`
 }
 
-func ChangeCurrencyByUserID(db *sql.DB, userID int64, currency Currency) error {
+func ChangeCurrencyByUserID(db *sql.DB, actor Actor, currency Currency) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := userAuditState(tx, actor.UserID)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE users
 		SET currency = ?
 		WHERE id = ?
 	`
 
-	result, err := db.Exec(query, currency, userID)
-	if err != nil {
+	if _, err := tx.Exec(query, currency, actor.UserID); err != nil {
 		return fmt.Errorf("failed to change currency for user: %w", err)
 	}
 
-	_, err = result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+	if err := recordUserChange(tx, actor, AuditCurrencyChanged, before); err != nil {
+		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
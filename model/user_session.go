@@ -0,0 +1,164 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUserSessionNotFound = errors.New("user session not found")
+)
+
+// UserSession describes the device behind a login session, the session data
+// itself lives in the sessions table under the same token
+type UserSession struct {
+	ID         int64     `db:"id"`
+	Token      string    `db:"token"`
+	UserID     int64     `db:"user_id"`
+	UserAgent  string    `db:"user_agent"`
+	IPAddress  string    `db:"ip_address"`
+	LastSeenAt time.Time `db:"last_seen_at"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+// DeviceName turns the user agent into a short description like
+// "Firefox on macOS"
+func (s *UserSession) DeviceName() string {
+	ua := s.UserAgent
+
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, platform := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Mac OS X", "macOS"},
+		{"Windows", "Windows"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, platform.token) {
+			return browser + " on " + platform.name
+		}
+	}
+
+	return browser
+}
+
+// TouchUserSession records the device of a session, or updates when it was
+// last seen if it is already known
+func TouchUserSession(db *sql.DB, token string, userID int64, userAgent, ipAddress string) error {
+	_, err := db.Exec(
+		`INSERT INTO user_sessions (token, user_id, user_agent, ip_address, last_seen_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (token) DO UPDATE SET
+			ip_address = excluded.ip_address,
+			last_seen_at = excluded.last_seen_at`,
+		token,
+		userID,
+		userAgent,
+		ipAddress,
+		time.Now().UTC(),
+	)
+	return err
+}
+
+// GetUserSessions gets the sessions of a user that haven't expired, most
+// recently used first. Sessions that ended in the meantime are forgotten.
+func GetUserSessions(db *sql.DB, userID int64) ([]UserSession, error) {
+	_, err := db.Exec(
+		`DELETE FROM user_sessions
+		WHERE user_id = ? AND token NOT IN (
+			SELECT token FROM sessions WHERE julianday('now') < expiry
+		)`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT id, token, user_id, user_agent, ip_address, last_seen_at, created_at
+		FROM user_sessions
+		WHERE user_id = ?
+		ORDER BY last_seen_at DESC, id DESC
+	`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []UserSession
+	for rows.Next() {
+		var s UserSession
+		err := rows.Scan(
+			&s.ID,
+			&s.Token,
+			&s.UserID,
+			&s.UserAgent,
+			&s.IPAddress,
+			&s.LastSeenAt,
+			&s.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// GetUserSessionByID gets a session owned by the user
+func GetUserSessionByID(db *sql.DB, id, userID int64) (*UserSession, error) {
+	var s UserSession
+	err := db.QueryRow(
+		`SELECT id, token, user_id, user_agent, ip_address, last_seen_at, created_at
+		FROM user_sessions WHERE id = ? AND user_id = ?`,
+		id,
+		userID,
+	).Scan(
+		&s.ID,
+		&s.Token,
+		&s.UserID,
+		&s.UserAgent,
+		&s.IPAddress,
+		&s.LastSeenAt,
+		&s.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserSessionNotFound
+		}
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// DeleteUserSession forgets the device of a session, the session itself has
+// to be destroyed separately
+func DeleteUserSession(db *sql.DB, token string) error {
+	_, err := db.Exec(`DELETE FROM user_sessions WHERE token = ?`, token)
+	return err
+}
@@ -0,0 +1,38 @@
+package csrf
+
+import (
+	"context"
+	"crypto/subtle"
+)
+
+const (
+	// HeaderName is the header htmx and fetch requests send the token in
+	HeaderName = "X-CSRF-Token"
+	// FieldName is the form field used by plain html forms
+	FieldName = "csrf_token"
+)
+
+type tokenKey struct{}
+
+// WithToken makes the session's token available to views. It is looked up
+// lazily so requests that never render a form don't start a session.
+func WithToken(ctx context.Context, token func() string) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+// Token returns the token of the request, or an empty string
+func Token(ctx context.Context) string {
+	token, ok := ctx.Value(tokenKey{}).(func() string)
+	if !ok {
+		return ""
+	}
+	return token()
+}
+
+// Matches compares a submitted token with the expected one in constant time
+func Matches(expected, submitted string) bool {
+	if expected == "" || submitted == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(submitted)) == 1
+}
@@ -0,0 +1,133 @@
+package locale
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// format is how a locale writes dates and numbers
+type format struct {
+	date     string
+	dateTime string
+	month    string
+	decimal  string
+	group    string
+	// symbolAfter puts the currency symbol behind the amount
+	symbolAfter bool
+}
+
+// formats holds every locale the settings offer, unknown ones are written
+// the en-US way
+var formats = map[string]format{
+	"en-US": {"Jan 2, 2006", "Jan 2, 2006 15:04", "January 2006", ".", ",", false},
+	"en-GB": {"2 Jan 2006", "2 Jan 2006 15:04", "January 2006", ".", ",", false},
+	"de-DE": {"02.01.2006", "02.01.2006 15:04", "01.2006", ",", ".", true},
+	"fr-FR": {"02/01/2006", "02/01/2006 15:04", "01/2006", ",", "\u202f", true},
+	"sr-RS": {"02.01.2006.", "02.01.2006. 15:04", "01.2006.", ",", ".", true},
+	"ja-JP": {"2006/01/02", "2006/01/02 15:04", "2006/01", ".", ",", false},
+}
+
+// Preferences is how the signed in user wants dates and amounts shown
+type Preferences struct {
+	Locale   string
+	Location *time.Location
+}
+
+// Default is used for guests and wherever no user is involved
+var Default = Preferences{Locale: "en-US", Location: time.UTC}
+
+// New reads the stored locale and timezone, a timezone that no longer
+// loads falls back to UTC
+func New(code, timezone string) Preferences {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	return Preferences{Locale: code, Location: location}
+}
+
+func (p Preferences) format() format {
+	if f, ok := formats[p.Locale]; ok {
+		return f
+	}
+	return formats[Default.Locale]
+}
+
+// Date writes a calendar date, such as when a transaction occurred, it is
+// not moved to the user's timezone
+func (p Preferences) Date(t time.Time) string {
+	return t.Format(p.format().date)
+}
+
+// LocalDate writes the day a moment fell on in the user's timezone
+func (p Preferences) LocalDate(t time.Time) string {
+	return p.Date(p.in(t))
+}
+
+// DateTime writes a moment in the user's timezone
+func (p Preferences) DateTime(t time.Time) string {
+	return p.in(t).Format(p.format().dateTime)
+}
+
+// Month writes the month of a calendar date
+func (p Preferences) Month(t time.Time) string {
+	return t.Format(p.format().month)
+}
+
+func (p Preferences) in(t time.Time) time.Time {
+	if p.Location == nil {
+		return t.UTC()
+	}
+	return t.In(p.Location)
+}
+
+// Amount writes the amount rounded to the given places with the locale's
+// separators, the symbol goes in front or behind it as the locale does
+func (p Preferences) Amount(amount decimal.Decimal, places int32, symbol string) string {
+	f := p.format()
+
+	digits := amount.Abs().StringFixed(places)
+	whole, fraction, _ := strings.Cut(digits, ".")
+
+	var b strings.Builder
+	if amount.Round(places).IsNegative() {
+		b.WriteString("-")
+	}
+	if !f.symbolAfter {
+		b.WriteString(symbol)
+	}
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(f.group)
+		}
+		b.WriteRune(digit)
+	}
+	if fraction != "" {
+		b.WriteString(f.decimal + fraction)
+	}
+	if f.symbolAfter && symbol != "" {
+		b.WriteString(" " + strings.TrimSpace(symbol))
+	}
+
+	return b.String()
+}
+
+type preferencesKey struct{}
+
+// WithPreferences makes the user's preferences available to views. They are
+// looked up lazily so requests that show no dates or amounts don't load them.
+func WithPreferences(ctx context.Context, preferences func() Preferences) context.Context {
+	return context.WithValue(ctx, preferencesKey{}, preferences)
+}
+
+// FromContext returns the preferences of the request, or the defaults
+func FromContext(ctx context.Context) Preferences {
+	preferences, ok := ctx.Value(preferencesKey{}).(func() Preferences)
+	if !ok {
+		return Default
+	}
+	return preferences()
+}
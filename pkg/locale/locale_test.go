@@ -0,0 +1,57 @@
+package locale
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestAmount(t *testing.T) {
+	tests := []struct {
+		locale string
+		amount string
+		places int32
+		symbol string
+		want   string
+	}{
+		{"en-US", "1234567.891", 2, "$", "$1,234,567.89"},
+		{"en-US", "-5", 2, "$", "-$5.00"},
+		{"en-US", "-0.001", 2, "$", "$0.00"},
+		{"ja-JP", "1234.5", 0, "¥", "¥1,235"},
+		{"de-DE", "1234.5", 2, "€", "1.234,50 €"},
+		{"de-DE", "-12", 2, "CHF ", "-12,00 CHF"},
+		{"fr-FR", "1234.5", 2, "€", "1\u202f234,50 €"},
+		{"sr-RS", "999", 2, "", "999,00"},
+		{"xx-XX", "1000", 2, "£", "£1,000.00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale+" "+tt.amount, func(t *testing.T) {
+			got := Preferences{Locale: tt.locale}.Amount(decimal.RequireFromString(tt.amount), tt.places, tt.symbol)
+			if got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDates(t *testing.T) {
+	p := New("de-DE", "America/New_York")
+	moment := time.Date(2026, 3, 1, 2, 30, 0, 0, time.UTC)
+
+	if got := p.Date(moment); got != "01.03.2026" {
+		t.Fatalf("expected the calendar date as is, got %q", got)
+	}
+	// still the evening before in New York
+	if got := p.LocalDate(moment); got != "28.02.2026" {
+		t.Fatalf("expected the day in the user's timezone, got %q", got)
+	}
+	if got := p.DateTime(moment); got != "28.02.2026 21:30" {
+		t.Fatalf("expected the time in the user's timezone, got %q", got)
+	}
+
+	if got := New("en-US", "Not/A_Zone").DateTime(moment); got != "Mar 1, 2026 02:30" {
+		t.Fatalf("expected an unknown timezone to fall back to UTC, got %q", got)
+	}
+}
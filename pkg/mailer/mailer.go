@@ -0,0 +1,118 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/smtp"
+	"numera/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers plain text emails.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the mailer selected by the MAIL_DRIVER setting.
+func New(cfg *config.Config) (Mailer, error) {
+	switch cfg.MailDriver {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom), nil
+	case "file":
+		if err := os.MkdirAll(cfg.MailDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create mail dir: %w", err)
+		}
+		return &FileMailer{from: cfg.MailFrom, dir: cfg.MailDir}, nil
+	case "stdout":
+		return &FileMailer{from: cfg.MailFrom, out: os.Stdout}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.MailDriver)
+	}
+}
+
+// format renders the message in RFC 5322 format.
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPMailer{
+		addr: host + ":" + port,
+		from: from,
+		auth: auth,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	envelopeFrom := m.from
+	if start := strings.LastIndex(envelopeFrom, "<"); start != -1 {
+		envelopeFrom = strings.TrimSuffix(envelopeFrom[start+1:], ">")
+	}
+
+	return smtp.SendMail(m.addr, m.auth, envelopeFrom, []string{msg.To}, format(m.from, msg))
+}
+
+// FileMailer is a local mail sink for development, it writes every message
+// as an .eml file into dir, or to out when no dir is set.
+type FileMailer struct {
+	from string
+	dir  string
+	out  io.Writer
+	mu   sync.Mutex
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	data := format(m.from, msg)
+
+	if m.dir == "" {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		_, err := fmt.Fprintf(m.out, "----- mail -----\n%s\n----- end mail -----\n", data)
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000"), sanitize(msg.To))
+	return os.WriteFile(filepath.Join(m.dir, name), data, 0o644)
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -1,9 +1,13 @@
 package session
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"net/http"
 	"numera/config"
+	"numera/pkg/csrf"
 	"time"
 
 	"github.com/alexedwards/scs/sqlite3store"
@@ -40,3 +44,107 @@ func (s *Session) GetUserID(r *http.Request) int64 {
 func (s *Session) SetUserID(r *http.Request, userID int64) {
 	s.Put(r.Context(), "USER_ID", userID)
 }
+
+// DestroyUserSessions removes every session that belongs to the user
+func (s *Session) DestroyUserSessions(ctx context.Context, userID int64) error {
+	return s.Iterate(ctx, func(ctx context.Context) error {
+		if s.GetInt64(ctx, "USER_ID") != userID {
+			return nil
+		}
+		return s.Destroy(ctx)
+	})
+}
+
+// DestroyOtherUserSessions removes every session of the user except the one
+// in ctx, used to sign out other devices after the password changed
+func (s *Session) DestroyOtherUserSessions(ctx context.Context, userID int64) error {
+	current := s.Token(ctx)
+	return s.Iterate(ctx, func(ctx context.Context) error {
+		if s.GetInt64(ctx, "USER_ID") != userID || s.Token(ctx) == current {
+			return nil
+		}
+		return s.Destroy(ctx)
+	})
+}
+
+// DestroyToken removes a session by its token, signing out whoever holds it
+func (s *Session) DestroyToken(token string) error {
+	return s.Store.Delete(token)
+}
+
+// trackInterval is how often the last seen time of a session is saved
+const trackInterval = time.Minute
+
+// NeedsTracking reports whether the device of the current session should be
+// saved, which is the case for new tokens and then once every trackInterval
+func (s *Session) NeedsTracking(r *http.Request) bool {
+	token := s.Token(r.Context())
+	if token == "" {
+		return false
+	}
+
+	seenAt := time.Unix(s.GetInt64(r.Context(), "SESSION_SEEN_AT"), 0)
+	return s.GetString(r.Context(), "SESSION_TRACKED_TOKEN") != token ||
+		time.Since(seenAt) > trackInterval
+}
+
+// MarkTracked remembers that the device of the current session was saved
+func (s *Session) MarkTracked(r *http.Request) {
+	s.Put(r.Context(), "SESSION_TRACKED_TOKEN", s.Token(r.Context()))
+	s.Put(r.Context(), "SESSION_SEEN_AT", time.Now().Unix())
+}
+
+// CSRFToken returns the token that state changing requests of this session
+// have to echo back, generating it on first use
+func (s *Session) CSRFToken(ctx context.Context) string {
+	if token := s.GetString(ctx, "CSRF_TOKEN"); token != "" {
+		return token
+	}
+
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	s.Put(ctx, "CSRF_TOKEN", token)
+	return token
+}
+
+// ValidCSRFToken reports whether submitted matches the token of the session,
+// sessions that never handed out a token match nothing
+func (s *Session) ValidCSRFToken(ctx context.Context, submitted string) bool {
+	return csrf.Matches(s.GetString(ctx, "CSRF_TOKEN"), submitted)
+}
+
+// twoFactorPendingTTL is how long a user has to enter their code after the
+// password was accepted
+const twoFactorPendingTTL = 5 * time.Minute
+
+// SetPendingTwoFactor remembers a user who passed the password check but still
+// has to enter a two factor code
+func (s *Session) SetPendingTwoFactor(r *http.Request, userID int64) {
+	s.Put(r.Context(), "2FA_USER_ID", userID)
+	s.Put(r.Context(), "2FA_STARTED_AT", time.Now().Unix())
+	s.Put(r.Context(), "2FA_ATTEMPTS", 0)
+}
+
+// GetPendingTwoFactor returns the user waiting for the second login step, or
+// 0 if there is none or it took too long
+func (s *Session) GetPendingTwoFactor(r *http.Request) int64 {
+	startedAt := time.Unix(s.GetInt64(r.Context(), "2FA_STARTED_AT"), 0)
+	if time.Since(startedAt) > twoFactorPendingTTL {
+		return 0
+	}
+	return s.GetInt64(r.Context(), "2FA_USER_ID")
+}
+
+// RecordTwoFactorAttempt counts a failed code and returns the total so far
+func (s *Session) RecordTwoFactorAttempt(r *http.Request) int {
+	attempts := s.GetInt(r.Context(), "2FA_ATTEMPTS") + 1
+	s.Put(r.Context(), "2FA_ATTEMPTS", attempts)
+	return attempts
+}
+
+func (s *Session) ClearPendingTwoFactor(r *http.Request) {
+	s.Remove(r.Context(), "2FA_USER_ID")
+	s.Remove(r.Context(), "2FA_STARTED_AT")
+	s.Remove(r.Context(), "2FA_ATTEMPTS")
+}
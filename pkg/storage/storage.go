@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"numera/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// Storage keeps files under slash separated keys, like an object store does.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns the storage selected by the STORAGE_DRIVER setting.
+func New(cfg *config.Config) (Storage, error) {
+	switch cfg.StorageDriver {
+	case "local":
+		if err := os.MkdirAll(cfg.StorageDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create storage dir: %w", err)
+		}
+		return &LocalStorage{dir: cfg.StorageDir}, nil
+	case "memory":
+		return NewMemoryStorage(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.StorageDriver)
+	}
+}
+
+// maxKeyLength is the longest key S3 accepts, in bytes
+const maxKeyLength = 1024
+
+// validKey rejects keys that could reach outside of the storage root or that
+// an S3 compatible bucket would not accept.
+func validKey(key string) bool {
+	if key == "" || len(key) > maxKeyLength || !utf8.ValidString(key) {
+		return false
+	}
+	if strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for part := range strings.SplitSeq(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// LocalStorage keeps every object as a file below dir.
+type LocalStorage struct {
+	dir string
+}
+
+func (s *LocalStorage) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the object to a temporary file first so a failed upload never
+// leaves half a file behind under the key.
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Delete removes the object, deleting one that doesn't exist is not an error.
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// MemoryStorage is a stand-in for an S3 compatible bucket, a flat map of keys
+// to objects held in memory. It is meant for tests and throwaway instances,
+// so it behaves the way a bucket does: every call validates the key, getting
+// a missing object is ErrNotFound and deleting one is not an error.
+type MemoryStorage struct {
+	objects map[string][]byte
+	mu      sync.RWMutex
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{objects: make(map[string][]byte)}
+}
+
+// Put replaces the object under key once all of r has been read, a failed
+// read leaves any previous object in place
+func (s *MemoryStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.objects[key] = data
+	return nil
+}
+
+func (s *MemoryStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Delete removes the object, deleting one that doesn't exist is not an error.
+func (s *MemoryStorage) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.objects, key)
+	return nil
+}
+
+// Len is the number of objects held
+func (s *MemoryStorage) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.objects)
+}
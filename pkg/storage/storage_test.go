@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// drivers runs the test against every storage driver, they are expected to
+// behave the same
+func drivers(t *testing.T, fn func(t *testing.T, s Storage)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, NewMemoryStorage())
+	})
+	t.Run("local", func(t *testing.T) {
+		fn(t, &LocalStorage{dir: t.TempDir()})
+	})
+}
+
+func readObject(t *testing.T, s Storage, key string) string {
+	t.Helper()
+
+	r, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestPutGetDelete(t *testing.T) {
+	drivers(t, func(t *testing.T, s Storage) {
+		ctx := context.Background()
+		key := "attachments/1/receipt"
+
+		if err := s.Put(ctx, key, strings.NewReader("first")); err != nil {
+			t.Fatal(err)
+		}
+		if got := readObject(t, s, key); got != "first" {
+			t.Fatalf("expected first, got %q", got)
+		}
+
+		if err := s.Put(ctx, key, strings.NewReader("second")); err != nil {
+			t.Fatal(err)
+		}
+		if got := readObject(t, s, key); got != "second" {
+			t.Fatalf("expected the object to be replaced, got %q", got)
+		}
+
+		if err := s.Delete(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound after delete, got %v", err)
+		}
+	})
+}
+
+func TestMissingObject(t *testing.T) {
+	drivers(t, func(t *testing.T, s Storage) {
+		ctx := context.Background()
+
+		if _, err := s.Get(ctx, "attachments/1/missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+		if err := s.Delete(ctx, "attachments/1/missing"); err != nil {
+			t.Fatalf("expected deleting a missing object to succeed, got %v", err)
+		}
+	})
+}
+
+func TestInvalidKeys(t *testing.T) {
+	keys := []string{
+		"",
+		"/etc/passwd",
+		"../outside",
+		"attachments/../../outside",
+		"attachments//1",
+		"attachments/./1",
+		"attachments\\1",
+		"attachments/\xff",
+		strings.Repeat("a", maxKeyLength+1),
+	}
+
+	drivers(t, func(t *testing.T, s Storage) {
+		ctx := context.Background()
+
+		for _, key := range keys {
+			if err := s.Put(ctx, key, strings.NewReader("data")); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Put(%q): expected ErrInvalidKey, got %v", key, err)
+			}
+			if _, err := s.Get(ctx, key); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Get(%q): expected ErrInvalidKey, got %v", key, err)
+			}
+			if err := s.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Delete(%q): expected ErrInvalidKey, got %v", key, err)
+			}
+		}
+	})
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestFailedPutKeepsObject(t *testing.T) {
+	drivers(t, func(t *testing.T, s Storage) {
+		ctx := context.Background()
+		key := "attachments/1/receipt"
+
+		if err := s.Put(ctx, key, strings.NewReader("kept")); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(ctx, key, io.MultiReader(strings.NewReader("half"), failingReader{})); err == nil {
+			t.Fatal("expected the failed upload to return an error")
+		}
+		if got := readObject(t, s, key); got != "kept" {
+			t.Fatalf("expected the previous object to be kept, got %q", got)
+		}
+	})
+}
@@ -0,0 +1,929 @@
+package services
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"numera/model"
+	"numera/pkg/validator"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ArchiveFormat = "numera-archive"
+	// ArchiveVersion is bumped whenever the layout of the archive changes,
+	// older versions must stay importable.
+	ArchiveVersion = 3
+
+	// maxArchiveFileSize caps how large a file in an archive may be once
+	// decompressed, the upload limit only applies to the compressed archive
+	maxArchiveFileSize = 256 << 20
+)
+
+var (
+	ErrArchiveInvalid     = errors.New("file is not a numera archive")
+	ErrArchiveUnsupported = errors.New("archive was created by a newer version of numera")
+	ErrArchiveTooLarge    = errors.New("archive content is too large")
+)
+
+type ArchiveManifest struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+type ArchiveProfile struct {
+	Name      string         `json:"name"`
+	Email     string         `json:"email"`
+	Currency  model.Currency `json:"currency" validate:"required,oneof=EUR USD RSD GBP JPY CHF"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+type ArchiveAccount struct {
+	ID                    int64             `json:"id"`
+	Name                  string            `json:"name" validate:"required,max=100"`
+	AccountType           model.AccountType `json:"account_type" validate:"required,oneof=checking savings cash"`
+	Balance               decimal.Decimal   `json:"balance"`
+	Color                 string            `json:"color"`
+	Currency              model.Currency    `json:"currency" validate:"required,oneof=EUR USD RSD GBP JPY CHF"`
+	AllowsNegativeBalance bool              `json:"allows_negative_balance"`
+	IsActive              bool              `json:"is_active"`
+	// GroupID, Position and Pinned are where the user put the account, since
+	// version 3
+	GroupID   int64     `json:"group_id,omitempty"`
+	Position  *int64    `json:"position,omitempty"`
+	Pinned    bool      `json:"pinned,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type ArchiveTransaction struct {
+	ID         int64           `json:"id"`
+	AccountID  int64           `json:"account_id"`
+	Amount     decimal.Decimal `json:"amount"`
+	Payee      string          `json:"payee"`
+	Category   string          `json:"category"`
+	Notes      string          `json:"notes"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	// the rest is since version 3
+	PayeeID          int64                     `json:"payee_id,omitempty"`
+	ReconciliationID int64                     `json:"reconciliation_id,omitempty"`
+	Cleared          bool                      `json:"cleared,omitempty"`
+	Splits           []ArchiveTransactionSplit `json:"splits,omitempty" validate:"dive"`
+	TagIDs           []int64                   `json:"tag_ids,omitempty"`
+}
+
+type ArchiveTransactionSplit struct {
+	Category string          `json:"category" validate:"max=50"`
+	Amount   decimal.Decimal `json:"amount"`
+	Notes    string          `json:"notes" validate:"max=200"`
+}
+
+type ArchiveAccountGroup struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name" validate:"required,max=50"`
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ArchiveReconciliation struct {
+	ID               int64           `json:"id"`
+	AccountID        int64           `json:"account_id"`
+	StatementDate    time.Time       `json:"statement_date"`
+	StatementBalance decimal.Decimal `json:"statement_balance"`
+	Adjustment       decimal.Decimal `json:"adjustment"`
+	CompletedAt      *time.Time      `json:"completed_at,omitempty"`
+	CreatedAt        time.Time       `json:"created_at"`
+}
+
+type ArchiveSavingsGoal struct {
+	ID           int64           `json:"id"`
+	Name         string          `json:"name" validate:"required,max=100"`
+	TargetAmount decimal.Decimal `json:"target_amount"`
+	Currency     model.Currency  `json:"currency" validate:"required,oneof=EUR USD RSD GBP JPY CHF"`
+	TargetDate   time.Time       `json:"target_date"`
+	// AccountID links an archived account, goals linked to accounts the user
+	// doesn't own lose the link
+	AccountID     int64                     `json:"account_id,omitempty"`
+	Contributions []ArchiveGoalContribution `json:"contributions" validate:"dive"`
+	CreatedAt     time.Time                 `json:"created_at"`
+	UpdatedAt     time.Time                 `json:"updated_at"`
+}
+
+type ArchiveGoalContribution struct {
+	Amount        decimal.Decimal `json:"amount"`
+	Note          string          `json:"note" validate:"max=200"`
+	ContributedAt time.Time       `json:"contributed_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+type ArchiveTag struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name" validate:"required,max=30"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ArchivePayee struct {
+	ID              int64  `json:"id"`
+	Name            string `json:"name" validate:"required,max=60"`
+	DefaultCategory string `json:"default_category" validate:"max=50"`
+	// Aliases are the stored glob patterns
+	Aliases   []string  `json:"aliases"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ArchiveContact struct {
+	ID        int64        `json:"id"`
+	Name      string       `json:"name" validate:"required,max=60"`
+	Email     string       `json:"email" validate:"max=100"`
+	IOUs      []ArchiveIOU `json:"ious" validate:"dive"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+type ArchiveIOU struct {
+	Direction   model.IOUDirection `json:"direction" validate:"required,oneof=lent borrowed"`
+	Amount      decimal.Decimal    `json:"amount"`
+	Currency    model.Currency     `json:"currency" validate:"required,oneof=EUR USD RSD GBP JPY CHF"`
+	Description string             `json:"description" validate:"max=200"`
+	DueDate     *time.Time         `json:"due_date,omitempty"`
+	SettledAt   *time.Time         `json:"settled_at,omitempty"`
+	// TransactionID is the archived settlement, settlements recorded on
+	// accounts the user doesn't own are left out
+	TransactionID int64     `json:"transaction_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Archive is the decoded content of an archive file
+type Archive struct {
+	Manifest        ArchiveManifest
+	Profile         ArchiveProfile
+	Accounts        []ArchiveAccount
+	Transactions    []ArchiveTransaction    // since version 2
+	AccountGroups   []ArchiveAccountGroup   // since version 3
+	Reconciliations []ArchiveReconciliation // since version 3
+	SavingsGoals    []ArchiveSavingsGoal    // since version 3
+	Tags            []ArchiveTag            // since version 3
+	Payees          []ArchivePayee          // since version 3
+	Contacts        []ArchiveContact        // since version 3
+}
+
+type ArchiveService struct {
+	db     *sql.DB
+	logger *logrus.Logger
+}
+
+func NewArchiveService(db *sql.DB, logger *logrus.Logger) *ArchiveService {
+	return &ArchiveService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Export writes a zip archive with the user's profile, their accounts with
+// every transaction on them, and the rest of what they keep: account groups,
+// reconciliations, savings goals, tags, payees and contacts, as json. Accounts
+// and transactions also get csv copies for use in spreadsheets.
+func (as *ArchiveService) Export(w io.Writer, userID int64) error {
+	user, err := model.GetUserByID(as.db, userID)
+	if err != nil {
+		return err
+	}
+
+	archive, err := as.collect(userID)
+	if err != nil {
+		return err
+	}
+
+	// tags and payee links are looked up before transactions are streamed,
+	// the database can't be queried meanwhile
+	transactionTags, err := model.GetTransactionTagsByUserID(as.db, userID)
+	if err != nil {
+		return err
+	}
+	tagIDs := make(map[int64][]int64)
+	for _, transactionTag := range transactionTags {
+		tagIDs[transactionTag.TransactionID] = append(tagIDs[transactionTag.TransactionID], transactionTag.TagID)
+	}
+	payeeIDs := make(map[int64]bool, len(archive.Payees))
+	for _, payee := range archive.Payees {
+		payeeIDs[payee.ID] = true
+	}
+
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"manifest.json", ArchiveManifest{
+			Format:     ArchiveFormat,
+			Version:    ArchiveVersion,
+			ExportedAt: time.Now().UTC(),
+		}},
+		{"profile.json", ArchiveProfile{
+			Name:      user.Name,
+			Email:     user.Email,
+			Currency:  user.Currency,
+			CreatedAt: user.CreatedAt,
+		}},
+		{"accounts.json", archive.Accounts},
+		{"account_groups.json", archive.AccountGroups},
+		{"reconciliations.json", archive.Reconciliations},
+		{"savings_goals.json", archive.SavingsGoals},
+		{"tags.json", archive.Tags},
+		{"payees.json", archive.Payees},
+		{"contacts.json", archive.Contacts},
+	}
+	for _, file := range files {
+		fw, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.name, err)
+		}
+	}
+
+	if err := as.exportTransactions(zw, userID, tagIDs, payeeIDs); err != nil {
+		return err
+	}
+
+	fw, err := zw.Create("accounts.csv")
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(fw)
+	cw.Write([]string{"id", "name", "account_type", "balance", "currency", "color", "allows_negative_balance", "is_active", "created_at"})
+	for _, account := range archive.Accounts {
+		cw.Write([]string{
+			fmt.Sprint(account.ID),
+			account.Name,
+			string(account.AccountType),
+			account.Balance.StringFixed(2),
+			string(account.Currency),
+			account.Color,
+			fmt.Sprint(account.AllowsNegativeBalance),
+			fmt.Sprint(account.IsActive),
+			account.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	as.logger.WithFields(logrus.Fields{
+		"user_id":        userID,
+		"accounts_count": len(archive.Accounts),
+	}).Info("archive_exported")
+
+	return zw.Close()
+}
+
+// collect loads everything that goes into the archive except transactions,
+// which are streamed. Links to accounts the user doesn't own are dropped since
+// those accounts aren't archived.
+func (as *ArchiveService) collect(userID int64) (*Archive, error) {
+	var archive Archive
+
+	accounts, err := model.GetAllAccountsByUserID(as.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	preferences, err := model.GetAccountPreferencesByUserID(as.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	preferencesByAccount := make(map[int64]model.AccountPreference, len(preferences))
+	for _, preference := range preferences {
+		preferencesByAccount[preference.AccountID] = preference
+	}
+
+	owned := make(map[int64]bool, len(accounts))
+	archive.Accounts = make([]ArchiveAccount, len(accounts))
+	for i, account := range accounts {
+		owned[account.ID] = true
+		preference := preferencesByAccount[account.ID]
+		archive.Accounts[i] = ArchiveAccount{
+			ID:                    account.ID,
+			Name:                  account.Name,
+			AccountType:           account.AccountType,
+			Balance:               account.Balance,
+			Color:                 account.Color,
+			Currency:              account.Currency,
+			AllowsNegativeBalance: account.AllowsNegativeBalance,
+			IsActive:              account.IsActive == 1,
+			GroupID:               preference.GroupID.Int64,
+			Pinned:                preference.Pinned,
+			CreatedAt:             account.CreatedAt,
+			UpdatedAt:             account.UpdatedAt,
+		}
+		if preference.Position.Valid {
+			archive.Accounts[i].Position = &preference.Position.Int64
+		}
+	}
+
+	groups, err := model.GetAccountGroupsByUserID(as.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	archive.AccountGroups = make([]ArchiveAccountGroup, len(groups))
+	for i, group := range groups {
+		archive.AccountGroups[i] = ArchiveAccountGroup{
+			ID:        group.ID,
+			Name:      group.Name,
+			Position:  group.Position,
+			CreatedAt: group.CreatedAt,
+		}
+	}
+
+	reconciliations, err := model.GetReconciliationsByUserID(as.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	archive.Reconciliations = make([]ArchiveReconciliation, len(reconciliations))
+	for i, reconciliation := range reconciliations {
+		archive.Reconciliations[i] = ArchiveReconciliation{
+			ID:               reconciliation.ID,
+			AccountID:        reconciliation.AccountID,
+			StatementDate:    reconciliation.StatementDate,
+			StatementBalance: reconciliation.StatementBalance,
+			Adjustment:       reconciliation.Adjustment,
+			CompletedAt:      archiveTime(reconciliation.CompletedAt),
+			CreatedAt:        reconciliation.CreatedAt,
+		}
+	}
+
+	goals, err := model.GetSavingsGoalsByUserID(as.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	archive.SavingsGoals = make([]ArchiveSavingsGoal, len(goals))
+	for i, goal := range goals {
+		contributions, err := model.GetSavingsGoalContributions(as.db, goal.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		archiveGoal := ArchiveSavingsGoal{
+			ID:            goal.ID,
+			Name:          goal.Name,
+			TargetAmount:  goal.TargetAmount,
+			Currency:      goal.Currency,
+			TargetDate:    goal.TargetDate,
+			Contributions: make([]ArchiveGoalContribution, len(contributions)),
+			CreatedAt:     goal.CreatedAt,
+			UpdatedAt:     goal.UpdatedAt,
+		}
+		if owned[goal.AccountID.Int64] {
+			archiveGoal.AccountID = goal.AccountID.Int64
+		}
+		for j, contribution := range contributions {
+			archiveGoal.Contributions[j] = ArchiveGoalContribution{
+				Amount:        contribution.Amount,
+				Note:          contribution.Note,
+				ContributedAt: contribution.ContributedAt,
+				CreatedAt:     contribution.CreatedAt,
+			}
+		}
+		archive.SavingsGoals[i] = archiveGoal
+	}
+
+	tags, err := model.GetTagsByUserID(as.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	archive.Tags = make([]ArchiveTag, len(tags))
+	for i, tag := range tags {
+		archive.Tags[i] = ArchiveTag{ID: tag.ID, Name: tag.Name, CreatedAt: tag.CreatedAt}
+	}
+
+	payees, err := model.GetPayeesByUserID(as.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	archive.Payees = make([]ArchivePayee, len(payees))
+	for i, payee := range payees {
+		aliases, err := model.GetPayeeAliases(as.db, payee.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		archive.Payees[i] = ArchivePayee{
+			ID:              payee.ID,
+			Name:            payee.Name,
+			DefaultCategory: payee.DefaultCategory,
+			Aliases:         make([]string, len(aliases)),
+			CreatedAt:       payee.CreatedAt,
+		}
+		for j, alias := range aliases {
+			archive.Payees[i].Aliases[j] = alias.Pattern
+		}
+	}
+
+	contacts, err := model.GetContactsByUserID(as.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	archive.Contacts = make([]ArchiveContact, len(contacts))
+	for i, contact := range contacts {
+		ious, err := model.GetContactIOUs(as.db, contact.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		archive.Contacts[i] = ArchiveContact{
+			ID:        contact.ID,
+			Name:      contact.Name,
+			Email:     contact.Email,
+			IOUs:      make([]ArchiveIOU, len(ious)),
+			CreatedAt: contact.CreatedAt,
+			UpdatedAt: contact.UpdatedAt,
+		}
+		for j, iou := range ious {
+			archiveIOU := ArchiveIOU{
+				Direction:   iou.Direction,
+				Amount:      iou.Amount,
+				Currency:    iou.Currency,
+				Description: iou.Description,
+				DueDate:     archiveTime(iou.DueDate),
+				SettledAt:   archiveTime(iou.SettledAt),
+				CreatedAt:   iou.CreatedAt,
+			}
+			if iou.TransactionID.Valid && owned[iou.AccountID] {
+				archiveIOU.TransactionID = iou.TransactionID.Int64
+			}
+			archive.Contacts[i].IOUs[j] = archiveIOU
+		}
+	}
+
+	return &archive, nil
+}
+
+func archiveTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+// exportTransactions writes transactions.json and transactions.csv, streaming
+// the rows so large histories are never held in memory. The archive only
+// holds the user's own accounts, so it takes every transaction on them,
+// including those household members entered.
+func (as *ArchiveService) exportTransactions(zw *zip.Writer, userID int64, tagIDs map[int64][]int64, payeeIDs map[int64]bool) error {
+	filter := model.TransactionFilter{OwnerID: userID}
+
+	fw, err := zw.Create("transactions.json")
+	if err != nil {
+		return err
+	}
+	separator := "["
+	err = model.StreamTransactions(as.db, filter, func(t model.Transaction) error {
+		transaction := ArchiveTransaction{
+			ID:               t.ID,
+			AccountID:        t.AccountID,
+			Amount:           t.Amount,
+			Payee:            t.Payee,
+			Category:         t.Category,
+			Notes:            t.Notes,
+			OccurredAt:       t.OccurredAt,
+			CreatedAt:        t.CreatedAt,
+			UpdatedAt:        t.UpdatedAt,
+			ReconciliationID: t.ReconciliationID.Int64,
+			Cleared:          t.Cleared,
+			TagIDs:           tagIDs[t.ID],
+		}
+		if payeeIDs[t.PayeeID.Int64] {
+			transaction.PayeeID = t.PayeeID.Int64
+		}
+		for _, split := range t.Splits {
+			transaction.Splits = append(transaction.Splits, ArchiveTransactionSplit{
+				Category: split.Category,
+				Amount:   split.Amount,
+				Notes:    split.Notes,
+			})
+		}
+
+		data, err := json.Marshal(transaction)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, separator+"\n  "); err != nil {
+			return err
+		}
+		separator = ","
+		_, err = fw.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write transactions.json: %w", err)
+	}
+	if separator == "[" {
+		io.WriteString(fw, "[")
+	}
+	if _, err := io.WriteString(fw, "\n]\n"); err != nil {
+		return err
+	}
+
+	fw, err = zw.Create("transactions.csv")
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(fw)
+	cw.Write([]string{"id", "account_id", "date", "payee", "category", "notes", "amount"})
+	err = model.StreamTransactions(as.db, filter, func(t model.Transaction) error {
+		return cw.Write([]string{
+			fmt.Sprint(t.ID),
+			fmt.Sprint(t.AccountID),
+			t.OccurredAt.Format("2006-01-02"),
+			t.Payee,
+			t.Category,
+			t.Notes,
+			t.Amount.String(),
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write transactions.csv: %w", err)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Read decodes an archive and checks that its version can be imported.
+func (as *ArchiveService) Read(r io.ReaderAt, size int64) (*Archive, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, ErrArchiveInvalid
+	}
+
+	var archive Archive
+	if err := readArchiveFile(zr, "manifest.json", &archive.Manifest); err != nil {
+		return nil, err
+	}
+
+	if archive.Manifest.Format != ArchiveFormat {
+		return nil, ErrArchiveInvalid
+	}
+	if archive.Manifest.Version < 1 || archive.Manifest.Version > ArchiveVersion {
+		as.logger.WithField("version", archive.Manifest.Version).Warn("unsupported_archive_version")
+		return nil, ErrArchiveUnsupported
+	}
+
+	if err := readArchiveFile(zr, "profile.json", &archive.Profile); err != nil {
+		return nil, err
+	}
+	if err := readArchiveFile(zr, "accounts.json", &archive.Accounts); err != nil {
+		return nil, err
+	}
+	if archive.Manifest.Version >= 2 {
+		if err := readArchiveFile(zr, "transactions.json", &archive.Transactions); err != nil {
+			return nil, err
+		}
+	}
+	if archive.Manifest.Version >= 3 {
+		files := []struct {
+			name string
+			dst  any
+		}{
+			{"account_groups.json", &archive.AccountGroups},
+			{"reconciliations.json", &archive.Reconciliations},
+			{"savings_goals.json", &archive.SavingsGoals},
+			{"tags.json", &archive.Tags},
+			{"payees.json", &archive.Payees},
+			{"contacts.json", &archive.Contacts},
+		}
+		for _, file := range files {
+			if err := readArchiveFile(zr, file.name, file.dst); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := archive.validate(); err != nil {
+		return nil, err
+	}
+
+	return &archive, nil
+}
+
+// validate checks every record and that records only refer to others in the
+// same archive
+func (archive *Archive) validate() error {
+	v := validator.New()
+	invalid := func(record any) bool {
+		return len(v.Validate(record)) > 0
+	}
+
+	if invalid(archive.Profile) {
+		return fmt.Errorf("%w: invalid profile", ErrArchiveInvalid)
+	}
+
+	groupIDs := make(map[int64]bool, len(archive.AccountGroups))
+	for _, group := range archive.AccountGroups {
+		if invalid(group) {
+			return fmt.Errorf("%w: invalid account group %d", ErrArchiveInvalid, group.ID)
+		}
+		groupIDs[group.ID] = true
+	}
+
+	accountIDs := make(map[int64]bool, len(archive.Accounts))
+	for _, account := range archive.Accounts {
+		if invalid(account) {
+			return fmt.Errorf("%w: invalid account %d", ErrArchiveInvalid, account.ID)
+		}
+		if account.GroupID != 0 && !groupIDs[account.GroupID] {
+			return fmt.Errorf("%w: account %d references unknown group", ErrArchiveInvalid, account.ID)
+		}
+		accountIDs[account.ID] = true
+	}
+
+	reconciliationIDs := make(map[int64]bool, len(archive.Reconciliations))
+	for _, reconciliation := range archive.Reconciliations {
+		if !accountIDs[reconciliation.AccountID] {
+			return fmt.Errorf("%w: reconciliation %d references unknown account", ErrArchiveInvalid, reconciliation.ID)
+		}
+		reconciliationIDs[reconciliation.ID] = true
+	}
+
+	payeeIDs := make(map[int64]bool, len(archive.Payees))
+	for _, payee := range archive.Payees {
+		if invalid(payee) {
+			return fmt.Errorf("%w: invalid payee %d", ErrArchiveInvalid, payee.ID)
+		}
+		payeeIDs[payee.ID] = true
+	}
+
+	tagIDs := make(map[int64]bool, len(archive.Tags))
+	for _, tag := range archive.Tags {
+		if invalid(tag) {
+			return fmt.Errorf("%w: invalid tag %d", ErrArchiveInvalid, tag.ID)
+		}
+		tagIDs[tag.ID] = true
+	}
+
+	transactionIDs := make(map[int64]bool, len(archive.Transactions))
+	for _, transaction := range archive.Transactions {
+		if invalid(transaction) {
+			return fmt.Errorf("%w: invalid transaction %d", ErrArchiveInvalid, transaction.ID)
+		}
+		if !accountIDs[transaction.AccountID] {
+			return fmt.Errorf("%w: transaction %d references unknown account", ErrArchiveInvalid, transaction.ID)
+		}
+		if transaction.PayeeID != 0 && !payeeIDs[transaction.PayeeID] {
+			return fmt.Errorf("%w: transaction %d references unknown payee", ErrArchiveInvalid, transaction.ID)
+		}
+		if transaction.ReconciliationID != 0 && !reconciliationIDs[transaction.ReconciliationID] {
+			return fmt.Errorf("%w: transaction %d references unknown reconciliation", ErrArchiveInvalid, transaction.ID)
+		}
+		for _, tagID := range transaction.TagIDs {
+			if !tagIDs[tagID] {
+				return fmt.Errorf("%w: transaction %d references unknown tag", ErrArchiveInvalid, transaction.ID)
+			}
+		}
+		transactionIDs[transaction.ID] = true
+	}
+
+	for _, goal := range archive.SavingsGoals {
+		if invalid(goal) {
+			return fmt.Errorf("%w: invalid savings goal %d", ErrArchiveInvalid, goal.ID)
+		}
+		if goal.AccountID != 0 && !accountIDs[goal.AccountID] {
+			return fmt.Errorf("%w: savings goal %d references unknown account", ErrArchiveInvalid, goal.ID)
+		}
+	}
+
+	for _, contact := range archive.Contacts {
+		if invalid(contact) {
+			return fmt.Errorf("%w: invalid contact %d", ErrArchiveInvalid, contact.ID)
+		}
+		for _, iou := range contact.IOUs {
+			if iou.TransactionID != 0 && !transactionIDs[iou.TransactionID] {
+				return fmt.Errorf("%w: iou of contact %d references unknown transaction", ErrArchiveInvalid, contact.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Import restores an archive into a freshly created user, remapping the
+// archived ids to the newly inserted ones. The ip address of the request is
+// recorded in the audit trail.
+func (as *ArchiveService) Import(archive *Archive, user model.CreateUserInput, ipAddress string) (*model.ImportResult, error) {
+	input := model.ImportInput{
+		User:      user,
+		Currency:  archive.Profile.Currency,
+		IPAddress: ipAddress,
+	}
+
+	for _, group := range archive.AccountGroups {
+		input.AccountGroups = append(input.AccountGroups, model.AccountGroup{
+			ID:        group.ID,
+			Name:      group.Name,
+			Position:  group.Position,
+			CreatedAt: group.CreatedAt,
+		})
+	}
+
+	for _, account := range archive.Accounts {
+		isActive := 0
+		if account.IsActive {
+			isActive = 1
+		}
+		input.Accounts = append(input.Accounts, model.Account{
+			ID:                    account.ID,
+			Name:                  account.Name,
+			AccountType:           account.AccountType,
+			Balance:               account.Balance,
+			Color:                 account.Color,
+			Currency:              account.Currency,
+			AllowsNegativeBalance: account.AllowsNegativeBalance,
+			IsActive:              isActive,
+			CreatedAt:             account.CreatedAt,
+			UpdatedAt:             account.UpdatedAt,
+		})
+
+		if account.GroupID != 0 || account.Position != nil || account.Pinned {
+			preference := model.AccountPreference{
+				AccountID: account.ID,
+				GroupID:   nullID(account.GroupID),
+				Pinned:    account.Pinned,
+			}
+			if account.Position != nil {
+				preference.Position = sql.NullInt64{Int64: *account.Position, Valid: true}
+			}
+			input.AccountPreferences = append(input.AccountPreferences, preference)
+		}
+	}
+
+	for _, payee := range archive.Payees {
+		input.Payees = append(input.Payees, model.Payee{
+			ID:              payee.ID,
+			Name:            payee.Name,
+			DefaultCategory: payee.DefaultCategory,
+			CreatedAt:       payee.CreatedAt,
+		})
+		for _, pattern := range payee.Aliases {
+			input.PayeeAliases = append(input.PayeeAliases, model.PayeeAlias{
+				PayeeID:   payee.ID,
+				Pattern:   pattern,
+				CreatedAt: payee.CreatedAt,
+			})
+		}
+	}
+
+	for _, reconciliation := range archive.Reconciliations {
+		input.Reconciliations = append(input.Reconciliations, model.Reconciliation{
+			ID:               reconciliation.ID,
+			AccountID:        reconciliation.AccountID,
+			StatementDate:    reconciliation.StatementDate,
+			StatementBalance: reconciliation.StatementBalance,
+			Adjustment:       reconciliation.Adjustment,
+			CompletedAt:      nullTime(reconciliation.CompletedAt),
+			CreatedAt:        reconciliation.CreatedAt,
+		})
+	}
+
+	for _, transaction := range archive.Transactions {
+		restored := model.Transaction{
+			ID:               transaction.ID,
+			AccountID:        transaction.AccountID,
+			Amount:           transaction.Amount,
+			Payee:            transaction.Payee,
+			PayeeID:          nullID(transaction.PayeeID),
+			Category:         transaction.Category,
+			Notes:            transaction.Notes,
+			OccurredAt:       transaction.OccurredAt,
+			CreatedAt:        transaction.CreatedAt,
+			UpdatedAt:        transaction.UpdatedAt,
+			ReconciliationID: nullID(transaction.ReconciliationID),
+			Cleared:          transaction.Cleared,
+		}
+		for _, split := range transaction.Splits {
+			restored.Splits = append(restored.Splits, model.TransactionSplit{
+				Category: split.Category,
+				Amount:   split.Amount,
+				Notes:    split.Notes,
+			})
+		}
+		input.Transactions = append(input.Transactions, restored)
+
+		for _, tagID := range transaction.TagIDs {
+			input.TransactionTags = append(input.TransactionTags, model.TransactionTag{
+				TransactionID: transaction.ID,
+				TagID:         tagID,
+			})
+		}
+	}
+
+	for _, tag := range archive.Tags {
+		input.Tags = append(input.Tags, model.Tag{ID: tag.ID, Name: tag.Name, CreatedAt: tag.CreatedAt})
+	}
+
+	for _, goal := range archive.SavingsGoals {
+		input.SavingsGoals = append(input.SavingsGoals, model.SavingsGoal{
+			ID:           goal.ID,
+			Name:         goal.Name,
+			TargetAmount: goal.TargetAmount,
+			Currency:     goal.Currency,
+			TargetDate:   goal.TargetDate,
+			AccountID:    nullID(goal.AccountID),
+			CreatedAt:    goal.CreatedAt,
+			UpdatedAt:    goal.UpdatedAt,
+		})
+		for _, contribution := range goal.Contributions {
+			input.Contributions = append(input.Contributions, model.SavingsGoalContribution{
+				GoalID:        goal.ID,
+				Amount:        contribution.Amount,
+				Note:          contribution.Note,
+				ContributedAt: contribution.ContributedAt,
+				CreatedAt:     contribution.CreatedAt,
+			})
+		}
+	}
+
+	for _, contact := range archive.Contacts {
+		input.Contacts = append(input.Contacts, model.Contact{
+			ID:        contact.ID,
+			Name:      contact.Name,
+			Email:     contact.Email,
+			CreatedAt: contact.CreatedAt,
+			UpdatedAt: contact.UpdatedAt,
+		})
+		for _, iou := range contact.IOUs {
+			input.IOUs = append(input.IOUs, model.IOU{
+				ContactID:     contact.ID,
+				Direction:     iou.Direction,
+				Amount:        iou.Amount,
+				Currency:      iou.Currency,
+				Description:   iou.Description,
+				DueDate:       nullTime(iou.DueDate),
+				SettledAt:     nullTime(iou.SettledAt),
+				TransactionID: nullID(iou.TransactionID),
+				CreatedAt:     iou.CreatedAt,
+			})
+		}
+	}
+
+	result, err := model.ImportUserData(as.db, input)
+	if err != nil {
+		return nil, err
+	}
+
+	as.logger.WithFields(logrus.Fields{
+		"user_id":         result.UserID,
+		"archive_version": archive.Manifest.Version,
+		"accounts_count":  len(result.AccountIDs),
+		"transactions":    len(input.Transactions),
+	}).Info("archive_imported")
+
+	return result, nil
+}
+
+func readArchiveFile(zr *zip.Reader, name string, dst any) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w: missing %s", ErrArchiveInvalid, name)
+	}
+	defer f.Close()
+
+	// the size comes from the zip header, the limit reader keeps a forged
+	// header from getting past the cap
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("%w: unreadable %s", ErrArchiveInvalid, name)
+	}
+	if info.Size() > maxArchiveFileSize {
+		return fmt.Errorf("%w: %s", ErrArchiveTooLarge, name)
+	}
+
+	if err := json.NewDecoder(io.LimitReader(f, maxArchiveFileSize)).Decode(dst); err != nil {
+		return fmt.Errorf("%w: malformed %s", ErrArchiveInvalid, name)
+	}
+
+	return nil
+}
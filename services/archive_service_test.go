@@ -0,0 +1,224 @@
+package services
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"numera/model"
+	"regexp"
+	"testing"
+)
+
+func exportArchive(t *testing.T, as *ArchiveService, userID int64) *Archive {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := as.Export(&buf, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := as.Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+func TestArchiveHoldsTransactionsOfOwnAccounts(t *testing.T) {
+	conn := openTestDB(t)
+	as := NewArchiveService(conn, testLogger())
+	h := createSharedHousehold(t, conn)
+
+	createTestTransaction(t, conn, h.ownerID, h.privateAccount, "Rent", -100)
+	createTestTransaction(t, conn, h.memberID, h.sharedAccount, "Groceries", -20)
+
+	payees := func(archive *Archive) []string {
+		var payees []string
+		for _, transaction := range archive.Transactions {
+			payees = append(payees, transaction.Payee)
+		}
+		return payees
+	}
+
+	// the member's entry is on the owner's account, so it goes with it
+	if got := payees(exportArchive(t, as, h.ownerID)); len(got) != 2 {
+		t.Fatalf("expected both transactions in the owner's archive, got %v", got)
+	}
+	if got := payees(exportArchive(t, as, h.memberID)); len(got) != 0 {
+		t.Fatalf("expected nothing in the member's archive, got %v", got)
+	}
+}
+
+// populate gives the user one of everything the archive holds
+func populate(t *testing.T, conn *sql.DB, userID int64) {
+	t.Helper()
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	account := createTestAccount(t, conn, userID, "Checking", 0)
+
+	groupID, err := model.CreateAccountGroup(conn, userID, model.AccountGroupInput{Name: "Daily"})
+	check(err)
+	check(model.ReorderAccounts(conn, userID, groupID, []int64{account.ID}))
+	check(model.SetAccountPinned(conn, userID, account.ID, true))
+
+	payeeID, _, err := model.CreatePayee(conn, userID, model.PayeeInput{Name: "Corner Shop", DefaultCategory: "Groceries"})
+	check(err)
+	payee, err := model.GetPayeeForUser(conn, payeeID, userID)
+	check(err)
+	_, err = model.AddPayeeAlias(conn, payee, model.PayeeAliasInput{Pattern: "CORNER*"})
+	check(err)
+
+	transactionID := createTestTransaction(t, conn, userID, account, "CORNER SHOP 42", -30)
+	transaction, err := model.GetAccountTransaction(conn, account.ID, transactionID)
+	check(err)
+	check(model.SplitTransaction(conn, transaction, []model.TransactionSplitInput{
+		{Category: "Groceries", Amount: "-20"},
+		{Category: "Household", Amount: "-10", Notes: "soap"},
+	}))
+	check(model.SetTransactionTags(conn, userID, transactionID, []string{"holiday"}))
+
+	account, err = model.GetAccountByID(conn, account.ID)
+	check(err)
+	_, err = model.StartReconciliation(conn, account.ID, userID, model.StartReconciliationInput{
+		StatementDate:    "2026-03-31",
+		StatementBalance: "-30",
+	})
+	check(err)
+	reconciliation, err := model.GetOpenReconciliation(conn, account.ID)
+	check(err)
+	check(model.SetTransactionCleared(conn, reconciliation, transactionID, true))
+	check(model.CompleteReconciliation(conn, model.Actor{UserID: userID}, account, reconciliation, false))
+
+	goalID, err := model.CreateSavingsGoal(conn, userID, model.SavingsGoalInput{
+		Name:         "Bike",
+		TargetAmount: "800",
+		Currency:     "EUR",
+		TargetDate:   "2026-12-01",
+		AccountID:    account.ID,
+	})
+	check(err)
+	_, err = model.AddSavingsGoalContribution(conn, goalID, model.SavingsGoalContributionInput{
+		Amount:        "50",
+		Note:          "birthday",
+		ContributedAt: "2026-03-02",
+	})
+	check(err)
+
+	contactID, err := model.CreateContact(conn, userID, model.ContactInput{Name: "Sam"})
+	check(err)
+	contact, err := model.GetContactForUser(conn, contactID, userID)
+	check(err)
+	iouID, err := model.CreateIOU(conn, contact, model.IOUInput{
+		Direction: model.IOULent,
+		Amount:    "15",
+		Currency:  "EUR",
+		DueDate:   "2026-04-01",
+	})
+	check(err)
+	iou, err := model.GetContactIOU(conn, contactID, iouID)
+	check(err)
+	account, err = model.GetAccountByID(conn, account.ID)
+	check(err)
+	check(model.SettleIOU(conn, model.Actor{UserID: userID}, iou, contact, account, model.IOUSettleInput{
+		AccountID: account.ID,
+		SettledAt: "2026-03-20",
+	}))
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	conn := openTestDB(t)
+	as := NewArchiveService(conn, testLogger())
+	userID := createTestUser(t, conn, "backup@example.com")
+	populate(t, conn, userID)
+
+	archive := exportArchive(t, as, userID)
+
+	result, err := as.Import(archive, model.CreateUserInput{
+		Name:            "Restored",
+		Email:           "restored@example.com",
+		Password:        "Password123!",
+		PasswordConfirm: "Password123!",
+	}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := exportArchive(t, as, result.UserID)
+
+	// ids change on restore, so everything is compared by what it refers to
+	normalize := func(archive *Archive) string {
+		archive.Manifest = ArchiveManifest{}
+		archive.Profile = ArchiveProfile{}
+		data, err := json.Marshal(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return regexp.MustCompile(`"(\w*_)?ids?":(\d+|\[[\d,]*\])`).ReplaceAllString(string(data), `"${1}id":_`)
+	}
+	if got, want := normalize(restored), normalize(archive); got != want {
+		t.Fatalf("restored archive differs\n got: %s\nwant: %s", got, want)
+	}
+
+	for name, n := range map[string]int{
+		"account groups":  len(restored.AccountGroups),
+		"reconciliations": len(restored.Reconciliations),
+		"savings goals":   len(restored.SavingsGoals),
+		"tags":            len(restored.Tags),
+		"payees":          len(restored.Payees),
+		"contacts":        len(restored.Contacts),
+	} {
+		if n != 1 {
+			t.Errorf("expected one of the %s to be restored, got %d", name, n)
+		}
+	}
+
+	transaction := restored.Transactions[0]
+	if len(transaction.Splits) != 2 || len(transaction.TagIDs) != 1 || transaction.PayeeID == 0 ||
+		transaction.ReconciliationID == 0 || !transaction.Cleared {
+		t.Fatalf("expected the transaction with its splits, tag, payee and reconciliation, got %+v", transaction)
+	}
+	if iou := restored.Contacts[0].IOUs[0]; iou.TransactionID != restored.Transactions[1].ID {
+		t.Fatalf("expected the iou to keep its settlement, got %+v", iou)
+	}
+	if goal := restored.SavingsGoals[0]; goal.AccountID != restored.Accounts[0].ID || len(goal.Contributions) != 1 {
+		t.Fatalf("expected the goal with its account and contribution, got %+v", goal)
+	}
+}
+
+func TestImportMatchesPayees(t *testing.T) {
+	conn := openTestDB(t)
+	as := NewArchiveService(conn, testLogger())
+	userID := createTestUser(t, conn, "payees@example.com")
+	account := createTestAccount(t, conn, userID, "Checking", 0)
+	createTestTransaction(t, conn, userID, account, "AMZN Mktp DE 1234", -25)
+
+	// an archive from before the payee was set up
+	archive := exportArchive(t, as, userID)
+	archive.Payees = append(archive.Payees, ArchivePayee{
+		ID:              1,
+		Name:            "Amazon",
+		DefaultCategory: "Shopping",
+		Aliases:         []string{"AMZN*"},
+	})
+
+	result, err := as.Import(archive, model.CreateUserInput{
+		Name:            "Restored",
+		Email:           "restored@example.com",
+		Password:        "Password123!",
+		PasswordConfirm: "Password123!",
+	}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := exportArchive(t, as, result.UserID)
+	if transaction := restored.Transactions[0]; transaction.PayeeID != restored.Payees[0].ID ||
+		transaction.Category != "Shopping" {
+		t.Fatalf("expected the transaction to be matched to Amazon, got %+v", transaction)
+	}
+}
@@ -0,0 +1,302 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"numera/model"
+	"numera/pkg/storage"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrAttachmentEmpty    = errors.New("attachment is empty")
+	ErrAttachmentTooLarge = errors.New("attachment is too large")
+	ErrAttachmentType     = errors.New("attachment type is not allowed")
+)
+
+// attachmentTypes are the mime types accepted for attachments, detected from
+// the file's content rather than trusted from the upload
+var attachmentTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"application/pdf",
+}
+
+const (
+	// thumbnailSize is the longest side of a thumbnail in pixels
+	thumbnailSize = 320
+	// thumbnailMaxPixels keeps images that would take too much memory or time
+	// to decode during the upload request from getting a thumbnail. Decoded
+	// they take up to 4 bytes a pixel, 64MB at the limit, which still fits
+	// the 12 megapixel photos phones take.
+	thumbnailMaxPixels = 16_000_000
+	maxFilenameLength  = 100
+)
+
+type AttachmentUpload struct {
+	UserID        int64
+	AccountID     int64
+	TransactionID sql.NullInt64
+	Filename      string
+	File          io.Reader
+}
+
+type AttachmentService struct {
+	db      *sql.DB
+	logger  *logrus.Logger
+	storage storage.Storage
+	maxSize int64
+	quota   int64
+}
+
+func NewAttachmentService(
+	db *sql.DB,
+	logger *logrus.Logger,
+	storage storage.Storage,
+	maxSize int64,
+	quota int64,
+) *AttachmentService {
+	return &AttachmentService{
+		db:      db,
+		logger:  logger,
+		storage: storage,
+		maxSize: maxSize,
+		quota:   quota,
+	}
+}
+
+// MaxSize is the largest file that can be attached, in bytes
+func (as *AttachmentService) MaxSize() int64 {
+	return as.maxSize
+}
+
+func (as *AttachmentService) Usage(userID int64) (model.AttachmentUsage, error) {
+	used, err := model.GetAttachmentUsage(as.db, userID)
+	if err != nil {
+		return model.AttachmentUsage{}, err
+	}
+	return model.AttachmentUsage{Used: used, Quota: as.quota}, nil
+}
+
+// Upload checks the file's type and size against the limits, stores it along
+// with a thumbnail for images and records the attachment
+func (as *AttachmentService) Upload(ctx context.Context, upload AttachmentUpload) (*model.Attachment, error) {
+	// one byte more than allowed is read to tell a file at the limit from a
+	// larger one
+	data, err := io.ReadAll(io.LimitReader(upload.File, as.maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, ErrAttachmentEmpty
+	}
+	if int64(len(data)) > as.maxSize {
+		return nil, ErrAttachmentTooLarge
+	}
+
+	mime := mimetype.Detect(data)
+	if !mimetype.EqualsAny(mime.String(), attachmentTypes...) {
+		return nil, ErrAttachmentType
+	}
+
+	usage, err := as.Usage(upload.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > usage.Remaining() {
+		return nil, model.ErrAttachmentQuotaExceeded
+	}
+
+	key, err := attachmentKey(upload.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := &model.Attachment{
+		UserID:        upload.UserID,
+		AccountID:     upload.AccountID,
+		TransactionID: upload.TransactionID,
+		Filename:      cleanFilename(upload.Filename, mime.Extension()),
+		ContentType:   mime.String(),
+		Size:          int64(len(data)),
+		StorageKey:    key,
+	}
+
+	if err := as.storage.Put(ctx, attachment.StorageKey, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to store attachment: %w", err)
+	}
+
+	thumbnail, err := makeThumbnail(data)
+	if err != nil {
+		// the attachment is still useful without a thumbnail
+		as.logger.WithError(err).WithField("content_type", attachment.ContentType).Warn("failed_to_make_thumbnail")
+	}
+	if thumbnail != nil {
+		thumbnailKey := attachment.StorageKey + "-thumb.jpg"
+		if err := as.storage.Put(ctx, thumbnailKey, bytes.NewReader(thumbnail)); err != nil {
+			as.logger.WithError(err).WithField("storage_key", thumbnailKey).Warn("failed_to_store_thumbnail")
+		} else {
+			attachment.ThumbnailKey = thumbnailKey
+		}
+	}
+
+	if err := model.CreateAttachment(as.db, attachment, as.quota); err != nil {
+		as.DeleteObjects(ctx, attachment.StorageKeys())
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+// Open reads the attachment's file, or its thumbnail
+func (as *AttachmentService) Open(ctx context.Context, attachment *model.Attachment, thumbnail bool) (io.ReadCloser, error) {
+	if thumbnail {
+		return as.storage.Get(ctx, attachment.ThumbnailKey)
+	}
+	return as.storage.Get(ctx, attachment.StorageKey)
+}
+
+// Delete removes the attachment and then its files from storage
+func (as *AttachmentService) Delete(ctx context.Context, attachment *model.Attachment) error {
+	if err := model.DeleteAttachment(as.db, attachment.ID); err != nil {
+		return err
+	}
+
+	as.DeleteObjects(ctx, attachment.StorageKeys())
+	return nil
+}
+
+// DeleteObjects removes files of attachments that are already gone from the
+// database. Failures are only logged, a leftover file is harmless and the
+// database is what counts towards the quota.
+func (as *AttachmentService) DeleteObjects(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := as.storage.Delete(ctx, key); err != nil {
+			as.logger.WithError(err).WithField("storage_key", key).Error("failed_to_delete_attachment_file")
+		}
+	}
+}
+
+// attachmentKey returns a new random storage key under the user's prefix
+func attachmentKey(userID int64) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate attachment key: %w", err)
+	}
+	return fmt.Sprintf("attachments/%d/%s", userID, hex.EncodeToString(b)), nil
+}
+
+// cleanFilename keeps the base name of the uploaded file for downloads,
+// falling back to a generic name with the detected extension
+func cleanFilename(filename, extension string) string {
+	filename = path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	filename = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(filename))
+
+	if filename == "" || filename == "." || filename == "/" {
+		return "attachment" + extension
+	}
+
+	if utf8.RuneCountInString(filename) > maxFilenameLength {
+		runes := []rune(filename)
+		filename = string(runes[:maxFilenameLength-len(extension)]) + extension
+	}
+
+	return filename
+}
+
+// makeThumbnail scales images down to fit thumbnailSize and encodes them as
+// jpeg. Formats the standard library can't decode, like pdf and webp, get no
+// thumbnail and nil is returned.
+func makeThumbnail(data []byte) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > thumbnailMaxPixels {
+		return nil, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(src, thumbnailSize), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleDown resizes the image so its longest side is at most size pixels,
+// averaging the source pixels every thumbnail pixel covers. Transparent
+// areas turn white since jpeg has no alpha channel.
+func scaleDown(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(bounds.Dy()*size/bounds.Dx(), 1)
+		} else {
+			width, height = max(bounds.Dx()*size/bounds.Dy(), 1), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/height, y0+1)
+		for x := range width {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(bounds.Min.X+(x+1)*bounds.Dx()/width, x0+1)
+
+			var r, g, b, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					// colors are premultiplied, adding the missing alpha
+					// composites them over white
+					r += uint64(pr + 0xffff - pa)
+					g += uint64(pg + 0xffff - pa)
+					b += uint64(pb + 0xffff - pa)
+					n++
+				}
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	return dst
+}
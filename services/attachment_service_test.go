@@ -0,0 +1,221 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"numera/model"
+	"numera/pkg/storage"
+	"strings"
+	"testing"
+)
+
+func newTestAttachmentService(t *testing.T, maxSize, quota int64) (*AttachmentService, *storage.MemoryStorage, int64) {
+	t.Helper()
+
+	conn := openTestDB(t)
+	store := storage.NewMemoryStorage()
+	as := NewAttachmentService(conn, testLogger(), store, maxSize, quota)
+
+	return as, store, createTestUser(t, conn, "attachments@example.com")
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xff})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+const testPDF = "%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\ntrailer << /Root 1 0 R >>\n%%EOF\n"
+
+func readStored(t *testing.T, as *AttachmentService, attachment *model.Attachment, thumbnail bool) []byte {
+	t.Helper()
+
+	r, err := as.Open(context.Background(), attachment, thumbnail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestAttachmentUploadImage(t *testing.T) {
+	as, store, userID := newTestAttachmentService(t, 1<<20, 10<<20)
+	data := testPNG(t, 640, 480)
+
+	attachment, err := as.Upload(context.Background(), AttachmentUpload{
+		UserID:    userID,
+		AccountID: 1,
+		Filename:  `C:\Users\me\receipt.png`,
+		File:      bytes.NewReader(data),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attachment.Filename != "receipt.png" || attachment.ContentType != "image/png" {
+		t.Fatalf("unexpected attachment %+v", attachment)
+	}
+	if !bytes.Equal(readStored(t, as, attachment, false), data) {
+		t.Fatal("stored file differs from the upload")
+	}
+	if store.Len() != 2 {
+		t.Fatalf("expected the file and its thumbnail to be stored, got %d objects", store.Len())
+	}
+
+	thumbnail, format, err := image.DecodeConfig(bytes.NewReader(readStored(t, as, attachment, true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" || thumbnail.Width != thumbnailSize || thumbnail.Height != 240 {
+		t.Fatalf("expected a %dx240 jpeg thumbnail, got a %dx%d %s", thumbnailSize, thumbnail.Width, thumbnail.Height, format)
+	}
+
+	usage, err := as.Usage(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Used != int64(len(data)) {
+		t.Fatalf("expected %d bytes used, got %d", len(data), usage.Used)
+	}
+}
+
+func TestAttachmentUploadPDF(t *testing.T) {
+	as, store, userID := newTestAttachmentService(t, 1<<20, 10<<20)
+
+	attachment, err := as.Upload(context.Background(), AttachmentUpload{
+		UserID:    userID,
+		AccountID: 1,
+		Filename:  "",
+		File:      strings.NewReader(testPDF),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attachment.Filename != "attachment.pdf" || attachment.HasThumbnail() {
+		t.Fatalf("expected a pdf without thumbnail, got %+v", attachment)
+	}
+	if store.Len() != 1 {
+		t.Fatalf("expected only the file to be stored, got %d objects", store.Len())
+	}
+}
+
+func TestAttachmentUploadRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"empty", nil, ErrAttachmentEmpty},
+		{"too large", bytes.Repeat([]byte("%PDF-1.4\n"), 200), ErrAttachmentTooLarge},
+		{"wrong type", []byte("<html><script>alert(1)</script></html>"), ErrAttachmentType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			as, store, userID := newTestAttachmentService(t, 1024, 10<<20)
+
+			_, err := as.Upload(context.Background(), AttachmentUpload{
+				UserID:    userID,
+				AccountID: 1,
+				Filename:  "file",
+				File:      bytes.NewReader(tt.data),
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if store.Len() != 0 {
+				t.Fatalf("expected nothing to be stored, got %d objects", store.Len())
+			}
+		})
+	}
+}
+
+func TestAttachmentQuota(t *testing.T) {
+	quota := int64(2 * len(testPDF))
+	as, store, userID := newTestAttachmentService(t, 1<<20, quota)
+
+	upload := func() (*model.Attachment, error) {
+		return as.Upload(context.Background(), AttachmentUpload{
+			UserID:    userID,
+			AccountID: 1,
+			Filename:  "statement.pdf",
+			File:      strings.NewReader(testPDF),
+		})
+	}
+
+	var attachments []*model.Attachment
+	for range 2 {
+		attachment, err := upload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	if _, err := upload(); !errors.Is(err, model.ErrAttachmentQuotaExceeded) {
+		t.Fatalf("expected ErrAttachmentQuotaExceeded, got %v", err)
+	}
+	if store.Len() != 2 {
+		t.Fatalf("expected the rejected file not to be stored, got %d objects", store.Len())
+	}
+
+	// deleting one frees its space again
+	if err := as.Delete(context.Background(), attachments[0]); err != nil {
+		t.Fatal(err)
+	}
+	if store.Len() != 1 {
+		t.Fatalf("expected the deleted file to be removed, got %d objects", store.Len())
+	}
+	if _, err := upload(); err != nil {
+		t.Fatalf("expected the upload to fit after deleting, got %v", err)
+	}
+}
+
+// withPNGSize rewrites the dimensions in the png header, leaving the pixel
+// data as it was
+func withPNGSize(data []byte, width, height uint32) []byte {
+	data = bytes.Clone(data)
+	// the IHDR chunk follows the 8 byte signature, its data starts after the
+	// length and type and is followed by a crc over type and data
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestMakeThumbnailSkipsLargeImages(t *testing.T) {
+	// claims to be 5000x4000, only the header is read before it is skipped
+	data := withPNGSize(testPNG(t, 10, 10), 5000, 4000)
+
+	thumbnail, err := makeThumbnail(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumbnail != nil {
+		t.Fatal("expected no thumbnail for an image above the pixel limit")
+	}
+}
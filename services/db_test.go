@@ -0,0 +1,131 @@
+package services
+
+import (
+	"database/sql"
+	"io"
+	"numera/db"
+	"numera/model"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pressly/goose/v3"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// openTestDB opens a migrated database in a temporary directory. Search needs
+// FTS5, so the tests only run when built with -tags sqlite_fts5.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	conn, err := db.Open(filepath.Join(t.TempDir(), "test.sqlite3"))
+	if err != nil {
+		if strings.Contains(err.Error(), "FTS5") {
+			t.Skip("sqlite was built without FTS5, run the tests with -tags sqlite_fts5")
+		}
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	goose.SetLogger(goose.NopLogger())
+	if err := db.RunMigrations(conn, "../migrations"); err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func createTestUser(t *testing.T, conn *sql.DB, email string) int64 {
+	t.Helper()
+
+	userID, err := model.CreateUser(conn, model.CreateUserInput{
+		Name:            "Test User",
+		Email:           email,
+		Password:        "Password123!",
+		PasswordConfirm: "Password123!",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return userID
+}
+
+// sharedHousehold is a household of two users, the owner keeps a private
+// account and shares another one with the member
+type sharedHousehold struct {
+	ownerID         int64
+	memberID        int64
+	privateAccount  *model.Account
+	sharedAccount   *model.Account
+	memberAccountID int64
+}
+
+func createSharedHousehold(t *testing.T, conn *sql.DB) sharedHousehold {
+	t.Helper()
+
+	h := sharedHousehold{
+		ownerID:  createTestUser(t, conn, "owner@example.com"),
+		memberID: createTestUser(t, conn, "member@example.com"),
+	}
+
+	householdID, err := model.CreateHousehold(conn, h.ownerID, model.CreateHouseholdInput{Name: "Home"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(
+		`INSERT INTO household_members (household_id, user_id, role) VALUES (?, ?, ?)`,
+		householdID, h.memberID, model.RoleEditor,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	h.privateAccount = createTestAccount(t, conn, h.ownerID, "Private", 0)
+	h.sharedAccount = createTestAccount(t, conn, h.ownerID, "Shared", householdID)
+	h.memberAccountID = createTestAccount(t, conn, h.memberID, "Member", 0).ID
+
+	return h
+}
+
+func createTestAccount(t *testing.T, conn *sql.DB, userID int64, name string, householdID int64) *model.Account {
+	t.Helper()
+
+	id, err := model.CreateAccount(conn, model.Actor{UserID: userID}, model.CreateAccountInput{
+		Name:                  name,
+		AccountType:           model.AccountTypeChecking,
+		Color:                 "blue",
+		Currency:              "EUR",
+		AllowsNegativeBalance: true,
+		HouseholdID:           householdID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := model.GetAccountByID(conn, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+func createTestTransaction(t *testing.T, conn *sql.DB, userID int64, account *model.Account, payee string, amount int64) int64 {
+	t.Helper()
+
+	id, err := model.CreateTransaction(conn, model.Actor{UserID: userID}, account, model.CreateTransactionInput{
+		Amount:     decimal.NewFromInt(amount),
+		Payee:      payee,
+		OccurredAt: "2026-03-01",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
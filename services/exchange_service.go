@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -30,14 +31,16 @@ type cacheEntry struct {
 }
 
 type ExchangeService struct {
+	db         *sql.DB
 	baseURL    string
 	httpClient *http.Client
 	cache      sync.Map
 	logger     *logrus.Logger
 }
 
-func NewExchangeService(logger *logrus.Logger) *ExchangeService {
+func NewExchangeService(db *sql.DB, logger *logrus.Logger) *ExchangeService {
 	return &ExchangeService{
+		db:         db,
 		baseURL:    "https://hexarate.paikama.co/api/rates",
 		httpClient: &http.Client{Timeout: 10 * time.Second},
 		logger:     logger,
@@ -135,6 +138,7 @@ func (es *ExchangeService) fetchRate(
 	}
 
 	rate := decimal.NewFromFloat(result.Data.Mid)
+	es.storeRate(from, to, rate, result.Data.Date)
 
 	expiresAt := time.Now().Add(CacheTTL)
 	es.cache.Store(cacheKey, cacheEntry{
@@ -154,6 +158,25 @@ func (es *ExchangeService) fetchRate(
 	return rate, nil
 }
 
+// storeRate keeps every fetched rate for the day the api quoted it, or today
+// when the date can't be read. Conversions don't depend on it, so failing to
+// store is only logged.
+func (es *ExchangeService) storeRate(from, to model.Currency, rate decimal.Decimal, date string) {
+	day, err := time.Parse("2006-01-02", date[:min(len(date), 10)])
+	if err != nil {
+		day = time.Now().UTC()
+	}
+
+	err = model.SaveExchangeRate(es.db, model.ExchangeRate{From: from, To: to, Rate: rate, Date: day})
+	if err != nil {
+		es.logger.WithFields(logrus.Fields{
+			"from": from,
+			"to":   to,
+			"date": date,
+		}).WithError(err).Warn("failed_to_store_exchange_rate")
+	}
+}
+
 // ConvertAmount converts a amount from one currency to another using the exchange rate.
 func (es *ExchangeService) ConvertAmount(
 	ctx context.Context,
@@ -0,0 +1,415 @@
+package services
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"numera/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+type ExportFormat string
+
+const (
+	ExportFormatCSV    ExportFormat = "csv"
+	ExportFormatOFX    ExportFormat = "ofx"
+	ExportFormatLedger ExportFormat = "ledger"
+)
+
+// ContentType returns the mime type used when serving the export
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportFormatCSV:
+		return "text/csv; charset=utf-8"
+	case ExportFormatOFX:
+		return "application/x-ofx"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// Extension returns the file extension used for the export
+func (f ExportFormat) Extension() string {
+	if f == ExportFormatLedger {
+		return "journal"
+	}
+	return string(f)
+}
+
+type ExportService struct {
+	db     *sql.DB
+	logger *logrus.Logger
+}
+
+func NewExportService(db *sql.DB, logger *logrus.Logger) *ExportService {
+	return &ExportService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// ExportTransactions streams the transactions matching the filter to w in
+// the requested format.
+func (es *ExportService) ExportTransactions(
+	w io.Writer,
+	format ExportFormat,
+	filter model.TransactionFilter,
+) error {
+	// accounts are loaded up front because the database can not be queried
+	// while transactions are being streamed, they are the same accounts the
+	// filter lets transactions through from
+	accounts, err := model.GetAllAccountsForUser(es.db, filter.UserID)
+	if err != nil {
+		return err
+	}
+	accountsByID := make(map[int64]model.Account, len(accounts))
+	for _, account := range accounts {
+		accountsByID[account.ID] = account
+	}
+
+	bw := bufio.NewWriter(w)
+
+	switch format {
+	case ExportFormatCSV:
+		err = es.writeCSV(bw, accountsByID, filter)
+	case ExportFormatOFX:
+		err = es.writeOFX(bw, accountsByID, filter)
+	case ExportFormatLedger:
+		err = es.writeLedger(bw, accountsByID, filter)
+	default:
+		err = fmt.Errorf("unknown export format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	es.logger.WithFields(logrus.Fields{
+		"user_id":    filter.UserID,
+		"account_id": filter.AccountID,
+		"format":     format,
+	}).Info("transactions_exported")
+
+	return bw.Flush()
+}
+
+func formatAmount(amount decimal.Decimal, currency model.Currency) string {
+	return amount.StringFixed(currency.Decimals())
+}
+
+func (es *ExportService) writeCSV(
+	w io.Writer,
+	accounts map[int64]model.Account,
+	filter model.TransactionFilter,
+) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "account", "payee", "category", "notes", "amount", "currency"})
+
+	// split transactions get a row per line so category totals add up
+	err := model.StreamTransactions(es.db, filter, func(t model.Transaction) error {
+		account := accounts[t.AccountID]
+		for _, line := range t.Lines() {
+			notes := line.Notes
+			if notes == "" {
+				notes = t.Notes
+			}
+			err := cw.Write([]string{
+				t.OccurredAt.Format("2006-01-02"),
+				account.Name,
+				t.Payee,
+				line.Category,
+				notes,
+				formatAmount(line.Amount, account.Currency),
+				string(account.Currency),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func ofxEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func ofxAccountType(accountType model.AccountType) string {
+	if accountType == model.AccountTypeSavings {
+		return "SAVINGS"
+	}
+	return "CHECKING"
+}
+
+// writeOFX writes an OFX 2.2 document with one bank statement per account.
+func (es *ExportService) writeOFX(
+	w io.Writer,
+	accounts map[int64]model.Account,
+	filter model.TransactionFilter,
+) error {
+	now := time.Now().UTC().Format("20060102150405")
+	start, end := "19700101", time.Now().UTC().Format("20060102")
+	if !filter.From.IsZero() {
+		start = filter.From.Format("20060102")
+	}
+	if !filter.To.IsZero() {
+		end = filter.To.Format("20060102")
+	}
+
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8" standalone="no"?>`+"\n")
+	fmt.Fprint(w, `<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>`+"\n")
+	fmt.Fprint(w, "<OFX>\n")
+	fmt.Fprintf(w, "<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>"+
+		"<DTSERVER>%s</DTSERVER><LANGUAGE>ENG</LANGUAGE></SONRS></SIGNONMSGSRSV1>\n", now)
+	fmt.Fprint(w, "<BANKMSGSRSV1>\n")
+
+	var current int64
+	closeStatement := func() {
+		if current == 0 {
+			return
+		}
+		account := accounts[current]
+		fmt.Fprint(w, "</BANKTRANLIST>\n")
+		fmt.Fprintf(w, "<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>\n",
+			formatAmount(account.Balance, account.Currency), now)
+		fmt.Fprint(w, "</STMTRS></STMTTRNRS>\n")
+	}
+
+	err := model.StreamTransactions(es.db, filter, func(t model.Transaction) error {
+		account := accounts[t.AccountID]
+
+		if t.AccountID != current {
+			closeStatement()
+			current = t.AccountID
+
+			fmt.Fprintf(w, "<STMTTRNRS><TRNUID>%d</TRNUID><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>\n", account.ID)
+			fmt.Fprintf(w, "<STMTRS><CURDEF>%s</CURDEF>\n", account.Currency)
+			fmt.Fprintf(w, "<BANKACCTFROM><BANKID>NUMERA</BANKID><ACCTID>%d</ACCTID><ACCTTYPE>%s</ACCTTYPE></BANKACCTFROM>\n",
+				account.ID, ofxAccountType(account.AccountType))
+			fmt.Fprintf(w, "<BANKTRANLIST><DTSTART>%s</DTSTART><DTEND>%s</DTEND>\n", start, end)
+		}
+
+		trnType := "CREDIT"
+		if t.Amount.IsNegative() {
+			trnType = "DEBIT"
+		}
+
+		_, err := fmt.Fprintf(w, "<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s</TRNAMT>"+
+			"<FITID>%d</FITID><NAME>%s</NAME><MEMO>%s</MEMO></STMTTRN>\n",
+			trnType,
+			t.OccurredAt.Format("20060102"),
+			formatAmount(t.Amount, account.Currency),
+			t.ID,
+			ofxEscape(truncate(t.Payee, 32)),
+			ofxEscape(truncate(t.Notes, 255)),
+		)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	closeStatement()
+	_, err = fmt.Fprint(w, "</BANKMSGSRSV1>\n</OFX>\n")
+	return err
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max])
+}
+
+// ledgerAccountName builds a hledger account name, colons separate account
+// levels so they are not allowed inside a single name.
+func ledgerAccountName(parts ...string) string {
+	for i, part := range parts {
+		part = strings.ReplaceAll(part, ":", "-")
+		parts[i] = strings.Join(strings.Fields(part), " ")
+	}
+	return strings.Join(parts, ":")
+}
+
+// ledgerText puts free text on a single journal line, a line break would
+// start a new entry or posting
+func ledgerText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// writeLedger writes a plain text journal readable by ledger-cli and hledger,
+// with commodity directives for every supported currency and price directives
+// for the stored exchange rates of the exported accounts' currencies, each
+// dated the day it was quoted.
+func (es *ExportService) writeLedger(
+	w io.Writer,
+	accounts map[int64]model.Account,
+	filter model.TransactionFilter,
+) error {
+	var currencies []model.Currency
+	for _, currency := range model.Currencies {
+		for _, account := range accounts {
+			if account.Currency == currency {
+				currencies = append(currencies, currency)
+				break
+			}
+		}
+	}
+	rates, err := model.GetExchangeRates(es.db, currencies, filter.To)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "; Exported from Numera on %s\n\n", time.Now().Format("2006-01-02"))
+
+	for _, currency := range model.Currencies {
+		fmt.Fprintf(w, "commodity %s %s\n", formatAmount(decimal.NewFromInt(1000), currency), currency)
+	}
+	fmt.Fprintln(w)
+
+	for _, rate := range rates {
+		fmt.Fprintf(w, "P %s %s %s %s\n", rate.Date.Format("2006-01-02"), rate.From, rate.Rate.String(), rate.To)
+	}
+	if len(rates) > 0 {
+		fmt.Fprintln(w)
+	}
+
+	return model.StreamTransactions(es.db, filter, func(t model.Transaction) error {
+		account := accounts[t.AccountID]
+
+		fmt.Fprintf(w, "%s %s", t.OccurredAt.Format("2006-01-02"), ledgerText(t.Payee))
+		if t.Notes != "" {
+			fmt.Fprintf(w, "  ; %s", ledgerText(t.Notes))
+		}
+		fmt.Fprintln(w)
+
+		fmt.Fprintf(w, "    %s  %s %s\n",
+			ledgerAccountName("Assets", capitalize(string(account.AccountType)), account.Name),
+			formatAmount(t.Amount, account.Currency),
+			account.Currency,
+		)
+
+		// an unsplit transaction is one line and its posting is left for
+		// ledger to balance, a split one gets a posting per line
+		lines := t.Lines()
+		for _, line := range lines {
+			category := line.Category
+			if category == "" {
+				category = "Uncategorized"
+			}
+			counterAccount := ledgerAccountName("Expenses", category)
+			if line.Amount.IsPositive() {
+				counterAccount = ledgerAccountName("Income", category)
+			}
+
+			if len(lines) == 1 {
+				fmt.Fprintf(w, "    %s\n", counterAccount)
+				continue
+			}
+
+			fmt.Fprintf(w, "    %s  %s %s", counterAccount, formatAmount(line.Amount.Neg(), account.Currency), account.Currency)
+			if line.Notes != "" {
+				fmt.Fprintf(w, "  ; %s", ledgerText(line.Notes))
+			}
+			fmt.Fprintln(w)
+		}
+
+		_, err := fmt.Fprintln(w)
+		return err
+	})
+}
+
+// ExportAuditLog streams the audit trail entries matching the filter to w as
+// csv, oldest first. The changes column holds the before and after json.
+func (es *ExportService) ExportAuditLog(w io.Writer, filter model.AuditLogFilter) error {
+	bw := bufio.NewWriter(w)
+	cw := csv.NewWriter(bw)
+	cw.Write([]string{
+		"id", "timestamp", "actor_id", "actor", "action",
+		"entity_type", "entity_id", "account_id", "ip_address", "changes",
+	})
+
+	err := model.StreamAuditLogs(es.db, filter, func(l model.AuditLog) error {
+		changes, err := json.Marshal(l.Changes)
+		if err != nil {
+			return err
+		}
+
+		accountID := ""
+		if l.AccountID.Valid {
+			accountID = strconv.FormatInt(l.AccountID.Int64, 10)
+		}
+
+		return cw.Write([]string{
+			strconv.FormatInt(l.ID, 10),
+			l.CreatedAt.UTC().Format(time.RFC3339),
+			strconv.FormatInt(l.ActorID, 10),
+			l.ActorName,
+			string(l.Action),
+			string(l.EntityType),
+			strconv.FormatInt(l.EntityID, 10),
+			accountID,
+			l.IPAddress,
+			string(changes),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	es.logger.WithFields(logrus.Fields{
+		"account_id": filter.AccountID,
+		"actor_id":   filter.ActorID,
+	}).Info("audit_log_exported")
+
+	return bw.Flush()
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+type ExportTransactionsInput struct {
+	Format    ExportFormat `form:"format" validate:"required,oneof=csv ofx ledger"`
+	AccountID int64        `form:"account_id" validate:"gte=0"`
+	From      string       `form:"from" validate:"omitempty,datetime=2006-01-02"`
+	To        string       `form:"to" validate:"omitempty,datetime=2006-01-02"`
+	TagID     int64        `form:"tag_id" validate:"gte=0"`
+}
+
+// Filter converts the validated input into a transaction filter for the user
+func (i ExportTransactionsInput) Filter(userID int64) model.TransactionFilter {
+	filter := model.TransactionFilter{
+		UserID:    userID,
+		AccountID: i.AccountID,
+		TagID:     i.TagID,
+	}
+	filter.From, _ = time.Parse("2006-01-02", i.From)
+	filter.To, _ = time.Parse("2006-01-02", i.To)
+	return filter
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"numera/model"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exportCSVRows(t *testing.T, es *ExportService, userID int64) map[string]string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := es.ExportTransactions(&buf, ExportFormatCSV, model.TransactionFilter{UserID: userID}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// payee to account name
+	rows := make(map[string]string, len(records)-1)
+	for _, record := range records[1:] {
+		rows[record[2]] = record[1]
+	}
+	return rows
+}
+
+func TestExportTransactionsOfVisibleAccounts(t *testing.T) {
+	conn := openTestDB(t)
+	es := NewExportService(conn, testLogger())
+	h := createSharedHousehold(t, conn)
+
+	createTestTransaction(t, conn, h.ownerID, h.privateAccount, "Rent", -100)
+	createTestTransaction(t, conn, h.ownerID, h.sharedAccount, "Fuel", -30)
+	createTestTransaction(t, conn, h.memberID, h.sharedAccount, "Groceries", -20)
+	member, err := model.GetAccountByID(conn, h.memberAccountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTestTransaction(t, conn, h.memberID, member, "Salary", 500)
+
+	tests := []struct {
+		name   string
+		userID int64
+		want   map[string]string
+	}{
+		{"owner", h.ownerID, map[string]string{"Rent": "Private", "Fuel": "Shared", "Groceries": "Shared"}},
+		{"member", h.memberID, map[string]string{"Fuel": "Shared", "Groceries": "Shared", "Salary": "Member"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := exportCSVRows(t, es, tt.userID)
+			if len(rows) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, rows)
+			}
+			for payee, account := range tt.want {
+				if rows[payee] != account {
+					t.Errorf("expected %s on %q, got %q", payee, account, rows[payee])
+				}
+			}
+		})
+	}
+
+	accounts, err := model.GetAllAccountsForUser(conn, h.memberID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(accounts))
+	for i, account := range accounts {
+		names[i] = account.Name
+	}
+	if !slices.Equal(names, []string{"Shared", "Member"}) {
+		t.Fatalf("expected the shared and own account, got %v", names)
+	}
+}
+
+func TestLedgerPricesFromFetchedRates(t *testing.T) {
+	conn := openTestDB(t)
+	userID := createTestUser(t, conn, "ledger@example.com")
+	account := createTestAccount(t, conn, userID, "Checking", 0)
+	createTestTransaction(t, conn, userID, account, "Rent", -100)
+
+	day := "2026-03-02"
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data":{"mid":1.0842,"date":"%sT00:00:00Z"}}`, day)
+	}))
+	defer api.Close()
+
+	exchange := NewExchangeService(conn, testLogger())
+	exchange.baseURL = api.URL
+	if _, err := exchange.fetchRate(context.Background(), "EUR", "USD"); err != nil {
+		t.Fatal(err)
+	}
+	// a later quote is left out when exporting up to an earlier day
+	day = "2026-04-01"
+	exchange.ClearCache()
+	if _, err := exchange.fetchRate(context.Background(), "EUR", "USD"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	es := NewExportService(conn, testLogger())
+	filter := model.TransactionFilter{UserID: userID, To: time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)}
+	if err := es.ExportTransactions(&buf, ExportFormatLedger, filter); err != nil {
+		t.Fatal(err)
+	}
+
+	var prices []string
+	for line := range strings.Lines(buf.String()) {
+		if strings.HasPrefix(line, "P ") {
+			prices = append(prices, strings.TrimSpace(line))
+		}
+	}
+	if !slices.Equal(prices, []string{"P 2026-03-02 EUR 1.0842 USD"}) {
+		t.Fatalf("expected the rate quoted on 2026-03-02, got %q", prices)
+	}
+}
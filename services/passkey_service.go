@@ -0,0 +1,201 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"numera/model"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrPasskeyCeremonyFailed = errors.New("passkey could not be verified")
+	ErrPasskeyCloned         = errors.New("passkey signature counter went backwards")
+)
+
+// passkeyUser adapts a user and their stored credentials to webauthn.User
+type passkeyUser struct {
+	user        *model.User
+	credentials []webauthn.Credential
+}
+
+// webAuthnID encodes the user id as the opaque user handle stored on the
+// authenticator, it is used to find the user during passwordless login.
+func webAuthnID(userID int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(userID))
+	return b
+}
+
+func (u *passkeyUser) WebAuthnID() []byte                         { return webAuthnID(u.user.ID) }
+func (u *passkeyUser) WebAuthnName() string                       { return u.user.Email }
+func (u *passkeyUser) WebAuthnDisplayName() string                { return u.user.Name }
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+type PasskeyService struct {
+	db       *sql.DB
+	logger   *logrus.Logger
+	webAuthn *webauthn.WebAuthn
+}
+
+func NewPasskeyService(db *sql.DB, logger *logrus.Logger, appURL string) (*PasskeyService, error) {
+	u, err := url.Parse(appURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid app url: %w", err)
+	}
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          u.Hostname(),
+		RPDisplayName: "Numera",
+		RPOrigins:     []string{appURL},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure webauthn: %w", err)
+	}
+
+	return &PasskeyService{
+		db:       db,
+		logger:   logger,
+		webAuthn: webAuthn,
+	}, nil
+}
+
+func (ps *PasskeyService) loadUser(userID int64) (*passkeyUser, error) {
+	user, err := model.GetUserByID(ps.db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys, err := model.GetPasskeysByUserID(ps.db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pu := &passkeyUser{user: user}
+	for _, passkey := range passkeys {
+		var credential webauthn.Credential
+		if err := json.Unmarshal(passkey.Credential, &credential); err != nil {
+			return nil, fmt.Errorf("failed to decode passkey %d: %w", passkey.ID, err)
+		}
+		pu.credentials = append(pu.credentials, credential)
+	}
+
+	return pu, nil
+}
+
+// BeginRegistration starts adding a passkey for the user. Keys are required to
+// be discoverable so they can be used without typing an email first.
+func (ps *PasskeyService) BeginRegistration(userID int64) (*protocol.CredentialCreation, *webauthn.SessionData, error) {
+	pu, err := ps.loadUser(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ps.webAuthn.BeginRegistration(
+		pu,
+		webauthn.WithExclusions(webauthn.Credentials(pu.credentials).CredentialDescriptors()),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		}),
+	)
+}
+
+// FinishRegistration verifies the authenticator response and stores the new
+// credential under the given name
+func (ps *PasskeyService) FinishRegistration(userID int64, name string, session webauthn.SessionData, r *http.Request) error {
+	pu, err := ps.loadUser(userID)
+	if err != nil {
+		return err
+	}
+
+	credential, err := ps.webAuthn.FinishRegistration(pu, session, r)
+	if err != nil {
+		ps.logger.WithError(err).WithField("user_id", userID).Warn("passkey_registration_rejected")
+		return ErrPasskeyCeremonyFailed
+	}
+
+	encoded, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+
+	if err := model.CreatePasskey(ps.db, userID, name, credential.ID, encoded); err != nil {
+		return err
+	}
+
+	ps.logger.WithField("user_id", userID).Info("passkey_registered")
+	return nil
+}
+
+// BeginLogin starts a passkey login. With a user id only that user's passkeys
+// are accepted, used as a second factor, without one any discoverable passkey
+// can sign in.
+func (ps *PasskeyService) BeginLogin(userID int64) (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	verification := webauthn.WithUserVerification(protocol.VerificationRequired)
+
+	if userID == 0 {
+		return ps.webAuthn.BeginDiscoverableLogin(verification)
+	}
+
+	pu, err := ps.loadUser(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ps.webAuthn.BeginLogin(pu, verification)
+}
+
+// FinishLogin verifies the assertion and returns the id of the user who
+// signed in, see BeginLogin for the meaning of userID.
+func (ps *PasskeyService) FinishLogin(userID int64, session webauthn.SessionData, r *http.Request) (int64, error) {
+	var credential *webauthn.Credential
+	var err error
+
+	if userID == 0 {
+		var user webauthn.User
+		user, credential, err = ps.webAuthn.FinishPasskeyLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			if len(userHandle) != 8 {
+				return nil, ErrPasskeyCeremonyFailed
+			}
+			return ps.loadUser(int64(binary.BigEndian.Uint64(userHandle)))
+		}, session, r)
+		if err == nil {
+			userID = user.(*passkeyUser).user.ID
+		}
+	} else {
+		var pu *passkeyUser
+		pu, err = ps.loadUser(userID)
+		if err != nil {
+			return 0, err
+		}
+		credential, err = ps.webAuthn.FinishLogin(pu, session, r)
+	}
+	if err != nil {
+		ps.logger.WithError(err).WithField("user_id", userID).Warn("passkey_login_rejected")
+		return 0, ErrPasskeyCeremonyFailed
+	}
+
+	if credential.Authenticator.CloneWarning {
+		ps.logger.WithField("user_id", userID).Warn("passkey_clone_detected")
+		return 0, ErrPasskeyCloned
+	}
+
+	encoded, err := json.Marshal(credential)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := model.UpdatePasskeyCredential(ps.db, credential.ID, encoded); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
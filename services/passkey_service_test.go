@@ -0,0 +1,273 @@
+package services
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"numera/model"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const testAppURL = "http://localhost:8000"
+
+// softAuthenticator is a software authenticator holding one discoverable
+// ES256 credential, it answers the ceremonies the way a browser would pass
+// an authenticator's response on
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	counter      uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentialID := make([]byte, 16)
+	rand.Read(credentialID)
+
+	return &softAuthenticator{key: key, credentialID: credentialID}
+}
+
+var b64 = base64.RawURLEncoding
+
+// authData builds the authenticator data with user presence and user
+// verification flags, attested credential data is appended when given
+func (a *softAuthenticator) authData(attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte("localhost"))
+
+	flags := byte(0x01 | 0x04)
+	if attested != nil {
+		flags |= 0x40
+	}
+
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+	return append(data, attested...)
+}
+
+func clientData(t *testing.T, ceremony string, challenge []byte) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": b64.EncodeToString(challenge),
+		"origin":    testAppURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// register answers a registration with a "none" attestation
+func (a *softAuthenticator) register(t *testing.T, challenge, userHandle []byte) []byte {
+	t.Helper()
+	a.userHandle = userHandle
+
+	publicKey, err := cbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attested := make([]byte, 16) // aaguid
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := cbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"id":    b64.EncodeToString(a.credentialID),
+		"rawId": b64.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(clientData(t, "webauthn.create", challenge)),
+			"attestationObject": b64.EncodeToString(attestation),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// assert signs a login challenge, bumping the signature counter first
+func (a *softAuthenticator) assert(t *testing.T, challenge []byte) []byte {
+	t.Helper()
+	a.counter++
+
+	authData := a.authData(nil)
+	clientDataJSON := clientData(t, "webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(bytes.Clone(authData), clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"id":    b64.EncodeToString(a.credentialID),
+		"rawId": b64.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(clientDataJSON),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(signature),
+			"userHandle":        b64.EncodeToString(a.userHandle),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func newTestPasskeyService(t *testing.T) (*PasskeyService, int64) {
+	t.Helper()
+
+	conn := openTestDB(t)
+	ps, err := NewPasskeyService(conn, testLogger(), testAppURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ps, createTestUser(t, conn, "passkey@example.com")
+}
+
+func registerPasskey(t *testing.T, ps *PasskeyService, userID int64, authenticator *softAuthenticator) {
+	t.Helper()
+
+	creation, session, err := ps.BeginRegistration(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := authenticator.register(t, creation.Response.Challenge, webAuthnID(userID))
+	r := httptest.NewRequest("POST", "/passkeys", bytes.NewReader(body))
+	if err := ps.FinishRegistration(userID, "Laptop", *session, r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loginWithPasskey(t *testing.T, ps *PasskeyService, userID int64, authenticator *softAuthenticator) (int64, error) {
+	t.Helper()
+
+	assertion, session, err := ps.BeginLogin(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	body := authenticator.assert(t, assertion.Response.Challenge)
+	r := httptest.NewRequest("POST", "/login/passkey", bytes.NewReader(body))
+	return ps.FinishLogin(userID, *session, r)
+}
+
+func TestPasskeyRegistration(t *testing.T) {
+	ps, userID := newTestPasskeyService(t)
+	authenticator := newSoftAuthenticator(t)
+
+	registerPasskey(t, ps, userID, authenticator)
+
+	passkeys, err := model.GetPasskeysByUserID(ps.db, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passkeys) != 1 || passkeys[0].Name != "Laptop" {
+		t.Fatalf("expected the Laptop passkey to be stored, got %+v", passkeys)
+	}
+}
+
+func TestPasskeyRegistrationRejectsWrongChallenge(t *testing.T) {
+	ps, userID := newTestPasskeyService(t)
+	authenticator := newSoftAuthenticator(t)
+
+	_, session, err := ps.BeginRegistration(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := authenticator.register(t, []byte("not the challenge"), webAuthnID(userID))
+	r := httptest.NewRequest("POST", "/passkeys", bytes.NewReader(body))
+	if err := ps.FinishRegistration(userID, "Laptop", *session, r); !errors.Is(err, ErrPasskeyCeremonyFailed) {
+		t.Fatalf("expected ErrPasskeyCeremonyFailed, got %v", err)
+	}
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	ps, userID := newTestPasskeyService(t)
+	authenticator := newSoftAuthenticator(t)
+	registerPasskey(t, ps, userID, authenticator)
+
+	t.Run("passwordless", func(t *testing.T) {
+		got, err := loginWithPasskey(t, ps, 0, authenticator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != userID {
+			t.Fatalf("expected user %d, got %d", userID, got)
+		}
+	})
+
+	t.Run("second factor", func(t *testing.T) {
+		got, err := loginWithPasskey(t, ps, userID, authenticator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != userID {
+			t.Fatalf("expected user %d, got %d", userID, got)
+		}
+	})
+
+	t.Run("other user's passkey", func(t *testing.T) {
+		otherID := createTestUser(t, ps.db, "other@example.com")
+		if _, err := loginWithPasskey(t, ps, otherID, authenticator); err == nil {
+			t.Fatal("expected the passkey to be rejected for another user")
+		}
+	})
+}
+
+func TestPasskeyLoginDetectsClone(t *testing.T) {
+	ps, userID := newTestPasskeyService(t)
+	authenticator := newSoftAuthenticator(t)
+	registerPasskey(t, ps, userID, authenticator)
+
+	authenticator.counter = 5
+	if _, err := loginWithPasskey(t, ps, 0, authenticator); err != nil {
+		t.Fatal(err)
+	}
+
+	// a copy of the key still at an older counter
+	authenticator.counter = 2
+	if _, err := loginWithPasskey(t, ps, 0, authenticator); !errors.Is(err, ErrPasskeyCloned) {
+		t.Fatalf("expected ErrPasskeyCloned, got %v", err)
+	}
+}
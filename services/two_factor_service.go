@@ -0,0 +1,210 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image/png"
+	"math/big"
+	"numera/model"
+	"numera/pkg/encrypt"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	totpIssuer        = "Numera"
+	totpPeriod        = 30
+	totpSkew          = 1
+	recoveryCodeCount = 10
+)
+
+var ErrTwoFactorCodeInvalid = errors.New("two factor code is invalid")
+
+var totpOpts = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// TwoFactorSetup holds what the user needs to add the account to an
+// authenticator app. EncryptedSecret is kept in the session until the user
+// confirms a code.
+type TwoFactorSetup struct {
+	Secret          string
+	EncryptedSecret string
+	QRCode          string
+}
+
+type TwoFactorService struct {
+	db        *sql.DB
+	logger    *logrus.Logger
+	encrypter *encrypt.Encrypter
+}
+
+func NewTwoFactorService(db *sql.DB, logger *logrus.Logger, encrypter *encrypt.Encrypter) *TwoFactorService {
+	return &TwoFactorService{
+		db:        db,
+		logger:    logger,
+		encrypter: encrypter,
+	}
+}
+
+// NewSetup generates a fresh secret for the user along with a QR code, the
+// image is rendered here so the secret never leaves the server except to the
+// user's browser.
+func (ts *TwoFactorService) NewSetup(email string) (*TwoFactorSetup, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: email,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate totp key: %w", err)
+	}
+
+	img, err := key.Image(220, 220)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render qr code: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode qr code: %w", err)
+	}
+
+	encrypted, err := ts.encrypter.Encrypt([]byte(key.Secret()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorSetup{
+		Secret:          key.Secret(),
+		EncryptedSecret: encrypted,
+		QRCode:          "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// matchStep returns the time step the code was generated for, allowing for a
+// little clock drift either way
+func matchStep(secret, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	current := now.Unix() / totpPeriod
+
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totpOpts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+func generateRecoveryCodes() ([]string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		for j := range b {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+			}
+			b[j] = alphabet[n.Int64()]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+	}
+
+	return codes, nil
+}
+
+// Enable checks the code against the pending secret and turns on two factor
+// authentication, returning the recovery codes to show to the user once.
+func (ts *TwoFactorService) Enable(userID int64, encryptedSecret, code string) ([]string, error) {
+	secret, err := ts.encrypter.Decrypt(encryptedSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := matchStep(string(secret), code, time.Now())
+	if !ok {
+		return nil, ErrTwoFactorCodeInvalid
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := model.EnableTwoFactor(ts.db, userID, encryptedSecret, step, codes); err != nil {
+		return nil, err
+	}
+
+	ts.logger.WithField("user_id", userID).Info("two_factor_enabled")
+	return codes, nil
+}
+
+// Verify accepts either a current TOTP code or an unused recovery code
+func (ts *TwoFactorService) Verify(userID int64, code string) error {
+	twoFactor, err := model.GetTwoFactorByUserID(ts.db, userID)
+	if err != nil {
+		return err
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) != int(otp.DigitsSix) {
+		if err := model.UseRecoveryCode(ts.db, userID, code); err != nil {
+			if errors.Is(err, model.ErrRecoveryCodeInvalid) {
+				return ErrTwoFactorCodeInvalid
+			}
+			return err
+		}
+
+		ts.logger.WithField("user_id", userID).Warn("recovery_code_used")
+		return nil
+	}
+
+	secret, err := ts.encrypter.Decrypt(twoFactor.Secret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := matchStep(string(secret), code, time.Now())
+	if !ok {
+		return ErrTwoFactorCodeInvalid
+	}
+
+	if err := model.ConsumeTOTPStep(ts.db, userID, step); err != nil {
+		if errors.Is(err, model.ErrTOTPCodeReused) {
+			return ErrTwoFactorCodeInvalid
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Disable turns off two factor authentication for the user
+func (ts *TwoFactorService) Disable(userID int64) error {
+	if err := model.DisableTwoFactor(ts.db, userID); err != nil {
+		return err
+	}
+
+	ts.logger.WithField("user_id", userID).Info("two_factor_disabled")
+	return nil
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"numera/model"
+	"numera/pkg/mailer"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// VerificationLinkTTL is how long a verification link stays valid
+	VerificationLinkTTL = 24 * time.Hour
+	// VerificationResendInterval is the minimum time between two emails
+	VerificationResendInterval = 2 * time.Minute
+)
+
+var (
+	ErrVerificationLinkInvalid = errors.New("verification link is invalid")
+	ErrVerificationLinkExpired = errors.New("verification link has expired")
+)
+
+// VerificationService sends and checks email verification links. Links are
+// signed with the app key instead of being stored, and include the email so
+// they stop working once the address changes.
+type VerificationService struct {
+	db     *sql.DB
+	logger *logrus.Logger
+	mailer mailer.Mailer
+	appURL string
+	key    []byte
+}
+
+func NewVerificationService(
+	db *sql.DB,
+	logger *logrus.Logger,
+	mailer mailer.Mailer,
+	appURL string,
+	appKey string,
+) *VerificationService {
+	return &VerificationService{
+		db:     db,
+		logger: logger,
+		mailer: mailer,
+		appURL: appURL,
+		key:    []byte(appKey),
+	}
+}
+
+func (vs *VerificationService) signature(userID int64, email string, expires int64) string {
+	mac := hmac.New(sha256.New, vs.key)
+	fmt.Fprintf(mac, "verify-email|%d|%s|%d", userID, email, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Link builds a signed verification link for the user
+func (vs *VerificationService) Link(user *model.User) string {
+	expires := time.Now().Add(VerificationLinkTTL).Unix()
+
+	query := url.Values{}
+	query.Set("id", strconv.FormatInt(user.ID, 10))
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", vs.signature(user.ID, user.Email, expires))
+
+	return vs.appURL + "/verify-email/confirm?" + query.Encode()
+}
+
+// Send emails a verification link to the user, it returns
+// model.ErrVerificationThrottled if one was sent too recently.
+func (vs *VerificationService) Send(ctx context.Context, user *model.User) error {
+	if err := model.ReserveVerificationEmail(vs.db, user.ID, VerificationResendInterval); err != nil {
+		return err
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your Numera email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n"+
+				"Thanks for signing up for Numera. Please confirm your email address "+
+				"by opening the link below:\n\n%s\n\n"+
+				"The link expires in %d hours. "+
+				"If you didn't create an account you can ignore this email.\n",
+			user.Name,
+			vs.Link(user),
+			int(VerificationLinkTTL.Hours()),
+		),
+	}
+
+	if err := vs.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send verification email: %w", err)
+	}
+
+	vs.logger.WithField("user_id", user.ID).Info("verification_email_sent")
+	return nil
+}
+
+// Verify checks a signed link and marks the user's email as verified
+func (vs *VerificationService) Verify(query url.Values) (int64, error) {
+	userID, err := strconv.ParseInt(query.Get("id"), 10, 64)
+	if err != nil {
+		return 0, ErrVerificationLinkInvalid
+	}
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return 0, ErrVerificationLinkInvalid
+	}
+
+	user, err := model.GetUserByID(vs.db, userID)
+	if err != nil {
+		if errors.Is(err, model.ErrUserNotFound) {
+			return 0, ErrVerificationLinkInvalid
+		}
+		return 0, err
+	}
+
+	expected := vs.signature(user.ID, user.Email, expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+		return 0, ErrVerificationLinkInvalid
+	}
+
+	if time.Now().Unix() > expires {
+		return 0, ErrVerificationLinkExpired
+	}
+
+	if err := model.MarkEmailVerified(vs.db, user.ID, user.Email); err != nil {
+		return 0, err
+	}
+
+	return user.ID, nil
+}
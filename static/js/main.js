@@ -1,10 +1,14 @@
 import htmx from 'htmx.org';
 import Alpine from 'alpinejs';
 import Notify from './notify';
+import Passkeys from './passkeys';
+import Sortable from './sortable';
 const notify = new Notify;
 
 window.Alpine = Alpine;
+window.passkeys = Passkeys(notify);
 Alpine.start();
+Sortable();
 
 const modal = document.getElementById("modal")
 
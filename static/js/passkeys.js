@@ -0,0 +1,128 @@
+import htmx from 'htmx.org';
+
+// Browser side of the WebAuthn ceremonies. The server sends binary fields as
+// base64url strings, they are converted to ArrayBuffers before calling the
+// credentials api and back again for the response.
+
+function decode(value) {
+  const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+  const padded = base64 + '='.repeat((4 - base64.length % 4) % 4);
+  return Uint8Array.from(atob(padded), c => c.charCodeAt(0)).buffer;
+}
+
+function encode(buffer) {
+  const bytes = String.fromCharCode(...new Uint8Array(buffer));
+  return btoa(bytes).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
+function csrfToken() {
+  return document.querySelector('meta[name="csrf-token"]')?.content || '';
+}
+
+async function post(url, body, contentType = 'application/json') {
+  const res = await fetch(url, {
+    method: 'POST',
+    credentials: 'same-origin',
+    headers: {
+      'Content-Type': contentType,
+      'Accept': 'application/json',
+      'X-CSRF-Token': csrfToken(),
+    },
+    body,
+  });
+  const payload = await res.json().catch(() => ({}));
+  if (!res.ok) {
+    const error = new Error(payload.error?.message || 'Something went wrong, please try again');
+    error.fields = payload.error?.fields;
+    throw error;
+  }
+  return payload.data;
+}
+
+export default function (notify) {
+  const supported = () => {
+    if (!window.PublicKeyCredential) {
+      notify.error('Passkeys are not supported by this browser');
+      return false;
+    }
+    return true;
+  };
+
+  const fail = (err) => {
+    // the user closed the browser prompt
+    if (err.name === 'NotAllowedError' || err.name === 'AbortError') {
+      return;
+    }
+    notify.error(err.message);
+  };
+
+  return {
+    async login(url) {
+      if (!supported()) return;
+
+      try {
+        const { publicKey } = await post(`${url}/options`);
+        publicKey.challenge = decode(publicKey.challenge);
+        publicKey.allowCredentials?.forEach(c => c.id = decode(c.id));
+
+        const credential = await navigator.credentials.get({ publicKey });
+        const result = await post(url, JSON.stringify({
+          id: credential.id,
+          rawId: encode(credential.rawId),
+          type: credential.type,
+          response: {
+            clientDataJSON: encode(credential.response.clientDataJSON),
+            authenticatorData: encode(credential.response.authenticatorData),
+            signature: encode(credential.response.signature),
+            userHandle: credential.response.userHandle ? encode(credential.response.userHandle) : null,
+          },
+        }));
+
+        window.location = result.redirect;
+      } catch (err) {
+        fail(err);
+      }
+    },
+
+    async register(form) {
+      if (!supported()) return;
+
+      const name = form.elements.name.value;
+      const errorEl = document.getElementById('error-name');
+      errorEl.textContent = '';
+
+      try {
+        const { publicKey } = await post(
+          '/settings/passkeys/options',
+          new URLSearchParams({ name }),
+          'application/x-www-form-urlencoded',
+        );
+        publicKey.challenge = decode(publicKey.challenge);
+        publicKey.user.id = decode(publicKey.user.id);
+        publicKey.excludeCredentials?.forEach(c => c.id = decode(c.id));
+
+        const credential = await navigator.credentials.create({ publicKey });
+        await post(`/settings/passkeys?${new URLSearchParams({ name })}`, JSON.stringify({
+          id: credential.id,
+          rawId: encode(credential.rawId),
+          type: credential.type,
+          response: {
+            clientDataJSON: encode(credential.response.clientDataJSON),
+            attestationObject: encode(credential.response.attestationObject),
+            transports: credential.response.getTransports?.() ?? [],
+          },
+        }));
+
+        form.reset();
+        notify.success('Passkey added');
+        htmx.trigger(document.body, 'reloadPasskeys');
+      } catch (err) {
+        if (err.fields?.name) {
+          errorEl.textContent = err.fields.name;
+          return;
+        }
+        fail(err);
+      }
+    },
+  };
+}
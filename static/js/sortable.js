@@ -0,0 +1,66 @@
+// Drag and drop ordering with the browser's native drag events. Lists are
+// marked with data-sortable and their items with data-sortable-item, lists
+// sharing the same data-sortable value can exchange items. Once an item is
+// dropped the list it landed in receives an "end" event, forms listen for it
+// with hx-trigger="end" and post their items' hidden inputs in the new order.
+
+let dragged = null;
+let source = null;
+
+function listOf(el) {
+  return el.closest('[data-sortable]');
+}
+
+// itemBefore finds the item the dragged one should be placed in front of,
+// lists are horizontal unless marked with data-sortable-axis="y"
+function itemBefore(list, x, y) {
+  const vertical = list.dataset.sortableAxis === 'y';
+  const items = [...list.querySelectorAll('[data-sortable-item]')]
+    .filter(item => item !== dragged && listOf(item) === list);
+
+  return items.find(item => {
+    const box = item.getBoundingClientRect();
+    return vertical ? y < box.top + box.height / 2 : x < box.left + box.width / 2;
+  });
+}
+
+export default function Sortable() {
+  document.addEventListener('dragstart', (e) => {
+    const item = e.target.closest?.('[data-sortable-item]');
+    if (!item) return;
+
+    dragged = item;
+    source = listOf(item);
+    e.dataTransfer.effectAllowed = 'move';
+    e.dataTransfer.setData('text/plain', '');
+    item.classList.add('opacity-50');
+  });
+
+  document.addEventListener('dragover', (e) => {
+    if (!dragged) return;
+
+    const list = e.target.closest?.('[data-sortable]');
+    if (!list || list.dataset.sortable !== source.dataset.sortable) return;
+    e.preventDefault();
+
+    const before = itemBefore(list, e.clientX, e.clientY);
+    if (before) {
+      before.before(dragged);
+    } else {
+      (list.querySelector('[data-sortable-items]') || list).appendChild(dragged);
+    }
+  });
+
+  document.addEventListener('drop', (e) => {
+    if (dragged) e.preventDefault();
+  });
+
+  document.addEventListener('dragend', () => {
+    if (!dragged) return;
+
+    dragged.classList.remove('opacity-50');
+    listOf(dragged).dispatchEvent(new Event('end'));
+    dragged = null;
+    source = null;
+  });
+}
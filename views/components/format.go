@@ -0,0 +1,35 @@
+package components
+
+import (
+	"context"
+	"numera/model"
+	"numera/pkg/locale"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Amount writes an amount with its currency in the user's locale
+func Amount(ctx context.Context, amount decimal.Decimal, currency model.Currency) string {
+	return model.FormatBalance(locale.FromContext(ctx), amount, currency)
+}
+
+// Date writes a calendar date, such as when a transaction occurred
+func Date(ctx context.Context, t time.Time) string {
+	return locale.FromContext(ctx).Date(t)
+}
+
+// LocalDate writes the day a moment fell on in the user's timezone
+func LocalDate(ctx context.Context, t time.Time) string {
+	return locale.FromContext(ctx).LocalDate(t)
+}
+
+// DateTime writes a moment in the user's timezone
+func DateTime(ctx context.Context, t time.Time) string {
+	return locale.FromContext(ctx).DateTime(t)
+}
+
+// Month writes the month of a calendar date
+func Month(ctx context.Context, t time.Time) string {
+	return locale.FromContext(ctx).Month(t)
+}